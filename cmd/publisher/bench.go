@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/collector"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	target "github.com/openconfig/gnmic/target"
+	"log/slog"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// benchTick is how often the load generator catches up with its rate.
+	benchTick = 10 * time.Millisecond
+	// maxLatencySamples bounds the memory used for latency percentiles.
+	maxLatencySamples = 100000
+)
+
+// benchCounters are the leaves of each synthetic update.
+var benchCounters = []string{
+	"in-octets", "out-octets", "in-unicast-pkts", "out-unicast-pkts",
+	"in-errors", "out-errors", "in-discards", "out-discards",
+}
+
+// bench drives synthetic interface counter updates for a number of targets
+// through the encoding, queueing and publishing path of the publisher, and
+// reports the throughput, the latency from generating an update to the
+// sink accepting it, and the allocations per update. Unless --publish is
+// given, the sink discards everything so only the publisher is measured.
+func bench(opts options) error {
+	if opts.benchTargets <= 0 || opts.benchRate <= 0 {
+		return fmt.Errorf("targets and rate must be positive")
+	}
+	conf, _, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	tmpl := conf.TargetConfigs()
+	if len(tmpl) == 0 {
+		return fmt.Errorf("no target configured to use as a template")
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	var out sink.Sink = discardSink{}
+	if opts.benchPublish {
+		conf.GetProxy.Enabled = false
+		conf.SetRelay.Enabled = false
+		var closeOutputs func()
+		_, out, closeOutputs, err = openOutputs(ctx, conf)
+		if err != nil {
+			return err
+		}
+		defer closeOutputs()
+	}
+	timing := newTimingSink(out)
+
+	// The publishers outlive the generators so queued updates are flushed.
+	pubCtx, stopPublishers := context.WithCancel(context.Background())
+	defer stopPublishers()
+	var pubs sync.WaitGroup
+	targets := make([]*collector.Target, opts.benchTargets)
+	for i := range targets {
+		tc := tmpl[0]
+		tc.Name = fmt.Sprintf("bench%d", i+1)
+		tt, err := collector.NewOfflineTarget(pubCtx, tc, timing, conf)
+		if err != nil {
+			return err
+		}
+		targets[i] = tt
+		pubs.Add(1)
+		go func() {
+			defer pubs.Done()
+			tt.RunPublisher(pubCtx)
+		}()
+	}
+
+	slog.Info("Running benchmark", "targets", opts.benchTargets, "rate", opts.benchRate,
+		"duration", opts.duration, "publish", opts.benchPublish)
+	genCtx, stopGenerators := context.WithTimeout(ctx, opts.duration)
+	defer stopGenerators()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+
+	var gens sync.WaitGroup
+	generated := make([]int, len(targets))
+	for i, tt := range targets {
+		gens.Add(1)
+		go func(i int, tt *collector.Target) {
+			defer gens.Done()
+			generated[i] = generate(genCtx, tt, opts.benchRate)
+		}(i, tt)
+	}
+	gens.Wait()
+	stopPublishers()
+	pubs.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	total := 0
+	for _, n := range generated {
+		total += n
+	}
+	var dropped, limited uint64
+	for _, tt := range targets {
+		dropped += tt.QueueDropped()
+		limited += tt.RateLimited()
+	}
+	timing.report(benchResult{
+		targets:     opts.benchTargets,
+		rate:        opts.benchRate,
+		elapsed:     elapsed,
+		generated:   total,
+		dropped:     int(dropped),
+		rateLimited: int(limited),
+		before:      before,
+		after:       after,
+	})
+	return nil
+}
+
+// generate hands rate updates per second to tt until ctx is cancelled and
+// returns how many it generated.
+func generate(ctx context.Context, tt *collector.Target, rate int) int {
+	subscription := tt.Config.SubscriptionConfigs()[0].Name
+	ticker := time.NewTicker(benchTick)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var counter uint64
+	sent := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return sent
+		case now := <-ticker.C:
+			due := int(now.Sub(start).Seconds() * float64(rate))
+			for ; sent < due && ctx.Err() == nil; sent++ {
+				counter += 1500
+				tt.HandleResponse(ctx, &target.SubscribeResponse{
+					SubscriptionName: subscription,
+					Response:         benchResponse(sent, counter),
+				})
+			}
+		}
+	}
+}
+
+// benchResponse returns an update of the counters of one of 48 interfaces,
+// timestamped now so the timing sink can measure its latency.
+func benchResponse(n int, value uint64) *gnmi.SubscribeResponse {
+	notif := &gnmi.Notification{
+		Timestamp: time.Now().UnixNano(),
+		Prefix: &gnmi.Path{Elem: []*gnmi.PathElem{
+			{Name: "interfaces"},
+			{Name: "interface", Key: map[string]string{"name": fmt.Sprintf("Ethernet%d", n%48+1)}},
+			{Name: "state"},
+			{Name: "counters"},
+		}},
+	}
+	for _, name := range benchCounters {
+		notif.Update = append(notif.Update, &gnmi.Update{
+			Path: &gnmi.Path{Elem: []*gnmi.PathElem{{Name: name}}},
+			Val:  &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: value}},
+		})
+	}
+	return &gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: notif}}
+}
+
+// discardSink accepts and drops every message.
+type discardSink struct{}
+
+func (discardSink) Publish(context.Context, string, []byte, map[string]string) error { return nil }
+func (discardSink) Close() error                                                     { return nil }
+
+// timingSink measures how long after its gNMI timestamp each message was
+// accepted by the next sink. Latencies are sampled into a bounded
+// reservoir.
+type timingSink struct {
+	next sink.Sink
+
+	mu        sync.Mutex
+	rnd       *rand.Rand
+	published int
+	failed    int
+	bytes     int
+	seen      int
+	samples   []time.Duration
+	max       time.Duration
+}
+
+func newTimingSink(next sink.Sink) *timingSink {
+	return &timingSink{next: next, rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (s *timingSink) Publish(ctx context.Context, subject string, payload []byte, meta map[string]string) error {
+	err := s.next.Publish(ctx, subject, payload, meta)
+	var latency time.Duration
+	if ts, perr := strconv.ParseInt(meta["Gnmi-Timestamp"], 10, 64); perr == nil {
+		latency = time.Since(time.Unix(0, ts))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.failed++
+		return err
+	}
+	s.published++
+	s.bytes += len(payload)
+	if latency > s.max {
+		s.max = latency
+	}
+	s.seen++
+	if len(s.samples) < maxLatencySamples {
+		s.samples = append(s.samples, latency)
+	} else if i := s.rnd.Intn(s.seen); i < maxLatencySamples {
+		s.samples[i] = latency
+	}
+	return nil
+}
+
+func (s *timingSink) Close() error {
+	return s.next.Close()
+}
+
+type benchResult struct {
+	targets     int
+	rate        int
+	elapsed     time.Duration
+	generated   int
+	dropped     int
+	rateLimited int
+	before      runtime.MemStats
+	after       runtime.MemStats
+}
+
+// report prints the results of a benchmark run to stdout.
+func (s *timingSink) report(r benchResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sort.Slice(s.samples, func(i, j int) bool { return s.samples[i] < s.samples[j] })
+	percentile := func(p float64) time.Duration {
+		if len(s.samples) == 0 {
+			return 0
+		}
+		return s.samples[int(p*float64(len(s.samples)-1))]
+	}
+	secs := r.elapsed.Seconds()
+	perUpdate := func(v uint64) float64 {
+		if r.generated == 0 {
+			return 0
+		}
+		return float64(v) / float64(r.generated)
+	}
+
+	fmt.Printf("targets:      %d at %d updates/s each, %s\n", r.targets, r.rate, r.elapsed.Round(time.Millisecond))
+	fmt.Printf("generated:    %d (%.0f/s)\n", r.generated, float64(r.generated)/secs)
+	fmt.Printf("published:    %d (%.0f/s, %.1f MB/s)\n", s.published, float64(s.published)/secs, float64(s.bytes)/secs/1e6)
+	fmt.Printf("failed:       %d, queue dropped %d, rate limited %d\n", s.failed, r.dropped, r.rateLimited)
+	fmt.Printf("latency:      p50 %s, p90 %s, p99 %s, max %s\n",
+		percentile(0.5), percentile(0.9), percentile(0.99), s.max)
+	fmt.Printf("allocations:  %.0f B/update, %.1f allocs/update, %d GC cycles, %s GC pause\n",
+		perUpdate(r.after.TotalAlloc-r.before.TotalAlloc),
+		perUpdate(r.after.Mallocs-r.before.Mallocs),
+		r.after.NumGC-r.before.NumGC,
+		time.Duration(r.after.PauseTotalNs-r.before.PauseTotalNs))
+}
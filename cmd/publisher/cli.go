@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/logging"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/collector"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/joho/godotenv"
+	"os"
+	"strings"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+const usage = `Usage: publisher [command] [flags]
+
+Commands:
+  run       collect telemetry and publish it to NATS (default)
+  validate  check the configuration and exit
+  record    capture the raw gNMI responses of the targets to a file
+  replay    publish the responses of a capture file as if just received
+  bench     measure the throughput of the publishing pipeline
+  version   print the version and exit
+
+Run 'publisher <command> -h' for the flags of a command.
+`
+
+// options holds the command line flags shared by run and validate, and
+// those of record, replay and bench.
+type options struct {
+	configFile string
+	envFile    string
+	natsURL    string
+	logLevel   string
+	targets    string
+	dryRun     bool
+	embedded   bool
+
+	output   string
+	duration time.Duration
+
+	input string
+	speed float64
+	loop  bool
+
+	benchTargets int
+	benchRate    int
+	benchPublish bool
+}
+
+func parseFlags(name string, args []string) (options, error) {
+	var opts options
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(&opts.configFile, "config", "./config/config.yaml", "path to the YAML config file")
+	fs.StringVar(&opts.envFile, "env-file", "./config/creds.env", "path to the credentials environment file")
+	fs.StringVar(&opts.natsURL, "nats-url", "", "NATS server URL (overrides nats_url)")
+	fs.StringVar(&opts.logLevel, "log-level", "", "log level: debug, info, warn or error (overrides log_level)")
+	fs.StringVar(&opts.targets, "target", "", "comma separated list of target names to collect from (default all)")
+	fs.BoolVar(&opts.embedded, "embedded-nats", false, "run a NATS server in-process instead of connecting to nats_url")
+	switch name {
+	case "record":
+		fs.StringVar(&opts.output, "output", "", "file to append the captured responses to")
+		fs.DurationVar(&opts.duration, "duration", 0, "stop recording after this long (default until interrupted)")
+	case "replay":
+		fs.StringVar(&opts.input, "input", "", "capture file to replay")
+		fs.Float64Var(&opts.speed, "speed", 1, "replay speed relative to the original, 0 for as fast as possible")
+		fs.BoolVar(&opts.loop, "loop", false, "start over at the end of the capture")
+	case "bench":
+		fs.IntVar(&opts.benchTargets, "targets", 10, "number of simulated targets")
+		fs.IntVar(&opts.benchRate, "rate", 100, "updates per second generated for each target")
+		fs.DurationVar(&opts.duration, "duration", 30*time.Second, "how long to generate updates for")
+		fs.BoolVar(&opts.benchPublish, "publish", false, "publish to the configured sink instead of discarding")
+	}
+	if name != "record" && name != "bench" {
+		fs.BoolVar(&opts.dryRun, "dry-run", false, "write telemetry to stdout instead of publishing it to NATS")
+	}
+	err := fs.Parse(args)
+	return opts, err
+}
+
+// load reads the config file and applies the command line overrides.
+func (o options) load() (config.Config, error) {
+	conf, err := collector.LoadConfig(o.configFile)
+	if err != nil {
+		return config.Config{}, err
+	}
+
+	if o.natsURL != "" {
+		conf.NatsURL = o.natsURL
+	}
+	if o.logLevel != "" {
+		conf.LogLevel = o.logLevel
+	}
+	conf.Tracing.ApplyEnv()
+	if o.embedded {
+		conf.EmbeddedNats.Enabled = true
+	}
+	if o.dryRun {
+		// Keep the whole gNMI and encoding path but publish nothing, and
+		// leave out the services that need a NATS connection.
+		conf.Sink = config.SinkConfig{Type: config.SinkStdout, Pretty: true}
+		conf.GetProxy.Enabled = false
+		conf.SetRelay.Enabled = false
+		conf.DeadLetter.Subject = ""
+		conf.Snapshots = config.SnapshotConfig{}
+		conf.Compression = config.CompressionConfig{}
+		conf.HA = config.HAConfig{}
+		conf.Control.Enabled = false
+	}
+	if o.targets != "" {
+		selected := make(map[string]bool)
+		for _, name := range strings.Split(o.targets, ",") {
+			selected[strings.TrimSpace(name)] = true
+		}
+		var targets []config.TargetConfig
+		for _, t := range conf.TargetConfigs() {
+			if selected[t.Name] {
+				targets = append(targets, t)
+				delete(selected, t.Name)
+			}
+		}
+		for name := range selected {
+			return config.Config{}, fmt.Errorf("target %q not found in %s", name, o.configFile)
+		}
+		conf.Targets = targets
+	}
+	return conf, nil
+}
+
+// validate loads the configuration and reports any errors in it.
+func validate(opts options) error {
+	// The env file is optional here, but load it when present so ${VAR}
+	// references resolve the same way they do for run.
+	if err := godotenv.Load(opts.envFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error loading .env file: %w", err)
+	}
+
+	conf, err := opts.load()
+	if err != nil {
+		return err
+	}
+	if _, err := config.SetupVault(context.Background(), conf.Vault); err != nil {
+		return err
+	}
+	if err := collector.Validate(conf); err != nil {
+		return err
+	}
+
+	subs := 0
+	targets := conf.TargetConfigs()
+	for _, t := range targets {
+		subs += len(t.SubscriptionConfigs())
+	}
+	fmt.Printf("%s is valid: %d target(s), %d subscription(s)\n", opts.configFile, len(targets), subs)
+	return nil
+}
+
+func main() {
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	collector.Version = version
+	switch cmd {
+	case "run", "validate", "record", "replay", "bench":
+		opts, err := parseFlags(cmd, args)
+		if err == flag.ErrHelp {
+			return
+		} else if err != nil {
+			os.Exit(2)
+		}
+		switch cmd {
+		case "validate":
+			err = validate(opts)
+		case "record":
+			err = record(opts)
+		case "replay":
+			err = replay(opts)
+		case "bench":
+			err = bench(opts)
+		default:
+			err = run(opts)
+		}
+		if err != nil {
+			logging.Fatal("Publisher failed", "command", cmd, "error", err)
+		}
+	case "version":
+		fmt.Printf("publisher %s\n", version)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
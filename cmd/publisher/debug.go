@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// startTime is when the process started, reported as uptime.
+var startTime = time.Now()
+
+// serveDebug exposes the Go profiler under /debug/pprof/ and runtime
+// statistics under /debug/runtime on addr. It is meant for diagnosing
+// memory growth or goroutine leaks and should not be reachable from
+// untrusted networks.
+func serveDebug(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/runtime", serveRuntimeStats)
+
+	slog.Info("Serving debug endpoints", "address", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Debug server stopped", "error", err)
+	}
+}
+
+// runtimeStats is the response of /debug/runtime.
+type runtimeStats struct {
+	Version    string      `json:"version"`
+	GoVersion  string      `json:"go_version"`
+	Uptime     string      `json:"uptime"`
+	Goroutines int         `json:"goroutines"`
+	CPUs       int         `json:"cpus"`
+	Memory     memoryStats `json:"memory"`
+	GC         gcStats     `json:"gc"`
+}
+
+type memoryStats struct {
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapIdle     uint64 `json:"heap_idle_bytes"`
+	HeapReleased uint64 `json:"heap_released_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse_bytes"`
+	Sys          uint64 `json:"sys_bytes"`
+	TotalAlloc   uint64 `json:"total_alloc_bytes"`
+	Mallocs      uint64 `json:"mallocs"`
+	Frees        uint64 `json:"frees"`
+}
+
+type gcStats struct {
+	NumGC       uint32   `json:"num_gc"`
+	NumForcedGC uint32   `json:"num_forced_gc"`
+	LastGC      string   `json:"last_gc,omitempty"`
+	NextGC      uint64   `json:"next_gc_bytes"`
+	CPUFraction float64  `json:"cpu_fraction"`
+	MemoryLimit int64    `json:"memory_limit_bytes"`
+	PauseTotal  string   `json:"pause_total"`
+	LastPauses  []string `json:"last_pauses"`
+}
+
+// serveRuntimeStats reports memory, GC and goroutine statistics as JSON.
+func serveRuntimeStats(w http.ResponseWriter, _ *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+
+	stats := runtimeStats{
+		Version:    version,
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(startTime).Round(time.Second).String(),
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.GOMAXPROCS(0),
+		Memory: memoryStats{
+			HeapAlloc:    m.HeapAlloc,
+			HeapInuse:    m.HeapInuse,
+			HeapIdle:     m.HeapIdle,
+			HeapReleased: m.HeapReleased,
+			HeapObjects:  m.HeapObjects,
+			StackInuse:   m.StackInuse,
+			Sys:          m.Sys,
+			TotalAlloc:   m.TotalAlloc,
+			Mallocs:      m.Mallocs,
+			Frees:        m.Frees,
+		},
+		GC: gcStats{
+			NumGC:       m.NumGC,
+			NumForcedGC: m.NumForcedGC,
+			NextGC:      m.NextGC,
+			CPUFraction: m.GCCPUFraction,
+			// A negative limit only reads the current one.
+			MemoryLimit: debug.SetMemoryLimit(-1),
+			PauseTotal:  gc.PauseTotal.String(),
+		},
+	}
+	if !gc.LastGC.IsZero() {
+		stats.GC.LastGC = gc.LastGC.UTC().Format(time.RFC3339Nano)
+	}
+	// The most recent pauses come first.
+	for i, p := range gc.Pause {
+		if i == 10 {
+			break
+		}
+		stats.GC.LastPauses = append(stats.GC.LastPauses, p.String())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	if err := enc.Encode(stats); err != nil {
+		slog.Debug("Error writing runtime stats", "error", err)
+	}
+}
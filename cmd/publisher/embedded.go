@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/nats-io/nats-server/v2/server"
+	"log/slog"
+	"time"
+)
+
+// startEmbeddedNats starts a NATS server in-process and waits until it
+// accepts connections. JetStream is enabled on it when jetStream is set. It
+// returns the client URL of the server and a function that shuts it down.
+func startEmbeddedNats(conf config.EmbeddedNatsConfig, jetStream bool) (string, func(), error) {
+	opts := &server.Options{
+		ServerName: "nats-gnmi-embedded",
+		Host:       conf.Host,
+		Port:       conf.Port,
+		HTTPPort:   conf.HTTPPort,
+		JetStream:  jetStream,
+		StoreDir:   conf.StoreDir,
+		// The publisher handles signals itself.
+		NoSigs: true,
+	}
+	if opts.Host == "" {
+		opts.Host = "127.0.0.1"
+	}
+	if opts.Port == 0 {
+		opts.Port = server.DEFAULT_PORT
+	}
+
+	srv, err := server.NewServer(opts)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not create embedded NATS server: %w", err)
+	}
+	srv.SetLoggerV2(natsServerLogger{}, false, false, false)
+	go srv.Start()
+	if !srv.ReadyForConnections(10 * time.Second) {
+		srv.Shutdown()
+		return "", nil, fmt.Errorf("embedded NATS server did not start on %s:%d", opts.Host, opts.Port)
+	}
+	slog.Info("Started embedded NATS server", "url", srv.ClientURL(), "jetstream", jetStream)
+	return srv.ClientURL(), func() {
+		srv.Shutdown()
+		srv.WaitForShutdown()
+	}, nil
+}
+
+// natsServerLogger writes the log of the embedded server through slog.
+type natsServerLogger struct{}
+
+func (natsServerLogger) Noticef(format string, v ...interface{}) {
+	slog.Info(fmt.Sprintf(format, v...), "component", "nats-server")
+}
+
+func (natsServerLogger) Warnf(format string, v ...interface{}) {
+	slog.Warn(fmt.Sprintf(format, v...), "component", "nats-server")
+}
+
+func (natsServerLogger) Fatalf(format string, v ...interface{}) {
+	slog.Error(fmt.Sprintf(format, v...), "component", "nats-server")
+}
+
+func (natsServerLogger) Errorf(format string, v ...interface{}) {
+	slog.Error(fmt.Sprintf(format, v...), "component", "nats-server")
+}
+
+func (natsServerLogger) Debugf(format string, v ...interface{}) {
+	slog.Debug(fmt.Sprintf(format, v...), "component", "nats-server")
+}
+
+func (natsServerLogger) Tracef(format string, v ...interface{}) {
+	slog.Debug(fmt.Sprintf(format, v...), "component", "nats-server")
+}
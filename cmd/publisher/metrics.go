@@ -0,0 +1,18 @@
+package main
+
+import (
+	"github.com/gwoodwa1/nats-gnmi-example/internal/metrics"
+	"log/slog"
+	"net/http"
+)
+
+// serveMetrics exposes the Prometheus metrics on addr under /metrics.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+
+	slog.Info("Serving metrics", "address", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		slog.Error("Metrics server stopped", "error", err)
+	}
+}
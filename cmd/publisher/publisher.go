@@ -3,226 +3,235 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/logging"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/tracing"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/collector"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
 	"github.com/joho/godotenv"
-	"github.com/nats-io/nats.go"
-	api "github.com/openconfig/gnmic/api"
-	"github.com/openconfig/gnmic/formatters"
-	target "github.com/openconfig/gnmic/target"
-	"gopkg.in/yaml.v3"
-	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 )
 
-type Config struct {
-	Name             string `yaml:"name"`
-	Address          string `yaml:"address"`
-	Insecure         bool   `yaml:"insecure"`
-	SkipVerify       bool   `yaml:"skipVerify"`
-	Gzip             bool   `yaml:"gzip"`
-	NatsURL          string `yaml:"nats_url"`
-	Topic            string `yaml:"telemetry_topic"`
-	XPath            string `yaml:"gnmi_xpath"`
-	Encoding         string `yaml:"encoding"`
-	ListMode         string `yaml:"listmode"`
-	SubscriptionMode string `yaml:"subscription_mode"`
-	SampleInterval   int    `yaml:"sample_interval"`
-}
-
-type TelemetryTarget struct {
-	Config   Config
-	Username string
-	Password string
-	Target   *target.Target
-}
-
-func NewTelemetryTarget(ctx context.Context, conf Config, username, password string) (*TelemetryTarget, error) {
-	tt := &TelemetryTarget{
-		Config:   conf,
-		Username: username,
-		Password: password,
-	}
-
-	var err error
-	tt.Target, err = api.NewTarget(
-		api.Name(tt.Config.Name),
-		api.Address(tt.Config.Address),
-		api.Username(username),
-		api.Password(password),
-		api.Insecure(tt.Config.Insecure),
-		api.SkipVerify(tt.Config.SkipVerify),
-		api.Gzip(tt.Config.Gzip),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("error creating target: %w", err)
+// setup loads the environment file, the configuration, logging and Vault,
+// which every command that collects telemetry needs, and validates the
+// configuration.
+func setup(opts options) (config.Config, *config.VaultSecrets, error) {
+	// Load credentials from the environment file.
+	if err := godotenv.Load(opts.envFile); err != nil {
+		return config.Config{}, nil, fmt.Errorf("error loading .env file: %w", err)
 	}
 
-	// Optionally handle context cancellation logic here.
-	go func() {
-		<-ctx.Done()
-		// Cleanup or close logic for tt.Target, if needed.
-	}()
-
-	return tt, nil
-}
-
-func collectTelemetry(ctx context.Context, tt *TelemetryTarget) error {
-	// Check if the TelemetryTarget and its internal Target are non-nil and initialized.
-	if tt == nil || tt.Target == nil {
-		return fmt.Errorf("telemetry target or its internal target is not properly initialized")
+	// Load configuration.
+	conf, err := opts.load()
+	if err != nil {
+		return config.Config{}, nil, fmt.Errorf("could not read config: %w", err)
 	}
-
-	// Ensure that a GNMI client is created before subscribing.
-	if err := tt.Target.CreateGNMIClient(ctx); err != nil {
-		return fmt.Errorf("error creating GNMI client: %w", err)
+	if err := logging.Setup(conf.LogLevel, conf.LogFormat); err != nil {
+		return config.Config{}, nil, fmt.Errorf("invalid logging config: %w", err)
 	}
-
-	// Creating a subscription request.
-	subReq, err := api.NewSubscribeRequest(
-		api.Encoding(tt.Config.Encoding),
-		api.SubscriptionListMode(tt.Config.ListMode),
-		api.Subscription(
-			api.Path(tt.Config.XPath),
-			api.SubscriptionMode(tt.Config.SubscriptionMode),
-			api.SampleInterval(time.Duration(tt.Config.SampleInterval)*time.Second),
-		))
+	// Vault has to be set up first so the secret references it resolves
+	// can be checked.
+	secrets, err := config.SetupVault(context.Background(), conf.Vault)
 	if err != nil {
-		return fmt.Errorf("error creating subscribe request: %w", err)
+		return config.Config{}, nil, err
 	}
+	if err := collector.Validate(conf); err != nil {
+		return config.Config{}, nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return conf, secrets, nil
+}
 
-	// Handling system signals and context cancellation for graceful shutdown.
+// signalContext returns a root context that is cancelled when the process
+// receives SIGINT or SIGTERM.
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Setup channel and notify for SIGINT and SIGTERM signals.
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 
+	// Launch a goroutine to handle termination signals.
 	go func() {
 		select {
 		case <-sigs:
+			slog.Info("Received termination signal, shutting down...")
+			cancel() // Upon receiving a signal, cancel the root context.
 		case <-ctx.Done():
 		}
-		tt.Target.StopSubscription("sub1")
+		signal.Stop(sigs)
 	}()
+	return ctx, cancel
+}
 
-	// Start subscription in a goroutine.
-	go tt.Target.Subscribe(ctx, subReq, "sub1")
+// run collects telemetry from every configured target until the process
+// receives SIGINT or SIGTERM.
+func run(opts options) error {
+	conf, secrets, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	username := os.Getenv("GNMI_USER")
+	password := os.Getenv("PASSWORD")
 
-	// Read subscriptions and handle responses or errors.
-	subRspChan, subErrChan := tt.Target.ReadSubscriptions()
-	for {
-		select {
-		case rsp := <-subRspChan:
-			// Processing subscription response...
-			options := &formatters.MarshalOptions{Multiline: true, Indent: " "}
-			jsonOutput, err := options.Marshal(rsp.Response, nil)
-			if err != nil {
-				log.Printf("error with JSON serialization %v", err)
-				continue
-			}
+	ctx, cancel := signalContext()
+	defer cancel() // Ensure resources are cleaned up when run exits.
 
-			if len(jsonOutput) > 0 {
-				log.Printf("Event at: %s for %s\n", time.Now().Format("2006-01-02 15:04:05"), tt.Config.Name)
-				log.Printf("Debug: JSON Output = %s\n", string(jsonOutput))
-				publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
-				err = sendToNats(publishCtx, tt.Config.NatsURL, string(jsonOutput), tt.Config.Topic)
-				cancel() // Ensure to cancel the context after use to release resources.
-				if err != nil {
-					log.Printf("Error sending to NATS: %v", err)
-				}
-			}
-		case <-ctx.Done():
-			// Context cancelled, exit function.
-			return nil
-		case tgErr := <-subErrChan:
-			// Log errors from the subscription and decide on further action (continue or return).
-			log.Printf("subscription %q stopped: %v", tgErr.SubscriptionName, tgErr.Err)
-			continue
+	if conf.MetricsAddress != "" {
+		go serveMetrics(conf.MetricsAddress)
+	}
+	if conf.DebugAddress != "" {
+		go serveDebug(conf.DebugAddress)
+	}
+	tracer := tracing.New(conf.Tracing, "nats-gnmi-publisher")
+	collector.SetTracer(tracer)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error exporting spans", "error", err)
 		}
+	}()
+	if secrets != nil {
+		go secrets.RenewToken(ctx)
 	}
-}
 
-func readConfig(filename string) (Config, error) {
-	file, err := os.Open(filename)
+	nc, out, closeOutputs, err := openOutputs(ctx, conf)
 	if err != nil {
-		return Config{}, fmt.Errorf("error opening YAML file: %v", err)
+		return err
 	}
-	defer file.Close()
-
-	yamlFile, err := io.ReadAll(file)
-	if err != nil {
-		return Config{}, fmt.Errorf("error reading YAML file: %v", err)
+	defer closeOutputs()
+
+	// Start one collector per target. Each runs independently so a failure on
+	// one device does not stop collection from the others.
+	c := collector.New(ctx, nc, out, username, password, conf)
+	if conf.Snapshots.Enabled() {
+		if c.Snapshots, err = nc.OpenSnapshotStore(conf.Snapshots); err != nil {
+			return err
+		}
 	}
-
-	var conf Config
-	err = yaml.Unmarshal(yamlFile, &conf)
-	if err != nil {
-		return Config{}, fmt.Errorf("Error parsing YAML file: %v", err)
+	// With HA the targets only start once this instance holds the lease.
+	if conf.HA.Enabled {
+		if err := c.StartLeaderElection(conf.HA); err != nil {
+			return err
+		}
 	}
-
-	return conf, nil
-}
-
-func sendToNats(ctx context.Context, natsURL, telemetryData, subject string) error {
-	// Establish a connection with a timeout.
-	nc, err := nats.Connect(
-		natsURL,
-		nats.Timeout(5*time.Second), // Set a connection timeout.
-	)
-	if err != nil {
-		return fmt.Errorf("error connecting to NATS: %v", err)
-	}
-	defer nc.Close()
-
-	// Check if context is done before trying to publish to prevent hanging when NATS server is not responsive.
-	select {
-	case <-ctx.Done():
-		return fmt.Errorf("context cancelled before sending message: %v", ctx.Err())
-	default:
-		// Try to send the message.
-		if err := nc.Publish(subject, []byte(telemetryData)); err != nil {
-			return fmt.Errorf("failed to send message to NATS: %v", err)
+	// Tunnel targets only start once their device connects to the server.
+	if conf.TunnelServer.Enabled() {
+		if err := c.ServeTunnel(conf.TunnelServer); err != nil {
+			return err
+		}
+	}
+	c.Apply(conf.TargetConfigs())
+	if conf.Control.Enabled {
+		if err := c.ServeControl(conf.Control, opts.load); err != nil {
+			return err
 		}
-		log.Printf("Message sent to NATS on subject: %s", subject)
 	}
 
+	// Pick up target changes on SIGHUP without restarting.
+	go c.ReloadOnSIGHUP(opts.load)
+	// Pause publishing on SIGUSR1 and resume it on SIGUSR2.
+	go c.PauseOnSignals()
+	if conf.TargetsDir.Path != "" {
+		go c.WatchTargetsDir(conf.TargetsDir, opts.load)
+	}
+	if conf.Inventory.Enabled() {
+		go c.WatchInventory(conf.Inventory, opts.load)
+	}
+	go c.NotifySystemd()
+	if conf.SelfTelemetry.Enabled {
+		go c.ReportStats()
+	}
+
+	<-ctx.Done()
+	c.Wait()
 	return nil
 }
 
-func main() {
-	// Load credentials from the environment file.
-	if err := godotenv.Load("./config/creds.env"); err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+// openOutputs opens a single NATS connection shared by every target for the
+// life of the process, unless neither the sink nor the request services need
+// it, and builds the sink chain on top of it. Credentials and TLS settings
+// from the environment take precedence over the config file. When an
+// embedded server is configured it is started first and the connection goes
+// to it. The returned function flushes the sink, then closes the connection
+// and stops the embedded server.
+func openOutputs(ctx context.Context, conf config.Config) (*sink.NATS, sink.Sink, func(), error) {
+	stopEmbedded := func() {}
+	if conf.EmbeddedNats.Enabled {
+		url, stop, err := startEmbeddedNats(conf.EmbeddedNats, conf.JetStream.Enabled)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		conf.NatsURL, stopEmbedded = url, stop
 	}
 
-	username := os.Getenv("GNMI_USER")
-	password := os.Getenv("PASSWORD")
-
-	// Load configuration.
-	conf, err := readConfig("./config/config.yaml")
-	if err != nil {
-		log.Fatalf("Could not read config: %v", err)
+	var nc *sink.NATS
+	if conf.Sink.UsesNats() || conf.GetProxy.Enabled || conf.SetRelay.Enabled || conf.DeadLetter.Subject != "" || conf.HA.Enabled || conf.Control.Enabled {
+		natsOpts, err := conf.NatsOptions(ctx)
+		if err != nil {
+			stopEmbedded()
+			return nil, nil, nil, err
+		}
+		nc, err = sink.Connect(conf.NatsURL, natsOpts...)
+		if err != nil {
+			stopEmbedded()
+			return nil, nil, nil, fmt.Errorf("could not connect to NATS: %w", err)
+		}
+	}
+	closeNats := func() {
+		if nc != nil {
+			if err := nc.Close(); err != nil {
+				slog.Error("Error closing NATS connection", "error", err)
+			}
+		}
+		stopEmbedded()
 	}
 
-	// Establish a root context with cancellation.
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel() // Ensure resources are cleaned up when main exits.
-
-	// Setup channel and notify for SIGINT and SIGTERM signals.
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	if conf.JetStream.Enabled && conf.Sink.UsesNats() {
+		if err := nc.EnableJetStream(conf.JetStream, conf.Subjects()); err != nil {
+			closeNats()
+			return nil, nil, nil, fmt.Errorf("could not set up JetStream: %w", err)
+		}
+	}
 
-	// Launch a goroutine to handle termination signals.
-	go func() {
-		<-sigs
-		log.Println("Received termination signal, shutting down...")
-		cancel() // Upon receiving a signal, cancel the root context.
-	}()
-	// Use NewTelemetryTarget to create the telemetry target
-	tt, err := NewTelemetryTarget(ctx, conf, username, password)
+	out, err := sink.New(conf.Sink, nc)
 	if err != nil {
-		log.Fatalf("Failed to create telemetry target: %v", err)
+		closeNats()
+		return nil, nil, nil, err
+	}
+	if conf.DeadLetter.Enabled() {
+		out, err = sink.NewRetry(out, conf.DeadLetter, nc)
+		if err != nil {
+			closeNats()
+			return nil, nil, nil, err
+		}
+	}
+	if conf.Signing.Enabled() {
+		out, err = sink.NewSign(out, conf.Signing)
+		if err != nil {
+			closeNats()
+			return nil, nil, nil, err
+		}
+	}
+	if conf.Compression.Enabled() {
+		out, err = sink.NewCompress(out, conf.Compression)
+		if err != nil {
+			closeNats()
+			return nil, nil, nil, err
+		}
 	}
-	// Start telemetry collection with the root context.
-	collectTelemetry(ctx, tt)
+	if conf.Batch.Enabled() {
+		out = sink.NewBatch(out, conf.Batch)
+	}
+	// Pending batches are flushed before the connection is closed.
+	return nc, out, func() {
+		if err := out.Close(); err != nil {
+			slog.Error("Error closing sink", "error", err)
+		}
+		closeNats()
+	}, nil
 }
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/collector"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// A capture file is a stream of records, each a varint length followed by a
+// protobuf message with this schema:
+//
+//	message Record {
+//	  string target = 1;
+//	  string subscription = 2;
+//	  int64 received_unix_nano = 3;
+//	  gnmi.SubscribeResponse response = 4;
+//	}
+//
+// This is the framing of Java's writeDelimitedTo and Go's protodelim, so
+// captures can be read by other tools as well.
+const (
+	recordTarget       protowire.Number = 1
+	recordSubscription protowire.Number = 2
+	recordReceived     protowire.Number = 3
+	recordResponse     protowire.Number = 4
+)
+
+// recorder appends raw SubscribeResponses to a capture file.
+type recorder struct {
+	mu    sync.Mutex
+	f     *os.File
+	w     *bufio.Writer
+	count int
+}
+
+func newRecorder(path string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening capture file: %w", err)
+	}
+	return &recorder{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// Record appends rsp with its metadata. Each record is flushed so a capture
+// stays readable if the process is killed.
+func (r *recorder) Record(target, subscription string, received time.Time, rsp *gnmi.SubscribeResponse) error {
+	data, err := proto.Marshal(rsp)
+	if err != nil {
+		return err
+	}
+	var rec []byte
+	rec = protowire.AppendTag(rec, recordTarget, protowire.BytesType)
+	rec = protowire.AppendString(rec, target)
+	rec = protowire.AppendTag(rec, recordSubscription, protowire.BytesType)
+	rec = protowire.AppendString(rec, subscription)
+	rec = protowire.AppendTag(rec, recordReceived, protowire.VarintType)
+	rec = protowire.AppendVarint(rec, uint64(received.UnixNano()))
+	rec = protowire.AppendTag(rec, recordResponse, protowire.BytesType)
+	rec = protowire.AppendBytes(rec, data)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.w.Write(protowire.AppendVarint(nil, uint64(len(rec)))); err != nil {
+		return err
+	}
+	if _, err := r.w.Write(rec); err != nil {
+		return err
+	}
+	r.count++
+	return r.w.Flush()
+}
+
+func (r *recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.w.Flush(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
+
+// record subscribes to the configured targets like run, but writes the raw
+// SubscribeResponses to a capture file instead of publishing them, until
+// the process is stopped or the duration has passed.
+func record(opts options) error {
+	if opts.output == "" {
+		return fmt.Errorf("no capture file given, use --output")
+	}
+	conf, _, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	username := os.Getenv("GNMI_USER")
+	password := os.Getenv("PASSWORD")
+
+	rec, err := newRecorder(opts.output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := rec.Close(); err != nil {
+			slog.Error("Error closing capture file", "error", err)
+		}
+	}()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	if opts.duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, opts.duration)
+		defer cancel()
+	}
+
+	// Only the gNMI side of the collector is used: nothing is published and
+	// the NATS request services are left off.
+	conf.GetProxy.Enabled = false
+	conf.SetRelay.Enabled = false
+	conf.Capabilities.Enabled = false
+	c := collector.New(ctx, nil, nil, username, password, conf)
+	c.Recorder = rec
+	c.Apply(conf.TargetConfigs())
+	slog.Info("Recording", "file", opts.output, "targets", len(conf.TargetConfigs()))
+
+	<-ctx.Done()
+	c.Wait()
+	slog.Info("Recording finished", "file", opts.output, "responses", rec.count)
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/collector"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	target "github.com/openconfig/gnmic/target"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxRecordSize bounds the length prefix of a capture record, so a corrupt
+// file fails cleanly instead of allocating an absurd buffer.
+const maxRecordSize = 64 << 20
+
+// capturedResponse is a record read back from a capture file.
+type capturedResponse struct {
+	target       string
+	subscription string
+	received     time.Time
+	response     *gnmi.SubscribeResponse
+}
+
+// readRecord reads the next record written by recorder. It returns io.EOF
+// at the end of the file.
+func readRecord(r *bufio.Reader) (capturedResponse, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return capturedResponse{}, err
+	}
+	if size > maxRecordSize {
+		return capturedResponse{}, fmt.Errorf("record of %d bytes exceeds the limit", size)
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return capturedResponse{}, fmt.Errorf("truncated record: %w", err)
+	}
+	return parseRecord(buf)
+}
+
+func parseRecord(b []byte) (capturedResponse, error) {
+	var rec capturedResponse
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return rec, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == recordTarget && typ == protowire.BytesType:
+			rec.target, n = protowire.ConsumeString(b)
+		case num == recordSubscription && typ == protowire.BytesType:
+			rec.subscription, n = protowire.ConsumeString(b)
+		case num == recordReceived && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			rec.received = time.Unix(0, int64(v))
+		case num == recordResponse && typ == protowire.BytesType:
+			var data []byte
+			data, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				rec.response = new(gnmi.SubscribeResponse)
+				if err := proto.Unmarshal(data, rec.response); err != nil {
+					return rec, fmt.Errorf("invalid response: %w", err)
+				}
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return rec, protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	if rec.response == nil {
+		return rec, fmt.Errorf("record has no response")
+	}
+	return rec, nil
+}
+
+// replay reads a capture file and feeds each response through the encoding
+// and publishing path of its target, as if it had just been received. The
+// original spacing between responses is kept, divided by the speed; with a
+// speed of 0 they are published as fast as the sink accepts them.
+func replay(opts options) error {
+	if opts.input == "" {
+		return fmt.Errorf("no capture file given, use --input")
+	}
+	if opts.speed < 0 {
+		return fmt.Errorf("speed must not be negative")
+	}
+	conf, _, err := setup(opts)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(opts.input)
+	if err != nil {
+		return fmt.Errorf("error opening capture file: %w", err)
+	}
+	defer f.Close()
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	// Only publishing is needed; nothing answers requests for the targets.
+	conf.GetProxy.Enabled = false
+	conf.SetRelay.Enabled = false
+	_, out, closeOutputs, err := openOutputs(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer closeOutputs()
+
+	p := newReplayer(ctx, out, conf)
+	defer p.stop()
+
+	r := bufio.NewReader(f)
+	var first, start time.Time
+	replayed := 0
+	for ctx.Err() == nil {
+		rec, err := readRecord(r)
+		if errors.Is(err, io.EOF) {
+			if !opts.loop {
+				break
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("error rewinding capture file: %w", err)
+			}
+			r.Reset(f)
+			first = time.Time{}
+			continue
+		} else if err != nil {
+			return fmt.Errorf("error reading %s: %w", opts.input, err)
+		}
+
+		if opts.speed > 0 {
+			if first.IsZero() {
+				first, start = rec.received, time.Now()
+			}
+			due := start.Add(time.Duration(float64(rec.received.Sub(first)) / opts.speed))
+			if d := time.Until(due); d > 0 {
+				select {
+				case <-ctx.Done():
+					continue
+				case <-time.After(d):
+				}
+			}
+		}
+		if tt := p.target(rec.target); tt != nil {
+			tt.HandleResponse(p.ctx, &target.SubscribeResponse{
+				SubscriptionName: rec.subscription,
+				Response:         rec.response,
+			})
+			replayed++
+		}
+	}
+	slog.Info("Replay finished", "file", opts.input, "responses", replayed)
+	return nil
+}
+
+// replayer holds a collector.Target, and its publishing goroutine, for each
+// configured target that appears in a capture.
+type replayer struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	sink    sink.Sink
+	conf    config.Config
+	configs map[string]config.TargetConfig
+	targets map[string]*collector.Target
+	wg      sync.WaitGroup
+}
+
+func newReplayer(ctx context.Context, out sink.Sink, conf config.Config) *replayer {
+	p := &replayer{
+		sink:    out,
+		conf:    conf,
+		configs: make(map[string]config.TargetConfig),
+		targets: make(map[string]*collector.Target),
+	}
+	p.ctx, p.cancel = context.WithCancel(ctx)
+	for _, tc := range conf.TargetConfigs() {
+		p.configs[tc.Name] = tc
+	}
+	return p
+}
+
+// target returns the collector.Target for name, creating it on first use. It
+// returns nil when the target is not configured, as its subjects and
+// encoding are unknown.
+func (p *replayer) target(name string) *collector.Target {
+	if tt, ok := p.targets[name]; ok {
+		return tt
+	}
+	tc, ok := p.configs[name]
+	if !ok {
+		slog.Warn("Skipping responses of a target that is not configured", "target", name)
+		p.targets[name] = nil
+		return nil
+	}
+	tt, err := collector.NewOfflineTarget(p.ctx, tc, p.sink, p.conf)
+	if err != nil {
+		slog.Error("Failed to create telemetry target", "target", name, "error", err)
+		p.targets[name] = nil
+		return nil
+	}
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		tt.RunPublisher(p.ctx)
+	}()
+	p.targets[name] = tt
+	return tt
+}
+
+// stop flushes the publish queues of the targets and waits for them.
+func (p *replayer) stop() {
+	p.cancel()
+	p.wg.Wait()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/logging"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+const usage = `Usage: simulator [command] [flags]
+
+Commands:
+  run       serve simulated interface telemetry over gNMI (default)
+  validate  check the configuration and exit
+  version   print the version and exit
+
+Run 'simulator <command> -h' for the flags of a command.
+`
+
+// run serves gNMI on the configured address until the process receives
+// SIGINT or SIGTERM.
+func run(conf Config) error {
+	var opts []grpc.ServerOption
+	if conf.TLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(conf.TLSCert, conf.TLSKey)
+		if err != nil {
+			return fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	unary, stream := authenticate(conf.Username, conf.Password)
+	opts = append(opts, grpc.UnaryInterceptor(unary), grpc.StreamInterceptor(stream))
+
+	lis, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	dev := newDevice(conf.Interfaces, conf.TrafficRate)
+	go dev.flap(ctx, conf.FlapInterval)
+
+	srv := grpc.NewServer(opts...)
+	gnmi.RegisterGNMIServer(srv, &server{dev: dev, hostname: conf.Hostname})
+	go func() {
+		<-ctx.Done()
+		slog.Info("Received termination signal, shutting down...")
+		// Subscriptions never finish on their own, so do not wait for them.
+		srv.Stop()
+	}()
+
+	slog.Info("Serving gNMI", "address", lis.Addr().String(), "hostname", conf.Hostname,
+		"interfaces", conf.Interfaces, "tls", conf.TLSCert != "")
+	return srv.Serve(lis)
+}
+
+func main() {
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "run", "validate":
+		conf, err := loadConfig(cmd, args)
+		if err == flag.ErrHelp {
+			return
+		} else if err != nil {
+			logging.Fatal("Could not load config", "error", err)
+		}
+		if err := logging.Setup(conf.LogLevel, conf.LogFormat); err != nil {
+			logging.Fatal("Invalid logging config", "error", err)
+		}
+		if err := conf.validate(); err != nil {
+			logging.Fatal("Invalid config", "error", err)
+		}
+		if cmd == "validate" {
+			fmt.Printf("configuration is valid: %d interface(s) on %s\n", conf.Interfaces, conf.Address)
+			return
+		}
+		if err := run(conf); err != nil {
+			logging.Fatal("Simulator failed", "command", cmd, "error", err)
+		}
+	case "version":
+		fmt.Printf("simulator %s\n", version)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/envsubst"
+	"gopkg.in/yaml.v3"
+	"os"
+	"time"
+)
+
+// Config holds the simulator settings. Values are taken from the optional
+// YAML file, then command line flags.
+type Config struct {
+	Address  string `yaml:"address"`
+	Hostname string `yaml:"hostname"`
+
+	// Username and Password, when set, are required in the metadata of
+	// every RPC, as gNMI clients send them.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// TLSCert and TLSKey serve gNMI over TLS; without them the server is
+	// plaintext and clients must set insecure.
+	TLSCert string `yaml:"tls_cert"`
+	TLSKey  string `yaml:"tls_key"`
+
+	// Interfaces is the number of simulated interfaces, named Ethernet1 up.
+	Interfaces int `yaml:"interfaces"`
+	// TrafficRate is the average rate, in bytes per second, that the
+	// counters of each interface that is up grow at.
+	TrafficRate float64 `yaml:"traffic_rate"`
+	// FlapInterval is how often a random interface changes its oper-status.
+	// Zero keeps every interface up.
+	FlapInterval time.Duration `yaml:"flap_interval"`
+
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"`
+}
+
+func readConfig(filename string) (Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading YAML file: %v", err)
+	}
+
+	// A negative flap interval marks it unset, as zero disables flapping.
+	conf := Config{FlapInterval: -1}
+	if err := yaml.Unmarshal(envsubst.Expand(data), &conf); err != nil {
+		return Config{}, fmt.Errorf("error parsing YAML file: %v", err)
+	}
+	return conf, nil
+}
+
+// loadConfig parses the command line flags of the named command and builds
+// the effective configuration.
+func loadConfig(name string, args []string) (Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to an optional YAML config file")
+	address := fs.String("address", "", "address to serve gNMI on (default :57400)")
+	hostname := fs.String("hostname", "", "name the simulated device reports (default sim1)")
+	interfaces := fs.Int("interfaces", 0, "number of simulated interfaces (default 8)")
+	flap := fs.Duration("flap-interval", -1, "how often an interface changes oper-status, 0 to disable (default 1m)")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	conf := Config{FlapInterval: -1}
+	if *configFile != "" {
+		var err error
+		if conf, err = readConfig(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+
+	setIfNotEmpty(&conf.Address, *address)
+	setIfNotEmpty(&conf.Hostname, *hostname)
+	if *interfaces > 0 {
+		conf.Interfaces = *interfaces
+	}
+	if *flap >= 0 {
+		conf.FlapInterval = *flap
+	}
+	setIfNotEmpty(&conf.LogLevel, *logLevel)
+
+	if conf.Address == "" {
+		conf.Address = ":57400"
+	}
+	if conf.Hostname == "" {
+		conf.Hostname = "sim1"
+	}
+	if conf.Interfaces == 0 {
+		conf.Interfaces = 8
+	}
+	if conf.TrafficRate == 0 {
+		conf.TrafficRate = 1e6
+	}
+	if conf.FlapInterval < 0 {
+		conf.FlapInterval = time.Minute
+	}
+	return conf, nil
+}
+
+// validate reports invalid settings.
+func (c Config) validate() error {
+	if c.Interfaces < 0 {
+		return fmt.Errorf("interfaces must not be negative")
+	}
+	if c.TrafficRate < 0 {
+		return fmt.Errorf("traffic_rate must not be negative")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be set together")
+	}
+	return nil
+}
+
+func setIfNotEmpty(dst *string, v string) {
+	if v != "" {
+		*dst = v
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// avgPacketSize converts the simulated byte rate to a packet rate.
+const avgPacketSize = 500
+
+// leaf is a single value of the simulated device.
+type leaf struct {
+	path  []*gnmi.PathElem
+	value interface{}
+}
+
+type iface struct {
+	name       string
+	operUp     bool
+	lastChange int64
+
+	inOctets, outOctets     uint64
+	inPkts, outPkts         uint64
+	inErrors, outErrors     uint64
+	inDiscards, outDiscards uint64
+}
+
+// device simulates the openconfig-interfaces state of a switch. Counters of
+// interfaces that are up grow with the time since they were last read, and
+// a random interface goes down or comes back up every flap interval.
+type device struct {
+	rate float64
+
+	mu       sync.Mutex
+	rnd      *rand.Rand
+	ifaces   []*iface
+	updated  time.Time
+	watchers map[chan string]struct{}
+}
+
+func newDevice(interfaces int, rate float64) *device {
+	now := time.Now()
+	d := &device{
+		rate:     rate,
+		rnd:      rand.New(rand.NewSource(now.UnixNano())),
+		updated:  now,
+		watchers: make(map[chan string]struct{}),
+	}
+	for i := 1; i <= interfaces; i++ {
+		d.ifaces = append(d.ifaces, &iface{
+			name:       fmt.Sprintf("Ethernet%d", i),
+			operUp:     true,
+			lastChange: now.UnixNano(),
+		})
+	}
+	return d
+}
+
+// advance grows the counters up to now. d.mu must be held.
+func (d *device) advance(now time.Time) {
+	elapsed := now.Sub(d.updated).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	d.updated = now
+	for _, i := range d.ifaces {
+		if !i.operUp {
+			continue
+		}
+		// Jitter each direction by up to 20% so the rates look alive.
+		in := uint64(d.rate * elapsed * (0.8 + 0.4*d.rnd.Float64()))
+		out := uint64(d.rate * elapsed * (0.8 + 0.4*d.rnd.Float64()))
+		i.inOctets += in
+		i.outOctets += out
+		i.inPkts += in / avgPacketSize
+		i.outPkts += out / avgPacketSize
+		// Roughly one error and one discard in a million packets.
+		if d.rnd.Float64() < float64(in/avgPacketSize)/1e6 {
+			i.inErrors++
+		}
+		if d.rnd.Float64() < float64(out/avgPacketSize)/1e6 {
+			i.outErrors++
+		}
+		if d.rnd.Float64() < float64(in/avgPacketSize)/1e6 {
+			i.inDiscards++
+		}
+		if d.rnd.Float64() < float64(out/avgPacketSize)/1e6 {
+			i.outDiscards++
+		}
+	}
+}
+
+// leaves returns every value of the device, or of the named interface only
+// when name is not empty.
+func (d *device) leaves(name string) []leaf {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.advance(time.Now())
+
+	var leaves []leaf
+	for _, i := range d.ifaces {
+		if name != "" && i.name != name {
+			continue
+		}
+		status := "DOWN"
+		if i.operUp {
+			status = "UP"
+		}
+		state := func(elems ...string) []*gnmi.PathElem {
+			p := []*gnmi.PathElem{
+				{Name: "interfaces"},
+				{Name: "interface", Key: map[string]string{"name": i.name}},
+				{Name: "state"},
+			}
+			for _, e := range elems {
+				p = append(p, &gnmi.PathElem{Name: e})
+			}
+			return p
+		}
+		leaves = append(leaves,
+			leaf{state("name"), i.name},
+			leaf{state("admin-status"), "UP"},
+			leaf{state("oper-status"), status},
+			leaf{state("last-change"), uint64(i.lastChange)},
+			leaf{state("counters", "in-octets"), i.inOctets},
+			leaf{state("counters", "out-octets"), i.outOctets},
+			leaf{state("counters", "in-unicast-pkts"), i.inPkts},
+			leaf{state("counters", "out-unicast-pkts"), i.outPkts},
+			leaf{state("counters", "in-errors"), i.inErrors},
+			leaf{state("counters", "out-errors"), i.outErrors},
+			leaf{state("counters", "in-discards"), i.inDiscards},
+			leaf{state("counters", "out-discards"), i.outDiscards},
+		)
+	}
+	return leaves
+}
+
+// flap toggles the oper-status of a random interface every interval until
+// ctx is cancelled, and tells the watchers which interface changed.
+func (d *device) flap(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || len(d.ifaces) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			d.mu.Lock()
+			d.advance(now)
+			i := d.ifaces[d.rnd.Intn(len(d.ifaces))]
+			i.operUp = !i.operUp
+			i.lastChange = now.UnixNano()
+			up := i.operUp
+			for ch := range d.watchers {
+				select {
+				case ch <- i.name:
+				default:
+				}
+			}
+			d.mu.Unlock()
+			slog.Info("Interface changed state", "interface", i.name, "up", up)
+		}
+	}
+}
+
+// watch returns a channel receiving the names of interfaces that changed
+// state, and a function to stop watching.
+func (d *device) watch() (<-chan string, func()) {
+	ch := make(chan string, 16)
+	d.mu.Lock()
+	d.watchers[ch] = struct{}{}
+	d.mu.Unlock()
+	return ch, func() {
+		d.mu.Lock()
+		delete(d.watchers, ch)
+		d.mu.Unlock()
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultSampleInterval applies to SAMPLE subscriptions without an interval.
+const defaultSampleInterval = 10 * time.Second
+
+// server implements the gNMI service on top of a simulated device. Set is
+// not supported.
+type server struct {
+	gnmi.UnimplementedGNMIServer
+	dev      *device
+	hostname string
+}
+
+// authenticate checks the username and password metadata of each RPC when
+// credentials are configured.
+func authenticate(username, password string) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	check := func(ctx context.Context) error {
+		if username == "" {
+			return nil
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		if first(md.Get("username")) != username || first(md.Get("password")) != password {
+			return status.Error(codes.Unauthenticated, "invalid username or password")
+		}
+		return nil
+	}
+	unary := func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := check(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	stream := func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := check(ss.Context()); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+	return unary, stream
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (s *server) Capabilities(context.Context, *gnmi.CapabilityRequest) (*gnmi.CapabilityResponse, error) {
+	return &gnmi.CapabilityResponse{
+		SupportedModels: []*gnmi.ModelData{
+			{Name: "openconfig-interfaces", Organization: "OpenConfig working group", Version: "3.0.0"},
+		},
+		SupportedEncodings: []gnmi.Encoding{gnmi.Encoding_JSON, gnmi.Encoding_JSON_IETF, gnmi.Encoding_PROTO},
+		GNMIVersion:        "0.8.0",
+	}, nil
+}
+
+func (s *server) Get(_ context.Context, req *gnmi.GetRequest) (*gnmi.GetResponse, error) {
+	if err := checkEncoding(req.GetEncoding()); err != nil {
+		return nil, err
+	}
+	rsp := &gnmi.GetResponse{}
+	for _, p := range req.GetPath() {
+		notif := s.notification(req.GetPrefix(), []*gnmi.Path{p}, "", req.GetEncoding())
+		if notif == nil {
+			return nil, status.Errorf(codes.NotFound, "no data at %v", p)
+		}
+		rsp.Notification = append(rsp.Notification, notif)
+	}
+	return rsp, nil
+}
+
+func checkEncoding(enc gnmi.Encoding) error {
+	switch enc {
+	case gnmi.Encoding_JSON, gnmi.Encoding_JSON_IETF, gnmi.Encoding_PROTO:
+		return nil
+	}
+	return status.Errorf(codes.Unimplemented, "encoding %s is not supported", enc)
+}
+
+// notification returns the device values under any of paths, relative to
+// prefix, or nil when there are none. name limits the values to a single
+// interface when it is not empty.
+func (s *server) notification(prefix *gnmi.Path, paths []*gnmi.Path, name string, enc gnmi.Encoding) *gnmi.Notification {
+	// Name the device in the prefix unless the client addressed it already.
+	if prefix.GetTarget() == "" {
+		prefix = &gnmi.Path{Origin: prefix.GetOrigin(), Elem: prefix.GetElem(), Target: s.hostname}
+	}
+	notif := &gnmi.Notification{Timestamp: time.Now().UnixNano(), Prefix: prefix}
+	for _, l := range s.dev.leaves(name) {
+		for _, p := range paths {
+			elems := append(append([]*gnmi.PathElem{}, prefix.GetElem()...), p.GetElem()...)
+			if !matches(elems, l.path) {
+				continue
+			}
+			notif.Update = append(notif.Update, &gnmi.Update{
+				Path: &gnmi.Path{Elem: l.path[len(prefix.GetElem()):]},
+				Val:  typedValue(l.value, enc),
+			})
+			break
+		}
+	}
+	if len(notif.Update) == 0 {
+		return nil
+	}
+	return notif
+}
+
+// matches reports whether the leaf at path is under pattern, in which names
+// and key values may be "*".
+func matches(pattern, path []*gnmi.PathElem) bool {
+	if len(pattern) > len(path) {
+		return false
+	}
+	for i, e := range pattern {
+		if e.GetName() != "*" && e.GetName() != path[i].GetName() {
+			return false
+		}
+		for k, v := range e.GetKey() {
+			if v != "*" && path[i].GetKey()[k] != v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func typedValue(v interface{}, enc gnmi.Encoding) *gnmi.TypedValue {
+	switch enc {
+	case gnmi.Encoding_JSON, gnmi.Encoding_JSON_IETF:
+		b, _ := json.Marshal(v)
+		if enc == gnmi.Encoding_JSON {
+			return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonVal{JsonVal: b}}
+		}
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_JsonIetfVal{JsonIetfVal: b}}
+	}
+	switch x := v.(type) {
+	case uint64:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_UintVal{UintVal: x}}
+	default:
+		return &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: fmt.Sprint(x)}}
+	}
+}
+
+func (s *server) Subscribe(stream gnmi.GNMI_SubscribeServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	list := req.GetSubscribe()
+	if list == nil {
+		return status.Error(codes.InvalidArgument, "the first request must be a SubscriptionList")
+	}
+	if err := checkEncoding(list.GetEncoding()); err != nil {
+		return err
+	}
+
+	sess := &session{server: s, stream: stream, list: list}
+	logger := slog.With("mode", list.GetMode(), "subscriptions", len(list.GetSubscription()))
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		logger = logger.With("client", p.Addr.String())
+	}
+	logger.Info("Subscription started")
+	defer logger.Info("Subscription ended")
+
+	switch list.GetMode() {
+	case gnmi.SubscriptionList_ONCE:
+		return sess.once()
+	case gnmi.SubscriptionList_POLL:
+		return sess.poll()
+	default:
+		return sess.subscribe(stream.Context())
+	}
+}
+
+// session serves one Subscribe RPC.
+type session struct {
+	server *server
+	list   *gnmi.SubscriptionList
+
+	// mu serializes Send, which is not safe for concurrent use.
+	mu     sync.Mutex
+	stream gnmi.GNMI_SubscribeServer
+}
+
+func (s *session) send(rsp *gnmi.SubscribeResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stream.Send(rsp)
+}
+
+// update sends the current values under the paths of subs, limited to the
+// named interface when name is not empty.
+func (s *session) update(subs []*gnmi.Subscription, name string) error {
+	paths := make([]*gnmi.Path, 0, len(subs))
+	for _, sub := range subs {
+		paths = append(paths, sub.GetPath())
+	}
+	notif := s.server.notification(s.list.GetPrefix(), paths, name, s.list.GetEncoding())
+	if notif == nil {
+		return nil
+	}
+	return s.send(&gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_Update{Update: notif}})
+}
+
+func (s *session) sync() error {
+	return s.send(&gnmi.SubscribeResponse{Response: &gnmi.SubscribeResponse_SyncResponse{SyncResponse: true}})
+}
+
+func (s *session) once() error {
+	if err := s.update(s.list.GetSubscription(), ""); err != nil {
+		return err
+	}
+	return s.sync()
+}
+
+func (s *session) poll() error {
+	if err := s.once(); err != nil {
+		return err
+	}
+	for {
+		req, err := s.stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if req.GetPoll() == nil {
+			return status.Error(codes.InvalidArgument, "only Poll requests may follow a POLL SubscriptionList")
+		}
+		if err := s.once(); err != nil {
+			return err
+		}
+	}
+}
+
+// subscribe sends the initial values and then samples or watches each
+// subscription until the client goes away.
+func (s *session) subscribe(ctx context.Context) error {
+	if !s.list.GetUpdatesOnly() {
+		if err := s.update(s.list.GetSubscription(), ""); err != nil {
+			return err
+		}
+	}
+	if err := s.sync(); err != nil {
+		return err
+	}
+
+	errc := make(chan error, 1)
+	var onChange []*gnmi.Subscription
+	for _, sub := range s.list.GetSubscription() {
+		switch sub.GetMode() {
+		case gnmi.SubscriptionMode_ON_CHANGE:
+			onChange = append(onChange, sub)
+			if hb := time.Duration(sub.GetHeartbeatInterval()); hb > 0 {
+				go s.every(ctx, hb, sub, errc)
+			}
+		default:
+			// SAMPLE, and TARGET_DEFINED which the simulator samples.
+			interval := time.Duration(sub.GetSampleInterval())
+			if interval <= 0 {
+				interval = defaultSampleInterval
+			}
+			go s.every(ctx, interval, sub, errc)
+		}
+	}
+	if len(onChange) > 0 {
+		changes, stop := s.server.dev.watch()
+		defer stop()
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case name := <-changes:
+					if err := s.update(onChange, name); err != nil {
+						report(errc, err)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+// every sends the values of sub each interval until ctx is cancelled.
+func (s *session) every(ctx context.Context, interval time.Duration, sub *gnmi.Subscription, errc chan<- error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.update([]*gnmi.Subscription{sub}, ""); err != nil {
+				report(errc, err)
+				return
+			}
+		}
+	}
+}
+
+// report passes err on unless an error is already pending.
+func report(errc chan<- error, err error) {
+	select {
+	case errc <- err:
+	default:
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// AlertingConfig evaluates Rules against the received values and notifies
+// Notifiers when an alert fires and when it resolves. An alert is kept per
+// rule, target and set of tags, so an alert already firing is not notified
+// again, except every RepeatInterval when it is set.
+type AlertingConfig struct {
+	Enabled        bool            `yaml:"enabled"`
+	Rules          []AlertRule     `yaml:"rules"`
+	Notifiers      []AlertNotifier `yaml:"notifiers"`
+	RepeatInterval time.Duration   `yaml:"repeat_interval"`
+}
+
+// AlertRule fires when the values of Path compare to Value with Operator,
+// one of ==, !=, >, >=, < or <=, for at least For. With Rate set, the rate
+// per second of the values is compared instead, for counters. Message is a
+// template of the notification text.
+type AlertRule struct {
+	Name     string        `yaml:"name"`
+	Path     string        `yaml:"path"`
+	Rate     bool          `yaml:"rate"`
+	Operator string        `yaml:"operator"`
+	Value    string        `yaml:"value"`
+	For      time.Duration `yaml:"for"`
+	Severity string        `yaml:"severity"`
+	Message  string        `yaml:"message"`
+}
+
+// AlertNotifier sends notifications to a Slack or Microsoft Teams incoming
+// webhook, or as JSON to any other webhook, signed with Secret when set.
+type AlertNotifier struct {
+	Type    string            `yaml:"type"`
+	URL     string            `yaml:"url"`
+	Secret  string            `yaml:"secret"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+func (a AlertingConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if len(a.Rules) == 0 {
+		return fmt.Errorf("alerting: at least one rule is required")
+	}
+	names := make(map[string]bool)
+	for _, r := range a.Rules {
+		if _, err := compileAlertRule(r); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("alerting: duplicate rule %q", r.Name)
+		}
+		names[r.Name] = true
+	}
+	for _, n := range a.Notifiers {
+		switch n.Type {
+		case "slack", "teams", "webhook":
+		default:
+			return fmt.Errorf("alerting: unknown notifier type %q", n.Type)
+		}
+		if _, err := url.ParseRequestURI(n.URL); err != nil {
+			return fmt.Errorf("alerting: invalid %s notifier url %q: %w", n.Type, n.URL, err)
+		}
+	}
+	return nil
+}
+
+// alertRule is a rule ready to be evaluated.
+type alertRule struct {
+	AlertRule
+	threshold float64
+	numeric   bool
+	message   *template.Template
+}
+
+func compileAlertRule(r AlertRule) (*alertRule, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("alerting: rule name is required")
+	}
+	if r.Path == "" {
+		return nil, fmt.Errorf("alerting: rule %s: path is required", r.Name)
+	}
+	rule := &alertRule{AlertRule: r}
+	f, err := strconv.ParseFloat(r.Value, 64)
+	rule.threshold, rule.numeric = f, err == nil
+	switch r.Operator {
+	case "==", "!=":
+	case ">", ">=", "<", "<=":
+		if !rule.numeric {
+			return nil, fmt.Errorf("alerting: rule %s: %s needs a numeric value, got %q", r.Name, r.Operator, r.Value)
+		}
+	default:
+		return nil, fmt.Errorf("alerting: rule %s: unknown operator %q", r.Name, r.Operator)
+	}
+	if r.Rate && !rule.numeric {
+		return nil, fmt.Errorf("alerting: rule %s: rate needs a numeric value, got %q", r.Name, r.Value)
+	}
+	if r.Message != "" {
+		if rule.message, err = template.New(r.Name).Option("missingkey=zero").Parse(r.Message); err != nil {
+			return nil, fmt.Errorf("alerting: rule %s: invalid message template: %w", r.Name, err)
+		}
+	}
+	return rule, nil
+}
+
+// matches reports whether v satisfies the rule. Numbers are compared as
+// numbers and anything else as text.
+func (r *alertRule) matches(v interface{}) bool {
+	if f, ok := numericValue(v); ok && r.numeric {
+		switch r.Operator {
+		case "==":
+			return f == r.threshold
+		case "!=":
+			return f != r.threshold
+		case ">":
+			return f > r.threshold
+		case ">=":
+			return f >= r.threshold
+		case "<":
+			return f < r.threshold
+		case "<=":
+			return f <= r.threshold
+		}
+	}
+	switch r.Operator {
+	case "==":
+		return valueText(v) == r.Value
+	case "!=":
+		return valueText(v) != r.Value
+	}
+	return false
+}
+
+// alert is the state of a rule for a target and set of tags, and the data
+// the message templates are executed with.
+type alert struct {
+	Rule      string            `json:"rule"`
+	Severity  string            `json:"severity,omitempty"`
+	Status    string            `json:"status"`
+	Target    string            `json:"target"`
+	Path      string            `json:"path"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Value     interface{}       `json:"value"`
+	Operator  string            `json:"operator"`
+	Threshold string            `json:"threshold"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    *time.Time        `json:"ends_at,omitempty"`
+	Message   string            `json:"message"`
+
+	// pending is when the rule started matching, and notified when the
+	// alert was last notified. last and lastTime are the previous sample,
+	// for rates.
+	pending  time.Time
+	notified time.Time
+	last     float64
+	lastTime time.Time
+	hasLast  bool
+}
+
+const (
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+)
+
+// alertNotifier formats notifications for a notifier and sends them.
+type alertNotifier struct {
+	typ    string
+	sender *webhookOutput
+}
+
+// alertOutput evaluates the rules against every received value.
+type alertOutput struct {
+	rules          []*alertRule
+	notifiers      []alertNotifier
+	repeatInterval time.Duration
+
+	mu     sync.Mutex
+	alerts map[string]*alert
+}
+
+func newAlertOutput(conf AlertingConfig) (*alertOutput, error) {
+	o := &alertOutput{repeatInterval: conf.RepeatInterval, alerts: make(map[string]*alert)}
+	for _, r := range conf.Rules {
+		rule, err := compileAlertRule(r)
+		if err != nil {
+			return nil, err
+		}
+		o.rules = append(o.rules, rule)
+	}
+	for _, n := range conf.Notifiers {
+		sender, err := newWebhookSender(WebhookConfig{URL: n.URL, Secret: n.Secret, Headers: n.Headers})
+		if err != nil {
+			o.Close()
+			return nil, err
+		}
+		o.notifiers = append(o.notifiers, alertNotifier{typ: n.Type, sender: sender})
+	}
+	slog.Info("Evaluating alert rules", "rules", len(o.rules), "notifiers", len(o.notifiers))
+	return o, nil
+}
+
+func (o *alertOutput) Write(_ context.Context, m *message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target, _, tags := splitTags(ev, m.Header.Get("Gnmi-Target"))
+		for _, rule := range o.rules {
+			v, ok := ev.Values[rule.Path]
+			if !ok {
+				continue
+			}
+			o.evaluate(rule, target, tags, v, ts)
+		}
+	}
+	return nil
+}
+
+// evaluate updates the alert of rule for target and tags with v, notifying
+// any change. o.mu must be held.
+func (o *alertOutput) evaluate(rule *alertRule, target string, tags map[string]string, v interface{}, ts time.Time) {
+	key := seriesKey(rule.Name, target, tags)
+	a, ok := o.alerts[key]
+	if !ok {
+		a = &alert{Rule: rule.Name, Severity: rule.Severity, Target: target, Path: rule.Path, Tags: tags,
+			Operator: rule.Operator, Threshold: rule.Value}
+		o.alerts[key] = a
+	}
+
+	if rule.Rate {
+		f, ok := numericValue(v)
+		if !ok {
+			return
+		}
+		prev, prevTime, hasPrev := a.last, a.lastTime, a.hasLast
+		a.last, a.lastTime, a.hasLast = f, ts, true
+		// The first sample and counter resets give no rate.
+		if !hasPrev || f < prev || !ts.After(prevTime) {
+			return
+		}
+		v = (f - prev) / ts.Sub(prevTime).Seconds()
+	}
+	a.Value = v
+
+	now := time.Now()
+	if !rule.matches(v) {
+		if a.Status == alertFiring {
+			a.Status, a.EndsAt = alertResolved, &ts
+			o.notify(rule, a)
+		}
+		a.Status, a.pending = "", time.Time{}
+		return
+	}
+	if a.pending.IsZero() {
+		a.pending = now
+	}
+	switch {
+	case a.Status != alertFiring && now.Sub(a.pending) >= rule.For:
+		a.Status, a.StartsAt, a.EndsAt = alertFiring, ts, nil
+		o.notify(rule, a)
+	case a.Status == alertFiring && o.repeatInterval > 0 && now.Sub(a.notified) >= o.repeatInterval:
+		o.notify(rule, a)
+	}
+}
+
+// seriesKey identifies the series of name, such as a rule or a path, for a
+// target and set of tags.
+func seriesKey(name, target string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(name + "\x00" + target)
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + tags[k])
+	}
+	return b.String()
+}
+
+// notify logs the current state of a and sends it to every notifier.
+// o.mu must be held.
+func (o *alertOutput) notify(rule *alertRule, a *alert) {
+	a.notified = time.Now()
+	a.Message = defaultAlertMessage(a)
+	if rule.message != nil {
+		var b strings.Builder
+		if err := rule.message.Execute(&b, a); err != nil {
+			slog.Error("Could not render alert message", "rule", rule.Name, "error", err)
+		} else {
+			a.Message = b.String()
+		}
+	}
+	slog.Warn("Alert "+a.Status, "rule", a.Rule, "target", a.Target, "path", a.Path, "value", a.Value, "message", a.Message)
+
+	for _, n := range o.notifiers {
+		body, err := n.body(a)
+		if err != nil {
+			slog.Error("Could not encode alert notification", "type", n.typ, "error", err)
+			continue
+		}
+		n.sender.enqueue(body)
+	}
+}
+
+// defaultAlertMessage returns a one line description of a, such as
+// "[FIRING] if-down: router1 /interfaces/interface/state/oper-status is DOWN
+// (== DOWN) interface_name=Ethernet1".
+func defaultAlertMessage(a *alert) string {
+	msg := fmt.Sprintf("[%s] %s: %s %s is %s (%s %s)",
+		strings.ToUpper(a.Status), a.Rule, a.Target, a.Path, valueText(a.Value), a.Operator, a.Threshold)
+	keys := make([]string, 0, len(a.Tags))
+	for k := range a.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		msg += " " + k + "=" + a.Tags[k]
+	}
+	return msg
+}
+
+// body returns the notification of a in the format of the notifier.
+func (n alertNotifier) body(a *alert) ([]byte, error) {
+	switch n.typ {
+	case "slack":
+		return json.Marshal(map[string]string{"text": a.Message})
+	case "teams":
+		color := "D70000"
+		if a.Status == alertResolved {
+			color = "2EB886"
+		}
+		return json.Marshal(map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"themeColor": color,
+			"summary":    a.Rule + " " + a.Status,
+			"text":       a.Message,
+		})
+	}
+	return json.Marshal(a)
+}
+
+// Close sends the queued notifications.
+func (o *alertOutput) Close() error {
+	for _, n := range o.notifiers {
+		n.sender.Close()
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AnomalyConfig flags the values that stray from their recent behaviour and
+// publishes an alert for each to NATS, on Subject followed by the direction
+// of the anomaly and the target, such as alerts.anomaly.drop.router1. The
+// mean and variance of each series are tracked as exponentially weighted
+// moving averages with weight Alpha, and a value is anomalous once its
+// z-score, the distance to the mean in standard deviations, exceeds
+// Threshold. Series are not flagged before Warmup values. Anomalous values
+// are left out of the averages, so an alert lasts until the series is back
+// to its usual behaviour.
+type AnomalyConfig struct {
+	Enabled   bool            `yaml:"enabled"`
+	Subject   string          `yaml:"subject"`
+	Series    []AnomalySeries `yaml:"series"`
+	Alpha     float64         `yaml:"alpha"`
+	Threshold float64         `yaml:"threshold"`
+	Warmup    int             `yaml:"warmup"`
+}
+
+// AnomalySeries selects the values whose path matches the regular
+// expression Path, and the Direction of the anomalies to flag: drop, spike
+// or both. With Rate set, the rate per second of the values is watched
+// instead, for counters.
+type AnomalySeries struct {
+	Path      string `yaml:"path"`
+	Rate      bool   `yaml:"rate"`
+	Direction string `yaml:"direction"`
+}
+
+const (
+	defaultAnomalySubject   = "alerts.anomaly"
+	defaultAnomalyAlpha     = 0.1
+	defaultAnomalyThreshold = 4
+	defaultAnomalyWarmup    = 10
+)
+
+// defaultAnomalySeries flags traffic drops and error spikes on interfaces.
+var defaultAnomalySeries = []AnomalySeries{
+	{Path: "/counters/(in|out)-octets$", Rate: true, Direction: "drop"},
+	{Path: "/counters/(in|out)-(errors|discards)$", Rate: true, Direction: "spike"},
+}
+
+func (a AnomalyConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.Alpha < 0 || a.Alpha >= 1 {
+		return fmt.Errorf("anomaly: alpha must be between 0 and 1")
+	}
+	if a.Threshold < 0 {
+		return fmt.Errorf("anomaly: threshold must not be negative")
+	}
+	_, err := compileAnomalySeries(a.Series)
+	return err
+}
+
+// anomalySeries is a series selector ready to be matched.
+type anomalySeries struct {
+	AnomalySeries
+	path *regexp.Regexp
+}
+
+func compileAnomalySeries(series []AnomalySeries) ([]anomalySeries, error) {
+	if len(series) == 0 {
+		series = defaultAnomalySeries
+	}
+	res := make([]anomalySeries, len(series))
+	for i, s := range series {
+		switch s.Direction {
+		case "drop", "spike", "both":
+		case "":
+			s.Direction = "both"
+		default:
+			return nil, fmt.Errorf("anomaly: unknown direction %q", s.Direction)
+		}
+		re, err := regexp.Compile(s.Path)
+		if err != nil {
+			return nil, fmt.Errorf("anomaly: invalid path expression %q: %w", s.Path, err)
+		}
+		res[i] = anomalySeries{AnomalySeries: s, path: re}
+	}
+	return res, nil
+}
+
+// anomaly is a published alert.
+type anomaly struct {
+	Status    string            `json:"status"`
+	Direction string            `json:"direction"`
+	Target    string            `json:"target"`
+	Path      string            `json:"path"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Value     float64           `json:"value"`
+	Mean      float64           `json:"mean"`
+	ZScore    float64           `json:"zscore"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// ewma is the state of a series: the weighted mean and variance of its
+// values, the previous sample for rates, and the direction of the anomaly it
+// is in, if any.
+type ewma struct {
+	mean     float64
+	variance float64
+	samples  int
+	last     float64
+	lastTime time.Time
+	hasLast  bool
+	flagged  string
+}
+
+// anomalyDetector watches the configured series and publishes an alert when
+// one becomes anomalous and when it recovers.
+type anomalyDetector struct {
+	conf   AnomalyConfig
+	series []anomalySeries
+	nc     *nats.Conn
+
+	mu    sync.Mutex
+	state map[string]*ewma
+}
+
+func newAnomalyDetector(conf AnomalyConfig, nc *nats.Conn) (*anomalyDetector, error) {
+	if conf.Subject == "" {
+		conf.Subject = defaultAnomalySubject
+	}
+	if conf.Alpha == 0 {
+		conf.Alpha = defaultAnomalyAlpha
+	}
+	if conf.Threshold == 0 {
+		conf.Threshold = defaultAnomalyThreshold
+	}
+	if conf.Warmup <= 0 {
+		conf.Warmup = defaultAnomalyWarmup
+	}
+	series, err := compileAnomalySeries(conf.Series)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Detecting anomalies", "series", len(series), "subject", conf.Subject+".>")
+	return &anomalyDetector{conf: conf, series: series, nc: nc, state: make(map[string]*ewma)}, nil
+}
+
+func (d *anomalyDetector) Write(_ context.Context, m *message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target, _, tags := splitTags(ev, m.Header.Get("Gnmi-Target"))
+		for path, v := range ev.Values {
+			f, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			for _, s := range d.series {
+				if s.path.MatchString(path) {
+					d.observe(s, target, path, tags, f, ts)
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// observe adds f to the series of path for target and tags, publishing any
+// change of its anomaly. d.mu must be held.
+func (d *anomalyDetector) observe(s anomalySeries, target, path string, tags map[string]string, f float64, ts time.Time) {
+	key := seriesKey(path, target, tags)
+	e, ok := d.state[key]
+	if !ok {
+		e = &ewma{}
+		d.state[key] = e
+	}
+
+	if s.Rate {
+		prev, prevTime, hasPrev := e.last, e.lastTime, e.hasLast
+		e.last, e.lastTime, e.hasLast = f, ts, true
+		// The first sample and counter resets give no rate.
+		if !hasPrev || f < prev || !ts.After(prevTime) {
+			return
+		}
+		f = (f - prev) / ts.Sub(prevTime).Seconds()
+	}
+
+	// The z-score is taken against the averages before f. A series that
+	// has been constant has no deviation, so any change is anomalous.
+	mean, diff := e.mean, f-e.mean
+	z := 0.0
+	if std := math.Sqrt(e.variance); std > 0 {
+		z = diff / std
+	} else if diff != 0 {
+		z = math.Copysign(math.Inf(1), diff)
+	}
+	direction := ""
+	if e.samples >= d.conf.Warmup {
+		switch {
+		case z <= -d.conf.Threshold && s.Direction != "spike":
+			direction = "drop"
+		case z >= d.conf.Threshold && s.Direction != "drop":
+			direction = "spike"
+		}
+	}
+	switch {
+	case e.samples == 0:
+		e.mean = f
+		e.samples++
+	case direction == "":
+		e.mean += d.conf.Alpha * diff
+		e.variance = (1 - d.conf.Alpha) * (e.variance + d.conf.Alpha*diff*diff)
+		e.samples++
+	}
+
+	if direction == e.flagged {
+		return
+	}
+	a := anomaly{Status: alertFiring, Direction: direction, Target: target, Path: path, Tags: tags,
+		Value: f, Mean: mean, ZScore: z, Timestamp: ts}
+	if direction == "" {
+		a.Status, a.Direction = alertResolved, e.flagged
+	}
+	e.flagged = direction
+	d.publish(a)
+}
+
+// publish sends a to the subject of its direction and target.
+func (d *anomalyDetector) publish(a anomaly) {
+	if math.IsInf(a.ZScore, 0) {
+		// JSON has no infinity.
+		a.ZScore = math.Copysign(math.MaxFloat64, a.ZScore)
+	}
+	slog.Warn("Anomaly "+a.Status, "direction", a.Direction, "target", a.Target, "path", a.Path, "value", a.Value, "mean", a.Mean)
+	data, err := json.Marshal(a)
+	if err != nil {
+		slog.Error("Could not encode anomaly", "error", err)
+		return
+	}
+	msg := nats.NewMsg(d.conf.Subject + "." + a.Direction + "." + subjectToken(a.Target))
+	msg.Header.Set("Gnmi-Target", a.Target)
+	msg.Data = data
+	if err := d.nc.PublishMsg(msg); err != nil {
+		slog.Error("Could not publish anomaly", "subject", msg.Subject, "error", err)
+	}
+}
+
+// subjectToken returns s as a single token of a NATS subject.
+func subjectToken(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(s)
+}
+
+// Close does nothing: the published anomalies are flushed when the
+// connection is closed.
+func (d *anomalyDetector) Close() error {
+	return nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIConfig serves the latest received value of every path over HTTP on
+// Address, so scripts can query the current state without speaking NATS:
+//
+//	GET /targets                         the targets with values
+//	GET /targets/{name}/state?path=...   the values of a target
+//
+// The path query parameter, which can be repeated, keeps the values under
+// the given path prefixes. Values not updated for Expiration are dropped; a
+// negative Expiration keeps them forever.
+type APIConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Address    string        `yaml:"address"`
+	Expiration time.Duration `yaml:"expiration"`
+}
+
+const defaultAPIExpiration = 10 * time.Minute
+
+func (a APIConfig) validate() error {
+	if a.Enabled && a.Address == "" {
+		return fmt.Errorf("api: address is required")
+	}
+	return nil
+}
+
+// stateValue is the latest value of a path and set of keys of a target.
+type stateValue struct {
+	Path      string            `json:"path"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Value     interface{}       `json:"value"`
+	Timestamp time.Time         `json:"timestamp"`
+	Subject   string            `json:"subject"`
+
+	updated time.Time
+}
+
+// targetSummary describes a target in the list of targets.
+type targetSummary struct {
+	Name    string    `json:"name"`
+	Values  int       `json:"values"`
+	Updated time.Time `json:"updated"`
+}
+
+// apiOutput keeps the latest values of every target and serves them.
+type apiOutput struct {
+	conf APIConfig
+	srv  *http.Server
+
+	mu      sync.Mutex
+	targets map[string]map[string]*stateValue
+}
+
+func newAPIOutput(conf APIConfig) (*apiOutput, error) {
+	if conf.Expiration == 0 {
+		conf.Expiration = defaultAPIExpiration
+	}
+	lis, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &apiOutput{conf: conf, targets: make(map[string]map[string]*stateValue)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/targets", o.serveTargets)
+	mux.HandleFunc("/targets/", o.serveState)
+	o.srv = &http.Server{Handler: mux}
+	go func() {
+		if err := o.srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			slog.Error("API server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving latest values over HTTP", "address", lis.Addr().String())
+	return o, nil
+}
+
+func (o *apiOutput) Write(_ context.Context, m *message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target, _, tags := splitTags(ev, m.Header.Get("Gnmi-Target"))
+		if target == "" {
+			continue
+		}
+		values, ok := o.targets[target]
+		if !ok {
+			values = make(map[string]*stateValue)
+			o.targets[target] = values
+		}
+		for path, v := range ev.Values {
+			values[seriesKey(path, "", tags)] = &stateValue{
+				Path: path, Tags: tags, Value: v, Timestamp: ts, Subject: m.Subject, updated: m.received,
+			}
+		}
+	}
+	return nil
+}
+
+// expire drops the values not updated for the expiration, and the targets
+// left without any. o.mu must be held.
+func (o *apiOutput) expire(now time.Time) {
+	if o.conf.Expiration < 0 {
+		return
+	}
+	for target, values := range o.targets {
+		for key, v := range values {
+			if now.Sub(v.updated) > o.conf.Expiration {
+				delete(values, key)
+			}
+		}
+		if len(values) == 0 {
+			delete(o.targets, target)
+		}
+	}
+}
+
+func (o *apiOutput) serveTargets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	o.mu.Lock()
+	o.expire(time.Now())
+	targets := make([]targetSummary, 0, len(o.targets))
+	for name, values := range o.targets {
+		t := targetSummary{Name: name, Values: len(values)}
+		for _, v := range values {
+			if v.updated.After(t.Updated) {
+				t.Updated = v.updated
+			}
+		}
+		targets = append(targets, t)
+	}
+	o.mu.Unlock()
+
+	sort.Slice(targets, func(i, j int) bool { return targets[i].Name < targets[j].Name })
+	writeJSON(w, map[string]interface{}{"targets": targets})
+}
+
+func (o *apiOutput) serveState(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/targets/"), "/state")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter := streamFilter{paths: r.URL.Query()["path"]}
+
+	o.mu.Lock()
+	o.expire(time.Now())
+	values, ok := o.targets[name]
+	state := make([]stateValue, 0, len(values))
+	for _, v := range values {
+		if filter.selected(v.Path) {
+			state = append(state, *v)
+		}
+	}
+	o.mu.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown target %q", name), http.StatusNotFound)
+		return
+	}
+
+	sort.Slice(state, func(i, j int) bool {
+		if state[i].Path != state[j].Path {
+			return state[i].Path < state[j].Path
+		}
+		return seriesKey("", "", state[i].Tags) < seriesKey("", "", state[j].Tags)
+	})
+	writeJSON(w, map[string]interface{}{"target": name, "values": state})
+}
+
+// writeJSON replies with v as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("Could not write API response", "error", err)
+	}
+}
+
+func (o *apiOutput) Close() error {
+	return o.srv.Close()
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// errBatcherClosed is handed to the callers of add whose items were never
+// written because the batcher was closed first.
+var errBatcherClosed = errors.New("output closed before the items were written")
+
+// batcher collects items for an output and hands them to flush from a single
+// goroutine, once size items are pending or every interval. Items of a failed
+// flush are kept and retried with the next batch. Once maxPending items are
+// pending, add blocks until some are written, so an unreachable backend holds
+// back the subscription instead of exhausting memory or losing items.
+type batcher[T any] struct {
+	name       string
+	size       int
+	maxPending int
+	flush      func([]T) error
+
+	mu    sync.Mutex
+	cond  *sync.Cond
+	items []T
+	// dones holds, at the index of the last item of each add, the func
+	// told whether its items were written.
+	dones  []func(error)
+	closed bool
+
+	full chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newBatcher[T any](name string, size int, interval time.Duration, flush func([]T) error) *batcher[T] {
+	b := &batcher[T]{
+		name:       name,
+		size:       size,
+		maxPending: 10 * size,
+		flush:      flush,
+		full:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	b.cond = sync.NewCond(&b.mu)
+	b.wg.Add(1)
+	go b.run(interval)
+	return b
+}
+
+// add queues items for the next flush, waiting while maxPending items are
+// pending. done is called once the items have been written, or with an
+// error if the batcher is closed before they are.
+func (b *batcher[T]) add(done func(error), items ...T) {
+	if len(items) == 0 {
+		done(nil)
+		return
+	}
+	b.mu.Lock()
+	for len(b.items) >= b.maxPending && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.mu.Unlock()
+		done(errBatcherClosed)
+		return
+	}
+	b.items = append(b.items, items...)
+	b.dones = append(b.dones, make([]func(error), len(items))...)
+	b.dones[len(b.dones)-1] = done
+	full := len(b.items) >= b.size
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *batcher[T]) run(interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			b.flushPending()
+			return
+		case <-ticker.C:
+		case <-b.full:
+		}
+		b.flushPending()
+	}
+}
+
+// flushPending flushes every pending item, size items at a time.
+func (b *batcher[T]) flushPending() {
+	for {
+		b.mu.Lock()
+		n := min(len(b.items), b.size)
+		batch, dones := b.items[:n:n], b.dones[:n:n]
+		b.items, b.dones = b.items[n:], b.dones[n:]
+		b.mu.Unlock()
+		if n == 0 {
+			return
+		}
+
+		if err := b.flush(batch); err != nil {
+			slog.Error("Error writing batch", "output", b.name, "items", n, "error", err)
+			b.mu.Lock()
+			b.items = append(batch, b.items...)
+			b.dones = append(dones, b.dones...)
+			b.mu.Unlock()
+			return
+		}
+		// Items are written in order, so the items of each done are all
+		// written once its last one is.
+		for _, done := range dones {
+			if done != nil {
+				done(nil)
+			}
+		}
+		b.cond.Broadcast()
+	}
+}
+
+// close flushes the pending items and stops the batcher. Items that still
+// cannot be written are discarded, telling their callers so.
+func (b *batcher[T]) close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+	close(b.done)
+	b.wg.Wait()
+
+	b.mu.Lock()
+	items, dones := b.items, b.dones
+	b.items, b.dones = nil, nil
+	b.mu.Unlock()
+	if len(items) > 0 {
+		slog.Warn("Discarded pending items", "output", b.name, "items", len(items))
+	}
+	for _, done := range dones {
+		if done != nil {
+			done(errBatcherClosed)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Chunk headers, set by the publisher on the parts of a message too large for
+// the server's max_payload.
+const (
+	chunkIDHeader    = "Gnmi-Chunk-Id"
+	chunkSeqHeader   = "Gnmi-Chunk-Seq"
+	chunkCountHeader = "Gnmi-Chunk-Count"
+)
+
+// chunkTimeout is how long the chunks of a message are kept waiting for the
+// rest, and maxChunks bounds how many a message may be split into.
+const (
+	chunkTimeout = time.Minute
+	maxChunks    = 10000
+)
+
+// chunkAssembler puts chunked messages back together. Chunks of different
+// messages may interleave; a message missing chunks for longer than
+// chunkTimeout is dropped. With JetStream, the chunks of a dropped message
+// are terminated, so they are not redelivered.
+type chunkAssembler struct {
+	mu        sync.Mutex
+	partial   map[string]*partialMsg
+	jetStream bool
+}
+
+type partialMsg struct {
+	first   *nats.Msg
+	chunks  [][]byte
+	parts   []*nats.Msg
+	missing int
+	started time.Time
+}
+
+func newChunkAssembler(jetStream bool) *chunkAssembler {
+	return &chunkAssembler{partial: make(map[string]*partialMsg), jetStream: jetStream}
+}
+
+// add returns msg itself when it is not a chunk, the whole message once
+// msg completes it, and nil while chunks are missing. It also returns the
+// messages the whole message was received in, to be acknowledged once it
+// has been handled.
+func (a *chunkAssembler) add(msg *nats.Msg) (*nats.Msg, []*nats.Msg, error) {
+	id := msg.Header.Get(chunkIDHeader)
+	if id == "" {
+		return msg, []*nats.Msg{msg}, nil
+	}
+	seq, err := strconv.Atoi(msg.Header.Get(chunkSeqHeader))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s header: %v", chunkSeqHeader, err)
+	}
+	count, err := strconv.Atoi(msg.Header.Get(chunkCountHeader))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s header: %v", chunkCountHeader, err)
+	}
+	if count < 1 || count > maxChunks || seq < 1 || seq > count {
+		return nil, nil, fmt.Errorf("invalid chunk %d of %d", seq, count)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.expire()
+	p, ok := a.partial[id]
+	if !ok {
+		p = &partialMsg{
+			chunks:  make([][]byte, count),
+			parts:   make([]*nats.Msg, count),
+			missing: count,
+			started: time.Now(),
+		}
+		a.partial[id] = p
+	}
+	if len(p.chunks) != count {
+		return nil, nil, fmt.Errorf("chunk %d of %s says %d chunks, expected %d", seq, id, count, len(p.chunks))
+	}
+	if p.chunks[seq-1] != nil {
+		// Redelivered chunk, acknowledged in place of the first delivery.
+		p.parts[seq-1] = msg
+		return nil, nil, nil
+	}
+	p.chunks[seq-1] = msg.Data
+	p.parts[seq-1] = msg
+	p.missing--
+	if seq == 1 {
+		p.first = msg
+	}
+	if p.missing > 0 {
+		return nil, nil, nil
+	}
+	delete(a.partial, id)
+
+	header := nats.Header{}
+	for k, vs := range p.first.Header {
+		switch k {
+		case chunkIDHeader, chunkSeqHeader, chunkCountHeader:
+		default:
+			header[k] = vs
+		}
+	}
+	if msgID := header.Get(nats.MsgIdHdr); msgID != "" {
+		header.Set(nats.MsgIdHdr, strings.TrimSuffix(msgID, ".1"))
+	}
+	return &nats.Msg{
+		Subject: p.first.Subject,
+		Reply:   p.first.Reply,
+		Header:  header,
+		Data:    bytes.Join(p.chunks, nil),
+	}, p.parts, nil
+}
+
+// expire drops the messages that have been missing chunks for too long.
+// a.mu must be held.
+func (a *chunkAssembler) expire() {
+	for id, p := range a.partial {
+		if time.Since(p.started) > chunkTimeout {
+			slog.Warn("Dropped incomplete chunked message", "chunk_id", id, "chunks", len(p.chunks), "missing", p.missing)
+			delete(a.partial, id)
+			if a.jetStream {
+				for _, part := range p.parts {
+					if part == nil {
+						continue
+					}
+					if err := part.Term(); err != nil {
+						slog.Error("Error terminating chunk", "subject", part.Subject, "error", err)
+					}
+				}
+			}
+		}
+	}
+}
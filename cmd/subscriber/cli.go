@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/logging"
+	"os"
+	"strings"
+)
+
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+const usage = `Usage: subscriber [command] [flags]
+
+Commands:
+  run       subscribe and log received telemetry (default)
+  tui       subscribe and show a live table of interface counters
+  validate  check the configuration and exit
+  query     print values stored by the sqlite output
+  version   print the version and exit
+
+Run 'subscriber <command> -h' for the flags of a command.
+`
+
+// validate reports any errors in the effective configuration.
+func validate(conf Config) error {
+	if _, err := conf.natsOptions(); err != nil {
+		return err
+	}
+	if err := conf.Tracing.Validate(); err != nil {
+		return err
+	}
+	for _, r := range conf.Routes {
+		if err := r.validate(); err != nil {
+			return err
+		}
+	}
+	if conf.Filter != "" {
+		if _, err := newEventFilter(conf.Filter); err != nil {
+			return err
+		}
+	}
+	if conf.JetStream.Enabled {
+		if _, err := deliverOption(conf.JetStream.DeliverPolicy); err != nil {
+			return err
+		}
+	}
+	if err := conf.Prometheus.validate(); err != nil {
+		return err
+	}
+	if err := conf.Postgres.validate(); err != nil {
+		return err
+	}
+	if err := conf.Kafka.validate(); err != nil {
+		return err
+	}
+	if err := conf.File.validate(); err != nil {
+		return err
+	}
+	if err := conf.Elasticsearch.validate(); err != nil {
+		return err
+	}
+	if err := conf.ClickHouse.validate(); err != nil {
+		return err
+	}
+	if err := conf.S3.validate(); err != nil {
+		return err
+	}
+	if err := conf.Parquet.validate(); err != nil {
+		return err
+	}
+	if err := conf.CSV.validate(); err != nil {
+		return err
+	}
+	if err := conf.Webhook.validate(); err != nil {
+		return err
+	}
+	if err := conf.Alerting.validate(); err != nil {
+		return err
+	}
+	if err := conf.MQTT.validate(); err != nil {
+		return err
+	}
+	if err := conf.Syslog.validate(); err != nil {
+		return err
+	}
+	if err := conf.Graphite.validate(); err != nil {
+		return err
+	}
+	if err := conf.OTLP.validate(); err != nil {
+		return err
+	}
+	if err := conf.GRPC.validate(); err != nil {
+		return err
+	}
+	if err := conf.WebSocket.validate(); err != nil {
+		return err
+	}
+	if err := conf.Anomaly.validate(); err != nil {
+		return err
+	}
+	if err := conf.API.validate(); err != nil {
+		return err
+	}
+	if err := conf.KV.validate(); err != nil {
+		return err
+	}
+	if err := conf.Rates.validate(); err != nil {
+		return err
+	}
+	if err := conf.Verify.validate(); err != nil {
+		return err
+	}
+	if err := conf.Stats.validate(); err != nil {
+		return err
+	}
+	fmt.Printf("configuration is valid: %d subject(s) on %s\n", len(conf.Subjects), conf.NatsURL)
+	return nil
+}
+
+func main() {
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+
+	switch cmd {
+	case "run", "tui", "validate":
+		conf, err := loadConfig(cmd, args)
+		if err == flag.ErrHelp {
+			return
+		} else if err != nil {
+			logging.Fatal("Could not load config", "error", err)
+		}
+		if err := logging.Setup(conf.LogLevel, conf.LogFormat); err != nil {
+			logging.Fatal("Invalid logging config", "error", err)
+		}
+		switch cmd {
+		case "validate":
+			err = validate(conf)
+		case "tui":
+			err = runDashboard(conf)
+		default:
+			err = run(conf, nil)
+		}
+		if err != nil {
+			logging.Fatal("Subscriber failed", "command", cmd, "error", err)
+		}
+	case "query":
+		if err := query(args); err != nil && err != flag.ErrHelp {
+			logging.Fatal("Query failed", "error", err)
+		}
+	case "version":
+		fmt.Printf("subscriber %s\n", version)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+}
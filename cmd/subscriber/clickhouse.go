@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClickHouseConfig stores every received value as a row of a MergeTree
+// table, created on startup if needed. Rows are sent over the HTTP interface
+// in the columnar Native format, in blocks of BatchSize rows or every
+// FlushInterval, which keeps up with chassis-scale counter streams. Rows
+// older than TTL are removed by ClickHouse when TTL is set.
+type ClickHouseConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	URL           string        `yaml:"url"`
+	Database      string        `yaml:"database"`
+	Table         string        `yaml:"table"`
+	Username      string        `yaml:"username"`
+	Password      string        `yaml:"password"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	TTL           time.Duration `yaml:"ttl"`
+	TLS           natsopts.TLS  `yaml:"tls"`
+}
+
+const (
+	defaultClickHouseDatabase  = "default"
+	defaultClickHouseTable     = "gnmi_telemetry"
+	defaultClickHouseBatchSize = 50000
+)
+
+func (c ClickHouseConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, err := url.ParseRequestURI(c.URL); err != nil {
+		return fmt.Errorf("clickhouse: invalid url %q: %w", c.URL, err)
+	}
+	if _, err := c.TLS.Config(); err != nil {
+		return fmt.Errorf("clickhouse: invalid TLS settings: %w", err)
+	}
+	return nil
+}
+
+// chRow is a row of the telemetry table. Value is NULL when text holds a
+// non-numeric value.
+type chRow struct {
+	time                       int64
+	target, subscription, path string
+	tags                       map[string]string
+	value                      float64
+	numeric                    bool
+	text                       string
+}
+
+// clickHouseOutput inserts the values of received events in blocks.
+type clickHouseOutput struct {
+	conf    ClickHouseConfig
+	table   string
+	client  *http.Client
+	batcher *batcher[chRow]
+}
+
+func newClickHouseOutput(conf ClickHouseConfig) (*clickHouseOutput, error) {
+	if conf.Database == "" {
+		conf.Database = defaultClickHouseDatabase
+	}
+	if conf.Table == "" {
+		conf.Table = defaultClickHouseTable
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultClickHouseBatchSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = time.Second
+	}
+	tlsConf, err := conf.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse: invalid TLS settings: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+
+	o := &clickHouseOutput{
+		conf:   conf,
+		table:  quoteIdentifier(conf.Database) + "." + quoteIdentifier(conf.Table),
+		client: &http.Client{Transport: transport, Timeout: time.Minute},
+	}
+	if err := o.createTable(); err != nil {
+		return nil, err
+	}
+	o.batcher = newBatcher("clickhouse", conf.BatchSize, conf.FlushInterval, o.insert)
+	slog.Info("Writing telemetry to ClickHouse", "table", o.table)
+	return o, nil
+}
+
+// createTable creates the telemetry table unless it exists.
+func (o *clickHouseOutput) createTable() error {
+	ttl := ""
+	if o.conf.TTL > 0 {
+		ttl = fmt.Sprintf("\nTTL toDateTime(time) + INTERVAL %d SECOND", int64(o.conf.TTL.Seconds()))
+	}
+	query := `CREATE TABLE IF NOT EXISTS ` + o.table + ` (
+	time         DateTime64(9, 'UTC') CODEC(DoubleDelta, ZSTD),
+	target       String,
+	subscription String,
+	path         String,
+	tags         Map(String, String),
+	value        Nullable(Float64),
+	value_text   String
+)
+ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (target, path, time)` + ttl
+	if err := o.do(query, nil); err != nil {
+		return fmt.Errorf("could not create table %s: %w", o.table, err)
+	}
+	return nil
+}
+
+func (o *clickHouseOutput) Write(_ context.Context, m *message) error {
+	target := m.Header.Get("Gnmi-Target")
+	var rows []chRow
+	for _, ev := range m.events {
+		row := chRow{time: m.received.UnixNano()}
+		if ev.Timestamp > 0 {
+			row.time = ev.Timestamp
+		}
+		row.target, row.subscription, row.tags = splitTags(ev, target)
+		for path, v := range ev.Values {
+			r := row
+			r.path = path
+			if r.value, r.numeric = numericValue(v); !r.numeric {
+				r.text = valueText(v)
+			}
+			rows = append(rows, r)
+		}
+	}
+	if len(rows) > 0 {
+		o.batcher.add(m.hold(), rows...)
+	}
+	return nil
+}
+
+// insert sends rows as a single block in the Native format, which carries
+// each column as a contiguous array.
+func (o *clickHouseOutput) insert(rows []chRow) error {
+	return o.do("INSERT INTO "+o.table+" FORMAT Native", nativeBlock(rows))
+}
+
+// do runs query with data as its input. Without data, the query is sent as
+// the request body.
+func (o *clickHouseOutput) do(query string, data []byte) error {
+	u := strings.TrimRight(o.conf.URL, "/") + "/"
+	if data == nil {
+		data = []byte(query)
+	} else {
+		u += "?query=" + url.QueryEscape(query)
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if o.conf.Username != "" {
+		req.Header.Set("X-ClickHouse-User", o.conf.Username)
+		req.Header.Set("X-ClickHouse-Key", o.conf.Password)
+	}
+
+	rsp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("%s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, rsp.Body)
+	return nil
+}
+
+func (o *clickHouseOutput) Close() error {
+	o.batcher.close()
+	return nil
+}
+
+// nativeBlock encodes rows as a block of the ClickHouse Native format: the
+// column and row counts, then for each column its name, type and values.
+func nativeBlock(rows []chRow) []byte {
+	var b []byte
+	b = binary.AppendUvarint(b, 7)
+	b = binary.AppendUvarint(b, uint64(len(rows)))
+
+	b = nativeColumn(b, "time", "DateTime64(9, 'UTC')")
+	for _, r := range rows {
+		b = binary.LittleEndian.AppendUint64(b, uint64(r.time))
+	}
+	b = nativeColumn(b, "target", "String")
+	for _, r := range rows {
+		b = nativeString(b, r.target)
+	}
+	b = nativeColumn(b, "subscription", "String")
+	for _, r := range rows {
+		b = nativeString(b, r.subscription)
+	}
+	b = nativeColumn(b, "path", "String")
+	for _, r := range rows {
+		b = nativeString(b, r.path)
+	}
+
+	// A Map is an array of key-value tuples: the cumulative entry count of
+	// each row, then every key, then every value. Keys are sorted so that a
+	// block is always encoded the same way.
+	b = nativeColumn(b, "tags", "Map(String, String)")
+	var keys, values []string
+	for _, r := range rows {
+		start := len(keys)
+		for k := range r.tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys[start:])
+		for _, k := range keys[start:] {
+			values = append(values, r.tags[k])
+		}
+		b = binary.LittleEndian.AppendUint64(b, uint64(len(keys)))
+	}
+	for _, k := range keys {
+		b = nativeString(b, k)
+	}
+	for _, v := range values {
+		b = nativeString(b, v)
+	}
+
+	// A Nullable column is its null map followed by the values.
+	b = nativeColumn(b, "value", "Nullable(Float64)")
+	for _, r := range rows {
+		if r.numeric {
+			b = append(b, 0)
+		} else {
+			b = append(b, 1)
+		}
+	}
+	for _, r := range rows {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(r.value))
+	}
+	b = nativeColumn(b, "value_text", "String")
+	for _, r := range rows {
+		b = nativeString(b, r.text)
+	}
+	return b
+}
+
+func nativeColumn(b []byte, name, typ string) []byte {
+	return nativeString(nativeString(b, name), typ)
+}
+
+func nativeString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// quoteIdentifier quotes a ClickHouse database or table name.
+func quoteIdentifier(name string) string {
+	return "`" + strings.NewReplacer(`\`, `\\`, "`", "\\`").Replace(name) + "`"
+}
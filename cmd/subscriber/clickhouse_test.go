@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNativeBlock(t *testing.T) {
+	rows := []chRow{
+		{time: 1, target: "r1", subscription: "s", path: "/a", value: 1.5, numeric: true},
+		{time: 2, target: "r1", subscription: "s", path: "/b", tags: map[string]string{"q": "0", "if": "e1"}, text: "up"},
+	}
+	want := "" +
+		// Columns and rows.
+		"\x07\x02" +
+		"\x04time\x14DateTime64(9, 'UTC')" +
+		"\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\x02\x00\x00\x00\x00\x00\x00\x00" +
+		"\x06target\x06String" +
+		"\x02r1\x02r1" +
+		"\x0csubscription\x06String" +
+		"\x01s\x01s" +
+		"\x04path\x06String" +
+		"\x02/a\x02/b" +
+		// Cumulative entry counts, then keys, then values.
+		"\x04tags\x13Map(String, String)" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x02\x00\x00\x00\x00\x00\x00\x00" +
+		"\x02if\x01q" +
+		"\x02e1\x010" +
+		// Null map, then the values, zero for nulls.
+		"\x05value\x11Nullable(Float64)" +
+		"\x00\x01" +
+		"\x00\x00\x00\x00\x00\x00\xf8\x3f" +
+		"\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x0avalue_text\x06String" +
+		"\x00\x02up"
+
+	if got := nativeBlock(rows); !bytes.Equal(got, []byte(want)) {
+		t.Errorf("nativeBlock() =\n%q\nwant\n%q", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/nats-io/nats.go"
+	"io"
+)
+
+// contentEncodingHeader names the algorithm the publisher compressed a
+// payload with.
+const contentEncodingHeader = "Content-Encoding"
+
+// maxDecompressedSize bounds the size of a decompressed payload, so a
+// corrupt or hostile message cannot exhaust memory.
+const maxDecompressedSize = 256 << 20
+
+// zstdDecoder is shared by every message; DecodeAll is safe for concurrent
+// use.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxDecompressedSize))
+
+// decompress returns msg with its payload decompressed, or msg itself when
+// it is not compressed.
+func decompress(msg *nats.Msg) (*nats.Msg, error) {
+	encoding := msg.Header.Get(contentEncodingHeader)
+	if encoding == "" || encoding == "identity" {
+		return msg, nil
+	}
+
+	var data []byte
+	var err error
+	switch encoding {
+	case "gzip":
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(msg.Data)); err == nil {
+			data, err = io.ReadAll(io.LimitReader(zr, maxDecompressedSize+1))
+			if err == nil && len(data) > maxDecompressedSize {
+				err = fmt.Errorf("payload larger than %d bytes", maxDecompressedSize)
+			}
+		}
+	case "zstd":
+		data, err = zstdDecoder.DecodeAll(msg.Data, nil)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not decompress %s payload: %w", encoding, err)
+	}
+
+	header := nats.Header{}
+	for k, vs := range msg.Header {
+		if k != contentEncodingHeader {
+			header[k] = vs
+		}
+	}
+	return &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Header: header, Data: data}, nil
+}
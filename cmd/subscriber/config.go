@@ -0,0 +1,278 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/envsubst"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config holds the subscriber settings. Values are taken from the optional
+// YAML file, then the NATS_* environment variables, then command line flags.
+type Config struct {
+	NatsURL  string        `yaml:"nats_url"`
+	Subjects []string      `yaml:"subjects"`
+	Queue    string        `yaml:"queue"`
+	Filter   string        `yaml:"filter"`
+	Routes   []Route       `yaml:"routes"`
+	NatsAuth natsopts.Auth `yaml:"nats_auth"`
+	NatsTLS  natsopts.TLS  `yaml:"nats_tls"`
+
+	JetStream     JetStreamConfig     `yaml:"jetstream"`
+	Prometheus    PrometheusConfig    `yaml:"prometheus"`
+	Postgres      PostgresConfig      `yaml:"postgres"`
+	Kafka         KafkaConfig         `yaml:"kafka"`
+	File          FileConfig          `yaml:"file"`
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch"`
+	ClickHouse    ClickHouseConfig    `yaml:"clickhouse"`
+	SQLite        SQLiteConfig        `yaml:"sqlite"`
+	S3            S3Config            `yaml:"s3"`
+	Parquet       ParquetConfig       `yaml:"parquet"`
+	CSV           CSVConfig           `yaml:"csv"`
+	Webhook       WebhookConfig       `yaml:"webhook"`
+	Alerting      AlertingConfig      `yaml:"alerting"`
+	MQTT          MQTTConfig          `yaml:"mqtt"`
+	Syslog        SyslogConfig        `yaml:"syslog"`
+	Graphite      GraphiteConfig      `yaml:"graphite"`
+	OTLP          OTLPConfig          `yaml:"otlp"`
+	GRPC          GRPCStreamConfig    `yaml:"grpc"`
+	WebSocket     WebSocketConfig     `yaml:"websocket"`
+	Anomaly       AnomalyConfig       `yaml:"anomaly"`
+	API           APIConfig           `yaml:"api"`
+	KV            KVConfig            `yaml:"kv"`
+	Rates         RatesConfig         `yaml:"rates"`
+	Verify        VerifyConfig        `yaml:"verify"`
+	Tracing       tracing.Config      `yaml:"tracing"`
+	Stats         StatsConfig         `yaml:"stats"`
+
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"`
+}
+
+// JetStreamConfig binds the subscriber to a durable JetStream consumer so it
+// picks up messages published while it was not running.
+type JetStreamConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Stream        string        `yaml:"stream"`
+	Durable       string        `yaml:"durable"`
+	DeliverPolicy string        `yaml:"deliver_policy"`
+	AckWait       time.Duration `yaml:"ack_wait"`
+}
+
+func readConfig(filename string) (Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading YAML file: %v", err)
+	}
+
+	var conf Config
+	if err := yaml.Unmarshal(envsubst.Expand(data), &conf); err != nil {
+		return Config{}, fmt.Errorf("error parsing YAML file: %v", err)
+	}
+	return conf, nil
+}
+
+// loadConfig parses the command line flags of the named command and builds
+// the effective configuration.
+func loadConfig(name string, args []string) (Config, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := fs.String("config", "", "path to an optional YAML config file")
+	natsURL := fs.String("nats-url", "", "NATS server URL (default "+nats.DefaultURL+")")
+	subjects := fs.String("subject", "", "comma separated list of subjects to subscribe to (default interface-counters)")
+	user := fs.String("user", "", "NATS username")
+	password := fs.String("password", "", "NATS password")
+	token := fs.String("token", "", "NATS token")
+	nkey := fs.String("nkey", "", "path to a NATS NKey seed file")
+	creds := fs.String("creds", "", "path to a NATS .creds file")
+	durable := fs.String("durable", "", "consume through this durable JetStream consumer")
+	stream := fs.String("stream", "", "JetStream stream to bind the durable consumer to")
+	promAddress := fs.String("prometheus-address", "", "expose received values as Prometheus metrics on this address")
+	postgresURL := fs.String("postgres-url", "", "store received values in the PostgreSQL database at this URL")
+	kafkaBrokers := fs.String("kafka-brokers", "", "comma separated list of Kafka brokers to forward events to")
+	archive := fs.String("archive", "", "append received messages as JSON lines to this file")
+	esURL := fs.String("elasticsearch-url", "", "index received values in the Elasticsearch or OpenSearch cluster at this URL")
+	clickhouseURL := fs.String("clickhouse-url", "", "store received values in the ClickHouse server at this HTTP URL")
+	sqlitePath := fs.String("sqlite", "", "store received values in the SQLite database at this path")
+	s3Bucket := fs.String("s3-bucket", "", "archive received messages in this S3 bucket")
+	parquetDir := fs.String("parquet-dir", "", "write received values to Parquet files under this directory")
+	csvPath := fs.String("csv", "", "write received values as CSV rows to this file, or to standard output for -")
+	csvPaths := fs.String("csv-paths", "", "comma separated list of path prefixes to write as CSV (default all)")
+	webhookURL := fs.String("webhook-url", "", "send received events to this HTTP endpoint")
+	mqttBroker := fs.String("mqtt-broker", "", "publish received events to the MQTT broker at this URL")
+	syslogAddress := fs.String("syslog-address", "", "send value changes as syslog messages to this udp://, tcp:// or tls:// address")
+	graphiteAddress := fs.String("graphite-address", "", "send received values to the Carbon server at this address")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "export received values as OTLP metrics to the OTLP/HTTP receiver at this URL")
+	grpcAddress := fs.String("grpc-address", "", "serve received events to TelemetryStream gRPC clients on this address")
+	webSocketAddress := fs.String("websocket-address", "", "stream received events as JSON to WebSocket clients on this address")
+	anomaly := fs.Bool("anomaly", false, "publish traffic drops and error spikes as alerts under alerts.anomaly")
+	apiAddress := fs.String("api-address", "", "serve the latest received values over HTTP on this address")
+	kvBucket := fs.String("kv-bucket", "", "mirror the latest received values into this NATS KV bucket")
+	queue := fs.String("queue", "", "share the messages with the other subscribers in this queue group")
+	filter := fs.String("filter", "", "only log and forward the events for which this jq expression is true")
+	rates := fs.Bool("rates", false, "add the rates per second of the received counters to their events")
+	verifyKeys := fs.String("verify-keys", "", "comma separated list of public key files to verify message signatures with")
+	statsInterval := fs.Duration("stats-interval", 0, "log a summary of the received messages at this interval instead of every message")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	var conf Config
+	if *configFile != "" {
+		var err error
+		if conf, err = readConfig(*configFile); err != nil {
+			return Config{}, err
+		}
+	}
+	conf.NatsAuth.ApplyEnv()
+	conf.NatsTLS.ApplyEnv()
+	conf.Tracing.ApplyEnv()
+
+	setIfNotEmpty(&conf.NatsURL, *natsURL)
+	setIfNotEmpty(&conf.NatsAuth.User, *user)
+	setIfNotEmpty(&conf.NatsAuth.Password, *password)
+	setIfNotEmpty(&conf.NatsAuth.Token, *token)
+	setIfNotEmpty(&conf.NatsAuth.NKeySeedFile, *nkey)
+	setIfNotEmpty(&conf.NatsAuth.CredsFile, *creds)
+	if *subjects != "" {
+		conf.Subjects = strings.Split(*subjects, ",")
+	}
+	if *durable != "" {
+		conf.JetStream.Enabled = true
+		conf.JetStream.Durable = *durable
+	}
+	setIfNotEmpty(&conf.JetStream.Stream, *stream)
+	if *promAddress != "" {
+		conf.Prometheus.Enabled = true
+		conf.Prometheus.Address = *promAddress
+	}
+	if *postgresURL != "" {
+		conf.Postgres.Enabled = true
+		conf.Postgres.URL = *postgresURL
+	}
+	if *kafkaBrokers != "" {
+		conf.Kafka.Enabled = true
+		conf.Kafka.Brokers = strings.Split(*kafkaBrokers, ",")
+	}
+	if *archive != "" {
+		conf.File.Enabled = true
+		conf.File.Path = *archive
+	}
+	if *esURL != "" {
+		conf.Elasticsearch.Enabled = true
+		conf.Elasticsearch.URLs = []string{*esURL}
+	}
+	if *clickhouseURL != "" {
+		conf.ClickHouse.Enabled = true
+		conf.ClickHouse.URL = *clickhouseURL
+	}
+	if *sqlitePath != "" {
+		conf.SQLite.Enabled = true
+		conf.SQLite.Path = *sqlitePath
+	}
+	if *s3Bucket != "" {
+		conf.S3.Enabled = true
+		conf.S3.Bucket = *s3Bucket
+	}
+	if *parquetDir != "" {
+		conf.Parquet.Enabled = true
+		conf.Parquet.Dir = *parquetDir
+	}
+	if *csvPath != "" {
+		conf.CSV.Enabled = true
+		conf.CSV.Path = *csvPath
+	}
+	if *csvPaths != "" {
+		conf.CSV.Paths = strings.Split(*csvPaths, ",")
+	}
+	if *webhookURL != "" {
+		conf.Webhook.Enabled = true
+		conf.Webhook.URL = *webhookURL
+	}
+	if *mqttBroker != "" {
+		conf.MQTT.Enabled = true
+		conf.MQTT.Broker = *mqttBroker
+	}
+	if *syslogAddress != "" {
+		conf.Syslog.Enabled = true
+		conf.Syslog.Address = *syslogAddress
+	}
+	if *graphiteAddress != "" {
+		conf.Graphite.Enabled = true
+		conf.Graphite.Address = *graphiteAddress
+	}
+	if *otlpEndpoint != "" {
+		conf.OTLP.Enabled = true
+		conf.OTLP.Endpoint = *otlpEndpoint
+	}
+	if *grpcAddress != "" {
+		conf.GRPC.Enabled = true
+		conf.GRPC.Address = *grpcAddress
+	}
+	if *webSocketAddress != "" {
+		conf.WebSocket.Enabled = true
+		conf.WebSocket.Address = *webSocketAddress
+	}
+	if *anomaly {
+		conf.Anomaly.Enabled = true
+	}
+	if *apiAddress != "" {
+		conf.API.Enabled = true
+		conf.API.Address = *apiAddress
+	}
+	if *kvBucket != "" {
+		conf.KV.Enabled = true
+		conf.KV.Bucket = *kvBucket
+	}
+	setIfNotEmpty(&conf.Queue, *queue)
+	setIfNotEmpty(&conf.Filter, *filter)
+	if *rates {
+		conf.Rates.Enabled = true
+	}
+	if *verifyKeys != "" {
+		conf.Verify.Enabled = true
+		conf.Verify.PublicKeys = strings.Split(*verifyKeys, ",")
+	}
+	if *statsInterval != 0 {
+		conf.Stats.Interval = *statsInterval
+	}
+	setIfNotEmpty(&conf.LogLevel, *logLevel)
+
+	if conf.NatsURL == "" {
+		conf.NatsURL = nats.DefaultURL
+	}
+	conf.Subjects = routeSubjects(conf.Subjects, conf.Routes)
+	if len(conf.Subjects) == 0 {
+		conf.Subjects = []string{"interface-counters"}
+	}
+	if conf.JetStream.Enabled && conf.JetStream.Durable == "" {
+		conf.JetStream.Durable = "nats-gnmi-subscriber"
+	}
+	return conf, nil
+}
+
+// natsOptions returns the connection options for the configured NATS
+// credentials and TLS settings.
+func (c Config) natsOptions() ([]nats.Option, error) {
+	opts, err := c.NatsAuth.Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS credentials: %w", err)
+	}
+	tlsOpts, err := c.NatsTLS.Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS TLS settings: %w", err)
+	}
+	return append(opts, tlsOpts...), nil
+}
+
+func setIfNotEmpty(dst *string, v string) {
+	if v != "" {
+		*dst = v
+	}
+}
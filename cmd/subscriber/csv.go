@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CSVConfig writes the received values as CSV rows of timestamp, target, path
+// and value to Path, or to standard output when Path is "-", for quick
+// analysis in a spreadsheet or pandas. Only the paths starting with one of
+// Paths are written, or every path when Paths is empty.
+type CSVConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Path    string   `yaml:"path"`
+	Paths   []string `yaml:"paths"`
+}
+
+func (c CSVConfig) validate() error {
+	if c.Enabled && c.Path == "" {
+		return fmt.Errorf("csv: path is required")
+	}
+	return nil
+}
+
+var csvHeader = []string{"timestamp", "target", "path", "value"}
+
+// csvOutput appends a row per selected value, flushing after every message
+// so rows show up as they are received.
+type csvOutput struct {
+	paths []string
+
+	mu sync.Mutex
+	w  *csv.Writer
+	c  io.Closer
+}
+
+func newCSVOutput(conf CSVConfig) (*csvOutput, error) {
+	o := &csvOutput{paths: conf.Paths}
+	if conf.Path == "-" {
+		o.w = csv.NewWriter(os.Stdout)
+		o.w.Write(csvHeader)
+		return o, nil
+	}
+
+	f, err := os.OpenFile(conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening CSV file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	o.w, o.c = csv.NewWriter(f), f
+	// Appending to an existing file keeps its header.
+	if info.Size() == 0 {
+		o.w.Write(csvHeader)
+	}
+	slog.Info("Writing telemetry as CSV", "file", conf.Path)
+	return o, nil
+}
+
+// selected reports whether the values of path are written.
+func (o *csvOutput) selected(path string) bool {
+	if len(o.paths) == 0 {
+		return true
+	}
+	for _, p := range o.paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *csvOutput) Write(_ context.Context, m *message) error {
+	target := m.Header.Get("Gnmi-Target")
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		source := target
+		if s, ok := ev.Tags["source"]; ok {
+			source = s
+		}
+
+		// Sort the paths so the rows of an event have a stable order.
+		paths := make([]string, 0, len(ev.Values))
+		for path := range ev.Values {
+			if o.selected(path) {
+				paths = append(paths, path)
+			}
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			row := []string{ts.UTC().Format(time.RFC3339Nano), source, path, valueText(ev.Values[path])}
+			if err := o.w.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	o.w.Flush()
+	return o.w.Error()
+}
+
+func (o *csvOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Flush()
+	err := o.w.Error()
+	if o.c != nil {
+		if cerr := o.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/formatters"
+	"github.com/openconfig/gnmic/utils"
+	"google.golang.org/protobuf/proto"
+	"strings"
+)
+
+// decodeEvents converts a telemetry message in any of the publisher payload
+// formats into gnmic event messages, one per update, with the path keys,
+// source and subscription name as tags. Batched messages are flattened.
+func decodeEvents(msg *nats.Msg) ([]*formatters.EventMsg, error) {
+	if strings.HasPrefix(msg.Header.Get("Content-Type"), "application/x-protobuf") {
+		var rsp gnmi.SubscribeResponse
+		if err := proto.Unmarshal(msg.Data, &rsp); err != nil {
+			return nil, fmt.Errorf("invalid proto payload: %w", err)
+		}
+		subscription := msg.Header.Get("Gnmi-Subscription")
+		return formatters.ResponseToEventMsgs(subscription, &rsp, map[string]string{
+			"source":            msg.Header.Get("Gnmi-Target"),
+			"subscription-name": subscription,
+		})
+	}
+
+	data := bytes.TrimSpace(msg.Data)
+	var items []json.RawMessage
+	if len(data) > 0 && data[0] == '[' {
+		if err := json.Unmarshal(data, &items); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %w", err)
+		}
+	} else {
+		items = []json.RawMessage{data}
+	}
+
+	var events []*formatters.EventMsg
+	for _, item := range items {
+		// Event messages and gnmic JSON notifications share no required
+		// field, and both may carry timestamp and deletes, so tell them
+		// apart by the fields only events have.
+		var probe struct {
+			Name    json.RawMessage `json:"name"`
+			Tags    json.RawMessage `json:"tags"`
+			Values  json.RawMessage `json:"values"`
+			Updates json.RawMessage `json:"updates"`
+		}
+		if err := json.Unmarshal(item, &probe); err != nil {
+			return nil, fmt.Errorf("invalid JSON payload: %w", err)
+		}
+		if probe.Updates == nil && (probe.Name != nil || probe.Tags != nil || probe.Values != nil) {
+			var e formatters.EventMsg
+			if err := json.Unmarshal(item, &e); err != nil {
+				return nil, fmt.Errorf("invalid event message: %w", err)
+			}
+			events = append(events, &e)
+			continue
+		}
+		var n formatters.NotificationRspMsg
+		if err := json.Unmarshal(item, &n); err != nil {
+			return nil, fmt.Errorf("invalid JSON notification: %w", err)
+		}
+		evs, err := notificationEvents(n)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, evs...)
+	}
+	return events, nil
+}
+
+// jsonUpdate is an update of the gnmic JSON rendering, whose type is not
+// exported by gnmic.
+type jsonUpdate struct {
+	Path   string                 `json:"Path"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// notificationEvents returns an event per update of a gnmic JSON
+// notification, named and tagged the way gnmic names events.
+func notificationEvents(n formatters.NotificationRspMsg) ([]*formatters.EventMsg, error) {
+	// The update type is unexported, so decode the updates again.
+	raw, err := json.Marshal(n.Updates)
+	if err != nil {
+		return nil, err
+	}
+	var updates []jsonUpdate
+	if err := json.Unmarshal(raw, &updates); err != nil {
+		return nil, err
+	}
+
+	prefix, err := utils.ParsePath(n.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid prefix %q: %w", n.Prefix, err)
+	}
+	events := make([]*formatters.EventMsg, 0, len(updates))
+	for _, upd := range updates {
+		path, err := utils.ParsePath(upd.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path %q: %w", upd.Path, err)
+		}
+		e := &formatters.EventMsg{
+			Name:      n.SubscriptionName,
+			Timestamp: n.Timestamp,
+			Tags:      make(map[string]string),
+			Values:    make(map[string]interface{}),
+		}
+		for _, p := range []*gnmi.Path{prefix, path} {
+			for _, elem := range p.GetElem() {
+				name := elem.GetName()
+				if i := strings.LastIndex(name, ":"); i >= 0 {
+					name = name[i+1:]
+				}
+				for k, v := range elem.GetKey() {
+					e.Tags[name+"_"+k] = v
+				}
+			}
+		}
+		if n.Source != "" {
+			e.Tags["source"] = n.Source
+		}
+		if n.SubscriptionName != "" {
+			e.Tags["subscription-name"] = n.SubscriptionName
+		}
+		base := strings.TrimRight("/"+utils.GnmiPathToXPath(&gnmi.Path{Elem: prefix.GetElem()}, true), "/")
+		for name, v := range upd.Values {
+			flatten(e.Values, base+"/"+strings.TrimLeft(name, "/"), v)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// flatten stores v under name in values, with the leaves of JSON objects
+// stored under their own path.
+func flatten(values map[string]interface{}, name string, v interface{}) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		values[name] = v
+		return
+	}
+	for k, child := range obj {
+		if i := strings.LastIndex(k, ":"); i >= 0 {
+			k = k[i+1:]
+		}
+		flatten(values, name+"/"+k, child)
+	}
+}
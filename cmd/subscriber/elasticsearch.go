@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ElasticsearchConfig indexes every received value as a document of a daily
+// index named Index-YYYY.MM.DD, through the bulk API of Elasticsearch or
+// OpenSearch. Documents are sent in batches of BatchSize, or every
+// FlushInterval. Documents rejected with 429 Too Many Requests are retried
+// with exponential backoff up to MaxRetries times.
+type ElasticsearchConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	URLs          []string      `yaml:"urls"`
+	Index         string        `yaml:"index"`
+	Username      string        `yaml:"username"`
+	Password      string        `yaml:"password"`
+	APIKey        string        `yaml:"api_key"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	MaxRetries    int           `yaml:"max_retries"`
+	TLS           natsopts.TLS  `yaml:"tls"`
+}
+
+const (
+	defaultElasticsearchIndex      = "gnmi-telemetry"
+	defaultElasticsearchBatchSize  = 1000
+	defaultElasticsearchMaxRetries = 5
+)
+
+func (e ElasticsearchConfig) validate() error {
+	if !e.Enabled {
+		return nil
+	}
+	if len(e.URLs) == 0 {
+		return fmt.Errorf("elasticsearch: at least one url is required")
+	}
+	for _, u := range e.URLs {
+		if _, err := url.ParseRequestURI(u); err != nil {
+			return fmt.Errorf("elasticsearch: invalid url %q: %w", u, err)
+		}
+	}
+	if _, err := e.TLS.Config(); err != nil {
+		return fmt.Errorf("elasticsearch: invalid TLS settings: %w", err)
+	}
+	return nil
+}
+
+// esDocument is a telemetry document and the index it goes to.
+type esDocument struct {
+	index string
+	body  []byte
+}
+
+// esSource is the indexed document. Numeric values go to Value and anything
+// else to ValueText, so the field mappings stay consistent.
+type esSource struct {
+	Timestamp    time.Time         `json:"@timestamp"`
+	Target       string            `json:"target"`
+	Subscription string            `json:"subscription"`
+	Subject      string            `json:"subject"`
+	Path         string            `json:"path"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Value        *float64          `json:"value,omitempty"`
+	ValueText    *string           `json:"value_text,omitempty"`
+}
+
+// elasticsearchOutput bulk-indexes the values of received events.
+type elasticsearchOutput struct {
+	conf    ElasticsearchConfig
+	client  *http.Client
+	batcher *batcher[esDocument]
+}
+
+func newElasticsearchOutput(conf ElasticsearchConfig) (*elasticsearchOutput, error) {
+	if conf.Index == "" {
+		conf.Index = defaultElasticsearchIndex
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultElasticsearchBatchSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = time.Second
+	}
+	if conf.MaxRetries <= 0 {
+		conf.MaxRetries = defaultElasticsearchMaxRetries
+	}
+	tlsConf, err := conf.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch: invalid TLS settings: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+
+	o := &elasticsearchOutput{
+		conf:   conf,
+		client: &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}
+	o.batcher = newBatcher("elasticsearch", conf.BatchSize, conf.FlushInterval, o.index)
+	slog.Info("Indexing telemetry", "urls", strings.Join(conf.URLs, ","), "index", conf.Index+"-*")
+	return o, nil
+}
+
+func (o *elasticsearchOutput) Write(_ context.Context, m *message) error {
+	target := m.Header.Get("Gnmi-Target")
+	var docs []esDocument
+	for _, ev := range m.events {
+		doc := esSource{Timestamp: m.received.UTC(), Subject: m.Subject}
+		if ev.Timestamp > 0 {
+			doc.Timestamp = time.Unix(0, ev.Timestamp).UTC()
+		}
+		doc.Target, doc.Subscription, doc.Tags = splitTags(ev, target)
+		index := o.conf.Index + "-" + doc.Timestamp.Format("2006.01.02")
+		for path, v := range ev.Values {
+			d := doc
+			d.Path = path
+			d.Value, d.ValueText = nil, nil
+			if f, ok := numericValue(v); ok {
+				d.Value = &f
+			} else {
+				s := valueText(v)
+				d.ValueText = &s
+			}
+			body, err := json.Marshal(d)
+			if err != nil {
+				return err
+			}
+			docs = append(docs, esDocument{index: index, body: body})
+		}
+	}
+	if len(docs) > 0 {
+		o.batcher.add(m.hold(), docs...)
+	}
+	return nil
+}
+
+// index sends docs with the bulk API, retrying the documents rejected with
+// 429 with exponential backoff. Documents rejected for other reasons are
+// dropped, as sending them again would fail the same way.
+func (o *elasticsearchOutput) index(docs []esDocument) error {
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		retry, err := o.bulk(docs)
+		if err == nil {
+			if len(retry) == 0 {
+				return nil
+			}
+			docs = retry
+		}
+		if attempt == o.conf.MaxRetries {
+			if err != nil {
+				return err
+			}
+			slog.Error("Dropping documents still rejected by Elasticsearch", "documents", len(docs), "retries", attempt)
+			return nil
+		}
+		slog.Debug("Retrying bulk request", "documents", len(docs), "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// bulkResponse holds the fields of a bulk API response needed to find the
+// rejected documents.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+// bulk sends one bulk request for docs and returns the documents to retry.
+// An error means none of docs were indexed; the URLs are tried in turn.
+func (o *elasticsearchOutput) bulk(docs []esDocument) ([]esDocument, error) {
+	var body bytes.Buffer
+	for _, d := range docs {
+		action, err := json.Marshal(map[string]map[string]string{"create": {"_index": d.index}})
+		if err != nil {
+			return nil, err
+		}
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(d.body)
+		body.WriteByte('\n')
+	}
+
+	var rsp bulkResponse
+	var err error
+	for _, u := range o.conf.URLs {
+		if err = o.post(strings.TrimRight(u, "/")+"/_bulk", body.Bytes(), &rsp); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !rsp.Errors {
+		return nil, nil
+	}
+
+	var retry []esDocument
+	var dropped int
+	var firstError json.RawMessage
+	for i, item := range rsp.Items {
+		for _, result := range item {
+			switch {
+			case result.Status == http.StatusTooManyRequests && i < len(docs):
+				retry = append(retry, docs[i])
+			case result.Status >= 300:
+				dropped++
+				if firstError == nil {
+					firstError = result.Error
+				}
+			}
+		}
+	}
+	if dropped > 0 {
+		slog.Error("Elasticsearch rejected documents", "documents", dropped, "error", string(firstError))
+	}
+	return retry, nil
+}
+
+// post sends body to u and decodes the JSON response into v.
+func (o *elasticsearchOutput) post(u string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	switch {
+	case o.conf.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+o.conf.APIKey)
+	case o.conf.Username != "":
+		req.SetBasicAuth(o.conf.Username, o.conf.Password)
+	}
+
+	rsp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("bulk request failed: %s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(rsp.Body).Decode(v)
+}
+
+func (o *elasticsearchOutput) Close() error {
+	o.batcher.close()
+	return nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileConfig archives every received message as a line of JSON in Path. The
+// file is rotated once it reaches MaxSizeMB or has been written to for
+// MaxAge, both checked on each write. Rotated files get a timestamp in their
+// name, are gzipped when Compress is set, and only the newest MaxBackups are
+// kept.
+type FileConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Path       string        `yaml:"path"`
+	MaxSizeMB  int           `yaml:"max_size_mb"`
+	MaxAge     time.Duration `yaml:"max_age"`
+	Compress   bool          `yaml:"compress"`
+	MaxBackups int           `yaml:"max_backups"`
+}
+
+func (f FileConfig) validate() error {
+	if f.Enabled && f.Path == "" {
+		return fmt.Errorf("file: path is required")
+	}
+	return nil
+}
+
+// fileRecord is a line of the archive. JSON payloads are embedded as is;
+// anything else, such as proto payloads, is base64 encoded.
+type fileRecord struct {
+	Subject  string            `json:"subject"`
+	Received time.Time         `json:"received"`
+	Meta     map[string]string `json:"meta,omitempty"`
+	Payload  json.RawMessage   `json:"payload"`
+}
+
+// archiveLine returns the line of JSON archiving m, newline included.
+func archiveLine(m *message) ([]byte, error) {
+	var payload bytes.Buffer
+	if err := json.Compact(&payload, m.Data); err != nil {
+		payload.Reset()
+		encoded, err := json.Marshal(m.Data)
+		if err != nil {
+			return nil, err
+		}
+		payload.Write(encoded)
+	}
+	record := fileRecord{Subject: m.Subject, Received: m.received, Payload: payload.Bytes()}
+	if len(m.Header) > 0 {
+		record.Meta = make(map[string]string, len(m.Header))
+		for k := range m.Header {
+			record.Meta[k] = m.Header.Get(k)
+		}
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+// fileOutput appends records to a file, rotating it as configured.
+type fileOutput struct {
+	conf FileConfig
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+
+	// wg tracks the compression of rotated files.
+	wg sync.WaitGroup
+}
+
+func newFileOutput(conf FileConfig) (*fileOutput, error) {
+	o := &fileOutput{conf: conf}
+	if err := os.MkdirAll(filepath.Dir(conf.Path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := o.open(); err != nil {
+		return nil, err
+	}
+	slog.Info("Archiving telemetry", "file", conf.Path)
+	return o, nil
+}
+
+// open opens the file for appending. o.mu must be held or o not yet shared.
+func (o *fileOutput) open() error {
+	f, err := os.OpenFile(o.conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening archive file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	o.f, o.size, o.opened = f, info.Size(), time.Now()
+	return nil
+}
+
+func (o *fileOutput) Write(_ context.Context, m *message) error {
+	line, err := archiveLine(m)
+	if err != nil {
+		return err
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.f == nil {
+		return fmt.Errorf("archive file is closed")
+	}
+	if o.due(len(line)) {
+		if err := o.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := o.f.Write(line)
+	o.size += int64(n)
+	return err
+}
+
+// due reports whether the file must be rotated before writing n more bytes.
+// Empty files are never rotated. o.mu must be held.
+func (o *fileOutput) due(n int) bool {
+	if o.size == 0 {
+		return false
+	}
+	if o.conf.MaxSizeMB > 0 && o.size+int64(n) > int64(o.conf.MaxSizeMB)<<20 {
+		return true
+	}
+	return o.conf.MaxAge > 0 && time.Since(o.opened) >= o.conf.MaxAge
+}
+
+// rotate moves the current file aside and opens a new one. o.mu must be held.
+func (o *fileOutput) rotate() error {
+	if err := o.f.Close(); err != nil {
+		slog.Warn("Error closing archive file", "file", o.conf.Path, "error", err)
+	}
+	o.f = nil
+
+	rotated := o.rotatedName(time.Now())
+	if err := os.Rename(o.conf.Path, rotated); err != nil {
+		return fmt.Errorf("error rotating archive file: %w", err)
+	}
+	if err := o.open(); err != nil {
+		return err
+	}
+
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		if o.conf.Compress {
+			if err := gzipFile(rotated); err != nil {
+				slog.Error("Error compressing archive file", "file", rotated, "error", err)
+			}
+		}
+		o.prune()
+	}()
+	return nil
+}
+
+// rotatedName returns an unused name for the file rotated at t, such as
+// telemetry-20240102T150405Z.ndjson for telemetry.ndjson.
+func (o *fileOutput) rotatedName(t time.Time) string {
+	ext := filepath.Ext(o.conf.Path)
+	base := strings.TrimSuffix(o.conf.Path, ext) + "-" + t.UTC().Format("20060102T150405Z")
+	name := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			if _, err := os.Stat(name + ".gz"); os.IsNotExist(err) {
+				return name
+			}
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+}
+
+// prune removes the oldest rotated files beyond MaxBackups.
+func (o *fileOutput) prune() {
+	if o.conf.MaxBackups <= 0 {
+		return
+	}
+	ext := filepath.Ext(o.conf.Path)
+	files, err := filepath.Glob(strings.TrimSuffix(o.conf.Path, ext) + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+	// Uncompressed copies of files being compressed are not backups.
+	var backups []string
+	for _, f := range files {
+		if strings.HasSuffix(f, ext) || strings.HasSuffix(f, ext+".gz") {
+			if _, err := os.Stat(f + ".gz"); err == nil {
+				continue
+			}
+			backups = append(backups, f)
+		}
+	}
+	// The timestamps in the names sort in rotation order.
+	sort.Strings(backups)
+	for len(backups) > o.conf.MaxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			slog.Warn("Error removing old archive file", "file", backups[0], "error", err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// gzipFile compresses name to name.gz and removes name.
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(name)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Close closes the file and waits for rotated files to be compressed.
+func (o *fileOutput) Close() error {
+	o.mu.Lock()
+	var err error
+	if o.f != nil {
+		err = o.f.Close()
+		o.f = nil
+	}
+	o.mu.Unlock()
+	o.wg.Wait()
+	return err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/itchyny/gojq"
+	"github.com/openconfig/gnmic/formatters"
+	"log/slog"
+)
+
+// eventFilter keeps the events for which a jq expression is true, that is
+// its first result is neither false nor null. The expression is run on each
+// event in its JSON form, such as
+//
+//	.tags.interface_name == "Ethernet1"
+//	.values["/interfaces/interface/state/counters/in-errors"] > 100
+type eventFilter struct {
+	expr string
+	code *gojq.Code
+}
+
+func newEventFilter(expr string) (*eventFilter, error) {
+	q, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+	}
+	code, err := gojq.Compile(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+	}
+	return &eventFilter{expr: expr, code: code}, nil
+}
+
+// apply returns the events matching the expression. Events the expression
+// fails on do not match.
+func (f *eventFilter) apply(events []*formatters.EventMsg) []*formatters.EventMsg {
+	var matched []*formatters.EventMsg
+	for _, ev := range events {
+		ok, err := f.match(ev)
+		if err != nil {
+			slog.Debug("Could not evaluate filter", "filter", f.expr, "event", ev.Name, "error", err)
+			continue
+		}
+		if ok {
+			matched = append(matched, ev)
+		}
+	}
+	return matched
+}
+
+func (f *eventFilter) match(ev *formatters.EventMsg) (bool, error) {
+	// gojq only takes the types encoding/json decodes to.
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return false, err
+	}
+	var input interface{}
+	if err := json.Unmarshal(b, &input); err != nil {
+		return false, err
+	}
+	v, ok := f.code.Run(input).Next()
+	if !ok {
+		return false, nil
+	}
+	switch v := v.(type) {
+	case error:
+		return false, v
+	case bool:
+		return v, nil
+	}
+	return v != nil, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// GraphiteConfig sends the numeric values of received events to a Carbon
+// server over the plaintext protocol. Metric is a template of the metric
+// name, executed with .Target, .Subscription, .Path, the gNMI path in dotted
+// form, .Tags and .Keys, the tag values sorted by tag name and joined with
+// dots. Every part is sanitized to letters, digits, - and _. With Tagged
+// set, the tags are also sent as Graphite 1.1 tags.
+type GraphiteConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+	Metric  string `yaml:"metric"`
+	Tagged  bool   `yaml:"tagged"`
+}
+
+const (
+	defaultGraphiteMetric = "gnmi.{{.Target}}{{with .Keys}}.{{.}}{{end}}.{{.Path}}"
+	defaultGraphitePort   = "2003"
+)
+
+func (g GraphiteConfig) validate() error {
+	if !g.Enabled {
+		return nil
+	}
+	if g.Address == "" {
+		return fmt.Errorf("graphite: address is required")
+	}
+	_, err := graphiteMetric(g)
+	return err
+}
+
+func graphiteMetric(conf GraphiteConfig) (*template.Template, error) {
+	if conf.Metric == "" {
+		conf.Metric = defaultGraphiteMetric
+	}
+	t, err := template.New("metric").Option("missingkey=zero").Parse(conf.Metric)
+	if err != nil {
+		return nil, fmt.Errorf("graphite: invalid metric template: %w", err)
+	}
+	return t, nil
+}
+
+// graphiteRecord is the data the metric template is executed with.
+type graphiteRecord struct {
+	Target       string
+	Subscription string
+	Path         string
+	Tags         map[string]string
+	Keys         string
+}
+
+// graphiteOutput writes metric lines to a connection it redials after
+// errors.
+type graphiteOutput struct {
+	address string
+	tagged  bool
+	metric  *template.Template
+
+	mu   sync.Mutex
+	conn net.Conn
+	w    *bufio.Writer
+}
+
+func newGraphiteOutput(conf GraphiteConfig) (*graphiteOutput, error) {
+	metric, err := graphiteMetric(conf)
+	if err != nil {
+		return nil, err
+	}
+	address := conf.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, defaultGraphitePort)
+	}
+	slog.Info("Sending telemetry to Graphite", "address", address)
+	return &graphiteOutput{address: address, tagged: conf.Tagged, metric: metric}, nil
+}
+
+// graphiteName replaces the characters of s that have a meaning in metric
+// names, or are not safe in file names, with _.
+func graphiteName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// graphitePath returns path in dotted form, such as
+// interfaces.interface.state.counters.in-octets.
+func graphitePath(path string) string {
+	elems := strings.Split(strings.Trim(path, "/"), "/")
+	for i, e := range elems {
+		elems[i] = graphiteName(e)
+	}
+	return strings.Join(elems, ".")
+}
+
+func (o *graphiteOutput) Write(_ context.Context, m *message) error {
+	var lines strings.Builder
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target, subscription, tags := splitTags(ev, m.Header.Get("Gnmi-Target"))
+		rec := graphiteRecord{
+			Target:       graphiteName(target),
+			Subscription: graphiteName(subscription),
+			Tags:         make(map[string]string, len(tags)),
+		}
+		for k, v := range tags {
+			rec.Tags[graphiteName(k)] = graphiteName(v)
+		}
+		keys := make([]string, 0, len(rec.Tags))
+		for k := range rec.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]string, len(keys))
+		for i, k := range keys {
+			values[i] = rec.Tags[k]
+		}
+		rec.Keys = strings.Join(values, ".")
+
+		for path, v := range ev.Values {
+			f, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			r := rec
+			r.Path = graphitePath(path)
+			var name strings.Builder
+			if err := o.metric.Execute(&name, r); err != nil {
+				return fmt.Errorf("could not render Graphite metric name: %w", err)
+			}
+			lines.WriteString(name.String())
+			if o.tagged {
+				for _, k := range keys {
+					lines.WriteString(";" + k + "=" + rec.Tags[k])
+				}
+			}
+			fmt.Fprintf(&lines, " %s %d\n", strconv.FormatFloat(f, 'f', -1, 64), ts.Unix())
+		}
+	}
+	if lines.Len() == 0 {
+		return nil
+	}
+	return o.send(lines.String())
+}
+
+// send writes lines, dialing first if needed and redialing once after an
+// error.
+func (o *graphiteOutput) send(lines string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if o.conn == nil {
+			if o.conn, err = net.DialTimeout("tcp", o.address, 5*time.Second); err != nil {
+				return fmt.Errorf("could not connect to Graphite: %w", err)
+			}
+			o.w = bufio.NewWriter(o.conn)
+		}
+		o.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = o.w.WriteString(lines); err == nil {
+			if err = o.w.Flush(); err == nil {
+				return nil
+			}
+		}
+		o.conn.Close()
+		o.conn = nil
+	}
+	return fmt.Errorf("error sending to Graphite: %w", err)
+}
+
+func (o *graphiteOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// subscribeDurable creates (or resumes) a durable JetStream consumer for each
+// subject. handler acknowledges the messages explicitly once handled (see
+// pendingAck), so anything published while the subscriber was down is delivered
+// when it comes back. With a queue group, the subscribers using the same
+// durable name share its messages.
+func subscribeDurable(nc *nats.Conn, conf JetStreamConfig, subjects []string, queue string, handler nats.MsgHandler) ([]*nats.Subscription, error) {
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("error creating JetStream context: %v", err)
+	}
+
+	deliver, err := deliverOption(conf.DeliverPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []*nats.Subscription
+	for _, subject := range subjects {
+		opts := []nats.SubOpt{
+			nats.Durable(durableName(conf.Durable, subject, len(subjects))),
+			nats.ManualAck(),
+			nats.AckExplicit(),
+			deliver,
+		}
+		if conf.Stream != "" {
+			opts = append(opts, nats.BindStream(conf.Stream))
+		}
+		if conf.AckWait > 0 {
+			opts = append(opts, nats.AckWait(conf.AckWait))
+		}
+
+		var sub *nats.Subscription
+		if queue != "" {
+			sub, err = js.QueueSubscribe(subject, queue, handler, opts...)
+		} else {
+			sub, err = js.Subscribe(subject, handler, opts...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error creating durable consumer for %s: %v", subject, err)
+		}
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+// pendingAck acknowledges the JetStream messages a telemetry message was
+// received in, the message itself or all of its chunks, once every output
+// holding it has written it. It is nil outside JetStream mode.
+type pendingAck struct {
+	mu     sync.Mutex
+	msgs   []*nats.Msg
+	holds  int
+	failed bool
+}
+
+// newPendingAck returns the acknowledgement of msgs, held by the handler
+// until it releases it.
+func newPendingAck(msgs []*nats.Msg) *pendingAck {
+	return &pendingAck{msgs: msgs, holds: 1}
+}
+
+// hold keeps the messages from being acknowledged until the returned func is
+// called with the outcome of writing them.
+func (p *pendingAck) hold() func(error) {
+	if p == nil {
+		return func(error) {}
+	}
+	p.mu.Lock()
+	p.holds++
+	p.mu.Unlock()
+	return p.release
+}
+
+// release drops a hold. Once none are left, the messages are acknowledged,
+// or, if an output could not write them, negatively acknowledged so that
+// they are delivered again.
+func (p *pendingAck) release(err error) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.failed = p.failed || err != nil
+	p.holds--
+	ack := p.holds == 0
+	p.mu.Unlock()
+	if !ack {
+		return
+	}
+	for _, msg := range p.msgs {
+		if p.failed {
+			err = msg.Nak()
+		} else {
+			err = msg.Ack()
+		}
+		if err != nil {
+			slog.Error("Error acknowledging message", "subject", msg.Subject, "error", err)
+		}
+	}
+}
+
+// durableName returns the consumer name for subject. Each filter subject needs
+// its own consumer, so the subject is appended when there is more than one.
+func durableName(durable, subject string, count int) string {
+	if count == 1 {
+		return durable
+	}
+	return durable + "_" + strings.NewReplacer(".", "_", "*", "all", ">", "rest").Replace(subject)
+}
+
+func deliverOption(policy string) (nats.SubOpt, error) {
+	switch policy {
+	case "", "all":
+		return nats.DeliverAll(), nil
+	case "new":
+		return nats.DeliverNew(), nil
+	case "last":
+		return nats.DeliverLast(), nil
+	case "last_per_subject":
+		return nats.DeliverLastPerSubject(), nil
+	}
+	return nil, fmt.Errorf("unknown deliver policy %q", policy)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+	"log/slog"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// KafkaConfig forwards every received event as a JSON record to Kafka, with
+// the NATS headers of its message. Topic and Key are templates executed for
+// each event, with .Subject, .Target, .Subscription and .Tags, so records
+// can be spread over topics and keyed by target or interface. Records with
+// the same key go to the same partition, keeping their order.
+type KafkaConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Brokers      []string      `yaml:"brokers"`
+	Topic        string        `yaml:"topic"`
+	Key          string        `yaml:"key"`
+	Compression  string        `yaml:"compression"`
+	RequiredAcks string        `yaml:"required_acks"`
+	BatchSize    int           `yaml:"batch_size"`
+	BatchTimeout time.Duration `yaml:"batch_timeout"`
+	SASL         KafkaSASL     `yaml:"sasl"`
+	TLS          natsopts.TLS  `yaml:"tls"`
+}
+
+// KafkaSASL authenticates to the brokers with the PLAIN, SCRAM-SHA-256 or
+// SCRAM-SHA-512 mechanism.
+type KafkaSASL struct {
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+const (
+	defaultKafkaTopic = "gnmi-telemetry"
+	defaultKafkaKey   = "{{.Target}}"
+)
+
+func (k KafkaConfig) validate() error {
+	if !k.Enabled {
+		return nil
+	}
+	if _, err := newKafkaWriter(k); err != nil {
+		return err
+	}
+	_, _, err := kafkaTemplates(k)
+	return err
+}
+
+// kafkaRecord is the data the topic and key templates are executed with.
+type kafkaRecord struct {
+	Subject      string
+	Target       string
+	Subscription string
+	Tags         map[string]string
+}
+
+// kafkaOutput produces a record per event, asynchronously and in batches.
+type kafkaOutput struct {
+	writer *kafka.Writer
+	topic  *template.Template
+	key    *template.Template
+}
+
+func newKafkaOutput(conf KafkaConfig) (*kafkaOutput, error) {
+	w, err := newKafkaWriter(conf)
+	if err != nil {
+		return nil, err
+	}
+	topic, key, err := kafkaTemplates(conf)
+	if err != nil {
+		return nil, err
+	}
+	w.Completion = func(messages []kafka.Message, err error) {
+		if err != nil {
+			slog.Error("Error producing Kafka records", "records", len(messages), "error", err)
+		}
+	}
+	slog.Info("Forwarding telemetry to Kafka", "brokers", strings.Join(conf.Brokers, ","))
+	return &kafkaOutput{writer: w, topic: topic, key: key}, nil
+}
+
+func newKafkaWriter(conf KafkaConfig) (*kafka.Writer, error) {
+	if len(conf.Brokers) == 0 {
+		return nil, fmt.Errorf("kafka: at least one broker is required")
+	}
+
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(conf.Brokers...),
+		Balancer:     &kafka.Hash{},
+		BatchSize:    conf.BatchSize,
+		BatchTimeout: conf.BatchTimeout,
+		Async:        true,
+	}
+	if w.BatchTimeout <= 0 {
+		w.BatchTimeout = 100 * time.Millisecond
+	}
+	switch conf.Compression {
+	case "", "none":
+	case "gzip":
+		w.Compression = kafka.Gzip
+	case "snappy":
+		w.Compression = kafka.Snappy
+	case "lz4":
+		w.Compression = kafka.Lz4
+	case "zstd":
+		w.Compression = kafka.Zstd
+	default:
+		return nil, fmt.Errorf("kafka: unknown compression %q", conf.Compression)
+	}
+	switch conf.RequiredAcks {
+	case "", "all":
+		w.RequiredAcks = kafka.RequireAll
+	case "one":
+		w.RequiredAcks = kafka.RequireOne
+	case "none":
+		w.RequiredAcks = kafka.RequireNone
+	default:
+		return nil, fmt.Errorf("kafka: required_acks must be none, one or all, got %q", conf.RequiredAcks)
+	}
+
+	transport := &kafka.Transport{ClientID: "nats-gnmi-subscriber"}
+	tlsConf, err := conf.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("kafka: invalid TLS settings: %w", err)
+	}
+	transport.TLS = tlsConf
+	if transport.SASL, err = conf.SASL.mechanism(); err != nil {
+		return nil, err
+	}
+	w.Transport = transport
+	return w, nil
+}
+
+func (s KafkaSASL) mechanism() (sasl.Mechanism, error) {
+	switch strings.ToUpper(s.Mechanism) {
+	case "":
+		return nil, nil
+	case "PLAIN":
+		return plain.Mechanism{Username: s.Username, Password: s.Password}, nil
+	case "SCRAM-SHA-256":
+		return scram.Mechanism(scram.SHA256, s.Username, s.Password)
+	case "SCRAM-SHA-512":
+		return scram.Mechanism(scram.SHA512, s.Username, s.Password)
+	}
+	return nil, fmt.Errorf("kafka: unknown SASL mechanism %q", s.Mechanism)
+}
+
+func kafkaTemplates(conf KafkaConfig) (topic, key *template.Template, err error) {
+	if conf.Topic == "" {
+		conf.Topic = defaultKafkaTopic
+	}
+	if conf.Key == "" {
+		conf.Key = defaultKafkaKey
+	}
+	if topic, err = template.New("topic").Option("missingkey=zero").Parse(conf.Topic); err != nil {
+		return nil, nil, fmt.Errorf("kafka: invalid topic template: %w", err)
+	}
+	if key, err = template.New("key").Option("missingkey=zero").Parse(conf.Key); err != nil {
+		return nil, nil, fmt.Errorf("kafka: invalid key template: %w", err)
+	}
+	return topic, key, nil
+}
+
+func (o *kafkaOutput) Write(ctx context.Context, m *message) error {
+	rec := kafkaRecord{
+		Subject:      m.Subject,
+		Target:       m.Header.Get("Gnmi-Target"),
+		Subscription: m.Header.Get("Gnmi-Subscription"),
+	}
+	var headers []kafka.Header
+	for k, vs := range m.Header {
+		for _, v := range vs {
+			headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+	}
+
+	msgs := make([]kafka.Message, 0, len(m.events))
+	for _, ev := range m.events {
+		value, err := json.Marshal(ev)
+		if err != nil {
+			return err
+		}
+		r := rec
+		r.Tags = ev.Tags
+		if source := ev.Tags["source"]; source != "" {
+			r.Target = source
+		}
+		if sub := ev.Tags["subscription-name"]; sub != "" {
+			r.Subscription = sub
+		}
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		msg, err := o.record(r, value, headers, ts)
+		if err != nil {
+			return err
+		}
+		msgs = append(msgs, msg)
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return o.writer.WriteMessages(ctx, msgs...)
+}
+
+// record returns the Kafka record for value, with its topic and key taken
+// from the templates.
+func (o *kafkaOutput) record(r kafkaRecord, value []byte, headers []kafka.Header, ts time.Time) (kafka.Message, error) {
+	var topic, key strings.Builder
+	if err := o.topic.Execute(&topic, r); err != nil {
+		return kafka.Message{}, fmt.Errorf("could not render Kafka topic: %w", err)
+	}
+	if err := o.key.Execute(&key, r); err != nil {
+		return kafka.Message{}, fmt.Errorf("could not render Kafka key: %w", err)
+	}
+	return kafka.Message{
+		Topic:   topic.String(),
+		Key:     []byte(key.String()),
+		Value:   value,
+		Headers: headers,
+		Time:    ts,
+	}, nil
+}
+
+// Close sends the pending records.
+func (o *kafkaOutput) Close() error {
+	return o.writer.Close()
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KVConfig mirrors the latest value of every path of every target into the
+// JetStream key-value bucket Bucket, so any NATS client can read the current
+// state of the devices, and its recent changes from the revisions of the
+// keys. The bucket is created when missing, keeping History revisions per
+// key, for TTL when set, on Replicas servers. Keys are the target, the path
+// elements and the keys of the path, such as
+// router1.interfaces.interface.state.oper-status.interface_name=Ethernet1.
+// Values are only written when they change.
+type KVConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Bucket   string        `yaml:"bucket"`
+	History  int           `yaml:"history"`
+	TTL      time.Duration `yaml:"ttl"`
+	Replicas int           `yaml:"replicas"`
+}
+
+const (
+	defaultKVBucket  = "gnmi-state"
+	defaultKVHistory = 10
+)
+
+func (k KVConfig) validate() error {
+	if !k.Enabled {
+		return nil
+	}
+	for _, r := range k.Bucket {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return fmt.Errorf("kv: invalid bucket name %q", k.Bucket)
+		}
+	}
+	if k.History < 0 || k.History > 64 {
+		return fmt.Errorf("kv: history must be at most 64")
+	}
+	return nil
+}
+
+// kvOutput writes the changed values from a single goroutine. Values
+// waiting to be written are replaced by newer ones of the same key, so a
+// slow server delays the state but does not queue up stale values.
+type kvOutput struct {
+	kv nats.KeyValue
+
+	mu      sync.Mutex
+	written map[string]string
+	pending map[string][]byte
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newKVOutput(conf KVConfig, nc *nats.Conn) (*kvOutput, error) {
+	if conf.Bucket == "" {
+		conf.Bucket = defaultKVBucket
+	}
+	if conf.History == 0 {
+		conf.History = defaultKVHistory
+	}
+	js, err := nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("kv: error creating JetStream context: %v", err)
+	}
+	kv, err := js.KeyValue(conf.Bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:      conf.Bucket,
+			Description: "Latest gNMI telemetry values",
+			History:     uint8(conf.History),
+			TTL:         conf.TTL,
+			Replicas:    conf.Replicas,
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kv: could not open bucket %s: %w", conf.Bucket, err)
+	}
+
+	o := &kvOutput{
+		kv:      kv,
+		written: make(map[string]string),
+		pending: make(map[string][]byte),
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	o.wg.Add(1)
+	go o.run()
+	slog.Info("Mirroring latest values to NATS KV", "bucket", conf.Bucket)
+	return o, nil
+}
+
+func (o *kvOutput) Write(_ context.Context, m *message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target, _, tags := splitTags(ev, m.Header.Get("Gnmi-Target"))
+		if target == "" {
+			continue
+		}
+		for path, v := range ev.Values {
+			key := kvKey(target, path, tags)
+			text := valueText(v)
+			if o.written[key] == text {
+				continue
+			}
+			data, err := json.Marshal(stateValue{Path: path, Tags: tags, Value: v, Timestamp: ts, Subject: m.Subject})
+			if err != nil {
+				return err
+			}
+			o.written[key] = text
+			o.pending[key] = data
+		}
+	}
+	if len(o.pending) > 0 {
+		select {
+		case o.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// kvKey returns the key of the values of path for target and tags. Dots
+// and other characters keys cannot hold become underscores within the
+// tokens.
+func kvKey(target, path string, tags map[string]string) string {
+	tokens := []string{kvToken(target)}
+	for _, elem := range strings.Split(path, "/") {
+		if elem != "" {
+			tokens = append(tokens, kvToken(elem))
+		}
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tokens = append(tokens, kvToken(name)+"="+kvToken(tags[name]))
+	}
+	return strings.Join(tokens, ".")
+}
+
+func kvToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '/' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// run writes the pending values until closed, and the last ones then.
+func (o *kvOutput) run() {
+	defer o.wg.Done()
+	for {
+		select {
+		case <-o.wake:
+			o.flush()
+		case <-o.done:
+			o.flush()
+			return
+		}
+	}
+}
+
+// flush writes the pending values. A value that cannot be written is
+// written again with its next change.
+func (o *kvOutput) flush() {
+	o.mu.Lock()
+	pending := o.pending
+	o.pending = make(map[string][]byte)
+	o.mu.Unlock()
+
+	for key, data := range pending {
+		_, err := o.kv.Put(key, data)
+		if errors.Is(err, nats.ErrConnectionClosed) {
+			slog.Warn("NATS connection closed before writing to NATS KV", "values", len(pending))
+			return
+		}
+		if err != nil {
+			slog.Error("Error writing to NATS KV", "key", key, "error", err)
+			o.mu.Lock()
+			delete(o.written, key)
+			o.mu.Unlock()
+		}
+	}
+}
+
+// Close writes the pending values.
+func (o *kvOutput) Close() error {
+	close(o.done)
+	o.wg.Wait()
+	return nil
+}
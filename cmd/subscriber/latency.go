@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"github.com/openconfig/gnmic/formatters"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultLatencyBuckets are the upper bounds, in seconds, of the latency
+// histogram buckets.
+var defaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// notificationTime returns the gNMI timestamp of msg: the Gnmi-Timestamp
+// header set by the publisher or, without it, the timestamp of the first
+// event.
+func notificationTime(msg *nats.Msg, events []*formatters.EventMsg) (time.Time, bool) {
+	if ts, err := strconv.ParseInt(msg.Header.Get("Gnmi-Timestamp"), 10, 64); err == nil && ts > 0 {
+		return time.Unix(0, ts), true
+	}
+	for _, ev := range events {
+		if ev.Timestamp > 0 {
+			return time.Unix(0, ev.Timestamp), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// latencyHistogram is a Prometheus histogram of latencies in seconds.
+type latencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// observe adds a latency to the histogram. Negative latencies, from clocks
+// that are not in sync, count as zero.
+func (h *latencyHistogram) observe(buckets []float64, latency time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(buckets))
+	}
+	secs := latency.Seconds()
+	if secs < 0 {
+		secs = 0
+	}
+	for i, le := range buckets {
+		if secs <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += secs
+}
+
+// write writes the series of the histogram named name with labels, given
+// without their braces.
+func (h *latencyHistogram) write(b *strings.Builder, name, labels string, buckets []float64) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, le := range buckets {
+		fmt.Fprintf(b, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	braces := ""
+	if labels != "" {
+		braces = "{" + labels + "}"
+	}
+	fmt.Fprintf(b, "%s_sum%s %s\n", name, braces, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(b, "%s_count%s %d\n", name, braces, h.count)
+}
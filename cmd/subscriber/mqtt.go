@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MQTTConfig publishes every received event as JSON to an MQTT broker, for
+// IoT and edge platforms that only speak MQTT. Topic is a template executed
+// for each event, with .Subject, .Target, .Subscription and .Tags. With
+// PerValue set, each value is published on its own instead, with the value
+// as payload and .Path also available to the topic template.
+type MQTTConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Broker   string        `yaml:"broker"`
+	ClientID string        `yaml:"client_id"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password"`
+	Topic    string        `yaml:"topic"`
+	QoS      byte          `yaml:"qos"`
+	Retain   bool          `yaml:"retain"`
+	PerValue bool          `yaml:"per_value"`
+	Timeout  time.Duration `yaml:"timeout"`
+	TLS      natsopts.TLS  `yaml:"tls"`
+}
+
+const (
+	defaultMQTTTopic         = "gnmi/{{.Target}}/{{.Subscription}}"
+	defaultMQTTPerValueTopic = "gnmi/{{.Target}}{{.Path}}"
+)
+
+func (m MQTTConfig) validate() error {
+	if !m.Enabled {
+		return nil
+	}
+	if _, err := url.ParseRequestURI(m.Broker); err != nil {
+		return fmt.Errorf("mqtt: invalid broker %q: %w", m.Broker, err)
+	}
+	if m.QoS > 2 {
+		return fmt.Errorf("mqtt: invalid qos %d", m.QoS)
+	}
+	if _, err := mqttTopic(m); err != nil {
+		return err
+	}
+	if _, err := m.TLS.Config(); err != nil {
+		return fmt.Errorf("mqtt: invalid TLS settings: %w", err)
+	}
+	return nil
+}
+
+func mqttTopic(conf MQTTConfig) (*template.Template, error) {
+	topic := conf.Topic
+	if topic == "" {
+		topic = defaultMQTTTopic
+		if conf.PerValue {
+			topic = defaultMQTTPerValueTopic
+		}
+	}
+	t, err := template.New("topic").Option("missingkey=zero").Parse(topic)
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: invalid topic template: %w", err)
+	}
+	return t, nil
+}
+
+// mqttRecord is the data the topic template is executed with.
+type mqttRecord struct {
+	Subject      string
+	Target       string
+	Subscription string
+	Path         string
+	Tags         map[string]string
+}
+
+// mqttOutput publishes events through a client that reconnects by itself.
+type mqttOutput struct {
+	conf   MQTTConfig
+	client mqtt.Client
+	topic  *template.Template
+}
+
+func newMQTTOutput(conf MQTTConfig) (*mqttOutput, error) {
+	if conf.ClientID == "" {
+		host, _ := os.Hostname()
+		conf.ClientID = "nats-gnmi-subscriber-" + host
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	topic, err := mqttTopic(conf)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf, err := conf.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("mqtt: invalid TLS settings: %w", err)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(conf.Broker).
+		SetClientID(conf.ClientID).
+		SetUsername(conf.Username).
+		SetPassword(conf.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("Lost connection to MQTT broker", "broker", conf.Broker, "error", err)
+		}).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info("Connected to MQTT broker", "broker", conf.Broker)
+		})
+	if tlsConf != nil {
+		opts.SetTLSConfig(tlsConf)
+	}
+	client := mqtt.NewClient(opts)
+	// With connect retry the client keeps trying in the background, so a
+	// broker that is down at startup does not stop the subscriber.
+	if tok := client.Connect(); tok.WaitTimeout(conf.Timeout) && tok.Error() != nil {
+		return nil, fmt.Errorf("could not connect to MQTT broker: %w", tok.Error())
+	}
+	slog.Info("Publishing telemetry to MQTT", "broker", conf.Broker, "qos", conf.QoS)
+	return &mqttOutput{conf: conf, client: client, topic: topic}, nil
+}
+
+func (o *mqttOutput) Write(_ context.Context, m *message) error {
+	// Messages are dropped while reconnecting, rather than holding up the
+	// other outputs.
+	if !o.client.IsConnectionOpen() {
+		return fmt.Errorf("not connected to MQTT broker %s", o.conf.Broker)
+	}
+	var tokens []mqtt.Token
+	for _, ev := range m.events {
+		rec := mqttRecord{
+			Subject:      m.Subject,
+			Target:       m.Header.Get("Gnmi-Target"),
+			Subscription: ev.Name,
+			Tags:         ev.Tags,
+		}
+		if source := ev.Tags["source"]; source != "" {
+			rec.Target = source
+		}
+		if sub := ev.Tags["subscription-name"]; sub != "" {
+			rec.Subscription = sub
+		}
+
+		if !o.conf.PerValue {
+			payload, err := json.Marshal(ev)
+			if err != nil {
+				return err
+			}
+			tok, err := o.publish(rec, payload)
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, tok)
+			continue
+		}
+		for path, v := range ev.Values {
+			r := rec
+			r.Path = path
+			tok, err := o.publish(r, []byte(valueText(v)))
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, tok)
+		}
+	}
+
+	// Waiting for the tokens keeps the broker's pace, and reports errors.
+	for _, tok := range tokens {
+		if !tok.WaitTimeout(o.conf.Timeout) {
+			return fmt.Errorf("timed out publishing to MQTT")
+		}
+		if err := tok.Error(); err != nil {
+			return fmt.Errorf("error publishing to MQTT: %w", err)
+		}
+	}
+	return nil
+}
+
+// publish publishes payload on the topic of r.
+func (o *mqttOutput) publish(r mqttRecord, payload []byte) (mqtt.Token, error) {
+	var topic strings.Builder
+	if err := o.topic.Execute(&topic, r); err != nil {
+		return nil, fmt.Errorf("could not render MQTT topic: %w", err)
+	}
+	return o.client.Publish(topic.String(), o.conf.QoS, o.conf.Retain, payload), nil
+}
+
+// Close disconnects from the broker, giving pending messages a second to be
+// sent.
+func (o *mqttOutput) Close() error {
+	o.client.Disconnect(1000)
+	return nil
+}
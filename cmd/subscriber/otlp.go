@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OTLPConfig exports the numeric values of received events as OpenTelemetry
+// metrics to an OTLP/HTTP receiver, such as an OpenTelemetry collector. The
+// latest value of every series is kept and the series updated since the
+// previous export are sent every Interval. Paths containing one of Counters
+// are sent as cumulative monotonic sums, and the others as gauges. Metric
+// names are Prefix followed by the path in dotted form.
+type OTLPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Endpoint is the base URL of the receiver, e.g. "http://localhost:4318".
+	// Metrics are posted to /v1/metrics under it.
+	Endpoint string `yaml:"endpoint"`
+	// MetricsEndpoint is the full URL to post metrics to, when the receiver
+	// does not use the standard path.
+	MetricsEndpoint string            `yaml:"metrics_endpoint"`
+	Headers         map[string]string `yaml:"headers"`
+	ServiceName     string            `yaml:"service_name"`
+	Prefix          string            `yaml:"prefix"`
+	Counters        []string          `yaml:"counters"`
+	Interval        time.Duration     `yaml:"interval"`
+}
+
+const (
+	defaultOTLPServiceName = "nats-gnmi-subscriber"
+	defaultOTLPPrefix      = "gnmi"
+	defaultOTLPInterval    = 10 * time.Second
+)
+
+var defaultOTLPCounters = []string{"/counters/"}
+
+// applyDefaults fills in the unset settings, taking the endpoint from the
+// standard OpenTelemetry environment variables.
+func (c *OTLPConfig) applyDefaults() {
+	if c.Endpoint == "" {
+		c.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if c.MetricsEndpoint == "" {
+		c.MetricsEndpoint = os.Getenv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT")
+	}
+	if c.MetricsEndpoint == "" && c.Endpoint != "" {
+		c.MetricsEndpoint = strings.TrimSuffix(c.Endpoint, "/") + "/v1/metrics"
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = defaultOTLPServiceName
+	}
+	if c.Prefix == "" {
+		c.Prefix = defaultOTLPPrefix
+	}
+	if c.Counters == nil {
+		c.Counters = defaultOTLPCounters
+	}
+	if c.Interval <= 0 {
+		c.Interval = defaultOTLPInterval
+	}
+}
+
+func (c OTLPConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	c.applyDefaults()
+	if c.MetricsEndpoint == "" {
+		return fmt.Errorf("otlp: endpoint, or OTEL_EXPORTER_OTLP_ENDPOINT, is required")
+	}
+	if _, err := url.ParseRequestURI(c.MetricsEndpoint); err != nil {
+		return fmt.Errorf("otlp: invalid endpoint %q: %w", c.MetricsEndpoint, err)
+	}
+	return nil
+}
+
+// otlpPoint is the latest value of a series.
+type otlpPoint struct {
+	name    string
+	counter bool
+	attrs   []otlpAttr
+	value   float64
+	start   time.Time
+	time    time.Time
+	updated bool
+}
+
+// otlpOutput keeps the latest value of every series and exports the updated
+// ones periodically.
+type otlpOutput struct {
+	conf     OTLPConfig
+	client   *http.Client
+	resource otlpResource
+
+	mu     sync.Mutex
+	points map[string]*otlpPoint
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newOTLPOutput(conf OTLPConfig) (*otlpOutput, error) {
+	conf.applyDefaults()
+	o := &otlpOutput{
+		conf:   conf,
+		client: &http.Client{Timeout: 10 * time.Second},
+		resource: otlpResource{Attributes: []otlpAttr{
+			otlpString("service.name", conf.ServiceName),
+		}},
+		points: make(map[string]*otlpPoint),
+		done:   make(chan struct{}),
+	}
+	o.wg.Add(1)
+	go o.run()
+	slog.Info("Exporting telemetry as OTLP metrics", "url", conf.MetricsEndpoint, "interval", conf.Interval)
+	return o, nil
+}
+
+// counter reports whether the values of path are counters.
+func (o *otlpOutput) counter(path string) bool {
+	for _, c := range o.conf.Counters {
+		if strings.Contains(path, c) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *otlpOutput) Write(_ context.Context, m *message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target, subscription, tags := splitTags(ev, m.Header.Get("Gnmi-Target"))
+
+		for path, v := range ev.Values {
+			// JSON has no NaN or infinities.
+			f, ok := numericValue(v)
+			if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+				continue
+			}
+			key := seriesKey(path, target, tags)
+			p, ok := o.points[key]
+			if !ok {
+				p = &otlpPoint{
+					name:    o.conf.Prefix + "." + graphitePath(path),
+					counter: o.counter(path),
+					attrs:   otlpAttrs(target, subscription, tags),
+					start:   ts,
+				}
+				o.points[key] = p
+			}
+			// A counter going down was reset, which starts a new
+			// cumulative series.
+			if p.counter && f < p.value {
+				p.start = ts
+			}
+			p.value, p.time, p.updated = f, ts, true
+		}
+	}
+	return nil
+}
+
+// otlpAttrs returns the attributes of a series, sorted by key.
+func otlpAttrs(target, subscription string, tags map[string]string) []otlpAttr {
+	attrs := []otlpAttr{otlpString("target", target), otlpString("subscription", subscription)}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		attrs = append(attrs, otlpString(k, tags[k]))
+	}
+	return attrs
+}
+
+// run exports the updated series every interval until the output is
+// closed.
+func (o *otlpOutput) run() {
+	defer o.wg.Done()
+	ticker := time.NewTicker(o.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.done:
+			o.export()
+			return
+		case <-ticker.C:
+			o.export()
+		}
+	}
+}
+
+// export sends the series updated since the previous export, one metric per
+// name.
+func (o *otlpOutput) export() {
+	o.mu.Lock()
+	metrics := make(map[string]*otlpMetric)
+	for _, p := range o.points {
+		if !p.updated {
+			continue
+		}
+		p.updated = false
+		m, ok := metrics[p.name]
+		if !ok {
+			m = &otlpMetric{Name: p.name}
+			if p.counter {
+				m.Sum = &otlpSum{AggregationTemporality: 2, IsMonotonic: true}
+			} else {
+				m.Gauge = &otlpGauge{}
+			}
+			metrics[p.name] = m
+		}
+		dp := otlpDataPoint{
+			Attributes: p.attrs,
+			Time:       strconv.FormatInt(p.time.UnixNano(), 10),
+			Value:      p.value,
+		}
+		if m.Sum != nil {
+			dp.StartTime = strconv.FormatInt(p.start.UnixNano(), 10)
+			m.Sum.DataPoints = append(m.Sum.DataPoints, dp)
+		} else {
+			m.Gauge.DataPoints = append(m.Gauge.DataPoints, dp)
+		}
+	}
+	o.mu.Unlock()
+	if len(metrics) == 0 {
+		return
+	}
+
+	scope := otlpScopeMetrics{Scope: otlpScope{Name: "github.com/gwoodwa1/nats-gnmi-example"}}
+	for _, m := range metrics {
+		scope.Metrics = append(scope.Metrics, *m)
+	}
+	body, err := json.Marshal(otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     o.resource,
+		ScopeMetrics: []otlpScopeMetrics{scope},
+	}}})
+	if err != nil {
+		slog.Warn("Could not encode metrics", "error", err)
+		return
+	}
+	if err := o.post(body); err != nil {
+		slog.Warn("Could not export metrics", "url", o.conf.MetricsEndpoint, "metrics", len(metrics), "error", err)
+	}
+}
+
+func (o *otlpOutput) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, o.conf.MetricsEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	rsp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("%s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Close exports the series updated since the last export.
+func (o *otlpOutput) Close() error {
+	close(o.done)
+	o.wg.Wait()
+	return nil
+}
+
+// The OTLP/HTTP JSON metrics request body. 64-bit integers are strings, as
+// the protocol specifies.
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string     `json:"name"`
+	Gauge *otlpGauge `json:"gauge,omitempty"`
+	Sum   *otlpSum   `json:"sum,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes []otlpAttr `json:"attributes,omitempty"`
+	StartTime  string     `json:"startTimeUnixNano,omitempty"`
+	Time       string     `json:"timeUnixNano"`
+	Value      float64    `json:"asDouble"`
+}
+
+type otlpAttr struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+func otlpString(key, value string) otlpAttr {
+	return otlpAttr{Key: key, Value: map[string]string{"stringValue": value}}
+}
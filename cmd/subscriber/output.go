@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"github.com/nats-io/nats.go"
+	"github.com/openconfig/gnmic/formatters"
+	"log/slog"
+	"time"
+)
+
+// message is a received telemetry message with the events decoded from it.
+type message struct {
+	*nats.Msg
+	received time.Time
+	events   []*formatters.EventMsg
+	acks     *pendingAck
+}
+
+// hold keeps m from being acknowledged until the returned func is called,
+// for outputs that write it after Write has returned.
+func (m *message) hold() func(error) {
+	return m.acks.hold()
+}
+
+// splitTags returns the target and subscription of ev, given by its source
+// and subscription-name tags or else by target and its name, and its other
+// tags.
+func splitTags(ev *formatters.EventMsg, target string) (string, string, map[string]string) {
+	subscription := ev.Name
+	tags := make(map[string]string, len(ev.Tags))
+	for k, v := range ev.Tags {
+		switch k {
+		case "source":
+			if v != "" {
+				target = v
+			}
+		case "subscription-name":
+			if v != "" {
+				subscription = v
+			}
+		default:
+			tags[k] = v
+		}
+	}
+	return target, subscription, tags
+}
+
+// output is a destination the subscriber hands every message to, in
+// addition to logging it.
+type output interface {
+	Write(ctx context.Context, m *message) error
+	Close() error
+}
+
+// newOutputs returns the outputs enabled in conf. Outputs that publish
+// back to NATS use nc.
+func newOutputs(conf Config, nc *nats.Conn) ([]output, error) {
+	var outputs []output
+	if conf.Prometheus.Enabled {
+		exp, err := newExporter(conf.Prometheus, conf.Queue)
+		if err != nil {
+			return nil, err
+		}
+		go exp.serve()
+		outputs = append(outputs, conf.route("prometheus", exp))
+	}
+	if conf.Postgres.Enabled {
+		pg, err := newPostgresOutput(conf.Postgres)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("postgres", pg))
+	}
+	if conf.Kafka.Enabled {
+		k, err := newKafkaOutput(conf.Kafka)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("kafka", k))
+	}
+	if conf.File.Enabled {
+		f, err := newFileOutput(conf.File)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("file", f))
+	}
+	if conf.Elasticsearch.Enabled {
+		es, err := newElasticsearchOutput(conf.Elasticsearch)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("elasticsearch", es))
+	}
+	if conf.ClickHouse.Enabled {
+		ch, err := newClickHouseOutput(conf.ClickHouse)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("clickhouse", ch))
+	}
+	if conf.SQLite.Enabled {
+		db, err := newSQLiteOutput(conf.SQLite)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("sqlite", db))
+	}
+	if conf.S3.Enabled {
+		s3, err := newS3Output(conf.S3)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("s3", s3))
+	}
+	if conf.Parquet.Enabled {
+		pq, err := newParquetOutput(conf.Parquet)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("parquet", pq))
+	}
+	if conf.CSV.Enabled {
+		c, err := newCSVOutput(conf.CSV)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("csv", c))
+	}
+	if conf.Webhook.Enabled {
+		wh, err := newWebhookOutput(conf.Webhook)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("webhook", wh))
+	}
+	if conf.Alerting.Enabled {
+		al, err := newAlertOutput(conf.Alerting)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("alerting", al))
+	}
+	if conf.MQTT.Enabled {
+		mq, err := newMQTTOutput(conf.MQTT)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("mqtt", mq))
+	}
+	if conf.Syslog.Enabled {
+		sl, err := newSyslogOutput(conf.Syslog)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("syslog", sl))
+	}
+	if conf.Graphite.Enabled {
+		g, err := newGraphiteOutput(conf.Graphite)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("graphite", g))
+	}
+	if conf.OTLP.Enabled {
+		ot, err := newOTLPOutput(conf.OTLP)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("otlp", ot))
+	}
+	if conf.GRPC.Enabled {
+		st, err := newStreamOutput(conf.GRPC)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("grpc", st))
+	}
+	if conf.WebSocket.Enabled {
+		ws, err := newWebSocketOutput(conf.WebSocket)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("websocket", ws))
+	}
+	if conf.Anomaly.Enabled {
+		d, err := newAnomalyDetector(conf.Anomaly, nc)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("anomaly", d))
+	}
+	if conf.API.Enabled {
+		api, err := newAPIOutput(conf.API)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("api", api))
+	}
+	if conf.KV.Enabled {
+		kv, err := newKVOutput(conf.KV, nc)
+		if err != nil {
+			closeOutputs(outputs)
+			return nil, err
+		}
+		outputs = append(outputs, conf.route("kv", kv))
+	}
+	return outputs, nil
+}
+
+// closeOutputs closes every output, logging any error.
+func closeOutputs(outputs []output) {
+	for _, out := range outputs {
+		if err := out.Close(); err != nil {
+			slog.Error("Error closing output", "error", err)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/compress"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParquetConfig writes the values of received events to Parquet files under
+// Dir, partitioned Hive style by target and UTC day, such as
+// Dir/target=router1/dt=2024-01-02/part-20240102T150000Z.parquet. DuckDB
+// and Athena can query the tree directly. A file is completed every
+// Interval, aligned to the clock; until then it has a .tmp suffix so
+// readers skip it.
+type ParquetConfig struct {
+	Enabled      bool          `yaml:"enabled"`
+	Dir          string        `yaml:"dir"`
+	Interval     time.Duration `yaml:"interval"`
+	Compression  string        `yaml:"compression"`
+	RowGroupSize int64         `yaml:"row_group_size"`
+}
+
+const (
+	defaultParquetInterval     = time.Hour
+	defaultParquetRowGroupSize = 100000
+)
+
+func (p ParquetConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if p.Dir == "" {
+		return fmt.Errorf("parquet: dir is required")
+	}
+	_, err := parquetCodec(p.Compression)
+	return err
+}
+
+func parquetCodec(name string) (compress.Codec, error) {
+	switch name {
+	case "", "zstd":
+		return &parquet.Zstd, nil
+	case "snappy":
+		return &parquet.Snappy, nil
+	case "gzip":
+		return &parquet.Gzip, nil
+	case "none":
+		return &parquet.Uncompressed, nil
+	}
+	return nil, fmt.Errorf("parquet: unknown compression %q", name)
+}
+
+// parquetRow is a row of the Parquet files. The target and day are given by
+// the partition directories. Numeric values go to Value and anything else to
+// ValueText.
+type parquetRow struct {
+	Time         time.Time         `parquet:"time,timestamp(nanosecond)"`
+	Subscription string            `parquet:"subscription,dict"`
+	Path         string            `parquet:"path,dict"`
+	Tags         map[string]string `parquet:"tags"`
+	Value        *float64          `parquet:"value,optional"`
+	ValueText    *string           `parquet:"value_text,optional"`
+}
+
+// parquetFile is a file of a partition being written.
+type parquetFile struct {
+	path string
+	f    *os.File
+	w    *parquet.GenericWriter[parquetRow]
+}
+
+// parquetOutput writes a file per partition and completes them at every
+// interval boundary.
+type parquetOutput struct {
+	conf  ParquetConfig
+	codec compress.Codec
+
+	mu    sync.Mutex
+	files map[string]*parquetFile
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newParquetOutput(conf ParquetConfig) (*parquetOutput, error) {
+	if conf.Interval <= 0 {
+		conf.Interval = defaultParquetInterval
+	}
+	if conf.RowGroupSize <= 0 {
+		conf.RowGroupSize = defaultParquetRowGroupSize
+	}
+	codec, err := parquetCodec(conf.Compression)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(conf.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	o := &parquetOutput{
+		conf:  conf,
+		codec: codec,
+		files: make(map[string]*parquetFile),
+		done:  make(chan struct{}),
+	}
+	o.wg.Add(1)
+	go o.run()
+	slog.Info("Writing telemetry to Parquet files", "dir", conf.Dir, "interval", conf.Interval)
+	return o, nil
+}
+
+func (o *parquetOutput) Write(_ context.Context, m *message) error {
+	target := m.Header.Get("Gnmi-Target")
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		ts = ts.UTC()
+		row := parquetRow{Time: ts}
+		var source string
+		source, row.Subscription, row.Tags = splitTags(ev, target)
+
+		rows := make([]parquetRow, 0, len(ev.Values))
+		for path, v := range ev.Values {
+			r := row
+			r.Path = path
+			if f, ok := numericValue(v); ok {
+				r.Value = &f
+			} else {
+				s := valueText(v)
+				r.ValueText = &s
+			}
+			rows = append(rows, r)
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		pf, err := o.file(source, ts)
+		if err != nil {
+			return err
+		}
+		if _, err := pf.w.Write(rows); err != nil {
+			return fmt.Errorf("error writing %s: %w", pf.path, err)
+		}
+	}
+	return nil
+}
+
+// file returns the file of the partition of target and the day of ts,
+// creating it if needed. o.mu must be held.
+func (o *parquetOutput) file(target string, ts time.Time) (*parquetFile, error) {
+	if target == "" {
+		target = "unknown"
+	}
+	dir := filepath.Join(o.conf.Dir,
+		"target="+strings.NewReplacer("/", "_", `\`, "_").Replace(target),
+		"dt="+ts.Format("2006-01-02"))
+	if pf, ok := o.files[dir]; ok {
+		return pf, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	path := o.fileName(dir, time.Now().UTC())
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("error creating Parquet file: %w", err)
+	}
+	pf := &parquetFile{
+		path: path,
+		f:    f,
+		w: parquet.NewGenericWriter[parquetRow](f,
+			parquet.Compression(o.codec),
+			parquet.MaxRowsPerRowGroup(o.conf.RowGroupSize)),
+	}
+	o.files[dir] = pf
+	return pf, nil
+}
+
+// fileName returns an unused name for a file of dir started at t.
+func (o *parquetOutput) fileName(dir string, t time.Time) string {
+	base := filepath.Join(dir, "part-"+t.Format("20060102T150405Z"))
+	name := base + ".parquet"
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d.parquet", base, i)
+	}
+}
+
+// run completes the files at every interval boundary until the output is
+// closed.
+func (o *parquetOutput) run() {
+	defer o.wg.Done()
+	for {
+		next := time.Now().Truncate(o.conf.Interval).Add(o.conf.Interval)
+		select {
+		case <-o.done:
+			o.complete()
+			return
+		case <-time.After(time.Until(next)):
+			o.complete()
+		}
+	}
+}
+
+// complete writes the footer of every open file and gives it its final
+// name, so later rows start new files.
+func (o *parquetOutput) complete() {
+	o.mu.Lock()
+	files := o.files
+	o.files = make(map[string]*parquetFile)
+	o.mu.Unlock()
+
+	for _, pf := range files {
+		err := pf.w.Close()
+		if cerr := pf.f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(pf.path+".tmp", pf.path)
+		}
+		if err != nil {
+			slog.Error("Error completing Parquet file", "file", pf.path, "error", err)
+		}
+	}
+}
+
+// Close completes the open files.
+func (o *parquetOutput) Close() error {
+	close(o.done)
+	o.wg.Wait()
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// PostgresConfig stores every received value as a row of a PostgreSQL
+// table, created on startup if needed and turned into a TimescaleDB
+// hypertable when the extension is available. Rows are written with COPY in
+// batches of BatchSize, or every FlushInterval.
+type PostgresConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	URL           string        `yaml:"url"`
+	Table         string        `yaml:"table"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+	ChunkInterval time.Duration `yaml:"chunk_interval"`
+}
+
+const (
+	defaultPostgresTable         = "gnmi_telemetry"
+	defaultPostgresBatchSize     = 5000
+	defaultPostgresFlushInterval = time.Second
+	defaultPostgresChunkInterval = 24 * time.Hour
+)
+
+func (p PostgresConfig) validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if p.URL == "" {
+		return fmt.Errorf("postgres: url is required")
+	}
+	if _, err := pgxpool.ParseConfig(p.URL); err != nil {
+		return fmt.Errorf("postgres: invalid url: %w", err)
+	}
+	return nil
+}
+
+var postgresColumns = []string{"time", "target", "subscription", "path", "tags", "value", "value_text"}
+
+// postgresOutput writes the values of received events to a table.
+type postgresOutput struct {
+	pool    *pgxpool.Pool
+	table   pgx.Identifier
+	batcher *batcher[[]any]
+}
+
+func newPostgresOutput(conf PostgresConfig) (*postgresOutput, error) {
+	if conf.Table == "" {
+		conf.Table = defaultPostgresTable
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultPostgresBatchSize
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = defaultPostgresFlushInterval
+	}
+	if conf.ChunkInterval <= 0 {
+		conf.ChunkInterval = defaultPostgresChunkInterval
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	pool, err := pgxpool.New(ctx, conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to PostgreSQL: %w", err)
+	}
+	o := &postgresOutput{
+		pool:  pool,
+		table: pgx.Identifier(strings.Split(conf.Table, ".")),
+	}
+	if err := o.createSchema(ctx, conf.ChunkInterval); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	o.batcher = newBatcher("postgres", conf.BatchSize, conf.FlushInterval, o.copy)
+	slog.Info("Writing telemetry to PostgreSQL", "table", o.table.Sanitize())
+	return o, nil
+}
+
+// createSchema creates the table and its index unless they exist, and makes
+// the table a hypertable when TimescaleDB is installed in the database.
+func (o *postgresOutput) createSchema(ctx context.Context, chunk time.Duration) error {
+	table := o.table.Sanitize()
+	index := pgx.Identifier{o.table[len(o.table)-1] + "_target_path_time"}.Sanitize()
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS ` + table + ` (
+			time         timestamptz NOT NULL,
+			target       text NOT NULL,
+			subscription text NOT NULL,
+			path         text NOT NULL,
+			tags         jsonb NOT NULL DEFAULT '{}',
+			value        double precision,
+			value_text   text
+		)`,
+		`CREATE INDEX IF NOT EXISTS ` + index + ` ON ` + table + ` (target, path, time DESC)`,
+	}
+	for _, stmt := range statements {
+		if _, err := o.pool.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("could not create table %s: %w", table, err)
+		}
+	}
+
+	var timescale bool
+	err := o.pool.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&timescale)
+	if err != nil {
+		return fmt.Errorf("could not look up the timescaledb extension: %w", err)
+	}
+	if !timescale {
+		slog.Warn("TimescaleDB extension not installed, using a plain table", "table", table)
+		return nil
+	}
+	_, err = o.pool.Exec(ctx, `SELECT create_hypertable($1::regclass, 'time', chunk_time_interval => $2::interval, if_not_exists => TRUE)`,
+		table, chunk)
+	if err != nil {
+		return fmt.Errorf("could not create hypertable %s: %w", table, err)
+	}
+	return nil
+}
+
+func (o *postgresOutput) Write(_ context.Context, m *message) error {
+	target := m.Header.Get("Gnmi-Target")
+	var rows [][]any
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		source, subscription, tags := splitTags(ev, target)
+		for path, v := range ev.Values {
+			var value *float64
+			var text *string
+			if f, ok := numericValue(v); ok {
+				value = &f
+			} else {
+				s := valueText(v)
+				text = &s
+			}
+			rows = append(rows, []any{ts, source, subscription, path, tags, value, text})
+		}
+	}
+	if len(rows) > 0 {
+		o.batcher.add(m.hold(), rows...)
+	}
+	return nil
+}
+
+// copy inserts rows with the COPY protocol.
+func (o *postgresOutput) copy(rows [][]any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := o.pool.CopyFrom(ctx, o.table, postgresColumns, pgx.CopyFromRows(rows))
+	return err
+}
+
+func (o *postgresOutput) Close() error {
+	o.batcher.close()
+	o.pool.Close()
+	return nil
+}
+
+// valueText renders a non-numeric value: strings as they are, anything else
+// as JSON.
+func valueText(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrometheusConfig exposes the last value of every numeric leaf received as
+// a Prometheus metric on Address. Metrics are named after the leaf path
+// unless a mapping in Metrics matches it. Series that are not updated for
+// Expiration are dropped; a negative Expiration keeps them forever. The
+// messages and bytes handled per subject are counted too, to show how
+// queue group members share them, and the latency from the gNMI timestamp
+// of the messages to their receipt is kept per target in a histogram with
+// LatencyBuckets, in seconds.
+type PrometheusConfig struct {
+	Enabled        bool            `yaml:"enabled"`
+	Address        string          `yaml:"address"`
+	Prefix         string          `yaml:"metric_prefix"`
+	Expiration     time.Duration   `yaml:"expiration"`
+	Metrics        []MetricMapping `yaml:"metrics"`
+	LatencyBuckets []float64       `yaml:"latency_buckets"`
+}
+
+// MetricMapping names the metric of the leaves whose path matches the Path
+// regular expression. Type is gauge (default) or counter.
+type MetricMapping struct {
+	Path string `yaml:"path"`
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+	Help string `yaml:"help"`
+}
+
+const (
+	defaultExporterAddress    = ":9804"
+	defaultExporterPrefix     = "gnmi"
+	defaultExporterExpiration = 10 * time.Minute
+)
+
+func (p PrometheusConfig) validate() error {
+	for i, le := range p.LatencyBuckets {
+		if le <= 0 || i > 0 && le <= p.LatencyBuckets[i-1] {
+			return fmt.Errorf("prometheus: latency buckets must be positive and increasing")
+		}
+	}
+	_, err := compileMappings(p.Metrics)
+	return err
+}
+
+type mapping struct {
+	MetricMapping
+	re *regexp.Regexp
+}
+
+func compileMappings(metrics []MetricMapping) ([]mapping, error) {
+	mappings := make([]mapping, 0, len(metrics))
+	for _, m := range metrics {
+		re, err := regexp.Compile(m.Path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metric path %q: %w", m.Path, err)
+		}
+		switch m.Type {
+		case "", "gauge", "counter":
+		default:
+			return nil, fmt.Errorf("metric %q: unknown type %q", m.Name, m.Type)
+		}
+		if m.Name != "" && !validMetricName.MatchString(m.Name) {
+			return nil, fmt.Errorf("invalid metric name %q", m.Name)
+		}
+		mappings = append(mappings, mapping{MetricMapping: m, re: re})
+	}
+	return mappings, nil
+}
+
+var (
+	validMetricName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	invalidNameChar = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+)
+
+// exporter keeps the last value of every series and serves them in the
+// Prometheus text exposition format.
+type exporter struct {
+	conf     PrometheusConfig
+	queue    string
+	mappings []mapping
+
+	mu       sync.Mutex
+	families map[string]*family
+	// names caches the metric of each leaf path.
+	names map[string]*family
+	// handled counts the messages handled per subject.
+	handled map[string]*handledCount
+	// latency holds the latency histogram of each target.
+	latency map[string]*latencyHistogram
+}
+
+type family struct {
+	name, help, typ string
+	series          map[string]*promSeries
+}
+
+// handledCount counts the messages handled on a subject.
+type handledCount struct {
+	messages, bytes int
+}
+
+type promSeries struct {
+	labels  string
+	value   float64
+	updated time.Time
+}
+
+func newExporter(conf PrometheusConfig, queue string) (*exporter, error) {
+	if conf.Address == "" {
+		conf.Address = defaultExporterAddress
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = defaultExporterPrefix
+	}
+	if conf.Expiration == 0 {
+		conf.Expiration = defaultExporterExpiration
+	}
+	if len(conf.LatencyBuckets) == 0 {
+		conf.LatencyBuckets = defaultLatencyBuckets
+	}
+	mappings, err := compileMappings(conf.Metrics)
+	if err != nil {
+		return nil, err
+	}
+	return &exporter{
+		conf:     conf,
+		queue:    queue,
+		mappings: mappings,
+		families: make(map[string]*family),
+		names:    make(map[string]*family),
+		handled:  make(map[string]*handledCount),
+		latency:  make(map[string]*latencyHistogram),
+	}, nil
+}
+
+// serve exposes the metrics on the configured address under /metrics.
+func (e *exporter) serve() {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+
+	slog.Info("Serving Prometheus metrics", "address", e.conf.Address)
+	if err := http.ListenAndServe(e.conf.Address, mux); err != nil {
+		slog.Error("Prometheus exporter stopped", "error", err)
+	}
+}
+
+func (e *exporter) Write(_ context.Context, m *message) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	h, ok := e.handled[m.Subject]
+	if !ok {
+		h = &handledCount{}
+		e.handled[m.Subject] = h
+	}
+	h.messages++
+	h.bytes += len(m.Data)
+	if ts, ok := notificationTime(m.Msg, m.events); ok {
+		target := m.Header.Get("Gnmi-Target")
+		if target == "" && len(m.events) > 0 {
+			target = m.events[0].Tags["source"]
+		}
+		l, ok := e.latency[target]
+		if !ok {
+			l = &latencyHistogram{}
+			e.latency[target] = l
+		}
+		l.observe(e.conf.LatencyBuckets, m.received.Sub(ts))
+	}
+	for _, ev := range m.events {
+		labels := labelString(ev.Tags)
+		for path, v := range ev.Values {
+			value, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			f := e.family(path)
+			s, ok := f.series[labels]
+			if !ok {
+				s = &promSeries{labels: labels}
+				f.series[labels] = s
+			}
+			s.value = value
+			s.updated = m.received
+		}
+	}
+	return nil
+}
+
+// family returns the metric family of the leaf at path. e.mu must be held.
+func (e *exporter) family(path string) *family {
+	if f, ok := e.names[path]; ok {
+		return f
+	}
+	name := e.conf.Prefix + "_" + strings.Trim(invalidNameChar.ReplaceAllString(path, "_"), "_")
+	help := "gNMI leaf " + path
+	typ := "gauge"
+	for _, m := range e.mappings {
+		if !m.re.MatchString(path) {
+			continue
+		}
+		if m.Name != "" {
+			name = m.Name
+		}
+		if m.Help != "" {
+			help = m.Help
+		}
+		if m.Type != "" {
+			typ = m.Type
+		}
+		break
+	}
+	f, ok := e.families[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ, series: make(map[string]*promSeries)}
+		e.families[name] = f
+	}
+	e.names[path] = f
+	return f
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	now := time.Now()
+	var b strings.Builder
+
+	e.mu.Lock()
+	names := make([]string, 0, len(e.families))
+	for name := range e.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f := e.families[name]
+		keys := make([]string, 0, len(f.series))
+		for k, s := range f.series {
+			if e.conf.Expiration > 0 && now.Sub(s.updated) > e.conf.Expiration {
+				delete(f.series, k)
+				continue
+			}
+			keys = append(keys, k)
+		}
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		for _, k := range keys {
+			fmt.Fprintf(&b, "%s%s %s\n", f.name, k, strconv.FormatFloat(f.series[k].value, 'g', -1, 64))
+		}
+	}
+	e.writeHandled(&b)
+	e.writeLatency(&b)
+	e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// writeHandled writes the counts of handled messages and bytes. e.mu must be
+// held.
+func (e *exporter) writeHandled(b *strings.Builder) {
+	if len(e.handled) == 0 {
+		return
+	}
+	subjects := make([]string, 0, len(e.handled))
+	for subject := range e.handled {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	for _, metric := range []struct {
+		name, help string
+		count      func(*handledCount) int
+	}{
+		{"subscriber_messages_total", "Messages handled by this subscriber.", func(h *handledCount) int { return h.messages }},
+		{"subscriber_bytes_total", "Payload bytes handled by this subscriber.", func(h *handledCount) int { return h.bytes }},
+	} {
+		name := e.conf.Prefix + "_" + metric.name
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", name, metric.help, name)
+		for _, subject := range subjects {
+			labels := map[string]string{"subject": subject}
+			if e.queue != "" {
+				labels["queue"] = e.queue
+			}
+			fmt.Fprintf(b, "%s%s %d\n", name, labelString(labels), metric.count(e.handled[subject]))
+		}
+	}
+}
+
+// writeLatency writes the latency histograms. e.mu must be held.
+func (e *exporter) writeLatency(b *strings.Builder) {
+	if len(e.latency) == 0 {
+		return
+	}
+	targets := make([]string, 0, len(e.latency))
+	for target := range e.latency {
+		targets = append(targets, target)
+	}
+	sort.Strings(targets)
+	name := e.conf.Prefix + "_subscriber_latency_seconds"
+	fmt.Fprintf(b, "# HELP %s Time from the gNMI timestamp of a message to its receipt.\n# TYPE %s histogram\n", name, name)
+	for _, target := range targets {
+		labels := map[string]string{"target": target}
+		if e.queue != "" {
+			labels["queue"] = e.queue
+		}
+		l := labelString(labels)
+		e.latency[target].write(b, name, l[1:len(l)-1], e.conf.LatencyBuckets)
+	}
+}
+
+func (e *exporter) Close() error {
+	return nil
+}
+
+// labelString renders tags as a sorted Prometheus label set, with the tag
+// names made valid label names.
+func labelString(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		label := invalidNameChar.ReplaceAllString(name, "_")
+		if label[0] >= '0' && label[0] <= '9' {
+			label = "_" + label
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(tags[name]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// numericValue converts a leaf value to a float. Strings holding numbers,
+// as 64-bit counters are encoded in JSON_IETF, are parsed and booleans
+// become 0 or 1; other values have no numeric form.
+func numericValue(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case float64:
+		return x, true
+	case float32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case uint32:
+		return float64(x), true
+	case bool:
+		if x {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
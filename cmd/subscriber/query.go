@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// query prints values stored in an SQLite database by the sqlite output, as
+// lines of JSON: the latest value of each path by default, or the history of
+// the paths since a given time.
+func query(args []string) error {
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	path := fs.String("db", defaultSQLitePath, "path to the SQLite database")
+	target := fs.String("target", "", "only values of this target")
+	prefix := fs.String("path", "", "only values whose path starts with this prefix")
+	since := fs.Duration("since", 0, "print the values recorded within this duration instead of the latest ones")
+	limit := fs.Int("limit", 0, "print at most this many values of the history")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if _, err := os.Stat(*path); err != nil {
+		return err
+	}
+
+	store, err := openSQLite(*path)
+	if err != nil {
+		return err
+	}
+	defer store.db.Close()
+
+	ctx := context.Background()
+	var values []storedValue
+	if *since > 0 {
+		values, err = store.history(ctx, *target, *prefix, time.Now().Add(-*since), *limit)
+	} else {
+		values, err = store.latest(ctx, *target, *prefix)
+	}
+	if err != nil {
+		return fmt.Errorf("could not query %s: %w", *path, err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
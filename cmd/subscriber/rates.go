@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"github.com/openconfig/gnmic/formatters"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RatesConfig adds the rate per second of the counters to the events before
+// they are handed to the outputs. The rate of a counter is named after its
+// leaf: octet counters become bits per second (in-octets gives in-bps),
+// packet counters packets per second (in-unicast-pkts gives
+// in-unicast-pps), and any other counter gets a -rate suffix. The first
+// value of a counter, and a counter that was reset, have no rate yet.
+type RatesConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Counters are regular expressions matching the paths of the counters,
+	// by default those under a counters container.
+	Counters []string `yaml:"counters"`
+	// DropCounters hands on the rates in place of the counters. Events left
+	// without values are dropped.
+	DropCounters bool `yaml:"drop_counters"`
+	// Expiration is how long the last value of a counter that is no longer
+	// received is kept.
+	Expiration time.Duration `yaml:"expiration"`
+}
+
+const defaultRatesExpiration = 10 * time.Minute
+
+var defaultRateCounters = []string{"/counters/"}
+
+func (r RatesConfig) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	_, err := compileCounters(r.Counters)
+	return err
+}
+
+func compileCounters(exprs []string) ([]*regexp.Regexp, error) {
+	if len(exprs) == 0 {
+		exprs = defaultRateCounters
+	}
+	res := make([]*regexp.Regexp, len(exprs))
+	for i, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rates: invalid counter expression %q: %w", expr, err)
+		}
+		res[i] = re
+	}
+	return res, nil
+}
+
+// counterSample is the last value of a counter.
+type counterSample struct {
+	value float64
+	time  time.Time
+}
+
+// rateCalculator keeps the last value of every counter of every target to
+// compute their rates.
+type rateCalculator struct {
+	conf     RatesConfig
+	counters []*regexp.Regexp
+
+	mu      sync.Mutex
+	samples map[string]counterSample
+	swept   time.Time
+}
+
+func newRateCalculator(conf RatesConfig) (*rateCalculator, error) {
+	if conf.Expiration <= 0 {
+		conf.Expiration = defaultRatesExpiration
+	}
+	counters, err := compileCounters(conf.Counters)
+	if err != nil {
+		return nil, err
+	}
+	return &rateCalculator{
+		conf:     conf,
+		counters: counters,
+		samples:  make(map[string]counterSample),
+		swept:    time.Now(),
+	}, nil
+}
+
+// apply adds the rates of the counters of the events of m, and returns the
+// events to hand on.
+func (r *rateCalculator) apply(m *message) []*formatters.EventMsg {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m.received.Sub(r.swept) > r.conf.Expiration {
+		r.sweep(m.received)
+	}
+
+	var kept []*formatters.EventMsg
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		// The tags hold the source and the keys of the path.
+		series := m.Header.Get("Gnmi-Target") + "\x00" + labelString(ev.Tags) + "\x00"
+		rates := make(map[string]interface{})
+		for path, v := range ev.Values {
+			if !r.isCounter(path) {
+				continue
+			}
+			f, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			if r.conf.DropCounters {
+				delete(ev.Values, path)
+			}
+			key := series + path
+			prev, ok := r.samples[key]
+			if ok && !ts.After(prev.time) {
+				// Out of order, or the same update again.
+				continue
+			}
+			r.samples[key] = counterSample{value: f, time: ts}
+			if !ok {
+				continue
+			}
+			if delta, ok := counterDelta(prev.value, f); ok {
+				name, scale := rateName(path)
+				rates[name] = delta * scale / ts.Sub(prev.time).Seconds()
+			}
+		}
+		for name, rate := range rates {
+			ev.Values[name] = rate
+		}
+		if len(ev.Values) > 0 || !r.conf.DropCounters {
+			kept = append(kept, ev)
+		}
+	}
+	return kept
+}
+
+func (r *rateCalculator) isCounter(path string) bool {
+	for _, re := range r.counters {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep forgets the counters not updated for the expiration. r.mu must be
+// held.
+func (r *rateCalculator) sweep(now time.Time) {
+	for key, s := range r.samples {
+		if now.Sub(s.time) > r.conf.Expiration {
+			delete(r.samples, key)
+		}
+	}
+	r.swept = now
+}
+
+// counterDelta returns the increase of a counter from prev to cur. A counter
+// going down from the upper half of the 32 or 64 bit range to the lower half
+// wrapped around; otherwise it was reset and the increase is unknown.
+func counterDelta(prev, cur float64) (float64, bool) {
+	if cur >= prev {
+		return cur - prev, true
+	}
+	for _, limit := range []float64{1 << 32, 1 << 64} {
+		if prev < limit {
+			if prev >= limit/2 && cur < limit/2 {
+				return limit - prev + cur, true
+			}
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// rateName returns the path of the rate of the counter at path, and the
+// factor to scale its increase by.
+func rateName(path string) (string, float64) {
+	switch {
+	case strings.HasSuffix(path, "octets"):
+		return strings.TrimSuffix(path, "octets") + "bps", 8
+	case strings.HasSuffix(path, "pkts"):
+		return strings.TrimSuffix(path, "pkts") + "pps", 1
+	case strings.HasSuffix(path, "packets"):
+		return strings.TrimSuffix(path, "packets") + "pps", 1
+	}
+	return path + "-rate", 1
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Route limits the named outputs to the messages received on Subjects,
+// which may use the * and > wildcards. Outputs without a route receive
+// every message. The subjects of the routes are subscribed to along with
+// the configured ones.
+type Route struct {
+	Subjects []string `yaml:"subjects"`
+	Outputs  []string `yaml:"outputs"`
+}
+
+// outputNames are the names routes refer to the outputs by: the names of
+// their config sections.
+var outputNames = map[string]bool{
+	"prometheus": true, "postgres": true, "kafka": true, "file": true, "elasticsearch": true,
+	"clickhouse": true, "sqlite": true, "s3": true, "parquet": true, "csv": true, "webhook": true,
+	"alerting": true, "mqtt": true, "syslog": true, "graphite": true, "otlp": true, "grpc": true,
+	"websocket": true, "anomaly": true, "api": true, "kv": true,
+}
+
+func (r Route) validate() error {
+	if len(r.Subjects) == 0 {
+		return fmt.Errorf("route: subjects are required")
+	}
+	if len(r.Outputs) == 0 {
+		return fmt.Errorf("route: outputs are required")
+	}
+	for _, name := range r.Outputs {
+		if !outputNames[name] {
+			return fmt.Errorf("route: unknown output %q", name)
+		}
+	}
+	return nil
+}
+
+// routeSubjects adds the subjects of the routes missing from subjects.
+func routeSubjects(subjects []string, routes []Route) []string {
+	seen := make(map[string]bool, len(subjects))
+	for _, s := range subjects {
+		seen[s] = true
+	}
+	for _, r := range routes {
+		for _, s := range r.Subjects {
+			if !seen[s] {
+				seen[s] = true
+				subjects = append(subjects, s)
+			}
+		}
+	}
+	return subjects
+}
+
+// route returns out limited to the subjects routed to the output named
+// name, if any.
+func (c Config) route(name string, out output) output {
+	var subjects []string
+	for _, r := range c.Routes {
+		for _, o := range r.Outputs {
+			if o == name {
+				subjects = append(subjects, r.Subjects...)
+			}
+		}
+	}
+	if subjects == nil {
+		return out
+	}
+	return routedOutput{output: out, subjects: subjects}
+}
+
+// routedOutput hands an output only the messages received on its subjects.
+type routedOutput struct {
+	output
+	subjects []string
+}
+
+func (o routedOutput) Write(ctx context.Context, m *message) error {
+	for _, s := range o.subjects {
+		if subjectMatches(s, m.Subject) {
+			return o.output.Write(ctx, m)
+		}
+	}
+	return nil
+}
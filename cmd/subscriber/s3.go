@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// S3Config archives received messages as gzipped JSON lines in objects of an
+// S3 bucket, for long-term storage. MinIO, and Google Cloud Storage with HMAC
+// keys, are reached through Endpoint. Messages are spooled to files in
+// SpoolDir and an object is uploaded per target every Interval, aligned to
+// the clock. Key is a template for the object key, executed with .Target,
+// .Subject and .Time, the time the object was started. Objects that fail to
+// upload stay in SpoolDir and are retried, also after a restart.
+type S3Config struct {
+	Enabled      bool          `yaml:"enabled"`
+	Endpoint     string        `yaml:"endpoint"`
+	Region       string        `yaml:"region"`
+	Bucket       string        `yaml:"bucket"`
+	PathStyle    bool          `yaml:"path_style"`
+	AccessKey    string        `yaml:"access_key"`
+	SecretKey    string        `yaml:"secret_key"`
+	SessionToken string        `yaml:"session_token"`
+	Key          string        `yaml:"key"`
+	Interval     time.Duration `yaml:"interval"`
+	SpoolDir     string        `yaml:"spool_dir"`
+}
+
+const (
+	defaultS3Region   = "us-east-1"
+	defaultS3Key      = `{{.Target}}/{{.Time.Format "2006/01/02"}}/{{.Time.Format "150405"}}.ndjson.gz`
+	defaultS3Interval = time.Hour
+)
+
+// applyDefaults fills in the unset settings, taking the credentials from the
+// standard AWS environment variables.
+func (s *S3Config) applyDefaults() {
+	if s.AccessKey == "" {
+		s.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s.Region == "" {
+		s.Region = defaultS3Region
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	if s.Key == "" {
+		s.Key = defaultS3Key
+	}
+	if s.Interval <= 0 {
+		s.Interval = defaultS3Interval
+	}
+	if s.SpoolDir == "" {
+		s.SpoolDir = filepath.Join(os.TempDir(), "nats-gnmi-s3")
+	}
+}
+
+func (s S3Config) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	s.applyDefaults()
+	if s.Bucket == "" {
+		return fmt.Errorf("s3: bucket is required")
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return fmt.Errorf("s3: access_key and secret_key, or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, are required")
+	}
+	if _, err := url.ParseRequestURI(s.Endpoint); err != nil {
+		return fmt.Errorf("s3: invalid endpoint %q: %w", s.Endpoint, err)
+	}
+	if _, err := template.New("key").Parse(s.Key); err != nil {
+		return fmt.Errorf("s3: invalid key template: %w", err)
+	}
+	return nil
+}
+
+// s3Object is an object being written to the spool.
+type s3Object struct {
+	path string
+	f    *os.File
+	zw   *gzip.Writer
+}
+
+// s3Output spools messages per target and uploads them periodically.
+type s3Output struct {
+	conf   S3Config
+	key    *template.Template
+	client *http.Client
+
+	mu      sync.Mutex
+	objects map[string]*s3Object
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// The spool holds objects being written, named .part, and complete objects
+// waiting to be uploaded, named after their escaped key.
+const s3PartSuffix = ".part"
+
+func newS3Output(conf S3Config) (*s3Output, error) {
+	conf.applyDefaults()
+	key, err := template.New("key").Option("missingkey=zero").Parse(conf.Key)
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid key template: %w", err)
+	}
+	if err := os.MkdirAll(conf.SpoolDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// Objects left by a previous run are uploaded with the next batch;
+	// partial ones were cut short by a crash and are kept as they are.
+	parts, _ := filepath.Glob(filepath.Join(conf.SpoolDir, "*"+s3PartSuffix))
+	for _, p := range parts {
+		slog.Warn("Uploading incomplete archive object left by a previous run", "file", p)
+		if err := os.Rename(p, strings.TrimSuffix(p, s3PartSuffix)); err != nil {
+			return nil, err
+		}
+	}
+
+	o := &s3Output{
+		conf:    conf,
+		key:     key,
+		client:  &http.Client{Timeout: 10 * time.Minute},
+		objects: make(map[string]*s3Object),
+		done:    make(chan struct{}),
+	}
+	o.wg.Add(1)
+	go o.run()
+	slog.Info("Archiving telemetry to S3", "endpoint", conf.Endpoint, "bucket", conf.Bucket, "interval", conf.Interval)
+	return o, nil
+}
+
+func (o *s3Output) Write(_ context.Context, m *message) error {
+	line, err := archiveLine(m)
+	if err != nil {
+		return err
+	}
+	target := m.Header.Get("Gnmi-Target")
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	obj, ok := o.objects[target]
+	if !ok {
+		if obj, err = o.create(target, m.Subject); err != nil {
+			return err
+		}
+		o.objects[target] = obj
+	}
+	_, err = obj.zw.Write(line)
+	return err
+}
+
+// create starts the spool file of a new object for target.
+func (o *s3Output) create(target, subject string) (*s3Object, error) {
+	if target == "" {
+		target = "unknown"
+	}
+	var key strings.Builder
+	data := struct {
+		Target, Subject string
+		Time            time.Time
+	}{target, subject, time.Now().UTC()}
+	if err := o.key.Execute(&key, data); err != nil {
+		return nil, fmt.Errorf("could not render object key: %w", err)
+	}
+
+	path := filepath.Join(o.conf.SpoolDir, url.PathEscape(key.String())+s3PartSuffix)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error creating spool file: %w", err)
+	}
+	return &s3Object{path: path, f: f, zw: gzip.NewWriter(f)}, nil
+}
+
+// run completes the objects and uploads the spool at every interval
+// boundary, until the output is closed.
+func (o *s3Output) run() {
+	defer o.wg.Done()
+	o.uploadSpool()
+	for {
+		next := time.Now().Truncate(o.conf.Interval).Add(o.conf.Interval)
+		select {
+		case <-o.done:
+			o.complete()
+			o.uploadSpool()
+			return
+		case <-time.After(time.Until(next)):
+			o.complete()
+			o.uploadSpool()
+		}
+	}
+}
+
+// complete finishes the objects being written, so later messages start new
+// ones.
+func (o *s3Output) complete() {
+	o.mu.Lock()
+	objects := o.objects
+	o.objects = make(map[string]*s3Object)
+	o.mu.Unlock()
+
+	for _, obj := range objects {
+		err := obj.zw.Close()
+		if cerr := obj.f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Rename(obj.path, strings.TrimSuffix(obj.path, s3PartSuffix))
+		}
+		if err != nil {
+			slog.Error("Error completing archive object", "file", obj.path, "error", err)
+		}
+	}
+}
+
+// uploadSpool uploads every complete object in the spool and removes the
+// uploaded ones.
+func (o *s3Output) uploadSpool() {
+	entries, err := os.ReadDir(o.conf.SpoolDir)
+	if err != nil {
+		slog.Error("Error reading spool directory", "dir", o.conf.SpoolDir, "error", err)
+		return
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasSuffix(e.Name(), s3PartSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		key, err := url.PathUnescape(name)
+		if err != nil {
+			slog.Warn("Ignoring unknown file in spool directory", "file", name)
+			continue
+		}
+		path := filepath.Join(o.conf.SpoolDir, name)
+		if err := o.upload(key, path); err != nil {
+			slog.Error("Error uploading archive object, will retry", "key", key, "error", err)
+			continue
+		}
+		slog.Debug("Uploaded archive object", "key", key)
+		if err := os.Remove(path); err != nil {
+			slog.Warn("Error removing uploaded spool file", "file", path, "error", err)
+		}
+	}
+}
+
+// upload puts the content of path in the bucket under key, signed with AWS
+// Signature Version 4.
+func (o *s3Output) upload(key, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	u, err := o.objectURL(key)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	o.sign(req, data, time.Now())
+
+	rsp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("%s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// objectURL returns the URL of the object named key.
+func (o *s3Output) objectURL(key string) (*url.URL, error) {
+	endpoint, err := url.Parse(o.conf.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u := *endpoint
+	if o.conf.PathStyle {
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + o.conf.Bucket + "/" + key
+	} else {
+		u.Host = o.conf.Bucket + "." + u.Host
+		u.Path = strings.TrimRight(u.Path, "/") + "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return &u, nil
+}
+
+// sign adds the AWS Signature Version 4 headers for the request with body.
+func (o *s3Output) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if o.conf.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", o.conf.SessionToken)
+	}
+
+	canonicalRequest, signedHeaders := sigV4CanonicalRequest(req, payloadHash)
+	scope := date + "/" + o.conf.Region + "/s3/aws4_request"
+	stringToSign := sigV4StringToSign(amzDate, scope, canonicalRequest)
+	signature := o.signature(date, stringToSign)
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+o.conf.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// sigV4CanonicalRequest returns the canonical form of req, signing all of
+// its headers, and the list of the signed headers.
+func sigV4CanonicalRequest(req *http.Request, payloadHash string) (string, string) {
+	headers := map[string]string{"host": req.URL.Host}
+	for k := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(req.Header.Get(k))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	return canonicalRequest, signedHeaders
+}
+
+// sigV4StringToSign returns the string signed for canonicalRequest.
+func sigV4StringToSign(amzDate, scope, canonicalRequest string) string {
+	return "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+}
+
+// signature signs stringToSign with the key derived from the secret key for
+// date, the region and S3.
+func (o *s3Output) signature(date, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+o.conf.SecretKey), date)
+	key = hmacSHA256(key, o.conf.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// s3EscapePath escapes every byte of p except the unreserved characters and
+// the slashes, as Signature Version 4 requires.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Close uploads the objects being written.
+func (o *s3Output) Close() error {
+	close(o.done)
+	o.wg.Wait()
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"strings"
+	"sync"
+)
+
+// snapshotHeader holds the bucket/name reference of the Object Store entry
+// the publisher stored a large initial sync in, in place of the messages.
+const snapshotHeader = "Gnmi-Snapshot"
+
+// snapshotReader reads the snapshots referred to by messages, opening each
+// bucket once.
+type snapshotReader struct {
+	nc *nats.Conn
+
+	mu      sync.Mutex
+	js      nats.JetStreamContext
+	buckets map[string]nats.ObjectStore
+}
+
+func newSnapshotReader(nc *nats.Conn) *snapshotReader {
+	return &snapshotReader{nc: nc, buckets: make(map[string]nats.ObjectStore)}
+}
+
+// resolve returns msg with the snapshot it refers to as its payload, or msg
+// itself when it does not refer to one.
+func (r *snapshotReader) resolve(msg *nats.Msg) (*nats.Msg, error) {
+	ref := msg.Header.Get(snapshotHeader)
+	if ref == "" {
+		return msg, nil
+	}
+	bucket, name, ok := strings.Cut(ref, "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid snapshot reference %q", ref)
+	}
+	obs, err := r.bucket(bucket)
+	if err != nil {
+		return nil, err
+	}
+	data, err := obs.GetBytes(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read snapshot %s: %w", ref, err)
+	}
+	return &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Header: msg.Header, Data: data}, nil
+}
+
+func (r *snapshotReader) bucket(name string) (nats.ObjectStore, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if obs, ok := r.buckets[name]; ok {
+		return obs, nil
+	}
+	if r.js == nil {
+		js, err := r.nc.JetStream()
+		if err != nil {
+			return nil, fmt.Errorf("error creating JetStream context: %v", err)
+		}
+		r.js = js
+	}
+	obs, err := r.js.ObjectStore(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not open snapshot bucket %s: %w", name, err)
+	}
+	r.buckets[name] = obs
+	return obs, nil
+}
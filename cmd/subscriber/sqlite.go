@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SQLiteConfig stores received values in a local SQLite database, for edge
+// deployments without a time series database. Rows older than Retention are
+// deleted periodically. Rows are inserted in a transaction per BatchSize
+// values or FlushInterval.
+type SQLiteConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Path          string        `yaml:"path"`
+	Retention     time.Duration `yaml:"retention"`
+	BatchSize     int           `yaml:"batch_size"`
+	FlushInterval time.Duration `yaml:"flush_interval"`
+}
+
+const (
+	defaultSQLitePath      = "telemetry.db"
+	defaultSQLiteRetention = 24 * time.Hour
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS telemetry (
+	time         INTEGER NOT NULL,
+	target       TEXT NOT NULL,
+	subscription TEXT NOT NULL,
+	path         TEXT NOT NULL,
+	tags         TEXT NOT NULL,
+	value        REAL,
+	value_text   TEXT
+);
+CREATE INDEX IF NOT EXISTS telemetry_target_path_time ON telemetry (target, path, time);
+CREATE INDEX IF NOT EXISTS telemetry_time ON telemetry (time);
+`
+
+// sqliteStore is a telemetry database. time is stored in nanoseconds since
+// the epoch and tags as a JSON object.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLite opens the database at path, creating it and its schema if
+// needed.
+func openSQLite(path string) (*sqliteStore, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; one connection avoids busy errors.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create SQLite schema in %s: %w", path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// storedValue is a value read back from the store.
+type storedValue struct {
+	Time         time.Time         `json:"time"`
+	Target       string            `json:"target"`
+	Subscription string            `json:"subscription"`
+	Path         string            `json:"path"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Value        interface{}       `json:"value"`
+}
+
+// latest returns the most recent value of every path of target starting
+// with path, or of every target when target is empty.
+func (s *sqliteStore) latest(ctx context.Context, target, path string) ([]storedValue, error) {
+	return s.query(ctx, `
+		SELECT t.time, t.target, t.subscription, t.path, t.tags, t.value, t.value_text
+		FROM telemetry t
+		JOIN (
+			SELECT target, path, tags, MAX(time) AS time FROM telemetry
+			WHERE (? = '' OR target = ?) AND path LIKE ? ESCAPE '\'
+			GROUP BY target, path, tags
+		) l ON t.target = l.target AND t.path = l.path AND t.tags = l.tags AND t.time = l.time
+		ORDER BY t.target, t.path, t.tags`,
+		target, target, likePrefix(path))
+}
+
+// history returns the values of the paths of target starting with path
+// recorded since the given time, oldest first, at most limit of them.
+func (s *sqliteStore) history(ctx context.Context, target, path string, since time.Time, limit int) ([]storedValue, error) {
+	if limit <= 0 {
+		limit = -1
+	}
+	return s.query(ctx, `
+		SELECT time, target, subscription, path, tags, value, value_text
+		FROM telemetry
+		WHERE (? = '' OR target = ?) AND path LIKE ? ESCAPE '\' AND time >= ?
+		ORDER BY time
+		LIMIT ?`,
+		target, target, likePrefix(path), since.UnixNano(), limit)
+}
+
+func (s *sqliteStore) query(ctx context.Context, query string, args ...interface{}) ([]storedValue, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []storedValue
+	for rows.Next() {
+		var v storedValue
+		var ts int64
+		var tags string
+		var value sql.NullFloat64
+		var text sql.NullString
+		if err := rows.Scan(&ts, &v.Target, &v.Subscription, &v.Path, &tags, &value, &text); err != nil {
+			return nil, err
+		}
+		v.Time = time.Unix(0, ts).UTC()
+		if err := json.Unmarshal([]byte(tags), &v.Tags); err != nil {
+			return nil, fmt.Errorf("invalid tags %q: %w", tags, err)
+		}
+		if value.Valid {
+			v.Value = value.Float64
+		} else {
+			v.Value = text.String
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// likePrefix returns a LIKE pattern matching the strings starting with p.
+func likePrefix(p string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(p) + "%"
+}
+
+// sqliteRow is a row waiting to be inserted.
+type sqliteRow struct {
+	time                       int64
+	target, subscription, path string
+	tags                       string
+	value                      sql.NullFloat64
+	text                       sql.NullString
+}
+
+// sqliteOutput stores the values of received events and enforces the
+// retention window.
+type sqliteOutput struct {
+	store     *sqliteStore
+	retention time.Duration
+	batcher   *batcher[sqliteRow]
+	done      chan struct{}
+}
+
+func newSQLiteOutput(conf SQLiteConfig) (*sqliteOutput, error) {
+	if conf.Path == "" {
+		conf.Path = defaultSQLitePath
+	}
+	if conf.Retention == 0 {
+		conf.Retention = defaultSQLiteRetention
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = 1000
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = time.Second
+	}
+	store, err := openSQLite(conf.Path)
+	if err != nil {
+		return nil, err
+	}
+	o := &sqliteOutput{store: store, retention: conf.Retention, done: make(chan struct{})}
+	o.batcher = newBatcher("sqlite", conf.BatchSize, conf.FlushInterval, o.insert)
+	if conf.Retention > 0 {
+		go o.expire()
+	}
+	slog.Info("Storing telemetry in SQLite", "file", conf.Path, "retention", conf.Retention)
+	return o, nil
+}
+
+func (o *sqliteOutput) Write(_ context.Context, m *message) error {
+	target := m.Header.Get("Gnmi-Target")
+	var rows []sqliteRow
+	for _, ev := range m.events {
+		row := sqliteRow{time: m.received.UnixNano()}
+		if ev.Timestamp > 0 {
+			row.time = ev.Timestamp
+		}
+		var tags map[string]string
+		row.target, row.subscription, tags = splitTags(ev, target)
+		encoded, err := json.Marshal(tags)
+		if err != nil {
+			return err
+		}
+		row.tags = string(encoded)
+		for path, v := range ev.Values {
+			r := row
+			r.path = path
+			if f, ok := numericValue(v); ok {
+				r.value = sql.NullFloat64{Float64: f, Valid: true}
+			} else {
+				r.text = sql.NullString{String: valueText(v), Valid: true}
+			}
+			rows = append(rows, r)
+		}
+	}
+	if len(rows) > 0 {
+		o.batcher.add(m.hold(), rows...)
+	}
+	return nil
+}
+
+// insert stores rows in a single transaction.
+func (o *sqliteOutput) insert(rows []sqliteRow) error {
+	tx, err := o.store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`INSERT INTO telemetry (time, target, subscription, path, tags, value, value_text) VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, r := range rows {
+		if _, err := stmt.Exec(r.time, r.target, r.subscription, r.path, r.tags, r.value, r.text); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// expire deletes the rows older than the retention window every minute, or
+// more often for short windows.
+func (o *sqliteOutput) expire() {
+	ticker := time.NewTicker(min(time.Minute, o.retention/10+time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-o.done:
+			return
+		case now := <-ticker.C:
+			res, err := o.store.db.Exec(`DELETE FROM telemetry WHERE time < ?`, now.Add(-o.retention).UnixNano())
+			if err != nil {
+				slog.Error("Error deleting expired telemetry", "error", err)
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				slog.Debug("Deleted expired telemetry", "rows", n)
+			}
+		}
+	}
+}
+
+func (o *sqliteOutput) Close() error {
+	close(o.done)
+	o.batcher.close()
+	return o.store.db.Close()
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatsConfig logs a summary of the received messages every Interval in
+// place of a line per message, which cannot be followed, and slows the
+// subscriber down, at more than a few hundred messages per second. The
+// messages are still logged at debug level.
+type StatsConfig struct {
+	Interval time.Duration `yaml:"interval"`
+	// Subjects is the number of subjects with the most messages to log
+	// the statistics of.
+	Subjects int `yaml:"subjects"`
+}
+
+const (
+	defaultStatsSubjects = 10
+
+	// maxLagSamples bounds the lags kept per interval to compute their
+	// percentiles.
+	maxLagSamples = 10000
+)
+
+func (s StatsConfig) validate() error {
+	if s.Interval < 0 {
+		return fmt.Errorf("stats: interval must not be negative")
+	}
+	return nil
+}
+
+// subjectStats are the statistics of the messages received on a subject.
+type subjectStats struct {
+	subject  string
+	messages int
+	bytes    int
+	maxLag   time.Duration
+}
+
+// receiveStats counts the received messages and logs a summary every
+// interval. The lag of a message is the time from its gNMI timestamp,
+// set by the publisher in the Gnmi-Timestamp header, to its receipt.
+type receiveStats struct {
+	conf  StatsConfig
+	queue string
+	rnd   *rand.Rand
+
+	mu       sync.Mutex
+	start    time.Time
+	messages int
+	bytes    int
+	subjects map[string]*subjectStats
+	seen     int
+	lags     []time.Duration
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newReceiveStats(conf StatsConfig, queue string) *receiveStats {
+	if conf.Subjects <= 0 {
+		conf.Subjects = defaultStatsSubjects
+	}
+	s := &receiveStats{
+		conf:     conf,
+		queue:    queue,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		start:    time.Now(),
+		subjects: make(map[string]*subjectStats),
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+// record counts msg, received at now.
+func (s *receiveStats) record(msg *nats.Msg, now time.Time) {
+	lag := time.Duration(-1)
+	if ts, ok := notificationTime(msg, nil); ok {
+		lag = now.Sub(ts)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages++
+	s.bytes += len(msg.Data)
+	sub, ok := s.subjects[msg.Subject]
+	if !ok {
+		sub = &subjectStats{subject: msg.Subject}
+		s.subjects[msg.Subject] = sub
+	}
+	sub.messages++
+	sub.bytes += len(msg.Data)
+	if lag < 0 {
+		return
+	}
+	if lag > sub.maxLag {
+		sub.maxLag = lag
+	}
+	// Keep a uniform sample of the lags.
+	s.seen++
+	if len(s.lags) < maxLagSamples {
+		s.lags = append(s.lags, lag)
+	} else if i := s.rnd.Intn(s.seen); i < maxLagSamples {
+		s.lags[i] = lag
+	}
+}
+
+// run logs a summary every interval until stopped, and a last one then.
+func (s *receiveStats) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			s.log(time.Now())
+			return
+		case now := <-ticker.C:
+			s.log(now)
+		}
+	}
+}
+
+// log logs the statistics since the previous summary and resets them.
+func (s *receiveStats) log(now time.Time) {
+	s.mu.Lock()
+	elapsed := now.Sub(s.start)
+	messages, bytes, lags := s.messages, s.bytes, s.lags
+	subjects := make([]*subjectStats, 0, len(s.subjects))
+	var maxLag time.Duration
+	for _, sub := range s.subjects {
+		subjects = append(subjects, sub)
+		if sub.maxLag > maxLag {
+			maxLag = sub.maxLag
+		}
+	}
+	s.start, s.messages, s.bytes, s.seen, s.lags = now, 0, 0, 0, nil
+	s.subjects = make(map[string]*subjectStats, len(subjects))
+	s.mu.Unlock()
+
+	secs := elapsed.Seconds()
+	if secs <= 0 {
+		secs = 1
+	}
+	args := []any{
+		"interval", elapsed.Round(time.Millisecond),
+		"messages", messages,
+		"msg_rate", strconv.FormatFloat(float64(messages)/secs, 'f', 1, 64),
+		"bytes", bytes,
+		"byte_rate", strconv.FormatFloat(float64(bytes)/secs, 'f', 0, 64),
+		"subjects", len(subjects),
+	}
+	if s.queue != "" {
+		args = append(args, "queue", s.queue)
+	}
+	if len(lags) > 0 {
+		sort.Slice(lags, func(i, j int) bool { return lags[i] < lags[j] })
+		percentile := func(p float64) time.Duration {
+			return lags[int(p*float64(len(lags)-1))].Round(time.Microsecond)
+		}
+		args = append(args, "lag_p50", percentile(0.5), "lag_p99", percentile(0.99), "lag_max", maxLag.Round(time.Microsecond))
+	}
+	slog.Info("Message statistics", args...)
+
+	sort.Slice(subjects, func(i, j int) bool {
+		if subjects[i].messages != subjects[j].messages {
+			return subjects[i].messages > subjects[j].messages
+		}
+		return subjects[i].subject < subjects[j].subject
+	})
+	if len(subjects) > s.conf.Subjects {
+		subjects = subjects[:s.conf.Subjects]
+	}
+	for _, sub := range subjects {
+		args := []any{
+			"subject", sub.subject,
+			"messages", sub.messages,
+			"msg_rate", strconv.FormatFloat(float64(sub.messages)/secs, 'f', 1, 64),
+			"bytes", sub.bytes,
+		}
+		if sub.maxLag > 0 {
+			args = append(args, "lag_max", sub.maxLag.Round(time.Microsecond))
+		}
+		slog.Info("Subject statistics", args...)
+	}
+}
+
+// stop logs the statistics since the last summary and stops logging them.
+func (s *receiveStats) stop() {
+	close(s.done)
+	s.wg.Wait()
+}
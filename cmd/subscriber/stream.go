@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/telemetrypb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GRPCStreamConfig serves the TelemetryStream gRPC service on Address, which
+// streams the received events to internal applications so they do not each
+// need NATS credentials. Clients give username and password metadata when
+// Username is set. Each client has a buffer of BufferSize events; events
+// are dropped for clients that fall further behind.
+type GRPCStreamConfig struct {
+	Enabled    bool   `yaml:"enabled"`
+	Address    string `yaml:"address"`
+	TLSCert    string `yaml:"tls_cert"`
+	TLSKey     string `yaml:"tls_key"`
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	BufferSize int    `yaml:"buffer_size"`
+}
+
+const defaultStreamBufferSize = 1000
+
+func (g GRPCStreamConfig) validate() error {
+	if !g.Enabled {
+		return nil
+	}
+	if g.Address == "" {
+		return fmt.Errorf("grpc: address is required")
+	}
+	if (g.TLSCert == "") != (g.TLSKey == "") {
+		return fmt.Errorf("grpc: tls_cert and tls_key must be set together")
+	}
+	return nil
+}
+
+// streamFilter selects the events and values sent to a streaming client.
+// Empty lists match everything.
+type streamFilter struct {
+	subjects []string
+	paths    []string
+	targets  []string
+}
+
+// matches reports whether events received on subject for target pass the
+// filter.
+func (f streamFilter) matches(subject, target string) bool {
+	if len(f.subjects) > 0 {
+		ok := false
+		for _, s := range f.subjects {
+			if subjectMatches(s, subject) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(f.targets) == 0 {
+		return true
+	}
+	for _, t := range f.targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// selected reports whether the values of path pass the filter.
+func (f streamFilter) selected(path string) bool {
+	if len(f.paths) == 0 {
+		return true
+	}
+	for _, p := range f.paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// streamClient is a Subscribe call and the events waiting to be sent to it.
+type streamClient struct {
+	streamFilter
+	events  chan *telemetrypb.Event
+	dropped int
+}
+
+// subjectMatches reports whether subject matches the NATS subject pattern,
+// where * matches a token and > the remaining ones.
+func subjectMatches(pattern, subject string) bool {
+	pts, sts := strings.Split(pattern, "."), strings.Split(subject, ".")
+	for i, p := range pts {
+		if p == ">" {
+			return len(sts) > i
+		}
+		if i >= len(sts) || p != "*" && p != sts[i] {
+			return false
+		}
+	}
+	return len(pts) == len(sts)
+}
+
+// streamOutput is a gRPC server handing the received events to the
+// subscribed clients.
+type streamOutput struct {
+	telemetrypb.UnimplementedTelemetryStreamServer
+	conf GRPCStreamConfig
+	srv  *grpc.Server
+
+	mu      sync.Mutex
+	clients map[*streamClient]struct{}
+
+	done chan struct{}
+}
+
+func newStreamOutput(conf GRPCStreamConfig) (*streamOutput, error) {
+	if conf.BufferSize <= 0 {
+		conf.BufferSize = defaultStreamBufferSize
+	}
+	var opts []grpc.ServerOption
+	if conf.TLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(conf.TLSCert, conf.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	if conf.Username != "" {
+		opts = append(opts, grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			md, _ := metadata.FromIncomingContext(ss.Context())
+			if first(md.Get("username")) != conf.Username || first(md.Get("password")) != conf.Password {
+				return status.Error(codes.Unauthenticated, "invalid username or password")
+			}
+			return handler(srv, ss)
+		}))
+	}
+	lis, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &streamOutput{
+		conf:    conf,
+		srv:     grpc.NewServer(opts...),
+		clients: make(map[*streamClient]struct{}),
+		done:    make(chan struct{}),
+	}
+	telemetrypb.RegisterTelemetryStreamServer(o.srv, o)
+	go func() {
+		if err := o.srv.Serve(lis); err != nil {
+			slog.Error("gRPC server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving telemetry over gRPC", "address", lis.Addr().String(), "tls", conf.TLSCert != "")
+	return o, nil
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Subscribe streams the events matching req until the client goes away or
+// the output is closed.
+func (o *streamOutput) Subscribe(req *telemetrypb.SubscribeRequest, stream telemetrypb.TelemetryStream_SubscribeServer) error {
+	c := &streamClient{
+		streamFilter: streamFilter{subjects: req.Subjects, paths: req.Paths, targets: req.Targets},
+		events:       make(chan *telemetrypb.Event, o.conf.BufferSize),
+	}
+	o.mu.Lock()
+	o.clients[c] = struct{}{}
+	o.mu.Unlock()
+	defer func() {
+		o.mu.Lock()
+		delete(o.clients, c)
+		o.mu.Unlock()
+	}()
+
+	addr := ""
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = p.Addr.String()
+	}
+	slog.Info("gRPC client subscribed", "peer", addr, "subjects", req.Subjects, "paths", req.Paths, "targets", req.Targets)
+	defer slog.Info("gRPC client unsubscribed", "peer", addr)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-o.done:
+			return status.Error(codes.Unavailable, "subscriber is shutting down")
+		case ev := <-c.events:
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (o *streamOutput) Write(_ context.Context, m *message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.clients) == 0 {
+		return nil
+	}
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target := m.Header.Get("Gnmi-Target")
+		if source := ev.Tags["source"]; source != "" {
+			target = source
+		}
+		subscription := ev.Name
+		if sub := ev.Tags["subscription-name"]; sub != "" {
+			subscription = sub
+		}
+
+		for c := range o.clients {
+			if !c.matches(m.Subject, target) {
+				continue
+			}
+			e := &telemetrypb.Event{
+				Subject:      m.Subject,
+				Target:       target,
+				Subscription: subscription,
+				Timestamp:    ts.UnixNano(),
+				Tags:         ev.Tags,
+			}
+			for path, v := range ev.Values {
+				if !c.selected(path) {
+					continue
+				}
+				e.Values = append(e.Values, streamValue(path, v))
+			}
+			if len(e.Values) == 0 {
+				continue
+			}
+			select {
+			case c.events <- e:
+			default:
+				c.dropped++
+				if c.dropped == 1 || c.dropped%1000 == 0 {
+					slog.Warn("gRPC client is too slow, dropping events", "dropped", c.dropped)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// streamValue returns v as a Value. Numbers are sent as doubles, and strings,
+// booleans and anything else as text.
+func streamValue(path string, v interface{}) *telemetrypb.Value {
+	value := &telemetrypb.Value{Path: path}
+	switch v.(type) {
+	case string, bool:
+	default:
+		if f, ok := numericValue(v); ok {
+			value.Value = &telemetrypb.Value_DoubleValue{DoubleValue: f}
+			return value
+		}
+	}
+	value.Value = &telemetrypb.Value_StringValue{StringValue: valueText(v)}
+	return value
+}
+
+// Close ends the open streams and stops the server.
+func (o *streamOutput) Close() error {
+	close(o.done)
+	o.srv.GracefulStop()
+	return nil
+}
@@ -1,42 +1,226 @@
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
-	"github.com/nats-io/nats.go"
+	"time"
 )
 
-func main() {
+// run subscribes to the configured subjects and logs every message until the
+// process receives SIGINT or SIGTERM. With a dashboard, it shows the
+// dashboard instead until the user quits it.
+func run(conf Config, ui *dashboard) error {
+	opts, err := conf.natsOptions()
+	if err != nil {
+		return err
+	}
+
+	tracer = tracing.New(conf.Tracing, "nats-gnmi-subscriber")
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tracer.Shutdown(ctx); err != nil {
+			slog.Error("Error exporting spans", "error", err)
+		}
+	}()
+
 	// Connect to NATS server
-	nc, err := nats.Connect(nats.DefaultURL)
+	nc, err := nats.Connect(conf.NatsURL, opts...)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("could not connect to NATS: %w", err)
 	}
 	defer nc.Close()
 
-	// Subscribe to subject
-	log.Printf("Listening on all subjects")
-	sub, err := nc.Subscribe("interface-counters", func(msg *nats.Msg) {
-		log.Printf("Received message on [%s]: %s", msg.Subject, string(msg.Data))
-	})
+	outputs, err := newOutputs(conf, nc)
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if ui != nil {
+		outputs = append(outputs, ui)
+	}
+	defer closeOutputs(outputs)
+	var filter *eventFilter
+	if conf.Filter != "" {
+		if filter, err = newEventFilter(conf.Filter); err != nil {
+			return err
+		}
+	}
+	var rates *rateCalculator
+	if conf.Rates.Enabled {
+		if rates, err = newRateCalculator(conf.Rates); err != nil {
+			return err
+		}
+	}
+	var stats *receiveStats
+	if conf.Stats.Interval > 0 {
+		stats = newReceiveStats(conf.Stats, conf.Queue)
+		defer stats.stop()
+	}
+	var verifier *verifier
+	if conf.Verify.Enabled {
+		if verifier, err = newVerifier(conf.Verify); err != nil {
+			return err
+		}
+	}
+	chunks := newChunkAssembler(conf.JetStream.Enabled)
+	snapshots := newSnapshotReader(nc)
+	var queueChunks sync.Once
+	handler := func(msg *nats.Msg) {
+		// Messages over the server's max_payload arrive in chunks, which
+		// are only acknowledged once the whole message has been handled.
+		whole, parts, err := chunks.add(msg)
+		if err != nil {
+			slog.Error("Could not reassemble chunked message", "subject", msg.Subject, "error", err)
+			parts = []*nats.Msg{msg}
+		}
+		if conf.Queue != "" && msg.Header.Get(chunkIDHeader) != "" {
+			queueChunks.Do(func() {
+				slog.Warn("Received a chunked message in a queue group, whose chunks are spread across its members", "queue", conf.Queue)
+			})
+		}
+		var acks *pendingAck
+		if conf.JetStream.Enabled && parts != nil {
+			acks = newPendingAck(parts)
+			defer acks.release(nil)
+		}
+		if whole == nil {
+			return
+		}
+		// Signatures cover the payload as published, before decompression.
+		if verifier != nil {
+			if whole, err = verifier.verify(whole); err != nil {
+				slog.Warn("Rejected message", "subject", msg.Subject, "error", err)
+				return
+			}
+		}
+		whole, err = decompress(whole)
+		if err != nil {
+			slog.Error("Could not decompress message", "subject", msg.Subject, "error", err)
+			return
+		}
+		// Large initial syncs arrive as references to snapshots.
+		m, err := snapshots.resolve(whole)
+		if err != nil {
+			slog.Error("Could not read snapshot", "subject", msg.Subject, "error", err)
+			return
+		}
+		handleMessage(m, acks, outputs, stats, rates, filter)
+	}
+
+	// Subscribe to the configured subjects
+	slog.Info("Listening", "subjects", strings.Join(conf.Subjects, ","), "queue", conf.Queue)
+	var subs []*nats.Subscription
+	if conf.JetStream.Enabled {
+		subs, err = subscribeDurable(nc, conf.JetStream, conf.Subjects, conf.Queue, handler)
+		if err != nil {
+			return fmt.Errorf("could not subscribe: %w", err)
+		}
+	} else {
+		for _, subject := range conf.Subjects {
+			var sub *nats.Subscription
+			if conf.Queue != "" {
+				sub, err = nc.QueueSubscribe(subject, conf.Queue, handler)
+			} else {
+				sub, err = nc.Subscribe(subject, handler)
+			}
+			if err != nil {
+				return fmt.Errorf("could not subscribe to %s: %w", subject, err)
+			}
+			subs = append(subs, sub)
+		}
 	}
 
-	// Handle SIGINT and SIGTERM signals to gracefully close the application.
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	if ui != nil {
+		if err := ui.run(); err != nil {
+			return fmt.Errorf("dashboard failed: %w", err)
+		}
+	} else {
+		// Handle SIGINT and SIGTERM signals to gracefully close the application.
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
-	// Wait until receiving a termination signal.
-	<-c
+		// Wait until receiving a termination signal.
+		<-c
+	}
+
+	// Durable consumers created by the client are deleted on unsubscribe or
+	// drain, so just close the connection to let the next run resume where
+	// this one stopped.
+	if conf.JetStream.Enabled {
+		return nil
+	}
 
 	// Unsubscribe and Drain the connection.
-	if err := sub.Unsubscribe(); err != nil {
-		log.Fatal(err)
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil {
+			return fmt.Errorf("could not unsubscribe from %s: %w", sub.Subject, err)
+		}
 	}
 	if err := nc.Drain(); err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("could not drain connection: %w", err)
+	}
+	return nil
+}
+
+// tracer records a span for every received message, continuing the trace
+// started by the publisher. It is nil unless tracing is configured.
+var tracer *tracing.Tracer
+
+// handleMessage logs msg, or counts it in stats when they are kept, and
+// hands it to every output. With rates, the rates of the counters are added
+// to the events first. With a filter, only the matching events are handed
+// on, and messages without any are neither logged nor handed on. The payload
+// is only decoded when there are outputs, rates or a filter. With acks, the
+// message is acknowledged once the outputs have written it.
+func handleMessage(msg *nats.Msg, acks *pendingAck, outputs []output, stats *receiveStats, rates *rateCalculator, filter *eventFilter) {
+	ctx := tracing.Extract(context.Background(), msg.Header.Get(tracing.Header))
+	ctx, span := tracer.Start(ctx, "nats.receive", tracing.KindConsumer,
+		tracing.String("messaging.destination.name", msg.Subject),
+		tracing.Int("messaging.message.body.size", int64(len(msg.Data))))
+	defer span.End()
+
+	received := time.Now()
+	if stats != nil {
+		stats.record(msg, received)
+	}
+	m := &message{Msg: msg, received: received, acks: acks}
+	if len(outputs) > 0 || rates != nil || filter != nil {
+		var err error
+		if m.events, err = decodeEvents(msg); err != nil {
+			span.RecordError(err)
+			slog.Warn("Could not decode message", "subject", msg.Subject, "error", err)
+		}
+	}
+	if rates != nil {
+		events := rates.apply(m)
+		if len(events) == 0 && len(m.events) > 0 {
+			return
+		}
+		m.events = events
+	}
+	if filter != nil {
+		if m.events = filter.apply(m.events); len(m.events) == 0 {
+			return
+		}
+	}
+
+	if stats != nil {
+		slog.Debug("Received message", "subject", msg.Subject, "data", string(msg.Data))
+	} else {
+		slog.Info("Received message", "subject", msg.Subject, "data", string(msg.Data))
+	}
+	for _, out := range outputs {
+		if err := out.Write(ctx, m); err != nil {
+			span.RecordError(err)
+			slog.Error("Error writing message to output", "subject", msg.Subject, "error", err)
+		}
 	}
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogConfig sends the changes of selected paths, such as interface
+// oper-status or BGP neighbor state, as RFC 5424 syslog messages to a SIEM.
+// Address is a udp://, tcp:// or tls:// URL. Each rule selects the paths
+// starting with Path and gives their messages a severity; by default they
+// select interface oper-status and BGP session state. A message is sent
+// when a value differs from the previous one of the same target, path and
+// tags; the first value seen is only recorded.
+type SyslogConfig struct {
+	Enabled  bool         `yaml:"enabled"`
+	Address  string       `yaml:"address"`
+	Facility string       `yaml:"facility"`
+	AppName  string       `yaml:"app_name"`
+	Hostname string       `yaml:"hostname"`
+	Rules    []SyslogRule `yaml:"rules"`
+	TLS      natsopts.TLS `yaml:"tls"`
+}
+
+// SyslogRule selects the paths starting with Path.
+type SyslogRule struct {
+	Path     string `yaml:"path"`
+	Severity string `yaml:"severity"`
+}
+
+const (
+	defaultSyslogFacility = "local7"
+	defaultSyslogSeverity = "notice"
+	defaultSyslogAppName  = "nats-gnmi"
+
+	// syslogSDID is the structured data ID of the messages, under the
+	// private enterprise number reserved for documentation.
+	syslogSDID = "gnmi@32473"
+)
+
+var defaultSyslogRules = []SyslogRule{
+	{Path: "/interfaces/interface/state/oper-status", Severity: "notice"},
+	{Path: "/network-instances/network-instance/protocols/protocol/bgp/neighbors/neighbor/state/session-state", Severity: "warning"},
+}
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "ntp": 12, "security": 13, "console": 14,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+func (s SyslogConfig) validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if _, _, err := parseSyslogAddress(s.Address); err != nil {
+		return err
+	}
+	if _, ok := syslogFacilities[s.Facility]; s.Facility != "" && !ok {
+		return fmt.Errorf("syslog: unknown facility %q", s.Facility)
+	}
+	for _, r := range s.Rules {
+		if r.Path == "" {
+			return fmt.Errorf("syslog: rule path is required")
+		}
+		if _, ok := syslogSeverities[r.Severity]; r.Severity != "" && !ok {
+			return fmt.Errorf("syslog: unknown severity %q", r.Severity)
+		}
+	}
+	if _, err := s.TLS.Config(); err != nil {
+		return fmt.Errorf("syslog: invalid TLS settings: %w", err)
+	}
+	return nil
+}
+
+// parseSyslogAddress returns the network and host:port of a syslog URL.
+func parseSyslogAddress(address string) (network, hostport string, err error) {
+	u, err := url.Parse(address)
+	if err != nil || u.Host == "" {
+		return "", "", fmt.Errorf("syslog: invalid address %q", address)
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return "", "", fmt.Errorf("syslog: unknown scheme %q in address %q", u.Scheme, address)
+	}
+	hostport = u.Host
+	if u.Port() == "" {
+		port := "514"
+		if u.Scheme == "tls" {
+			port = "6514"
+		}
+		hostport = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u.Scheme, hostport, nil
+}
+
+// syslogOutput sends a message for every change of a selected value. Stream
+// connections use octet-counting framing and are redialed after errors.
+type syslogOutput struct {
+	conf     SyslogConfig
+	network  string
+	hostport string
+	tls      *tls.Config
+	facility int
+	pid      string
+
+	mu   sync.Mutex
+	conn net.Conn
+	last map[string]string
+}
+
+func newSyslogOutput(conf SyslogConfig) (*syslogOutput, error) {
+	if conf.Facility == "" {
+		conf.Facility = defaultSyslogFacility
+	}
+	if conf.AppName == "" {
+		conf.AppName = defaultSyslogAppName
+	}
+	if conf.Hostname == "" {
+		conf.Hostname, _ = os.Hostname()
+	}
+	if len(conf.Rules) == 0 {
+		conf.Rules = defaultSyslogRules
+	}
+	network, hostport, err := parseSyslogAddress(conf.Address)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf, err := conf.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("syslog: invalid TLS settings: %w", err)
+	}
+	if network == "tls" && tlsConf == nil {
+		tlsConf = &tls.Config{}
+	}
+	if tlsConf != nil && tlsConf.ServerName == "" {
+		tlsConf.ServerName, _, _ = net.SplitHostPort(hostport)
+	}
+
+	o := &syslogOutput{
+		conf:     conf,
+		network:  network,
+		hostport: hostport,
+		tls:      tlsConf,
+		facility: syslogFacilities[conf.Facility],
+		pid:      strconv.Itoa(os.Getpid()),
+		last:     make(map[string]string),
+	}
+	slog.Info("Sending telemetry changes to syslog", "address", conf.Address)
+	return o, nil
+}
+
+// rule returns the rule selecting path, if any.
+func (o *syslogOutput) rule(path string) (SyslogRule, bool) {
+	for _, r := range o.conf.Rules {
+		if strings.HasPrefix(path, r.Path) {
+			return r, true
+		}
+	}
+	return SyslogRule{}, false
+}
+
+func (o *syslogOutput) Write(_ context.Context, m *message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target, _, tags := splitTags(ev, m.Header.Get("Gnmi-Target"))
+
+		for path, v := range ev.Values {
+			r, ok := o.rule(path)
+			if !ok {
+				continue
+			}
+			value := valueText(v)
+			key := seriesKey(path, target, tags)
+			old, seen := o.last[key]
+			o.last[key] = value
+			if !seen || old == value {
+				continue
+			}
+			severity := defaultSyslogSeverity
+			if r.Severity != "" {
+				severity = r.Severity
+			}
+			msg := o.format(syslogSeverities[severity], ts, target, path, tags, old, value)
+			if err := o.send(msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// format returns the RFC 5424 message for a change of path from old to
+// value, such as
+//
+//	<189>1 2024-01-02T15:04:05.123456Z collector nats-gnmi 42 CHANGE [gnmi@32473 target="router1" path="/interfaces/interface/state/oper-status" old="UP" new="DOWN" interface_name="Ethernet1"] router1 /interfaces/interface/state/oper-status changed from UP to DOWN
+func (o *syslogOutput) format(severity int, ts time.Time, target, path string, tags map[string]string, old, value string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s CHANGE [%s",
+		o.facility*8+severity, ts.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(o.conf.Hostname, 255), syslogHeaderField(o.conf.AppName, 48), o.pid, syslogSDID)
+	params := [][2]string{{"target", target}, {"path", path}, {"old", old}, {"new", value}}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		params = append(params, [2]string{k, tags[k]})
+	}
+	for _, p := range params {
+		fmt.Fprintf(&b, ` %s="%s"`, syslogParamName(p[0]), syslogParamValue.Replace(p[1]))
+	}
+	fmt.Fprintf(&b, "] %s %s changed from %s to %s", target, path, old, value)
+	return []byte(b.String())
+}
+
+// syslogHeaderField returns s as a header field: printable ASCII without
+// spaces, at most limit long, or "-" when empty.
+func syslogHeaderField(s string, limit int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return -1
+		}
+		return r
+	}, s)
+	if len(s) > limit {
+		s = s[:limit]
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// syslogParamName returns s as a structured data parameter name.
+func syslogParamName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, s)
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
+
+// syslogParamValue escapes structured data parameter values.
+var syslogParamValue = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// send sends msg, dialing first if needed and redialing once after an
+// error. o.mu must be held.
+func (o *syslogOutput) send(msg []byte) error {
+	if o.network != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if o.conn == nil {
+			if o.conn, err = o.dial(); err != nil {
+				return fmt.Errorf("could not connect to syslog server: %w", err)
+			}
+		}
+		o.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if _, err = o.conn.Write(msg); err == nil {
+			return nil
+		}
+		o.conn.Close()
+		o.conn = nil
+	}
+	return fmt.Errorf("error sending syslog message: %w", err)
+}
+
+func (o *syslogOutput) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	switch {
+	case o.network == "udp":
+		return dialer.Dial("udp", o.hostport)
+	case o.tls != nil:
+		return tls.DialWithDialer(dialer, "tcp", o.hostport, o.tls)
+	}
+	return dialer.Dial("tcp", o.hostport)
+}
+
+func (o *syslogOutput) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
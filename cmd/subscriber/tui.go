@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/logging"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dashboard is an output keeping the latest state of every interface
+// received, which the tui command renders as a live table.
+type dashboard struct {
+	subjects []string
+	started  time.Time
+
+	mu       sync.Mutex
+	rows     map[string]*dashboardRow
+	messages int
+
+	// The fields below are only used by the bubbletea program.
+	width, height int
+	offset        int
+	lastMessages  int
+	lastTick      time.Time
+	msgRate       float64
+}
+
+// dashboardRow is the state of an interface, or of a target for values
+// that are not of an interface.
+type dashboardRow struct {
+	target, name string
+	status       string
+	counters     map[string]*dashboardCounter
+	updated      time.Time
+}
+
+// dashboardCounter is the last value of a counter and, from the second
+// value on, its rate per second.
+type dashboardCounter struct {
+	value   float64
+	time    time.Time
+	rate    float64
+	hasRate bool
+}
+
+// dashboardLog is the file the tui command writes logs to, as they would
+// garble the screen.
+var dashboardLog = filepath.Join(os.TempDir(), "nats-gnmi-subscriber.log")
+
+// runDashboard runs the subscriber with the dashboard, logging to
+// dashboardLog. The per-message logs are left out unless a log level is
+// set.
+func runDashboard(conf Config) error {
+	f, err := os.OpenFile(dashboardLog, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	defer f.Close()
+	level := conf.LogLevel
+	if level == "" {
+		level = "warn"
+	}
+	logger, err := logging.NewWriter(f, level, conf.LogFormat)
+	if err != nil {
+		return err
+	}
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(logger)
+	return run(conf, newDashboard(conf.Subjects))
+}
+
+func newDashboard(subjects []string) *dashboard {
+	return &dashboard{
+		subjects: subjects,
+		started:  time.Now(),
+		rows:     make(map[string]*dashboardRow),
+	}
+}
+
+func (d *dashboard) Write(_ context.Context, m *message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.messages++
+	for _, ev := range m.events {
+		ts := m.received
+		if ev.Timestamp > 0 {
+			ts = time.Unix(0, ev.Timestamp)
+		}
+		target := m.Header.Get("Gnmi-Target")
+		if source := ev.Tags["source"]; source != "" {
+			target = source
+		}
+		name := ev.Tags["interface_name"]
+		key := target + "\x00" + name
+		row, ok := d.rows[key]
+		if !ok {
+			row = &dashboardRow{target: target, name: name, counters: make(map[string]*dashboardCounter)}
+			d.rows[key] = row
+		}
+		row.updated = m.received
+
+		for path, v := range ev.Values {
+			leaf := path[strings.LastIndex(path, "/")+1:]
+			if leaf == "oper-status" {
+				row.status = strings.Trim(valueText(v), `"`)
+				continue
+			}
+			if !strings.Contains(path, "/counters/") {
+				continue
+			}
+			f, ok := numericValue(v)
+			if !ok {
+				continue
+			}
+			c, ok := row.counters[leaf]
+			if !ok {
+				row.counters[leaf] = &dashboardCounter{value: f, time: ts}
+				continue
+			}
+			if dt := ts.Sub(c.time).Seconds(); dt > 0 {
+				// A counter going down was reset, so only the new value
+				// is known.
+				if f >= c.value {
+					c.rate, c.hasRate = (f-c.value)/dt, true
+				}
+				c.value, c.time = f, ts
+			}
+		}
+	}
+	return nil
+}
+
+func (d *dashboard) Close() error {
+	return nil
+}
+
+// run shows the dashboard until the user quits.
+func (d *dashboard) run() error {
+	_, err := tea.NewProgram(d, tea.WithAltScreen()).Run()
+	return err
+}
+
+type dashboardTick time.Time
+
+func (d *dashboard) tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return dashboardTick(t)
+	})
+}
+
+func (d *dashboard) Init() tea.Cmd {
+	d.lastTick = time.Now()
+	return d.tick()
+}
+
+func (d *dashboard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "ctrl+c", "esc":
+			return d, tea.Quit
+		case "up", "k":
+			d.scroll(-1)
+		case "down", "j":
+			d.scroll(1)
+		case "pgup":
+			d.scroll(-d.pageSize())
+		case "pgdown", " ":
+			d.scroll(d.pageSize())
+		case "home", "g":
+			d.offset = 0
+		}
+	case tea.WindowSizeMsg:
+		d.width, d.height = msg.Width, msg.Height
+		d.scroll(0)
+	case dashboardTick:
+		now := time.Time(msg)
+		d.mu.Lock()
+		messages := d.messages
+		d.mu.Unlock()
+		if dt := now.Sub(d.lastTick).Seconds(); dt > 0 {
+			d.msgRate = float64(messages-d.lastMessages) / dt
+		}
+		d.lastMessages, d.lastTick = messages, now
+		return d, d.tick()
+	}
+	return d, nil
+}
+
+// pageSize returns the number of table rows that fit on the screen, below
+// the status line and table header and above the help line.
+func (d *dashboard) pageSize() int {
+	if n := d.height - 4; n > 0 {
+		return n
+	}
+	return 1
+}
+
+// scroll moves the first row shown by n, keeping the table on the screen.
+func (d *dashboard) scroll(n int) {
+	d.mu.Lock()
+	rows := len(d.rows)
+	d.mu.Unlock()
+	d.offset += n
+	if last := rows - d.pageSize(); d.offset > last {
+		d.offset = last
+	}
+	if d.offset < 0 {
+		d.offset = 0
+	}
+}
+
+var (
+	dashboardTitle  = lipgloss.NewStyle().Bold(true).Reverse(true)
+	dashboardHeader = lipgloss.NewStyle().Bold(true).Underline(true)
+	dashboardUp     = lipgloss.NewStyle().Foreground(lipgloss.Color("2"))
+	dashboardDown   = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+	dashboardFaint  = lipgloss.NewStyle().Faint(true)
+)
+
+func (d *dashboard) View() string {
+	d.mu.Lock()
+	rows := make([]dashboardRow, 0, len(d.rows))
+	for _, r := range d.rows {
+		row := *r
+		row.counters = make(map[string]*dashboardCounter, len(r.counters))
+		for k, c := range r.counters {
+			cc := *c
+			row.counters[k] = &cc
+		}
+		rows = append(rows, row)
+	}
+	messages := d.messages
+	d.mu.Unlock()
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].target != rows[j].target {
+			return rows[i].target < rows[j].target
+		}
+		return lessInterface(rows[i].name, rows[j].name)
+	})
+
+	var b strings.Builder
+	title := fmt.Sprintf(" %s  %d messages  %.1f msg/s  up %s ",
+		strings.Join(d.subjects, ","), messages, d.msgRate, time.Since(d.started).Round(time.Second))
+	b.WriteString(dashboardTitle.Render(padRight(title, d.width)) + "\n")
+
+	const format = "%-20s %-16s %-8s %11s %11s %10s %10s %8s %8s %9s"
+	b.WriteString(dashboardHeader.Render(fmt.Sprintf(format,
+		"TARGET", "INTERFACE", "STATUS", "IN", "OUT", "IN PPS", "OUT PPS", "ERRORS", "DISCARDS", "UPDATED")) + "\n")
+
+	now := time.Now()
+	end := d.offset + d.pageSize()
+	if end > len(rows) {
+		end = len(rows)
+	}
+	for _, r := range rows[min(d.offset, len(rows)):end] {
+		name := r.name
+		if name == "" {
+			name = "-"
+		}
+		status := padRight(r.status, 8)
+		switch r.status {
+		case "UP":
+			status = dashboardUp.Render(status)
+		case "DOWN", "LOWER_LAYER_DOWN":
+			status = dashboardDown.Render(status)
+		case "":
+			status = padRight("-", 8)
+		}
+		line := fmt.Sprintf("%-20s %-16s %s %11s %11s %10s %10s %8s %8s %9s",
+			truncate(r.target, 20), truncate(name, 16), status,
+			formatBits(r.rate("in-octets")*8), formatBits(r.rate("out-octets")*8),
+			formatCount(r.rate("in-unicast-pkts")), formatCount(r.rate("out-unicast-pkts")),
+			formatCount(r.total("in-errors", "out-errors")), formatCount(r.total("in-discards", "out-discards")),
+			formatAge(now.Sub(r.updated)))
+		b.WriteString(line + "\n")
+	}
+	for i := end - d.offset; i < d.pageSize(); i++ {
+		b.WriteString("\n")
+	}
+	b.WriteString(dashboardFaint.Render(fmt.Sprintf("↑/↓ scroll  pgup/pgdown page  q quit    %d-%d of %d", min(d.offset+1, end), end, len(rows))))
+	return b.String()
+}
+
+// rate returns the rate per second of a counter, or -1 when it is unknown.
+func (r dashboardRow) rate(counter string) float64 {
+	c, ok := r.counters[counter]
+	if !ok || !c.hasRate {
+		return -1
+	}
+	return c.rate
+}
+
+// total returns the sum of the values of counters, or -1 when none is known.
+func (r dashboardRow) total(counters ...string) float64 {
+	total, known := 0.0, false
+	for _, name := range counters {
+		if c, ok := r.counters[name]; ok {
+			total += c.value
+			known = true
+		}
+	}
+	if !known {
+		return -1
+	}
+	return total
+}
+
+// lessInterface orders interface names with their numbers in numeric order,
+// so Ethernet2 comes before Ethernet10.
+func lessInterface(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := leadingDigits(a), leadingDigits(b)
+		if da != "" && db != "" {
+			if len(da) != len(db) {
+				return len(da) < len(db)
+			}
+			if da != db {
+				return da < db
+			}
+			a, b = a[len(da):], b[len(db):]
+			continue
+		}
+		if a[0] != b[0] {
+			return a[0] < b[0]
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func leadingDigits(s string) string {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	return s[:i]
+}
+
+// formatBits returns a rate in bits per second with an SI prefix.
+func formatBits(bps float64) string {
+	if bps < 0 {
+		return "-"
+	}
+	return formatSI(bps) + "b/s"
+}
+
+// formatCount returns n with an SI prefix, or - when it is negative.
+func formatCount(n float64) string {
+	if n < 0 {
+		return "-"
+	}
+	return formatSI(n)
+}
+
+func formatSI(n float64) string {
+	for _, unit := range []string{"", "k", "M", "G", "T"} {
+		if n < 1000 || unit == "T" {
+			if unit == "" {
+				return fmt.Sprintf("%.0f", n)
+			}
+			return fmt.Sprintf("%.1f%s", n, unit)
+		}
+		n /= 1000
+	}
+	return ""
+}
+
+func formatAge(d time.Duration) string {
+	if d < time.Second {
+		return "now"
+	}
+	return d.Round(time.Second).String() + " ago"
+}
+
+func truncate(s string, n int) string {
+	if len([]rune(s)) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func padRight(s string, n int) string {
+	if pad := n - len([]rune(s)); pad > 0 {
+		return s + strings.Repeat(" ", pad)
+	}
+	return s
+}
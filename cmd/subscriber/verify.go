@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"os"
+)
+
+// VerifyConfig checks the signatures the publisher adds with its signing
+// key against the ed25519 public keys in the PEM files PublicKeys. Messages
+// that are unsigned, or whose signature does not verify, are dropped in the
+// reject Mode (the default). In the flag mode they are handed on like any
+// other, and every message gets a Gnmi-Signature-Status header of valid,
+// invalid or unsigned for the outputs that keep headers.
+type VerifyConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	PublicKeys []string `yaml:"public_keys"`
+	Mode       string   `yaml:"mode"`
+}
+
+// Verification modes.
+const (
+	verifyReject = "reject"
+	verifyFlag   = "flag"
+)
+
+// Signature headers, and the status added in the flag mode.
+const (
+	signatureHeader       = "Gnmi-Signature"
+	signingKeyHeader      = "Gnmi-Signing-Key"
+	signatureStatusHeader = "Gnmi-Signature-Status"
+)
+
+func (v VerifyConfig) validate() error {
+	if !v.Enabled {
+		return nil
+	}
+	switch v.Mode {
+	case "", verifyReject, verifyFlag:
+	default:
+		return fmt.Errorf("verify: unknown mode %q", v.Mode)
+	}
+	if len(v.PublicKeys) == 0 {
+		return fmt.Errorf("verify: no public_keys")
+	}
+	_, err := loadPublicKeys(v.PublicKeys)
+	return err
+}
+
+// verifier checks message signatures against a set of trusted keys.
+type verifier struct {
+	keys map[string]ed25519.PublicKey
+	flag bool
+}
+
+func newVerifier(conf VerifyConfig) (*verifier, error) {
+	keys, err := loadPublicKeys(conf.PublicKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{keys: keys, flag: conf.Mode == verifyFlag}, nil
+}
+
+// loadPublicKeys reads the ed25519 public keys in files, by key ID.
+func loadPublicKeys(files []string) (map[string]ed25519.PublicKey, error) {
+	keys := make(map[string]ed25519.PublicKey, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("verify: %w", err)
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("verify: %s is not PEM encoded", file)
+		}
+		parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("verify: error parsing %s: %w", file, err)
+		}
+		pub, ok := parsed.(ed25519.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("verify: %s holds a %T, not an ed25519 key", file, parsed)
+		}
+		keys[keyID(pub)] = pub
+	}
+	return keys, nil
+}
+
+// keyID returns the ID the publisher sends along with signatures made with
+// the private key of pub: the first 8 bytes of its SHA-256 digest, hex
+// encoded.
+func keyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// check returns the signature status of msg: valid, invalid or unsigned.
+func (v *verifier) check(msg *nats.Msg) string {
+	sig := msg.Header.Get(signatureHeader)
+	if sig == "" {
+		return "unsigned"
+	}
+	pub, ok := v.keys[msg.Header.Get(signingKeyHeader)]
+	if !ok {
+		return "invalid"
+	}
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil || !ed25519.Verify(pub, msg.Data, raw) {
+		return "invalid"
+	}
+	return "valid"
+}
+
+// verify returns msg when its signature is valid. Otherwise it returns an
+// error in the reject mode, and msg with its status in the flag mode.
+func (v *verifier) verify(msg *nats.Msg) (*nats.Msg, error) {
+	status := v.check(msg)
+	if !v.flag {
+		if status != "valid" {
+			return nil, fmt.Errorf("%s message", status)
+		}
+		return msg, nil
+	}
+	header := nats.Header{}
+	for k, vs := range msg.Header {
+		header[k] = vs
+	}
+	header.Set(signatureStatusHeader, status)
+	return &nats.Msg{Subject: msg.Subject, Reply: msg.Reply, Header: header, Data: msg.Data}, nil
+}
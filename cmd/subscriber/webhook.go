@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// WebhookConfig sends every received event to an HTTP endpoint, such as an
+// ITSM system or custom automation. Only the values under one of Paths are
+// sent, or all of them when Paths is empty, and only events for which Filter
+// renders "true" when it is set. Body is a template rendering the request
+// body, the event as JSON by default. With Secret set, the body is signed
+// with HMAC-SHA256 in the X-Signature-256 header. Requests failing with a
+// network error, 429 or 5xx are retried with exponential backoff up to
+// MaxRetries times.
+type WebhookConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"`
+	Headers     map[string]string `yaml:"headers"`
+	Paths       []string          `yaml:"paths"`
+	Filter      string            `yaml:"filter"`
+	Body        string            `yaml:"body"`
+	ContentType string            `yaml:"content_type"`
+	Secret      string            `yaml:"secret"`
+	MaxRetries  int               `yaml:"max_retries"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	QueueSize   int               `yaml:"queue_size"`
+	TLS         natsopts.TLS      `yaml:"tls"`
+}
+
+const (
+	defaultWebhookMaxRetries = 3
+	defaultWebhookQueueSize  = 1000
+	webhookSignatureHeader   = "X-Signature-256"
+)
+
+func (w WebhookConfig) validate() error {
+	if !w.Enabled {
+		return nil
+	}
+	if _, err := url.ParseRequestURI(w.URL); err != nil {
+		return fmt.Errorf("webhook: invalid url %q: %w", w.URL, err)
+	}
+	if _, _, err := webhookTemplates(w); err != nil {
+		return err
+	}
+	if _, err := w.TLS.Config(); err != nil {
+		return fmt.Errorf("webhook: invalid TLS settings: %w", err)
+	}
+	return nil
+}
+
+// webhookEvent is the data the filter and body templates are executed with,
+// and the default body.
+type webhookEvent struct {
+	Subject      string                 `json:"subject"`
+	Target       string                 `json:"target"`
+	Subscription string                 `json:"subscription"`
+	Time         time.Time              `json:"timestamp"`
+	Tags         map[string]string      `json:"tags,omitempty"`
+	Values       map[string]interface{} `json:"values"`
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func webhookTemplates(conf WebhookConfig) (filter, body *template.Template, err error) {
+	if conf.Filter != "" {
+		if filter, err = template.New("filter").Funcs(webhookFuncs).Option("missingkey=zero").Parse(conf.Filter); err != nil {
+			return nil, nil, fmt.Errorf("webhook: invalid filter template: %w", err)
+		}
+	}
+	if conf.Body != "" {
+		if body, err = template.New("body").Funcs(webhookFuncs).Option("missingkey=zero").Parse(conf.Body); err != nil {
+			return nil, nil, fmt.Errorf("webhook: invalid body template: %w", err)
+		}
+	}
+	return filter, body, nil
+}
+
+// webhookOutput queues the rendered bodies and sends them one at a time, in
+// order, from a single goroutine.
+type webhookOutput struct {
+	conf   WebhookConfig
+	client *http.Client
+	filter *template.Template
+	body   *template.Template
+
+	queue   chan []byte
+	closing chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	dropped int
+}
+
+func newWebhookOutput(conf WebhookConfig) (*webhookOutput, error) {
+	o, err := newWebhookSender(conf)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("Forwarding telemetry to webhook", "url", conf.URL)
+	return o, nil
+}
+
+// newWebhookSender returns a webhookOutput that only sends what is queued
+// with enqueue, such as alert notifications.
+func newWebhookSender(conf WebhookConfig) (*webhookOutput, error) {
+	if conf.Method == "" {
+		conf.Method = http.MethodPost
+	}
+	if conf.ContentType == "" {
+		conf.ContentType = "application/json"
+	}
+	if conf.MaxRetries <= 0 {
+		conf.MaxRetries = defaultWebhookMaxRetries
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 10 * time.Second
+	}
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = defaultWebhookQueueSize
+	}
+	filter, body, err := webhookTemplates(conf)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf, err := conf.TLS.Config()
+	if err != nil {
+		return nil, fmt.Errorf("webhook: invalid TLS settings: %w", err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+
+	o := &webhookOutput{
+		conf:    conf,
+		client:  &http.Client{Transport: transport, Timeout: conf.Timeout},
+		filter:  filter,
+		body:    body,
+		queue:   make(chan []byte, conf.QueueSize),
+		closing: make(chan struct{}),
+	}
+	o.wg.Add(1)
+	go o.run()
+	return o, nil
+}
+
+// selected reports whether path is one of the configured paths.
+func (o *webhookOutput) selected(path string) bool {
+	if len(o.conf.Paths) == 0 {
+		return true
+	}
+	for _, p := range o.conf.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *webhookOutput) Write(_ context.Context, m *message) error {
+	for _, ev := range m.events {
+		e := webhookEvent{
+			Subject:      m.Subject,
+			Target:       m.Header.Get("Gnmi-Target"),
+			Subscription: ev.Name,
+			Time:         m.received.UTC(),
+			Tags:         ev.Tags,
+			Values:       make(map[string]interface{}, len(ev.Values)),
+		}
+		if ev.Timestamp > 0 {
+			e.Time = time.Unix(0, ev.Timestamp).UTC()
+		}
+		if source := ev.Tags["source"]; source != "" {
+			e.Target = source
+		}
+		if sub := ev.Tags["subscription-name"]; sub != "" {
+			e.Subscription = sub
+		}
+		for path, v := range ev.Values {
+			if o.selected(path) {
+				e.Values[path] = v
+			}
+		}
+		if len(e.Values) == 0 {
+			continue
+		}
+
+		if o.filter != nil {
+			var out strings.Builder
+			if err := o.filter.Execute(&out, e); err != nil {
+				return fmt.Errorf("could not render webhook filter: %w", err)
+			}
+			if strings.TrimSpace(out.String()) != "true" {
+				continue
+			}
+		}
+		body, err := o.render(e)
+		if err != nil {
+			return err
+		}
+		o.enqueue(body)
+	}
+	return nil
+}
+
+// enqueue queues body to be sent, dropping it when the queue is full.
+func (o *webhookOutput) enqueue(body []byte) {
+	select {
+	case o.queue <- body:
+	default:
+		o.mu.Lock()
+		o.dropped++
+		if o.dropped == 1 || o.dropped%100 == 0 {
+			slog.Warn("Webhook queue is full, dropping events", "url", o.conf.URL, "dropped", o.dropped)
+		}
+		o.mu.Unlock()
+	}
+}
+
+// render returns the request body for e.
+func (o *webhookOutput) render(e webhookEvent) ([]byte, error) {
+	if o.body == nil {
+		return json.Marshal(e)
+	}
+	var b bytes.Buffer
+	if err := o.body.Execute(&b, e); err != nil {
+		return nil, fmt.Errorf("could not render webhook body: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// run sends the queued bodies until the queue is closed.
+func (o *webhookOutput) run() {
+	defer o.wg.Done()
+	for body := range o.queue {
+		if err := o.send(body); err != nil {
+			slog.Error("Error sending webhook", "url", o.conf.URL, "error", err)
+		}
+	}
+}
+
+// send sends body, retrying with exponential backoff. Once the output is
+// closing, each body is only tried once.
+func (o *webhookOutput) send(body []byte) error {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := o.post(body)
+		if err == nil || !retry || attempt == o.conf.MaxRetries {
+			return err
+		}
+		slog.Debug("Retrying webhook", "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-o.closing:
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// post sends a request with body and reports whether a failure is worth
+// retrying.
+func (o *webhookOutput) post(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(o.conf.Method, o.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", o.conf.ContentType)
+	for k, v := range o.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	if o.conf.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(o.conf.Secret))
+		mac.Write(body)
+		req.Header.Set(webhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	rsp, err := o.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, rsp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+	retry = rsp.StatusCode == http.StatusTooManyRequests || rsp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook request failed: %s: %s", rsp.Status, bytes.TrimSpace(msg))
+}
+
+// Close sends the queued events, without retrying them.
+func (o *webhookOutput) Close() error {
+	close(o.closing)
+	close(o.queue)
+	o.wg.Wait()
+	return nil
+}
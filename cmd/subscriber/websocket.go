@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSocketConfig streams the received events as JSON to WebSocket clients,
+// such as browser dashboards, on Path at Address. Clients filter the stream
+// with the repeatable subject, path and target query parameters, e.g.
+// /ws?target=router1&path=/interfaces/. Browsers must be served from the
+// same host or from one of Origins; "*" allows any origin. Each client has a
+// buffer of BufferSize messages; messages are dropped for clients that fall
+// further behind.
+type WebSocketConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Address    string   `yaml:"address"`
+	Path       string   `yaml:"path"`
+	Origins    []string `yaml:"origins"`
+	BufferSize int      `yaml:"buffer_size"`
+}
+
+const (
+	defaultWebSocketPath       = "/ws"
+	defaultWebSocketBufferSize = 256
+
+	webSocketWriteTimeout = 10 * time.Second
+	webSocketPingInterval = 30 * time.Second
+)
+
+func (w WebSocketConfig) validate() error {
+	if !w.Enabled {
+		return nil
+	}
+	if w.Address == "" {
+		return fmt.Errorf("websocket: address is required")
+	}
+	if w.Path != "" && !strings.HasPrefix(w.Path, "/") {
+		return fmt.Errorf("websocket: path %q must start with /", w.Path)
+	}
+	return nil
+}
+
+// webSocketClient is a connection and the messages waiting to be sent on it.
+type webSocketClient struct {
+	streamFilter
+	conn     *websocket.Conn
+	messages chan []byte
+	dropped  int
+}
+
+// webSocketOutput is an HTTP server handing the received events to the
+// connected WebSocket clients.
+type webSocketOutput struct {
+	conf     WebSocketConfig
+	srv      *http.Server
+	upgrader websocket.Upgrader
+
+	mu      sync.Mutex
+	clients map[*webSocketClient]struct{}
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newWebSocketOutput(conf WebSocketConfig) (*webSocketOutput, error) {
+	if conf.Path == "" {
+		conf.Path = defaultWebSocketPath
+	}
+	if conf.BufferSize <= 0 {
+		conf.BufferSize = defaultWebSocketBufferSize
+	}
+	lis, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	o := &webSocketOutput{
+		conf:    conf,
+		clients: make(map[*webSocketClient]struct{}),
+		done:    make(chan struct{}),
+	}
+	if len(conf.Origins) > 0 {
+		o.upgrader.CheckOrigin = o.checkOrigin
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(conf.Path, o.serveWebSocket)
+	o.srv = &http.Server{Handler: mux}
+	go func() {
+		if err := o.srv.Serve(lis); err != nil && err != http.ErrServerClosed {
+			slog.Error("WebSocket server failed", "error", err)
+		}
+	}()
+	slog.Info("Serving telemetry over WebSocket", "address", lis.Addr().String(), "path", conf.Path)
+	return o, nil
+}
+
+// checkOrigin reports whether the request comes from one of the allowed
+// origins.
+func (o *webSocketOutput) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range o.conf.Origins {
+		if allowed == "*" || allowed == origin || allowed == u.Host {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *webSocketOutput) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := o.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has replied with the error.
+		return
+	}
+	q := r.URL.Query()
+	c := &webSocketClient{
+		streamFilter: streamFilter{subjects: q["subject"], paths: q["path"], targets: q["target"]},
+		conn:         conn,
+		messages:     make(chan []byte, o.conf.BufferSize),
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	select {
+	case <-o.done:
+		conn.Close()
+		return
+	default:
+	}
+	o.clients[c] = struct{}{}
+	o.wg.Add(1)
+	go o.send(c, r.RemoteAddr)
+	slog.Info("WebSocket client connected", "peer", r.RemoteAddr, "subjects", c.subjects, "paths", c.paths, "targets", c.targets)
+}
+
+// send writes the messages of c, and pings it, until the connection fails or
+// the output is closed. A goroutine reads the connection to handle control
+// frames and notice the client going away.
+func (o *webSocketOutput) send(c *webSocketClient, addr string) {
+	defer o.wg.Done()
+	defer func() {
+		o.mu.Lock()
+		delete(o.clients, c)
+		o.mu.Unlock()
+		c.conn.Close()
+		slog.Info("WebSocket client disconnected", "peer", addr)
+	}()
+
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := c.conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(webSocketPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-gone:
+			return
+		case <-o.done:
+			c.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "subscriber is shutting down"),
+				time.Now().Add(time.Second))
+			return
+		case <-ping.C:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout))
+		case msg := <-c.messages:
+			c.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			err = c.conn.WriteMessage(websocket.TextMessage, msg)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (o *webSocketOutput) Write(_ context.Context, m *message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.clients) == 0 {
+		return nil
+	}
+	for _, ev := range m.events {
+		e := webhookEvent{
+			Subject:      m.Subject,
+			Target:       m.Header.Get("Gnmi-Target"),
+			Subscription: ev.Name,
+			Time:         m.received.UTC(),
+			Tags:         ev.Tags,
+		}
+		if ev.Timestamp > 0 {
+			e.Time = time.Unix(0, ev.Timestamp).UTC()
+		}
+		if source := ev.Tags["source"]; source != "" {
+			e.Target = source
+		}
+		if sub := ev.Tags["subscription-name"]; sub != "" {
+			e.Subscription = sub
+		}
+
+		for c := range o.clients {
+			if !c.matches(m.Subject, e.Target) {
+				continue
+			}
+			e.Values = make(map[string]interface{}, len(ev.Values))
+			for path, v := range ev.Values {
+				if c.selected(path) {
+					e.Values[path] = v
+				}
+			}
+			if len(e.Values) == 0 {
+				continue
+			}
+			msg, err := json.Marshal(e)
+			if err != nil {
+				return fmt.Errorf("could not encode event: %w", err)
+			}
+			select {
+			case c.messages <- msg:
+			default:
+				c.dropped++
+				if c.dropped == 1 || c.dropped%1000 == 0 {
+					slog.Warn("WebSocket client is too slow, dropping events", "dropped", c.dropped)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Close closes the connections and stops the server.
+func (o *webSocketOutput) Close() error {
+	o.mu.Lock()
+	close(o.done)
+	o.mu.Unlock()
+	err := o.srv.Close()
+	o.wg.Wait()
+	return err
+}
@@ -0,0 +1,171 @@
+// Package cron parses cron-style schedules and works out when they next run.
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed schedule: either five cron fields (minute, hour,
+// day of month, month and day of week) or a fixed interval.
+type Schedule struct {
+	every time.Duration
+
+	minute, hour, dom, month, dow uint64
+	// A day matches when it matches both day fields if either of them is
+	// *, and when it matches either of them otherwise, as in cron.
+	domStar, dowStar bool
+}
+
+// descriptors are the shorthands for common schedules.
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses spec, which is five cron fields such as "*/15 * * * *", one
+// of the descriptors such as "@hourly", or "@every" followed by a duration
+// such as "@every 15m". Fields take values, ranges, steps and lists, such
+// as "1-5", "*/10" or "0,30"; Sunday is 0 or 7.
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		if every < time.Second {
+			return nil, fmt.Errorf("invalid schedule %q: interval must be at least 1s", spec)
+		}
+		return &Schedule{every: every}, nil
+	}
+	if strings.HasPrefix(spec, "@") {
+		expanded, ok := descriptors[spec]
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule %q: unknown descriptor", spec)
+		}
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: need 5 fields, got %d", spec, len(fields))
+	}
+	s := &Schedule{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+		name     string
+	}{
+		{&s.minute, 0, 59, "minute"},
+		{&s.hour, 0, 23, "hour"},
+		{&s.dom, 1, 31, "day of month"},
+		{&s.month, 1, 12, "month"},
+		{&s.dow, 0, 7, "day of week"},
+	} {
+		bits, err := parseField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s: %w", spec, f.name, err)
+		}
+		*f.bits = bits
+	}
+	// 7 is Sunday too.
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("invalid schedule %q: it never runs", spec)
+	}
+	return s, nil
+}
+
+// parseField returns the values of a cron field between min and max as a
+// bit set.
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			first, last, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(first); err != nil {
+				return 0, fmt.Errorf("invalid value %q", first)
+			}
+			switch {
+			case isRange:
+				if hi, err = strconv.Atoi(last); err != nil {
+					return 0, fmt.Errorf("invalid value %q", last)
+				}
+			case !hasStep:
+				// "5" is just 5, while "5/10" runs from 5 to max.
+				hi = lo
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	if bits == 0 {
+		return 0, errors.New("no values")
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t the schedule runs, in the location of
+// t. It returns the zero time if it does not run within five years.
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -0,0 +1,82 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	at := func(s string) time.Time {
+		t.Helper()
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		spec string
+		from string
+		want string
+	}{
+		{"* * * * *", "2024-01-03 10:07:30", "2024-01-03 10:08:00"},
+		{"*/15 * * * *", "2024-01-03 10:07:00", "2024-01-03 10:15:00"},
+		{"*/15 * * * *", "2024-01-03 10:45:00", "2024-01-03 11:00:00"},
+		{"5/20 * * * *", "2024-01-03 10:26:00", "2024-01-03 10:45:00"},
+		{"0,30 * * * *", "2024-01-03 10:07:00", "2024-01-03 10:30:00"},
+		{"0 9-17 * * 1-5", "2024-01-05 17:30:00", "2024-01-08 09:00:00"},
+		{"0 22 * * *", "2024-12-31 23:00:00", "2025-01-01 22:00:00"},
+		// 7 is Sunday, as is 0.
+		{"0 0 * * 7", "2024-01-03 10:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * 0", "2024-01-03 10:00:00", "2024-01-07 00:00:00"},
+		// With both day fields restricted, either of them matching will do.
+		{"0 0 13 * 5", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 0 13 * 5", "2024-01-12 00:00:00", "2024-01-13 00:00:00"},
+		// With either of them *, both must match.
+		{"0 0 13 * *", "2024-01-01 00:00:00", "2024-01-13 00:00:00"},
+		{"0 0 13 * */1", "2024-01-01 00:00:00", "2024-01-13 00:00:00"},
+		{"0 0 * * 5", "2024-01-12 00:00:00", "2024-01-19 00:00:00"},
+		{"0 0 29 2 *", "2024-03-01 00:00:00", "2028-02-29 00:00:00"},
+		{"@hourly", "2024-01-03 10:07:00", "2024-01-03 11:00:00"},
+		{"@weekly", "2024-01-03 10:07:00", "2024-01-07 00:00:00"},
+		{"@yearly", "2024-01-03 10:07:00", "2025-01-01 00:00:00"},
+		{"@every 90s", "2024-01-03 10:07:10", "2024-01-03 10:08:40"},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		if got, want := s.Next(at(tt.from)), at(tt.want); !got.Equal(want) {
+			t.Errorf("Parse(%q).Next(%s) = %s, want %s", tt.spec, tt.from, got, want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1,,2 * * * *",
+		"@fortnightly",
+		"@every 500ms",
+		"@every soon",
+		// These never run.
+		"0 0 30 2 *",
+		"0 0 31 4,6,9,11 *",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
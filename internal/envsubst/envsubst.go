@@ -0,0 +1,24 @@
+// Package envsubst expands ${VAR} references in configuration files.
+package envsubst
+
+import (
+	"os"
+	"regexp"
+)
+
+// ref matches ${VAR} and ${VAR:-default}. Bare $VAR is left alone so values
+// such as passwords can still contain a dollar sign.
+var ref = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// Expand replaces every ${VAR} in data with the value of the environment
+// variable VAR. ${VAR:-default} uses default when VAR is unset or empty, and
+// an unset variable without a default expands to the empty string.
+func Expand(data []byte) []byte {
+	return ref.ReplaceAllFunc(data, func(m []byte) []byte {
+		groups := ref.FindSubmatch(m)
+		if v := os.Getenv(string(groups[1])); v != "" {
+			return []byte(v)
+		}
+		return groups[2]
+	})
+}
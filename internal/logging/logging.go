@@ -0,0 +1,52 @@
+// Package logging sets up the structured slog logger shared by the publisher
+// and subscriber.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// New returns a logger writing to stderr at the given level ("debug",
+// "info", "warn" or "error") in the given format ("text" or "json").
+func New(level, format string) (*slog.Logger, error) {
+	return NewWriter(os.Stderr, level, format)
+}
+
+// NewWriter is like New but writes to w.
+func NewWriter(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format %q", format)
+}
+
+// Setup builds a logger with New and installs it as the slog default.
+func Setup(level, format string) error {
+	logger, err := New(level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// Fatal logs msg at error level and exits.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
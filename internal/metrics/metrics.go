@@ -0,0 +1,253 @@
+// Package metrics is a small Prometheus instrumentation library. It supports
+// labelled counters, gauges and histograms and serves them in the Prometheus
+// text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds a set of metrics and exposes them over HTTP.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*vec
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Default is the registry shared by the packages of the publisher pipeline,
+// so a program embedding them serves all of their metrics from one place.
+var Default = NewRegistry()
+
+// CounterVec is a counter partitioned by label values.
+type CounterVec struct{ v *vec }
+
+// GaugeVec is a gauge partitioned by label values.
+type GaugeVec struct{ v *vec }
+
+// HistogramVec is a histogram partitioned by label values.
+type HistogramVec struct{ v *vec }
+
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", nil, labels)}
+}
+
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", nil, labels)}
+}
+
+// NewHistogramVec registers a histogram with the given upper bucket bounds,
+// which must be sorted in increasing order.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.register(name, help, "histogram", buckets, labels)}
+}
+
+func (r *Registry) register(name, help, typ string, buckets []float64, labels []string) *vec {
+	v := &vec{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+	r.mu.Lock()
+	r.metrics = append(r.metrics, v)
+	r.mu.Unlock()
+	return v
+}
+
+// Inc adds one to the counter for the label values.
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta, which must not be negative, to the counter for the label
+// values.
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.v.update(labelValues, func(s *series) { s.value += delta })
+}
+
+// Total returns the sum of the counter over every series whose leading
+// label values are prefix, e.g. every subject of one target.
+func (c *CounterVec) Total(prefix ...string) float64 {
+	return c.v.total(prefix)
+}
+
+// Set sets the gauge for the label values.
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.v.update(labelValues, func(s *series) { s.value = value })
+}
+
+// Add adds delta to the gauge for the label values.
+func (g *GaugeVec) Add(delta float64, labelValues ...string) {
+	g.v.update(labelValues, func(s *series) { s.value += delta })
+}
+
+// Delete removes the series for the label values.
+func (g *GaugeVec) Delete(labelValues ...string) {
+	g.v.mu.Lock()
+	delete(g.v.series, seriesKey(labelValues))
+	g.v.mu.Unlock()
+}
+
+// Observe records value in the histogram for the label values.
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.v.update(labelValues, func(s *series) {
+		if s.counts == nil {
+			s.counts = make([]uint64, len(h.v.buckets))
+		}
+		for i, bound := range h.v.buckets {
+			if value <= bound {
+				s.counts[i]++
+			}
+		}
+		s.value += value
+		s.count++
+	})
+}
+
+type vec struct {
+	name    string
+	help    string
+	typ     string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64 // counter/gauge value, or the histogram sum
+	count       uint64
+	counts      []uint64
+}
+
+func seriesKey(labelValues []string) string {
+	return strings.Join(labelValues, "\xff")
+}
+
+func (v *vec) update(labelValues []string, fn func(*series)) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := seriesKey(labelValues)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	fn(s)
+}
+
+func (v *vec) total(prefix []string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var sum float64
+	for _, s := range v.series {
+		if hasPrefix(s.labelValues, prefix) {
+			sum += s.value
+		}
+	}
+	return sum
+}
+
+func hasPrefix(labelValues, prefix []string) bool {
+	if len(prefix) > len(labelValues) {
+		return false
+	}
+	for i, p := range prefix {
+		if labelValues[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeHTTP writes every metric in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// WriteTo writes every metric in the Prometheus text format to w.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*vec(nil), r.metrics...)
+	r.mu.Unlock()
+
+	var b strings.Builder
+	for _, v := range metrics {
+		v.write(&b)
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (v *vec) write(b *strings.Builder) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(b, "# TYPE %s %s\n", v.name, v.typ)
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.series[k]
+		if v.typ != "histogram" {
+			fmt.Fprintf(b, "%s%s %s\n", v.name, labelString(v.labels, s.labelValues, "", ""), formatFloat(s.value))
+			continue
+		}
+		for i, bound := range v.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", v.name, labelString(v.labels, s.labelValues, "le", formatFloat(bound)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", v.name, labelString(v.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", v.name, labelString(v.labels, s.labelValues, "", ""), formatFloat(s.value))
+		fmt.Fprintf(b, "%s_count%s %d\n", v.name, labelString(v.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelString(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelEscaper.Replace(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,57 @@
+// Package natsopts holds the NATS connection settings shared by the
+// publisher and subscriber and turns them into nats.Option values.
+package natsopts
+
+import (
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"os"
+)
+
+// Auth holds the credentials used to connect to a secured NATS server. Only
+// one method is used, in order of preference: creds file, NKey seed, token,
+// then username and password.
+type Auth struct {
+	User         string `yaml:"user"`
+	Password     string `yaml:"password"`
+	Token        string `yaml:"token"`
+	NKeySeedFile string `yaml:"nkey_seed_file"`
+	CredsFile    string `yaml:"creds_file"`
+}
+
+// ApplyEnv overrides the configured values with any of NATS_USER,
+// NATS_PASSWORD, NATS_TOKEN, NATS_NKEY_SEED_FILE and NATS_CREDS_FILE that
+// are set in the environment.
+func (a *Auth) ApplyEnv() {
+	setFromEnv(&a.User, "NATS_USER")
+	setFromEnv(&a.Password, "NATS_PASSWORD")
+	setFromEnv(&a.Token, "NATS_TOKEN")
+	setFromEnv(&a.NKeySeedFile, "NATS_NKEY_SEED_FILE")
+	setFromEnv(&a.CredsFile, "NATS_CREDS_FILE")
+}
+
+// Options returns the nats.Option needed to authenticate, or nil when no
+// credentials are configured.
+func (a Auth) Options() ([]nats.Option, error) {
+	switch {
+	case a.CredsFile != "":
+		return []nats.Option{nats.UserCredentials(a.CredsFile)}, nil
+	case a.NKeySeedFile != "":
+		opt, err := nats.NkeyOptionFromSeed(a.NKeySeedFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading NKey seed: %v", err)
+		}
+		return []nats.Option{opt}, nil
+	case a.Token != "":
+		return []nats.Option{nats.Token(a.Token)}, nil
+	case a.User != "":
+		return []nats.Option{nats.UserInfo(a.User, a.Password)}, nil
+	}
+	return nil, nil
+}
+
+func setFromEnv(dst *string, key string) {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		*dst = v
+	}
+}
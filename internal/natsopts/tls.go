@@ -0,0 +1,81 @@
+package natsopts
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"os"
+	"strconv"
+)
+
+// TLS holds the settings for an encrypted NATS connection. TLS is used when
+// Enabled is set or any of the files are configured.
+type TLS struct {
+	Enabled            bool   `yaml:"enabled"`
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// ApplyEnv overrides the configured values with any of NATS_TLS_CA_FILE,
+// NATS_TLS_CERT_FILE, NATS_TLS_KEY_FILE and NATS_TLS_INSECURE that are set in
+// the environment.
+func (t *TLS) ApplyEnv() {
+	setFromEnv(&t.CAFile, "NATS_TLS_CA_FILE")
+	setFromEnv(&t.CertFile, "NATS_TLS_CERT_FILE")
+	setFromEnv(&t.KeyFile, "NATS_TLS_KEY_FILE")
+	if v, err := strconv.ParseBool(os.Getenv("NATS_TLS_INSECURE")); err == nil {
+		t.InsecureSkipVerify = v
+	}
+}
+
+func (t TLS) enabled() bool {
+	return t.Enabled || t.CAFile != "" || t.CertFile != "" || t.InsecureSkipVerify
+}
+
+// Options returns the nats.Option enabling TLS, or nil when TLS is not
+// configured.
+func (t TLS) Options() ([]nats.Option, error) {
+	tlsConf, err := t.Config()
+	if err != nil || tlsConf == nil {
+		return nil, err
+	}
+	return []nats.Option{nats.Secure(tlsConf)}, nil
+}
+
+// Config returns the client TLS configuration, or nil when TLS is not
+// configured. It is also used for the connections of subscriber outputs.
+func (t TLS) Config() (*tls.Config, error) {
+	if !t.enabled() {
+		return nil, nil
+	}
+
+	tlsConf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", t.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %v", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
@@ -0,0 +1,223 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxQueued     = 4096
+	maxBatch      = 512
+	exportTimeout = 10 * time.Second
+	flushInterval = 5 * time.Second
+)
+
+// exporter posts finished spans to an OTLP/HTTP receiver in batches, using
+// the JSON encoding of the protocol. Spans are dropped when the receiver
+// cannot keep up, rather than slowing down the pipeline being traced.
+type exporter struct {
+	url      string
+	headers  map[string]string
+	resource otlpResource
+	client   *http.Client
+
+	spans chan otlpSpan
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func newExporter(url string, headers map[string]string, service string) *exporter {
+	e := &exporter{
+		url:     url,
+		headers: headers,
+		resource: otlpResource{Attributes: []otlpAttr{
+			attr(String("service.name", service)),
+		}},
+		client: &http.Client{Timeout: exportTimeout},
+		spans:  make(chan otlpSpan, maxQueued),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *exporter) add(s *Span, end time.Time) {
+	span := otlpSpan{
+		TraceID:   hex.EncodeToString(s.sc.TraceID[:]),
+		SpanID:    hex.EncodeToString(s.sc.SpanID[:]),
+		Name:      s.name,
+		Kind:      int(s.kind),
+		StartTime: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTime:   strconv.FormatInt(end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	s.mu.Lock()
+	for _, a := range s.attrs {
+		span.Attributes = append(span.Attributes, attr(a))
+	}
+	if s.errMsg != "" {
+		span.Status = &otlpStatus{Code: 2, Message: s.errMsg}
+	}
+	s.mu.Unlock()
+
+	select {
+	case e.spans <- span:
+	default:
+		slog.Debug("Dropped span, export queue is full", "span", s.name)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []otlpSpan
+	for {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+			if len(batch) < maxBatch {
+				continue
+			}
+		case <-ticker.C:
+		case <-e.stop:
+			// Export whatever is still queued before returning.
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			e.export(batch)
+			return
+		}
+		e.export(batch)
+		batch = nil
+	}
+}
+
+func (e *exporter) shutdown(ctx context.Context) error {
+	close(e.stop)
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *exporter) export(spans []otlpSpan) {
+	if len(spans) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: e.resource,
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: "github.com/gwoodwa1/nats-gnmi-example"},
+			Spans: spans,
+		}},
+	}}})
+	if err != nil {
+		slog.Warn("Could not encode spans", "error", err)
+		return
+	}
+	if err := e.post(body); err != nil {
+		slog.Warn("Could not export spans", "url", e.url, "spans", len(spans), "error", err)
+	}
+}
+
+func (e *exporter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	rsp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return fmt.Errorf("%s: %s", rsp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// The OTLP/HTTP JSON request body. Trace and span IDs are hex encoded and
+// 64-bit integers are strings, as the protocol specifies.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttr `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string      `json:"traceId"`
+	SpanID       string      `json:"spanId"`
+	ParentSpanID string      `json:"parentSpanId,omitempty"`
+	Name         string      `json:"name"`
+	Kind         int         `json:"kind"`
+	StartTime    string      `json:"startTimeUnixNano"`
+	EndTime      string      `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr  `json:"attributes,omitempty"`
+	Status       *otlpStatus `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+// attr encodes a as an OTLP attribute.
+func attr(a Attr) otlpAttr {
+	var v map[string]interface{}
+	switch x := a.Value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": x}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.Itoa(x)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": x}
+	case bool:
+		v = map[string]interface{}{"boolValue": x}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(x)}
+	}
+	return otlpAttr{Key: a.Key, Value: v}
+}
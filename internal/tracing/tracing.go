@@ -0,0 +1,319 @@
+// Package tracing records OpenTelemetry compatible spans and exports them to
+// an OTLP/HTTP collector. Trace context travels between the publisher and
+// subscriber in the W3C traceparent header of each NATS message, so an update
+// can be followed from gNMI through to the subscriber.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is the message header that carries the trace context.
+const Header = "traceparent"
+
+// Config enables tracing when Endpoint is set. The OTEL_EXPORTER_OTLP_ENDPOINT,
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT and OTEL_SERVICE_NAME environment
+// variables take precedence, as in the OpenTelemetry SDKs.
+type Config struct {
+	// Endpoint is the base URL of the OTLP/HTTP receiver, e.g.
+	// "http://localhost:4318". Spans are posted to /v1/traces under it.
+	Endpoint string `yaml:"endpoint"`
+	// TracesEndpoint is the full URL to post spans to, when the receiver
+	// does not use the standard path.
+	TracesEndpoint string            `yaml:"traces_endpoint"`
+	ServiceName    string            `yaml:"service_name"`
+	Headers        map[string]string `yaml:"headers"`
+	// SampleRatio is the fraction of new traces that are recorded, from 0
+	// to 1. It defaults to 1. Traces started upstream follow the sampling
+	// decision in their traceparent.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// ApplyEnv overrides the settings with the standard OpenTelemetry
+// environment variables.
+func (c *Config) ApplyEnv() {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); v != "" {
+		c.Endpoint = v
+	}
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); v != "" {
+		c.TracesEndpoint = v
+	}
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		c.ServiceName = v
+	}
+}
+
+func (c Config) enabled() bool {
+	return c.Endpoint != "" || c.TracesEndpoint != ""
+}
+
+func (c Config) url() string {
+	if c.TracesEndpoint != "" {
+		return c.TracesEndpoint
+	}
+	return strings.TrimSuffix(c.Endpoint, "/") + "/v1/traces"
+}
+
+// Validate reports invalid settings.
+func (c Config) Validate() error {
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("tracing sample_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// Tracer starts spans and exports them once they end. A nil Tracer is valid
+// and records nothing, so callers need not check whether tracing is enabled.
+type Tracer struct {
+	service   string
+	threshold uint64
+	exp       *exporter
+}
+
+// New returns a Tracer exporting to the configured endpoint, or nil when
+// tracing is not enabled. defaultService names the process when no service
+// name is configured.
+func New(conf Config, defaultService string) *Tracer {
+	if !conf.enabled() {
+		return nil
+	}
+	service := conf.ServiceName
+	if service == "" {
+		service = defaultService
+	}
+	ratio := conf.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	threshold := uint64(math.MaxUint64)
+	if ratio < 1 {
+		threshold = uint64(ratio * math.MaxUint64)
+	}
+	return &Tracer{
+		service:   service,
+		threshold: threshold,
+		exp:       newExporter(conf.url(), conf.Headers, service),
+	}
+}
+
+// Shutdown exports the spans that have ended and stops the exporter.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.exp.shutdown(ctx)
+}
+
+// SpanContext identifies a span and carries its sampling decision.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether sc identifies a span.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(value string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx in which sc is the parent of
+// new spans. ctx is returned unchanged when sc is not valid.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	if !sc.IsValid() {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFromContext returns the current span context of ctx.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(contextKey{}).(SpanContext)
+	return sc
+}
+
+// Extract returns a copy of ctx continuing the trace in a traceparent header
+// value. ctx is returned unchanged when the value is empty or invalid.
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	sc, ok := ParseTraceparent(traceparent)
+	if !ok {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Traceparent returns the traceparent header value for the current span of
+// ctx, or "" when there is none.
+func Traceparent(ctx context.Context) string {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.Traceparent()
+}
+
+// Kind is the OTLP span kind.
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+	KindProducer Kind = 4
+	KindConsumer Kind = 5
+)
+
+// Attr is a span attribute. Values are strings, integers, floats or bools.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// String returns a string attribute.
+func String(key, value string) Attr { return Attr{key, value} }
+
+// Int returns an integer attribute.
+func Int(key string, value int64) Attr { return Attr{key, value} }
+
+// Span is an operation in a trace. A nil Span is valid and records nothing.
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	parent [8]byte
+	name   string
+	kind   Kind
+	start  time.Time
+
+	mu     sync.Mutex
+	attrs  []Attr
+	errMsg string
+	ended  bool
+}
+
+// Start begins a span that is a child of the current span of ctx, or the
+// root of a new trace, and returns a context in which it is current.
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind, attrs ...Attr) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if parent.IsValid() {
+		s.sc.TraceID = parent.TraceID
+		s.sc.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		randomBytes(s.sc.TraceID[:])
+		s.sc.Sampled = t.sample(s.sc.TraceID)
+	}
+	randomBytes(s.sc.SpanID[:])
+	return ContextWithSpanContext(ctx, s.sc), s
+}
+
+// sample decides from the trace ID whether a new trace is recorded, so every
+// process makes the same decision for the same trace.
+func (t *Tracer) sample(id [16]byte) bool {
+	if t.threshold == math.MaxUint64 {
+		return true
+	}
+	var v uint64
+	for _, b := range id[8:] {
+		v = v<<8 | uint64(b)
+	}
+	return v < t.threshold
+}
+
+func randomBytes(b []byte) {
+	// crypto/rand does not fail on supported platforms.
+	_, _ = rand.Read(b)
+}
+
+// Context returns the span context of s.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttributes adds attributes to s.
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, attrs...)
+	s.mu.Unlock()
+}
+
+// RecordError marks s as failed with err. A nil err is ignored.
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errMsg = err.Error()
+	s.mu.Unlock()
+}
+
+// End completes s and queues it for export if its trace is sampled. Calls
+// after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.exp.add(s, end)
+	}
+}
@@ -0,0 +1,45 @@
+package collector
+
+import (
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"math/rand"
+	"time"
+)
+
+// backoff produces exponentially growing, jittered delays.
+type backoff struct {
+	conf    config.BackoffConfig
+	current time.Duration
+}
+
+func newBackoff(conf config.BackoffConfig) *backoff {
+	if conf.InitialInterval <= 0 {
+		conf.InitialInterval = time.Second
+	}
+	if conf.MaxInterval <= 0 {
+		conf.MaxInterval = time.Minute
+	}
+	if conf.Multiplier < 1 {
+		conf.Multiplier = 2
+	}
+	return &backoff{conf: conf, current: conf.InitialInterval}
+}
+
+// next returns the delay before the next attempt and grows the interval for
+// the one after. The delay is picked at random between half and all of the
+// current interval so that targets failing together do not retry in step.
+func (b *backoff) next() time.Duration {
+	d := b.current
+	b.current = time.Duration(float64(b.current) * b.conf.Multiplier)
+	if b.current > b.conf.MaxInterval {
+		b.current = b.conf.MaxInterval
+	}
+
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// reset starts over from the initial interval.
+func (b *backoff) reset() {
+	b.current = b.conf.InitialInterval
+}
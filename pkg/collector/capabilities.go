@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"context"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/formatters"
+)
+
+// checkCapabilities calls gNMI Capabilities after connecting, when the
+// capabilities are published or needed to choose the encodings of the
+// subscriptions. Failures are logged and do not end the session, as
+// telemetry can still be collected without them.
+func (tt *Target) checkCapabilities(ctx context.Context) {
+	if tt.capabilitiesSubject == "" && len(tt.Config.EncodingFallback) == 0 {
+		return
+	}
+	capCtx, cancel := context.WithTimeout(ctx, tt.capabilitiesTimeout)
+	defer cancel()
+	capRsp, err := tt.Target.Capabilities(capCtx)
+	if err != nil {
+		tt.logger.Warn("Could not get capabilities", "error", err)
+		return
+	}
+	tt.setSupportedEncodings(capRsp.SupportedEncodings)
+	if tt.capabilitiesSubject != "" {
+		tt.publishCapabilities(capCtx, capRsp)
+	}
+}
+
+// publishCapabilities publishes the supported models and encodings of the
+// device as JSON on tt.capabilitiesSubject.
+func (tt *Target) publishCapabilities(ctx context.Context, capRsp *gnmi.CapabilityResponse) {
+	logger := tt.logger.With("subject", tt.capabilitiesSubject)
+
+	options := &formatters.MarshalOptions{Multiline: true, Indent: " "}
+	payload, err := options.Marshal(capRsp, map[string]string{"source": tt.Config.Name})
+	if err != nil {
+		logger.Error("Error serializing capabilities", "error", err)
+		return
+	}
+
+	meta := map[string]string{
+		"Content-Type": config.ContentType(config.FormatJSON),
+		"Gnmi-Target":  tt.Config.Name,
+	}
+	if tt.instanceID != "" {
+		meta["Collector-Id"] = tt.instanceID
+	}
+	if err := tt.Sink.Publish(ctx, tt.capabilitiesSubject, payload, meta); err != nil {
+		logger.Error("Error publishing capabilities", "error", err)
+		return
+	}
+	logger.Info("Published capabilities", "models", len(capRsp.SupportedModels), "gnmi_version", capRsp.GNMIVersion)
+}
@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/utils"
+	"google.golang.org/protobuf/proto"
+	"sync"
+)
+
+// changeFilter remembers the last value published for every path so that
+// samples which did not change can be dropped. It stands in for
+// suppress_redundant on devices that do not support it.
+type changeFilter struct {
+	mu   sync.Mutex
+	last map[string]*gnmi.TypedValue
+}
+
+func newChangeFilter() *changeFilter {
+	return &changeFilter{last: make(map[string]*gnmi.TypedValue)}
+}
+
+// filter removes the updates of n whose value is the same as the last one
+// seen for that path on the subscription, and reports how many were removed.
+// Deleted paths are forgotten so they are published again if they return.
+func (f *changeFilter) filter(subscription string, n *gnmi.Notification) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	prefix := subscription + "|" + utils.GnmiPathToXPath(n.GetPrefix(), false) + "|"
+	for _, del := range n.GetDelete() {
+		delete(f.last, prefix+utils.GnmiPathToXPath(del, false))
+	}
+
+	changed := n.Update[:0]
+	for _, upd := range n.GetUpdate() {
+		key := prefix + utils.GnmiPathToXPath(upd.GetPath(), false)
+		if last, ok := f.last[key]; ok && proto.Equal(last, upd.GetVal()) {
+			continue
+		}
+		f.last[key] = upd.GetVal()
+		changed = append(changed, upd)
+	}
+	suppressed := len(n.GetUpdate()) - len(changed)
+	n.Update = changed
+	return suppressed
+}
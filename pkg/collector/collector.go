@@ -0,0 +1,274 @@
+// Package collector subscribes to gNMI targets and publishes their
+// telemetry through a sink.Sink. It is the pipeline behind the publisher
+// command, for programs that want to embed it.
+package collector
+
+import (
+	"context"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
+	"github.com/nats-io/nats.go"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/grpctunnel/tunnel"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Collector runs a Target per configured device and reconciles the
+// running targets when the configuration changes.
+type Collector struct {
+	ctx          context.Context
+	nats         *sink.NATS
+	sink         sink.Sink
+	username     string
+	password     string
+	getProxy     config.ServiceConfig
+	setRelay     config.ServiceConfig
+	capabilities config.ServiceConfig
+	instanceID   string
+	deduplicate  bool
+
+	selfTelemetry config.SelfTelemetryConfig
+	// Recorder, when set before the first Apply, makes targets write their
+	// responses to it instead of publishing them.
+	Recorder Recorder
+	// Snapshots, when set before the first Apply, stores the large initial
+	// syncs of the targets.
+	Snapshots *sink.SnapshotStore
+
+	mu      sync.Mutex
+	targets map[string]*runningTarget
+	wg      sync.WaitGroup
+	// applied is the last set of targets passed to Apply. They only run
+	// while the collector is not on standby.
+	applied []config.TargetConfig
+	standby bool
+	// paused is shared with every target; while it is set, their responses
+	// are dropped instead of published.
+	paused atomic.Bool
+	// tunnel is the gRPC tunnel server of the dial-out targets, and
+	// registered holds the targets whose devices are connected to it.
+	tunnel     *tunnel.Server
+	tunnelType string
+	registered map[string]bool
+}
+
+// Recorder receives the raw SubscribeResponses of every target, instead of
+// them being published, when set on a Collector.
+type Recorder interface {
+	Record(target, subscription string, received time.Time, rsp *gnmi.SubscribeResponse) error
+}
+
+type runningTarget struct {
+	tt     *Target
+	cancel context.CancelFunc
+	subs   []*nats.Subscription
+	once   sync.Once
+}
+
+// close stops collection and request handling for the target. It is safe to
+// call more than once.
+func (rt *runningTarget) close() {
+	rt.once.Do(func() {
+		rt.cancel()
+		for _, sub := range rt.subs {
+			if err := sub.Unsubscribe(); err != nil {
+				rt.tt.logger.Debug("Error unsubscribing", "subject", sub.Subject, "error", err)
+			}
+		}
+	})
+}
+
+// New returns a Collector for the targets of conf that publishes to out. nc
+// is used by the NATS request services and may be nil when none is enabled.
+// The services in conf are only read here, so they do not change on reload.
+func New(ctx context.Context, nc *sink.NATS, out sink.Sink, username, password string, conf config.Config) *Collector {
+	return &Collector{
+		ctx:          ctx,
+		nats:         nc,
+		sink:         out,
+		username:     username,
+		password:     password,
+		getProxy:     conf.GetProxy,
+		setRelay:     conf.SetRelay,
+		capabilities: conf.Capabilities,
+		instanceID:   conf.CollectorID(),
+		deduplicate:  conf.JetStream.Enabled && conf.JetStream.Deduplicate,
+		targets:      make(map[string]*runningTarget),
+
+		selfTelemetry: conf.SelfTelemetry,
+	}
+}
+
+// Apply starts new targets, stops removed ones and updates the rest. Targets
+// whose connection settings changed are restarted; when only subscriptions
+// changed, just those subscriptions are restarted. On standby the targets
+// are only remembered, to be started when the collector becomes the leader.
+func (c *Collector) Apply(confs []config.TargetConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.applied = confs
+	if c.standby {
+		return
+	}
+	c.reconcile(confs)
+}
+
+// setStandby stops every target when standby is set, and starts the applied
+// ones when it is cleared.
+func (c *Collector) setStandby(standby bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.standby = standby
+	if standby {
+		c.reconcile(nil)
+		return
+	}
+	c.reconcile(c.applied)
+}
+
+// reconcile brings the running targets in line with confs. c.mu must be
+// held.
+func (c *Collector) reconcile(confs []config.TargetConfig) {
+	wanted := make(map[string]config.TargetConfig, len(confs))
+	for _, tc := range confs {
+		if c.awaitingTunnel(tc) {
+			// Started once the device connects to the tunnel server.
+			continue
+		}
+		if _, ok := wanted[tc.Name]; ok {
+			slog.Warn("Ignoring duplicate target", "target", tc.Name)
+			continue
+		}
+		wanted[tc.Name] = tc
+	}
+
+	for name, rt := range c.targets {
+		tc, ok := wanted[name]
+		if !ok || !sameConnection(rt.tt.Config, tc) {
+			c.stop(name)
+			continue
+		}
+		if err := rt.tt.SetSubscriptions(tc.SubscriptionConfigs()); err != nil {
+			rt.tt.logger.Error("Could not update subscriptions", "error", err)
+		}
+	}
+
+	for _, tc := range confs {
+		if _, ok := c.targets[tc.Name]; !ok && !c.awaitingTunnel(tc) {
+			c.start(tc)
+		}
+	}
+}
+
+// start launches collection from tc. c.mu must be held.
+func (c *Collector) start(tc config.TargetConfig) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	tt, err := NewTarget(ctx, tc, c.sink, c.username, c.password)
+	if err != nil {
+		cancel()
+		slog.Error("Failed to create telemetry target", "target", tc.Name, "error", err)
+		return
+	}
+
+	tt.instanceID = c.instanceID
+	tt.deduplicate = c.deduplicate
+	tt.recorder = c.Recorder
+	tt.snapshots = c.Snapshots
+	tt.paused = &c.paused
+	if tc.Tunnel {
+		tt.tunnel, tt.tunnelType = c.tunnel, c.tunnelType
+	}
+	tt.capabilitiesTimeout = c.capabilities.RequestTimeout()
+	if c.capabilities.Enabled {
+		tt.capabilitiesSubject = c.capabilities.SubjectFor("meta.capabilities", tc.Name)
+	}
+
+	rt := &runningTarget{tt: tt, cancel: cancel}
+	if c.getProxy.Enabled {
+		c.serve(rt, c.getProxy.SubjectFor("gnmi.get", tc.Name), tt.serveGet(c.getProxy.RequestTimeout()))
+	}
+	if c.setRelay.Enabled {
+		c.serve(rt, c.setRelay.SubjectFor("gnmi.set", tc.Name), tt.serveSet(c.setRelay.RequestTimeout()))
+	}
+	c.targets[tc.Name] = rt
+	tt.logger.Info("Started target", "address", tc.Address)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if err := collectTelemetry(ctx, tt); err != nil {
+			tt.logger.Error("Telemetry collection failed", "error", err)
+		}
+
+		// Forget the target so the next reload can start it again.
+		c.mu.Lock()
+		if c.targets[tc.Name] == rt {
+			delete(c.targets, tc.Name)
+		}
+		c.mu.Unlock()
+		rt.close()
+	}()
+}
+
+// serve answers requests on subject with handler until rt is closed.
+func (c *Collector) serve(rt *runningTarget, subject string, handler nats.MsgHandler) {
+	sub, err := c.nats.Subscribe(subject, handler)
+	if err != nil {
+		rt.tt.logger.Error("Could not serve requests", "error", err)
+		return
+	}
+	rt.subs = append(rt.subs, sub)
+	rt.tt.logger.Info("Serving requests", "subject", subject)
+}
+
+// stop cancels collection from the named target. c.mu must be held.
+func (c *Collector) stop(name string) {
+	rt, ok := c.targets[name]
+	if !ok {
+		return
+	}
+	rt.close()
+	delete(c.targets, name)
+	rt.tt.logger.Info("Stopped target")
+}
+
+// Wait blocks until every target has stopped.
+func (c *Collector) Wait() {
+	c.wg.Wait()
+}
+
+// ReloadOnSIGHUP reloads the configuration with load whenever the process
+// receives SIGHUP and applies the new target list.
+func (c *Collector) ReloadOnSIGHUP(load func() (config.Config, error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-hup:
+			conf, err := load()
+			if err != nil {
+				slog.Error("Could not reload config", "error", err)
+				continue
+			}
+			slog.Info("Reloading config")
+			c.Apply(conf.TargetConfigs())
+		}
+	}
+}
+
+// sameConnection reports whether a and b differ only in subscription
+// settings, which can be changed without reconnecting to the device.
+func sameConnection(a, b config.TargetConfig) bool {
+	return reflect.DeepEqual(a.ConnectionConfig(), b.ConnectionConfig())
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
+	"log/slog"
+)
+
+// LoadConfig reads the configuration file like config.Read, and adds the
+// targets defined in the inventory.
+func LoadConfig(filename string) (config.Config, error) {
+	conf, err := config.Read(filename)
+	if err != nil {
+		return config.Config{}, err
+	}
+	if conf.Inventory.Enabled() {
+		targets, err := readInventory(conf.Inventory)
+		if err != nil {
+			return config.Config{}, err
+		}
+		conf.Targets = append(conf.Targets, targets...)
+	}
+	return conf, nil
+}
+
+// Validate checks conf for errors that would otherwise only show up once
+// collection starts. On top of conf.Validate it builds the stream, event
+// processors and subscribe requests the pipeline would use.
+func Validate(conf config.Config) error {
+	errs := []error{conf.Validate()}
+	if _, err := sink.StreamConfig(conf.JetStream, conf.Subjects()); conf.JetStream.Enabled && err != nil {
+		errs = append(errs, err)
+	}
+	for _, t := range conf.TargetConfigs() {
+		if _, err := newEventProcessors(t.Processors, t.EventProcessors, slog.Default()); err != nil {
+			errs = append(errs, fmt.Errorf("target %q: %w", t.Name, err))
+		}
+		for _, sc := range t.SubscriptionConfigs() {
+			if _, err := newSubscribeRequest(sc); err != nil {
+				errs = append(errs, fmt.Errorf("target %q: subscription %q: %w", t.Name, sc.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	consul "github.com/hashicorp/consul/api"
+	"strconv"
+	"time"
+)
+
+// consulInventory reads targets from a Consul KV prefix and watches it with
+// blocking queries. The address and token default to the standard
+// CONSUL_HTTP_ADDR and CONSUL_HTTP_TOKEN environment variables.
+type consulInventory struct {
+	kv     *consul.KV
+	prefix string
+}
+
+func newConsulInventory(ctx context.Context, conf config.InventoryConfig) (*consulInventory, error) {
+	cc := consul.DefaultConfig()
+	if conf.Address != "" {
+		cc.Address = conf.Address
+	}
+	if conf.Token != "" {
+		token, err := config.ResolveSecret(ctx, conf.Token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Consul token: %w", err)
+		}
+		cc.Token = token
+	}
+	client, err := consul.NewClient(cc)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Consul client: %w", err)
+	}
+	return &consulInventory{kv: client.KV(), prefix: conf.Prefix}, nil
+}
+
+func (i *consulInventory) list(ctx context.Context) (map[string][]byte, string, error) {
+	pairs, meta, err := i.kv.List(i.prefix, (&consul.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	values := make(map[string][]byte, len(pairs))
+	for _, p := range pairs {
+		values[p.Key] = p.Value
+	}
+	return values, strconv.FormatUint(meta.LastIndex, 10), nil
+}
+
+func (i *consulInventory) wait(ctx context.Context, revision string) (string, error) {
+	index, err := strconv.ParseUint(revision, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid Consul index %q", revision)
+	}
+	for {
+		q := &consul.QueryOptions{WaitIndex: index, WaitTime: 5 * time.Minute}
+		_, meta, err := i.kv.List(i.prefix, q.WithContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		// The index is unchanged when the query timed out. It can also go
+		// backwards, e.g. after a snapshot restore.
+		if meta.LastIndex != index {
+			return strconv.FormatUint(meta.LastIndex, 10), nil
+		}
+	}
+}
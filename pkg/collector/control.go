@@ -0,0 +1,227 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ControlRequest is the body of a request on the control subject. A body
+// that is not a JSON object is taken as the command followed by its target
+// and subscription, so `nats req collector.pub1.ctrl "stop-subscription
+// leaf1 interfaces"` works without any JSON.
+type ControlRequest struct {
+	Command      string `json:"command"`
+	Target       string `json:"target"`
+	Subscription string `json:"subscription"`
+}
+
+// Control commands.
+const (
+	ctrlListTargets       = "list-targets"
+	ctrlStartSubscription = "start-subscription"
+	ctrlStopSubscription  = "stop-subscription"
+	ctrlPoll              = "poll"
+	ctrlReloadConfig      = "reload-config"
+	ctrlStats             = "stats"
+	ctrlPause             = "pause"
+	ctrlResume            = "resume"
+)
+
+// parseControlRequest decodes a control request body.
+func parseControlRequest(data []byte) (ControlRequest, error) {
+	var req ControlRequest
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, fmt.Errorf("invalid request: %w", err)
+		}
+	} else {
+		fields := strings.Fields(string(data))
+		for i, f := range []*string{&req.Command, &req.Target, &req.Subscription} {
+			if i < len(fields) {
+				*f = fields[i]
+			}
+		}
+	}
+	if req.Command == "" {
+		return req, errors.New("no command in request")
+	}
+	return req, nil
+}
+
+// controlTarget describes a running target in the list-targets reply.
+type controlTarget struct {
+	Name          string   `json:"name"`
+	Address       string   `json:"address"`
+	Connected     bool     `json:"connected"`
+	Subscriptions []string `json:"subscriptions"`
+	Stopped       []string `json:"stopped,omitempty"`
+	Failed        []string `json:"failed,omitempty"`
+}
+
+type controlTargets struct {
+	Collector string          `json:"collector"`
+	Standby   bool            `json:"standby"`
+	Paused    bool            `json:"paused"`
+	Targets   []controlTarget `json:"targets"`
+}
+
+// controller serves the control API of a Collector. NATS calls the handler
+// of a subscription for one message at a time, so its state needs no lock.
+type controller struct {
+	c    *Collector
+	load func() (config.Config, error)
+	// The published totals and time of the previous stats request, for the
+	// message rates.
+	last     map[string]uint64
+	lastTime time.Time
+}
+
+// ServeControl answers control requests on the subject of conf until the
+// Collector is stopped. reload-config reloads the configuration with load,
+// like SIGHUP.
+func (c *Collector) ServeControl(conf config.ControlConfig, load func() (config.Config, error)) error {
+	ctl := &controller{c: c, load: load, last: make(map[string]uint64), lastTime: time.Now()}
+	subject := conf.SubjectFor(c.instanceID)
+	sub, err := c.nats.Subscribe(subject, ctl.handle)
+	if err != nil {
+		return err
+	}
+	slog.Info("Serving control requests", "subject", subject)
+	go func() {
+		<-c.ctx.Done()
+		if err := sub.Unsubscribe(); err != nil {
+			slog.Debug("Error unsubscribing", "subject", subject, "error", err)
+		}
+	}()
+	return nil
+}
+
+func (ctl *controller) handle(msg *nats.Msg) {
+	logger := slog.With("subject", msg.Subject)
+	payload, err := ctl.run(logger, msg.Data)
+	if err != nil {
+		logger.Warn("Control request failed", "error", err)
+	}
+	respond(logger, msg, payload, err)
+}
+
+// run performs the request in data and returns the reply.
+func (ctl *controller) run(logger *slog.Logger, data []byte) ([]byte, error) {
+	req, err := parseControlRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	logger = logger.With("command", req.Command)
+
+	var reply any
+	switch req.Command {
+	case ctrlListTargets:
+		reply = ctl.c.listTargets()
+	case ctrlStartSubscription, ctrlStopSubscription:
+		if req.Target == "" || req.Subscription == "" {
+			return nil, fmt.Errorf("%s needs a target and a subscription", req.Command)
+		}
+		stop := req.Command == ctrlStopSubscription
+		if err := ctl.c.setStopped(req.Target, req.Subscription, stop); err != nil {
+			return nil, err
+		}
+		logger.Info("Changed subscription", "target", req.Target, "subscription", req.Subscription)
+		reply = map[string]string{"target": req.Target, "subscription": req.Subscription}
+	case ctrlPoll:
+		if req.Target == "" {
+			return nil, fmt.Errorf("%s needs a target", req.Command)
+		}
+		polled, err := ctl.c.poll(req.Target, req.Subscription)
+		if err != nil {
+			return nil, err
+		}
+		logger.Debug("Polled subscriptions", "target", req.Target, "subscriptions", polled)
+		reply = map[string]any{"target": req.Target, "polled": polled}
+	case ctrlReloadConfig:
+		conf, err := ctl.load()
+		if err != nil {
+			return nil, fmt.Errorf("could not reload config: %w", err)
+		}
+		logger.Info("Reloading config")
+		targets := conf.TargetConfigs()
+		ctl.c.Apply(targets)
+		reply = map[string]int{"targets": len(targets)}
+	case ctrlStats:
+		now := time.Now()
+		reply = ctl.c.stats(now, ctl.last, now.Sub(ctl.lastTime))
+		ctl.lastTime = now
+	case ctrlPause:
+		ctl.c.Pause()
+		reply = map[string]bool{"paused": true}
+	case ctrlResume:
+		ctl.c.Resume()
+		reply = map[string]bool{"paused": false}
+	default:
+		return nil, fmt.Errorf("unknown command %q", req.Command)
+	}
+	return json.Marshal(reply)
+}
+
+// listTargets describes the running targets, sorted by name.
+func (c *Collector) listTargets() controlTargets {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := controlTargets{Collector: c.instanceID, Standby: c.standby, Paused: c.Paused(), Targets: []controlTarget{}}
+	for name, rt := range c.targets {
+		ct := controlTarget{
+			Name:          name,
+			Address:       rt.tt.Config.Address,
+			Connected:     rt.tt.connected(),
+			Subscriptions: []string{},
+		}
+		rt.tt.mu.Lock()
+		for sub := range rt.tt.running {
+			ct.Subscriptions = append(ct.Subscriptions, sub)
+		}
+		for sub := range rt.tt.stopped {
+			ct.Stopped = append(ct.Stopped, sub)
+		}
+		for sub := range rt.tt.failed {
+			ct.Failed = append(ct.Failed, sub)
+		}
+		rt.tt.mu.Unlock()
+		sort.Strings(ct.Subscriptions)
+		sort.Strings(ct.Stopped)
+		sort.Strings(ct.Failed)
+		list.Targets = append(list.Targets, ct)
+	}
+	sort.Slice(list.Targets, func(i, j int) bool { return list.Targets[i].Name < list.Targets[j].Name })
+	return list
+}
+
+// setStopped stops or starts a subscription of the named running target.
+func (c *Collector) setStopped(target, subscription string, stopped bool) error {
+	c.mu.Lock()
+	rt, ok := c.targets[target]
+	c.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("no running target %q", target)
+	}
+	return rt.tt.setStopped(subscription, stopped)
+}
+
+// poll triggers the named POLL subscription of the named running target, or
+// all of its POLL subscriptions when subscription is empty.
+func (c *Collector) poll(target, subscription string) ([]string, error) {
+	c.mu.Lock()
+	rt, ok := c.targets[target]
+	c.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no running target %q", target)
+	}
+	return rt.tt.poll(subscription)
+}
@@ -0,0 +1,69 @@
+package collector
+
+import (
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"google.golang.org/protobuf/proto"
+	"strings"
+)
+
+// setSupportedEncodings records the encodings the device listed in its
+// Capabilities response, for choosing the encoding of each subscription of
+// the session. An empty list tells nothing, so it is ignored.
+func (tt *Target) setSupportedEncodings(encs []gnmi.Encoding) {
+	if len(encs) == 0 {
+		return
+	}
+	supported := make(map[gnmi.Encoding]bool, len(encs))
+	for _, enc := range encs {
+		supported[enc] = true
+	}
+	tt.mu.Lock()
+	tt.supported = supported
+	tt.mu.Unlock()
+
+	for _, enc := range tt.Config.EncodingFallback {
+		if supported[encodingValue(enc)] {
+			return
+		}
+	}
+	if len(tt.Config.EncodingFallback) > 0 {
+		tt.logger.Warn("Device supports none of the encoding_fallback encodings", "supported", encs)
+	}
+}
+
+// encoding returns the encoding sc is subscribed with: its own, unless the
+// device does not support it, in which case the first encoding_fallback
+// entry the device supports. tt.mu must be held.
+func (tt *Target) encoding(sc config.SubscriptionConfig) string {
+	if tt.supported == nil || tt.supported[encodingValue(sc.Encoding)] {
+		return sc.Encoding
+	}
+	for _, enc := range tt.Config.EncodingFallback {
+		if tt.supported[encodingValue(enc)] {
+			return enc
+		}
+	}
+	return sc.Encoding
+}
+
+// subscriptionEncoding returns the encoding the named running subscription
+// was subscribed with.
+func (tt *Target) subscriptionEncoding(name string) string {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return tt.encoding(tt.running[name])
+}
+
+// encodingValue returns the gNMI encoding named enc, which is JSON when enc
+// is empty.
+func encodingValue(enc string) gnmi.Encoding {
+	return gnmi.Encoding(gnmi.Encoding_value[strings.ToUpper(enc)])
+}
+
+// withEncoding returns a copy of req asking for enc instead.
+func withEncoding(req *gnmi.SubscribeRequest, enc string) *gnmi.SubscribeRequest {
+	req = proto.Clone(req).(*gnmi.SubscribeRequest)
+	req.GetSubscribe().Encoding = encodingValue(enc)
+	return req
+}
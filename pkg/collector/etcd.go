@@ -0,0 +1,212 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etcdInventory reads targets from an etcd key prefix through the JSON
+// gateway of the v3 API, which every etcd server since 3.4 serves on its
+// client port, and watches the prefix for changes.
+type etcdInventory struct {
+	client   *http.Client
+	endpoint string
+	key      []byte
+	rangeEnd []byte
+	username string
+	password string
+}
+
+func newEtcdInventory(ctx context.Context, conf config.InventoryConfig) (*etcdInventory, error) {
+	endpoint := conf.Address
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:2379"
+	} else if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+
+	i := &etcdInventory{
+		client:   &http.Client{},
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		key:      []byte(conf.Prefix),
+		rangeEnd: prefixEnd([]byte(conf.Prefix)),
+	}
+	if conf.Username != "" {
+		var err error
+		if i.username, err = config.ResolveSecret(ctx, conf.Username); err != nil {
+			return nil, fmt.Errorf("invalid etcd username: %w", err)
+		}
+		if i.password, err = config.ResolveSecret(ctx, conf.Password); err != nil {
+			return nil, fmt.Errorf("invalid etcd password: %w", err)
+		}
+	}
+	return i, nil
+}
+
+// prefixEnd returns the end of the key range holding every key that starts
+// with prefix.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// Every byte is 0xff, so the range runs to the end of the keyspace.
+	return []byte{0}
+}
+
+// etcdHeader is the response header of the v3 API. 64-bit integers are
+// encoded as strings by the JSON gateway.
+type etcdHeader struct {
+	Revision string `json:"revision"`
+}
+
+func (i *etcdInventory) list(ctx context.Context) (map[string][]byte, string, error) {
+	req := map[string]interface{}{"key": i.key, "range_end": i.rangeEnd}
+	var rsp struct {
+		Header etcdHeader `json:"header"`
+		KVs    []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	body, err := i.post(ctx, "/v3/kv/range", req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(&rsp); err != nil {
+		return nil, "", fmt.Errorf("invalid range response: %w", err)
+	}
+
+	values := make(map[string][]byte, len(rsp.KVs))
+	for _, kv := range rsp.KVs {
+		values[string(kv.Key)] = kv.Value
+	}
+	return values, rsp.Header.Revision, nil
+}
+
+func (i *etcdInventory) wait(ctx context.Context, revision string) (string, error) {
+	rev, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid etcd revision %q", revision)
+	}
+	req := map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            i.key,
+			"range_end":      i.rangeEnd,
+			"start_revision": strconv.FormatInt(rev+1, 10),
+		},
+	}
+	// Closing the body cancels the watch on the server.
+	body, err := i.post(ctx, "/v3/watch", req)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	dec := json.NewDecoder(body)
+	for {
+		var msg struct {
+			Result struct {
+				Header          etcdHeader        `json:"header"`
+				Canceled        bool              `json:"canceled"`
+				CancelReason    string            `json:"cancel_reason"`
+				CompactRevision uint64            `json:"compact_revision,string"`
+				Events          []json.RawMessage `json:"events"`
+			} `json:"result"`
+			Error *struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			return "", fmt.Errorf("watch ended: %w", err)
+		}
+		switch {
+		case msg.Error != nil:
+			return "", fmt.Errorf("watch failed: %s", msg.Error.Message)
+		case msg.Result.CompactRevision != 0:
+			// Our revision was compacted away; the caller lists again.
+			return "", fmt.Errorf("watch revision %s has been compacted", revision)
+		case msg.Result.Canceled:
+			return "", fmt.Errorf("watch cancelled: %s", msg.Result.CancelReason)
+		case len(msg.Result.Events) > 0:
+			return msg.Result.Header.Revision, nil
+		}
+	}
+}
+
+// post sends req to the gateway and returns the response body, which the
+// caller must close.
+func (i *etcdInventory) post(ctx context.Context, path string, req interface{}) (io.ReadCloser, error) {
+	var token string
+	if i.username != "" {
+		var err error
+		if token, err = i.authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint+path, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		httpReq.Header.Set("Authorization", token)
+	}
+
+	rsp, err := i.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		defer rsp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return nil, fmt.Errorf("%s returned %s: %s", path, rsp.Status, bytes.TrimSpace(msg))
+	}
+	return rsp.Body, nil
+}
+
+// authenticate returns a token for the configured user. Tokens are short
+// lived, so a new one is requested for every call.
+func (i *etcdInventory) authenticate(ctx context.Context) (string, error) {
+	data, err := json.Marshal(map[string]string{"name": i.username, "password": i.password})
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, i.endpoint+"/v3/auth/authenticate", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	rsp, err := i.client.Do(httpReq)
+	if err != nil {
+		return "", err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("etcd authentication failed: %s", rsp.Status)
+	}
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(rsp.Body).Decode(&auth); err != nil {
+		return "", fmt.Errorf("invalid authentication response: %w", err)
+	}
+	return auth.Token, nil
+}
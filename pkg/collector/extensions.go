@@ -0,0 +1,49 @@
+package collector
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/openconfig/gnmi/proto/gnmi_ext"
+	api "github.com/openconfig/gnmic/api"
+	"strconv"
+)
+
+// extensionOptions returns the options adding exts to a SubscribeRequest.
+func extensionOptions(exts []config.ExtensionConfig) ([]api.GNMIOption, error) {
+	var opts []api.GNMIOption
+	for _, e := range exts {
+		if h := e.History; h != nil {
+			if !h.SnapshotTime.IsZero() {
+				opts = append(opts, api.Extension_HistorySnapshotTime(h.SnapshotTime))
+			} else {
+				opts = append(opts, api.Extension_HistoryRange(h.Start, h.End))
+			}
+			continue
+		}
+		msg, err := e.Message()
+		if err != nil {
+			return nil, fmt.Errorf("extension %d: %w", e.ID, err)
+		}
+		opts = append(opts, api.Extension(&gnmi_ext.Extension{
+			Ext: &gnmi_ext.Extension_RegisteredExt{
+				RegisteredExt: &gnmi_ext.RegisteredExtension{
+					Id:  gnmi_ext.ExtensionID(e.ID),
+					Msg: msg,
+				},
+			},
+		}))
+	}
+	return opts, nil
+}
+
+// addExtensionHeaders adds the registered extensions of a response to h, as
+// a Gnmi-Extension-<id> header holding the base64 encoded message.
+func addExtensionHeaders(h map[string]string, exts []*gnmi_ext.Extension) {
+	for _, ext := range exts {
+		if reg := ext.GetRegisteredExt(); reg != nil {
+			id := strconv.FormatInt(int64(reg.GetId()), 10)
+			h["Gnmi-Extension-"+id] = base64.StdEncoding.EncodeToString(reg.GetMsg())
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package collector
+
+import (
+	"encoding/json"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/formatters"
+	"google.golang.org/protobuf/proto"
+)
+
+// marshalResponse converts rsp into the configured payload format. meta is
+// added to the output, as tags for event messages, and eps are applied to
+// event messages in order. A nil payload means there is nothing to publish.
+func marshalResponse(format string, rsp *gnmi.SubscribeResponse, meta map[string]string, eps ...formatters.EventProcessor) ([]byte, error) {
+	switch format {
+	case config.FormatProto:
+		return proto.Marshal(rsp)
+	case config.FormatEvent:
+		events, err := formatters.ResponseToEventMsgs(meta["subscription-name"], rsp, meta, eps...)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			return nil, nil
+		}
+		return json.MarshalIndent(events, "", " ")
+	default:
+		options := &formatters.MarshalOptions{Multiline: true, Indent: " "}
+		return options.Marshal(rsp, meta)
+	}
+}
@@ -0,0 +1,108 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	api "github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	"time"
+)
+
+// GetRequest is the body of a request on the Get subject. A body that is not
+// a JSON object is taken as a single path, so `nats req gnmi.get.leaf1
+// /system/state` works without any quoting.
+type GetRequest struct {
+	Path     string   `json:"path"`
+	Paths    []string `json:"paths"`
+	Prefix   string   `json:"prefix"`
+	Encoding string   `json:"encoding"`
+	// DataType is one of all, config, state or operational.
+	DataType string `json:"type"`
+}
+
+// parseGetRequest decodes a Get request body.
+func parseGetRequest(data []byte) (GetRequest, error) {
+	var req GetRequest
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &req); err != nil {
+			return req, fmt.Errorf("invalid request: %w", err)
+		}
+	} else {
+		req.Path = string(data)
+	}
+	if req.Path != "" {
+		req.Paths = append([]string{req.Path}, req.Paths...)
+	}
+	if len(req.Paths) == 0 {
+		return req, errors.New("no path in request")
+	}
+	return req, nil
+}
+
+// serveGet returns a NATS handler that runs each request as a gNMI Get
+// against the target and replies with the gnmic JSON rendering of the
+// GetResponse, or a JSON object with an "error" field.
+func (tt *Target) serveGet(timeout time.Duration) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		logger := tt.logger.With("subject", msg.Subject)
+		payload, err := tt.get(msg.Data, timeout)
+		if err != nil {
+			gnmiGetRequests.Inc(tt.Config.Name, "error")
+			logger.Warn("gNMI Get failed", "error", err)
+		} else {
+			gnmiGetRequests.Inc(tt.Config.Name, "ok")
+			logger.Debug("Served gNMI Get", "bytes", len(payload))
+		}
+		respond(logger, msg, payload, err)
+	}
+}
+
+// get performs the Get described by data and renders the response.
+func (tt *Target) get(data []byte, timeout time.Duration) ([]byte, error) {
+	req, err := parseGetRequest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := req.Encoding
+	if encoding == "" {
+		encoding = tt.Config.Encoding
+	}
+	opts := []api.GNMIOption{api.DataType(req.DataType)}
+	if encoding != "" {
+		opts = append(opts, api.Encoding(encoding))
+	}
+	if req.Prefix != "" {
+		opts = append(opts, api.Prefix(req.Prefix))
+	}
+	for _, p := range req.Paths {
+		opts = append(opts, api.Path(p))
+	}
+	getReq, err := api.NewGetRequest(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+
+	if !tt.connected() {
+		return nil, errNotConnected
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	getRsp, err := tt.Target.Get(ctx, getReq)
+	if err != nil {
+		return nil, err
+	}
+	if tt.redactor != nil {
+		for _, n := range getRsp.GetNotification() {
+			tt.redactor.apply(n)
+		}
+	}
+
+	options := &formatters.MarshalOptions{Multiline: true, Indent: " "}
+	return options.Marshal(getRsp, map[string]string{"source": tt.Config.Name})
+}
@@ -0,0 +1,95 @@
+package collector
+
+import (
+	"context"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	"google.golang.org/grpc/stats"
+	"io"
+)
+
+// Registering the compressors makes gRPC advertise them to devices, which
+// may then compress their responses with either.
+func init() {
+	encoding.RegisterCompressor(zstdCompressor{})
+}
+
+// zstdCompressor is the gRPC zstd compressor.
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string {
+	return "zstd"
+}
+
+func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdReader{dec: dec}, nil
+}
+
+// zstdReader releases its decoder once the message has been read.
+type zstdReader struct {
+	dec *zstd.Decoder
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	n, err := r.dec.Read(p)
+	if err != nil {
+		r.dec.Close()
+	}
+	return n, err
+}
+
+// compressionStats is a gRPC stats handler that records the compression the
+// device applies to its responses, from the header of every call.
+type compressionStats struct {
+	tt *Target
+}
+
+func (c compressionStats) HandleRPC(_ context.Context, s stats.RPCStats) {
+	if h, ok := s.(*stats.InHeader); ok && h.Client {
+		enc := h.Compression
+		if enc == "" {
+			enc = "identity"
+		}
+		c.tt.setResponseEncoding(enc)
+	}
+}
+
+func (compressionStats) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (compressionStats) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (compressionStats) HandleConn(context.Context, stats.ConnStats) {}
+
+// setResponseEncoding records the compression of the device's responses,
+// logging it when it changes.
+func (tt *Target) setResponseEncoding(encoding string) {
+	tt.mu.Lock()
+	previous := tt.responseEncoding
+	tt.responseEncoding = encoding
+	tt.mu.Unlock()
+	if encoding == previous {
+		return
+	}
+	if previous != "" {
+		gnmiCompression.Delete(tt.Config.Name, previous)
+	}
+	gnmiCompression.Set(1, tt.Config.Name, encoding)
+	requested := tt.Config.GRPCCompressionCodec()
+	if requested == "" {
+		requested = "identity"
+	}
+	tt.logger.Info("Negotiated gRPC compression", "requested", requested, "responses", encoding)
+}
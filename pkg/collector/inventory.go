@@ -0,0 +1,131 @@
+package collector
+
+import (
+	"context"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/envsubst"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inventory is a store of target definitions.
+type inventory interface {
+	// list returns the value of every key under the prefix, and the store
+	// revision they were read at. Revisions are opaque to the caller.
+	list(ctx context.Context) (map[string][]byte, string, error)
+	// wait blocks until a key under the prefix changes after revision and
+	// returns the new revision.
+	wait(ctx context.Context, revision string) (string, error)
+}
+
+func newInventory(ctx context.Context, conf config.InventoryConfig) (inventory, error) {
+	switch conf.Type {
+	case config.InventoryConsul, config.InventoryEtcd:
+		if conf.Prefix == "" {
+			return nil, fmt.Errorf("inventory prefix is not set")
+		}
+		if conf.Type == config.InventoryConsul {
+			return newConsulInventory(ctx, conf)
+		}
+		return newEtcdInventory(ctx, conf)
+	case config.InventoryKubernetes:
+		return newKubernetesInventory(ctx, conf)
+	default:
+		return nil, fmt.Errorf("unknown inventory type %q", conf.Type)
+	}
+}
+
+// readInventory returns the targets defined in the inventory. A target
+// without a name is named after the last element of its key, less any
+// .yaml, .yml or .json extension.
+func readInventory(conf config.InventoryConfig) ([]config.TargetConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	inv, err := newInventory(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+	values, _, err := inv.list(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s inventory: %v", conf.Type, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	targets := make([]config.TargetConfig, 0, len(keys))
+	for _, key := range keys {
+		value := values[key]
+		if strings.HasSuffix(key, "/") || len(strings.TrimSpace(string(value))) == 0 {
+			// Folders and empty placeholders.
+			continue
+		}
+		var t config.TargetConfig
+		if err := yaml.Unmarshal(envsubst.Expand(value), &t); err != nil {
+			return nil, fmt.Errorf("error parsing inventory key %s: %v", key, err)
+		}
+		if t.Name == "" {
+			t.Name = path.Base(key)
+			switch ext := path.Ext(t.Name); ext {
+			case ".yaml", ".yml", ".json":
+				t.Name = strings.TrimSuffix(t.Name, ext)
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// WatchInventory reloads the configuration with load whenever a target in
+// the inventory is added, changed or removed, and applies the new target
+// list.
+func (c *Collector) WatchInventory(conf config.InventoryConfig, load func() (config.Config, error)) {
+	const retryInterval = 5 * time.Second
+	inv, err := newInventory(c.ctx, conf)
+	if err != nil {
+		slog.Error("Could not watch inventory", "type", conf.Type, "error", err)
+		return
+	}
+
+	var revision string
+	for {
+		if revision == "" {
+			_, revision, err = inv.list(c.ctx)
+		} else {
+			revision, err = inv.wait(c.ctx, revision)
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("Could not watch inventory", "type", conf.Type, "error", err, "retry_in", retryInterval)
+			revision = ""
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+
+		// Reload after every (re)start of the watch as well, since changes
+		// made while it was not running would be missed otherwise. Applying
+		// an unchanged target list does nothing.
+		newConf, err := load()
+		if err != nil {
+			slog.Error("Could not reload targets", "type", conf.Type, "error", err)
+			continue
+		}
+		slog.Debug("Inventory changed, reloading", "type", conf.Type, "revision", revision)
+		c.Apply(newConf.TargetConfigs())
+	}
+}
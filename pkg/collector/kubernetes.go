@@ -0,0 +1,212 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The GnmiTarget custom resource, defined in deploy/kubernetes/crd.yaml.
+const (
+	gnmiTargetGroup   = "nats-gnmi.io"
+	gnmiTargetVersion = "v1alpha1"
+	gnmiTargetPlural  = "gnmitargets"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubernetesInventory reads targets from GnmiTarget resources through the
+// Kubernetes API and watches them for changes. The spec of each resource is
+// a target, named after the resource unless it sets a name. Inside a pod the
+// API server, CA and token of the pod's service account are used.
+type kubernetesInventory struct {
+	client    *http.Client
+	endpoint  string
+	namespace string
+	token     string
+}
+
+func newKubernetesInventory(ctx context.Context, conf config.InventoryConfig) (*kubernetesInventory, error) {
+	endpoint := conf.Address
+	if endpoint == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("inventory address is not set and not running in Kubernetes")
+		}
+		endpoint = "https://" + net.JoinHostPort(host, port)
+	}
+
+	caFile := conf.CAFile
+	if caFile == "" {
+		caFile = filepath.Join(serviceAccountDir, "ca.crt")
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if ca, err := os.ReadFile(caFile); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	} else if conf.CAFile != "" {
+		return nil, fmt.Errorf("error reading Kubernetes CA: %w", err)
+	}
+
+	i := &kubernetesInventory{
+		client:    &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig}},
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		namespace: conf.Namespace,
+	}
+	if conf.Token != "" {
+		token, err := config.ResolveSecret(ctx, conf.Token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kubernetes token: %w", err)
+		}
+		i.token = token
+	}
+	return i, nil
+}
+
+// gnmiTarget is the part of a GnmiTarget resource the publisher reads.
+type gnmiTarget struct {
+	Metadata struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Spec json.RawMessage `json:"spec"`
+}
+
+// resourcePath returns the API path of the GnmiTarget collection, across
+// every namespace when none is configured.
+func (i *kubernetesInventory) resourcePath() string {
+	path := "/apis/" + gnmiTargetGroup + "/" + gnmiTargetVersion
+	if i.namespace != "" {
+		path += "/namespaces/" + url.PathEscape(i.namespace)
+	}
+	return path + "/" + gnmiTargetPlural
+}
+
+func (i *kubernetesInventory) list(ctx context.Context) (map[string][]byte, string, error) {
+	body, err := i.get(ctx, i.resourcePath())
+	if err != nil {
+		return nil, "", err
+	}
+	defer body.Close()
+
+	var rsp struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Items []gnmiTarget `json:"items"`
+	}
+	if err := json.NewDecoder(body).Decode(&rsp); err != nil {
+		return nil, "", fmt.Errorf("invalid list response: %w", err)
+	}
+
+	values := make(map[string][]byte, len(rsp.Items))
+	for _, item := range rsp.Items {
+		values[item.Metadata.Namespace+"/"+item.Metadata.Name] = item.Spec
+	}
+	return values, rsp.Metadata.ResourceVersion, nil
+}
+
+func (i *kubernetesInventory) wait(ctx context.Context, revision string) (string, error) {
+	query := url.Values{
+		"watch":           {"true"},
+		"resourceVersion": {revision},
+		"timeoutSeconds":  {"300"},
+	}
+	for {
+		body, err := i.get(ctx, i.resourcePath()+"?"+query.Encode())
+		if err != nil {
+			return "", err
+		}
+		rv, err := waitEvent(body)
+		body.Close()
+		if err != nil || rv != "" {
+			return rv, err
+		}
+		// The server ended the watch after timeoutSeconds without a change.
+	}
+}
+
+// waitEvent reads watch events from body until a GnmiTarget is added,
+// changed or deleted, and returns the resource version of the change. It
+// returns an empty version when the stream ends first.
+func waitEvent(body io.Reader) (string, error) {
+	dec := json.NewDecoder(body)
+	for {
+		var event struct {
+			Type   string          `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&event); err == io.EOF {
+			return "", nil
+		} else if err != nil {
+			return "", fmt.Errorf("watch ended: %w", err)
+		}
+
+		switch event.Type {
+		case "ADDED", "MODIFIED", "DELETED":
+			var t gnmiTarget
+			if err := json.Unmarshal(event.Object, &t); err != nil {
+				return "", fmt.Errorf("invalid watch event: %w", err)
+			}
+			return t.Metadata.ResourceVersion, nil
+		case "ERROR":
+			// Typically 410 Gone once the version is too old; the caller
+			// lists again.
+			var status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(event.Object, &status)
+			return "", fmt.Errorf("watch failed with %d: %s", status.Code, status.Message)
+		}
+	}
+}
+
+// get requests path from the API server and returns the response body,
+// which the caller must close. The service account token is read for every
+// request since Kubernetes rotates it.
+func (i *kubernetesInventory) get(ctx context.Context, path string) (io.ReadCloser, error) {
+	token := i.token
+	if token == "" {
+		data, err := os.ReadFile(filepath.Join(serviceAccountDir, "token"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("error reading service account token: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, i.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rsp, err := i.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		defer rsp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(rsp.Body, 512))
+		return nil, fmt.Errorf("%s returned %s: %s", path, rsp.Status, strings.TrimSpace(string(msg)))
+	}
+	return rsp.Body, nil
+}
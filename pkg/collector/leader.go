@@ -0,0 +1,100 @@
+package collector
+
+import (
+	"errors"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"time"
+)
+
+// StartLeaderElection puts the collector on standby and competes for the
+// lease of conf in the background, collecting only while it holds it. The
+// lease is released when the collector's context is cancelled, so another
+// instance takes over without waiting for it to expire.
+func (c *Collector) StartLeaderElection(conf config.HAConfig) error {
+	ttl := conf.LeaseTTL()
+	kv, err := c.nats.KeyValue(&nats.KeyValueConfig{
+		Bucket:      conf.BucketName(),
+		Description: "Leases of the publisher HA groups",
+		History:     1,
+		TTL:         ttl,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.standby = true
+	c.mu.Unlock()
+
+	group := conf.GroupName()
+	haLeader.Set(0, group)
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.lead(kv, group, ttl)
+	}()
+	return nil
+}
+
+// lead holds on to the lease of group in kv while it can, and tries to take
+// it over while another instance holds it.
+func (c *Collector) lead(kv nats.KeyValue, group string, ttl time.Duration) {
+	logger := slog.With("group", group, "instance_id", c.instanceID)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+
+	var (
+		leading   bool
+		revision  uint64
+		lastRenew time.Time
+	)
+	stepDown := func(reason string, err error) {
+		logger.Warn("Lost leadership, going on standby", "reason", reason, "error", err)
+		leading = false
+		haLeader.Set(0, group)
+		c.setStandby(true)
+	}
+
+	for {
+		if leading {
+			rev, err := kv.Update(group, []byte(c.instanceID), revision)
+			switch {
+			case err == nil:
+				revision, lastRenew = rev, time.Now()
+			case errors.Is(err, nats.ErrKeyExists):
+				// The lease expired and another instance took it.
+				stepDown("lease taken over", err)
+			case time.Since(lastRenew) > ttl/2:
+				// Step down well before the lease can expire, so two
+				// instances never collect for long at the same time.
+				stepDown("lease not renewed", err)
+			default:
+				logger.Debug("Could not renew lease", "error", err)
+			}
+		} else {
+			rev, err := kv.Create(group, []byte(c.instanceID))
+			switch {
+			case err == nil:
+				logger.Info("Became leader, starting collection")
+				leading, revision, lastRenew = true, rev, time.Now()
+				haLeader.Set(1, group)
+				c.setStandby(false)
+			case !errors.Is(err, nats.ErrKeyExists):
+				logger.Warn("Could not acquire lease", "error", err)
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			if leading {
+				if err := kv.Delete(group, nats.LastRevision(revision)); err != nil {
+					logger.Warn("Could not release lease", "error", err)
+				}
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package collector
+
+import "github.com/gwoodwa1/nats-gnmi-example/internal/metrics"
+
+var (
+	gnmiResponses = metrics.Default.NewCounterVec("publisher_gnmi_responses_received_total",
+		"gNMI SubscribeResponses received.", "target", "subscription")
+	gnmiUpdatesSuppressed = metrics.Default.NewCounterVec("publisher_gnmi_updates_suppressed_total",
+		"Unchanged updates dropped by changes_only.", "target", "subscription")
+	gnmiValuesRedacted = metrics.Default.NewCounterVec("publisher_gnmi_values_redacted_total",
+		"Values masked or dropped by redact.", "target", "subscription")
+	gnmiErrors = metrics.Default.NewCounterVec("publisher_gnmi_subscription_errors_total",
+		"Errors reported by gNMI subscriptions.", "target", "subscription")
+	gnmiSubscriptionRestarts = metrics.Default.NewCounterVec("publisher_gnmi_subscription_restarts_total",
+		"Failed subscriptions restarted by their restart policy.", "target", "subscription")
+	gnmiSubscriptionsFailed = metrics.Default.NewCounterVec("publisher_gnmi_subscriptions_failed_total",
+		"Subscriptions given up by their restart policy.", "target", "subscription")
+	gnmiReconnects = metrics.Default.NewCounterVec("publisher_gnmi_reconnects_total",
+		"gNMI sessions re-established after a failure.", "target")
+	gnmiCompression = metrics.Default.NewGaugeVec("publisher_gnmi_compression",
+		"1 for the gRPC compression the target applies to its responses.", "target", "encoding")
+	tunnelConnected = metrics.Default.NewGaugeVec("publisher_tunnel_connected",
+		"1 while the device of the target is registered on the tunnel server, 0 otherwise.", "target")
+	gnmiGetRequests = metrics.Default.NewCounterVec("publisher_gnmi_get_requests_total",
+		"gNMI Get requests served over NATS, by result.", "target", "result")
+	gnmiSetRequests = metrics.Default.NewCounterVec("publisher_gnmi_set_requests_total",
+		"gNMI Set requests relayed from NATS, by result.", "target", "result")
+	gnmiPolls = metrics.Default.NewCounterVec("publisher_gnmi_polls_total",
+		"Polls of POLL subscriptions requested through the control API.", "target", "subscription")
+	natsPublishes = metrics.Default.NewCounterVec("publisher_nats_publishes_total",
+		"Messages published to NATS.", "target", "subject")
+	natsPublishFailures = metrics.Default.NewCounterVec("publisher_nats_publish_failures_total",
+		"Messages that could not be published to NATS.", "target", "subject")
+	natsRateLimited = metrics.Default.NewCounterVec("publisher_nats_rate_limited_total",
+		"Messages dropped because the target exceeded its rate limit.", "target", "subject")
+	queueLength = metrics.Default.NewGaugeVec("publisher_queue_length",
+		"Messages waiting to be published.", "target")
+	queueDropped = metrics.Default.NewCounterVec("publisher_queue_dropped_total",
+		"Messages dropped because the publish queue was full.", "target")
+	natsPublishedBytes = metrics.Default.NewCounterVec("publisher_nats_published_bytes_total",
+		"Payload bytes published to NATS.", "target")
+	pausedDropped = metrics.Default.NewCounterVec("publisher_paused_dropped_total",
+		"gNMI responses dropped while publishing was paused.", "target")
+	pausedGauge = metrics.Default.NewGaugeVec("publisher_paused",
+		"1 while publishing is paused, 0 otherwise.")
+	haLeader = metrics.Default.NewGaugeVec("publisher_ha_leader",
+		"1 while the publisher holds the lease of its HA group, 0 on standby.", "group")
+)
+
+// QueueDropped returns how many messages of the target were dropped because
+// its publish queue was full.
+func (tt *Target) QueueDropped() uint64 {
+	return uint64(queueDropped.Total(tt.Config.Name))
+}
+
+// RateLimited returns how many messages of the target were dropped because
+// it exceeded its rate limit.
+func (tt *Target) RateLimited() uint64 {
+	return uint64(natsRateLimited.Total(tt.Config.Name))
+}
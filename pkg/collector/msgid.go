@@ -0,0 +1,31 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"github.com/openconfig/gnmic/utils"
+	"strconv"
+)
+
+// messageID returns a deterministic ID for a notification from a target.
+// The same sample published twice, after a retry or by a standby publisher,
+// gets the same ID so JetStream can drop the copy.
+func messageID(target, subscription string, n *gnmi.Notification) string {
+	h := sha256.New()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	write(target)
+	write(subscription)
+	write(strconv.FormatInt(n.GetTimestamp(), 10))
+	write(utils.GnmiPathToXPath(n.GetPrefix(), false))
+	for _, upd := range n.GetUpdate() {
+		write(utils.GnmiPathToXPath(upd.GetPath(), false))
+	}
+	for _, del := range n.GetDelete() {
+		write("-" + utils.GnmiPathToXPath(del, false))
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
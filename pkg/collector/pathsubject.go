@@ -0,0 +1,105 @@
+package collector
+
+import (
+	"github.com/openconfig/gnmi/proto/gnmi"
+	target "github.com/openconfig/gnmic/target"
+	"sort"
+	"strings"
+)
+
+// pathSubject appends to subject the elements of prefix and path up to the
+// last one, which names the leaf. Key values follow their element, sorted by
+// key name, so interfaces/interface[name=Ethernet1]/state/counters/in-octets
+// becomes <subject>.interfaces.interface.Ethernet1.state.counters. Module
+// prefixes are dropped from element names, and characters subjects cannot
+// hold become underscores.
+func pathSubject(subject string, prefix, path *gnmi.Path) string {
+	elems := append(append([]*gnmi.PathElem(nil), prefix.GetElem()...), path.GetElem()...)
+	if len(elems) > 0 {
+		elems = elems[:len(elems)-1]
+	}
+	var b strings.Builder
+	b.WriteString(subject)
+	for _, e := range elems {
+		name := e.GetName()
+		if i := strings.LastIndex(name, ":"); i >= 0 {
+			name = name[i+1:]
+		}
+		b.WriteString("." + subjectToken(name))
+		keys := make([]string, 0, len(e.GetKey()))
+		for k := range e.GetKey() {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString("." + subjectToken(e.GetKey()[k]))
+		}
+	}
+	return b.String()
+}
+
+func subjectToken(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '.' || r == '*' || r == '>' || r <= ' ' || r == 0x7f:
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// splitByPath splits the notification of rsp into one response per subject
+// derived from the paths of its updates and deletes, in the order the
+// subjects first appear. Other responses are returned as is on subject.
+func splitByPath(subject string, rsp *target.SubscribeResponse) ([]string, []*target.SubscribeResponse) {
+	notif := rsp.Response.GetUpdate()
+	if notif == nil {
+		return []string{subject}, []*target.SubscribeResponse{rsp}
+	}
+
+	var subjects []string
+	parts := make(map[string]*gnmi.Notification)
+	part := func(s string) *gnmi.Notification {
+		n, ok := parts[s]
+		if !ok {
+			n = &gnmi.Notification{
+				Timestamp: notif.GetTimestamp(),
+				Prefix:    notif.GetPrefix(),
+				Atomic:    notif.GetAtomic(),
+			}
+			parts[s] = n
+			subjects = append(subjects, s)
+		}
+		return n
+	}
+	for _, upd := range notif.GetUpdate() {
+		n := part(pathSubject(subject, notif.GetPrefix(), upd.GetPath()))
+		n.Update = append(n.Update, upd)
+	}
+	for _, del := range notif.GetDelete() {
+		n := part(pathSubject(subject, notif.GetPrefix(), del))
+		n.Delete = append(n.Delete, del)
+	}
+	if len(subjects) <= 1 {
+		if len(subjects) == 1 {
+			subject = subjects[0]
+		}
+		return []string{subject}, []*target.SubscribeResponse{rsp}
+	}
+
+	rsps := make([]*target.SubscribeResponse, len(subjects))
+	for i, s := range subjects {
+		rsps[i] = &target.SubscribeResponse{
+			SubscriptionName:   rsp.SubscriptionName,
+			SubscriptionConfig: rsp.SubscriptionConfig,
+			Response: &gnmi.SubscribeResponse{
+				Response:  &gnmi.SubscribeResponse_Update{Update: parts[s]},
+				Extension: rsp.Response.GetExtension(),
+			},
+		}
+	}
+	return subjects, rsps
+}
@@ -0,0 +1,74 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"strings"
+)
+
+// pathKeyTags returns the keys of the prefix and path elements as tags named
+// after the element and key, the same way gnmic names event tags:
+// interface[name=Ethernet1] becomes interface_name=Ethernet1. Module
+// prefixes are dropped from element names.
+func pathKeyTags(prefix, path *gnmi.Path) map[string]string {
+	tags := make(map[string]string)
+	for _, p := range []*gnmi.Path{prefix, path} {
+		for _, e := range p.GetElem() {
+			name := e.GetName()
+			if i := strings.LastIndex(name, ":"); i >= 0 {
+				name = name[i+1:]
+			}
+			for k, v := range e.GetKey() {
+				if name == "" {
+					tags[k] = v
+					continue
+				}
+				tags[name+"_"+k] = v
+			}
+		}
+	}
+	return tags
+}
+
+// addPathKeyTags adds a "tags" map with the path keys of each update to the
+// gnmic JSON rendering of rsp in payload.
+func addPathKeyTags(payload []byte, rsp *gnmi.SubscribeResponse, indent string) ([]byte, error) {
+	notif := rsp.GetUpdate()
+	if len(notif.GetUpdate()) == 0 || len(payload) == 0 {
+		return payload, nil
+	}
+
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		return nil, err
+	}
+	var updates []map[string]json.RawMessage
+	if raw, ok := msg["updates"]; ok {
+		if err := json.Unmarshal(raw, &updates); err != nil {
+			return nil, err
+		}
+	}
+	if len(updates) != len(notif.GetUpdate()) {
+		return nil, fmt.Errorf("rendered %d updates, response has %d", len(updates), len(notif.GetUpdate()))
+	}
+
+	for i, upd := range notif.GetUpdate() {
+		tags := pathKeyTags(notif.GetPrefix(), upd.GetPath())
+		if len(tags) == 0 {
+			continue
+		}
+		raw, err := json.Marshal(tags)
+		if err != nil {
+			return nil, err
+		}
+		updates[i]["tags"] = raw
+	}
+
+	raw, err := json.Marshal(updates)
+	if err != nil {
+		return nil, err
+	}
+	msg["updates"] = raw
+	return json.MarshalIndent(msg, "", indent)
+}
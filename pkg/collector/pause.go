@@ -0,0 +1,51 @@
+package collector
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Pause stops publishing. The gNMI sessions stay up, but the messages of
+// every target are dropped until Resume is called.
+func (c *Collector) Pause() {
+	if c.paused.CompareAndSwap(false, true) {
+		pausedGauge.Set(1)
+		slog.Info("Paused publishing")
+	}
+}
+
+// Resume publishes messages again after Pause.
+func (c *Collector) Resume() {
+	if c.paused.CompareAndSwap(true, false) {
+		pausedGauge.Set(0)
+		slog.Info("Resumed publishing")
+	}
+}
+
+// Paused reports whether publishing is paused.
+func (c *Collector) Paused() bool {
+	return c.paused.Load()
+}
+
+// PauseOnSignals pauses publishing when the process receives SIGUSR1 and
+// resumes it on SIGUSR2.
+func (c *Collector) PauseOnSignals() {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case sig := <-sigs:
+			if sig == syscall.SIGUSR1 {
+				c.Pause()
+			} else {
+				c.Resume()
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	target "github.com/openconfig/gnmic/target"
+	"google.golang.org/grpc/metadata"
+	"io"
+	"sort"
+	"strings"
+)
+
+func isPoll(listMode string) bool {
+	return strings.EqualFold(listMode, "poll")
+}
+
+// startPoll runs the POLL subscription req on its own stream, which is
+// polled whenever it is triggered through tt.poll. gnmic cannot be asked to
+// poll a subscription, so POLL subscriptions do not go through it. tt.mu
+// must be held.
+func (tt *Target) startPoll(ctx context.Context, req *gnmi.SubscribeRequest, name string) {
+	// A trigger arriving while another is pending is merged with it.
+	trigger := make(chan struct{}, 1)
+	if tt.polls == nil {
+		tt.polls = make(map[string]chan struct{})
+	}
+	tt.polls[name] = trigger
+	go tt.runPoll(ctx, req, name, trigger)
+}
+
+// runPoll sends req and then a Poll every time trigger fires, handing the
+// responses and errors of the stream to the session until ctx is cancelled.
+func (tt *Target) runPoll(ctx context.Context, req *gnmi.SubscribeRequest, name string, trigger <-chan struct{}) {
+	stream, err := tt.Target.Client.Subscribe(tt.withCredentials(ctx))
+	if err == nil {
+		err = stream.Send(req)
+	}
+	if err != nil {
+		tt.pollFailed(ctx, name, err)
+		return
+	}
+
+	go func() {
+		for {
+			rsp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				err = errors.New("device ended the poll subscription")
+			}
+			if err != nil {
+				tt.pollFailed(ctx, name, err)
+				return
+			}
+			select {
+			case tt.pollResponses <- &target.SubscribeResponse{SubscriptionName: name, Response: rsp}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	poll := &gnmi.SubscribeRequest{Request: &gnmi.SubscribeRequest_Poll{Poll: &gnmi.Poll{}}}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+			// A failed Send means the stream is gone; Recv reports why.
+			if err := stream.Send(poll); err != nil {
+				return
+			}
+			tt.logger.Debug("Polled subscription", "subscription", name)
+		}
+	}
+}
+
+// pollFailed hands the error of a POLL subscription to the session.
+func (tt *Target) pollFailed(ctx context.Context, name string, err error) {
+	select {
+	case tt.pollErrors <- &target.TargetError{SubscriptionName: name, Err: err}:
+	case <-ctx.Done():
+	}
+}
+
+// withCredentials adds the username and password of the session to ctx, as
+// gnmic does for its own RPCs.
+func (tt *Target) withCredentials(ctx context.Context) context.Context {
+	conf := tt.Target.Config
+	if conf.Username != nil && *conf.Username != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "username", *conf.Username)
+	}
+	if conf.Password != nil && *conf.Password != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "password", *conf.Password)
+	}
+	return ctx
+}
+
+// poll triggers the named running POLL subscription, or all of them when
+// name is empty, and returns the names of those polled.
+func (tt *Target) poll(name string) ([]string, error) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if tt.subCtx == nil {
+		return nil, errNotConnected
+	}
+
+	var names []string
+	if name != "" {
+		if _, ok := tt.polls[name]; !ok {
+			if _, ok := tt.requests[name]; !ok {
+				return nil, fmt.Errorf("no subscription %q", name)
+			}
+			return nil, fmt.Errorf("subscription %q is not a running poll subscription", name)
+		}
+		names = []string{name}
+	} else {
+		for n := range tt.polls {
+			names = append(names, n)
+		}
+		if len(names) == 0 {
+			return nil, errors.New("no running poll subscriptions")
+		}
+		sort.Strings(names)
+	}
+
+	for _, n := range names {
+		select {
+		case tt.polls[n] <- struct{}{}:
+		default:
+		}
+		gnmiPolls.Inc(tt.Config.Name, n)
+	}
+	return names, nil
+}
@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/openconfig/gnmic/formatters"
+	_ "github.com/openconfig/gnmic/formatters/all"
+	"log/slog"
+	"sort"
+)
+
+// newEventProcessors initializes the named processors, in order, from defs.
+func newEventProcessors(defs config.ProcessorConfigs, names []string, logger *slog.Logger) ([]formatters.EventProcessor, error) {
+	eps := make([]formatters.EventProcessor, 0, len(names))
+	for _, name := range names {
+		def, ok := defs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown event processor %q", name)
+		}
+		if len(def) != 1 {
+			return nil, fmt.Errorf("event processor %q must have exactly one type, one of %v", name, processorTypes())
+		}
+		for typ, conf := range def {
+			initFn, ok := formatters.EventProcessors[typ]
+			if !ok {
+				return nil, fmt.Errorf("event processor %q has unknown type %q, must be one of %v", name, typ, processorTypes())
+			}
+			ep := initFn()
+			l := slog.NewLogLogger(logger.With("processor", name).Handler(), slog.LevelDebug)
+			if err := ep.Init(conf, formatters.WithLogger(l)); err != nil {
+				return nil, fmt.Errorf("event processor %q: %w", name, err)
+			}
+			eps = append(eps, ep)
+		}
+	}
+	return eps, nil
+}
+
+// processorTypes returns the registered event processor types.
+func processorTypes() []string {
+	types := make([]string, 0, len(formatters.EventProcessors))
+	for typ := range formatters.EventProcessors {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	return types
+}
@@ -0,0 +1,241 @@
+package collector
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// proxyDialer opens the connections of a gNMI session through the proxy of
+// the target. An SSH jump host is connected to once and its connection
+// shared by every dial until the dialer is closed.
+type proxyDialer struct {
+	url                *url.URL
+	username, password string
+	conf               config.ProxyConfig
+	logger             *slog.Logger
+
+	mu  sync.Mutex
+	ssh *ssh.Client
+}
+
+// newProxyDialer returns the dialer for conf, resolving its credentials.
+// gRPC only reports that a connection timed out, so dial errors are logged
+// to logger.
+func newProxyDialer(ctx context.Context, conf config.ProxyConfig, logger *slog.Logger) (*proxyDialer, error) {
+	u, err := conf.Parse()
+	if err != nil {
+		return nil, err
+	}
+	password, _ := u.User.Password()
+	username, password, err := conf.Credentials.Resolve(ctx, u.User.Username(), password)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving proxy credentials: %w", err)
+	}
+	d := &proxyDialer{url: u, username: username, password: password, conf: conf}
+	d.logger = logger.With("proxy", d.String())
+	return d, nil
+}
+
+// String returns the proxy URL without its password, for logging.
+func (d *proxyDialer) String() string {
+	return d.url.Redacted()
+}
+
+// hostPort returns the address of the proxy, with the default port of its
+// scheme if the URL has none.
+func (d *proxyDialer) hostPort() string {
+	if port := d.url.Port(); port != "" {
+		return net.JoinHostPort(d.url.Hostname(), port)
+	}
+	port := map[string]string{
+		config.ProxyHTTP:    "80",
+		config.ProxySOCKS5:  "1080",
+		config.ProxySOCKS5H: "1080",
+		config.ProxySSH:     "22",
+	}[d.url.Scheme]
+	return net.JoinHostPort(d.url.Hostname(), port)
+}
+
+// DialContext connects to addr through the proxy. addr is passed on as
+// configured, so the proxy resolves names the publisher cannot.
+func (d *proxyDialer) DialContext(ctx context.Context, addr string) (net.Conn, error) {
+	conn, err := d.dial(ctx, addr)
+	if err != nil {
+		d.logger.Warn("Could not connect through proxy", "error", err)
+	}
+	return conn, err
+}
+
+func (d *proxyDialer) dial(ctx context.Context, addr string) (net.Conn, error) {
+	switch d.url.Scheme {
+	case config.ProxyHTTP:
+		return d.dialHTTP(ctx, addr)
+	case config.ProxySSH:
+		return d.dialSSH(ctx, addr)
+	}
+	var auth *proxy.Auth
+	if d.username != "" {
+		auth = &proxy.Auth{User: d.username, Password: d.password}
+	}
+	dialer, err := proxy.SOCKS5("tcp", d.hostPort(), auth, &net.Dialer{})
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+}
+
+// dialHTTP opens a tunnel to addr with an HTTP CONNECT request.
+func (d *proxyDialer) dialHTTP(ctx context.Context, addr string) (net.Conn, error) {
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.hostPort())
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if d.username != "" {
+		creds := base64.StdEncoding.EncodeToString([]byte(d.username + ":" + d.password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error sending CONNECT to proxy: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("error reading CONNECT response from proxy: %w", err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, rsp.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were read into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// dialSSH opens a channel to addr through the SSH jump host, connecting to
+// the jump host first if needed.
+func (d *proxyDialer) dialSSH(ctx context.Context, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ssh == nil {
+		client, err := d.connectSSH(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to jump host %s: %w", d.hostPort(), err)
+		}
+		d.ssh = client
+	}
+	conn, err := d.ssh.Dial("tcp", addr)
+	if err != nil {
+		// The connection to the jump host may be gone; start over on the
+		// next dial.
+		d.ssh.Close()
+		d.ssh = nil
+		return nil, fmt.Errorf("error connecting to %s through jump host: %w", addr, err)
+	}
+	return conn, nil
+}
+
+func (d *proxyDialer) connectSSH(ctx context.Context) (*ssh.Client, error) {
+	var auth []ssh.AuthMethod
+	if d.conf.KeyFile != "" {
+		key, err := os.ReadFile(d.conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key_file: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if d.password != "" {
+		auth = append(auth, ssh.Password(d.password))
+	}
+
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if !d.conf.InsecureIgnoreHostKey {
+		file := d.conf.KnownHosts
+		if file == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			file = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		var err error
+		if hostKey, err = knownhosts.New(file); err != nil {
+			return nil, fmt.Errorf("invalid known_hosts: %w", err)
+		}
+	}
+
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", d.hostPort())
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.hostPort(), &ssh.ClientConfig{
+		User:            d.username,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Close closes the connection to the SSH jump host, if any.
+func (d *proxyDialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ssh == nil {
+		return nil
+	}
+	err := d.ssh.Close()
+	d.ssh = nil
+	return err
+}
@@ -0,0 +1,112 @@
+package collector
+
+import (
+	"context"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/tracing"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"time"
+)
+
+// outMsg is an encoded response waiting to be published.
+type outMsg struct {
+	subscription string
+	subject      string
+	payload      []byte
+	meta         map[string]string
+	// trace is the span of the update the message was built from.
+	trace tracing.SpanContext
+}
+
+// publishQueue is a bounded FIFO of messages for one target.
+type publishQueue struct {
+	ch     chan outMsg
+	policy string
+}
+
+func newPublishQueue(conf config.QueueConfig) *publishQueue {
+	if conf.Size <= 0 {
+		conf.Size = 1000
+	}
+	if conf.Policy == "" {
+		conf.Policy = config.QueueBlock
+	}
+	return &publishQueue{ch: make(chan outMsg, conf.Size), policy: conf.Policy}
+}
+
+// push queues m according to the policy and returns the number of messages
+// dropped to do so.
+func (q *publishQueue) push(ctx context.Context, m outMsg) int {
+	switch q.policy {
+	case config.QueueDropNewest:
+		select {
+		case q.ch <- m:
+			return 0
+		default:
+			return 1
+		}
+	case config.QueueDropOldest:
+		dropped := 0
+		for {
+			select {
+			case q.ch <- m:
+				return dropped
+			default:
+			}
+			select {
+			case <-q.ch:
+				dropped++
+			default:
+			}
+		}
+	default:
+		select {
+		case q.ch <- m:
+			return 0
+		case <-ctx.Done():
+			return 1
+		}
+	}
+}
+
+// enqueue queues m for publishing, counting the messages dropped to do so.
+func (tt *Target) enqueue(ctx context.Context, m outMsg) {
+	if dropped := tt.queue.push(ctx, m); dropped > 0 {
+		queueDropped.Add(float64(dropped), tt.Config.Name)
+		tt.logger.Debug("Dropped queued messages", "subscription", m.subscription, "policy", tt.queue.policy, "dropped", dropped)
+	}
+	queueLength.Set(float64(len(tt.queue.ch)), tt.Config.Name)
+}
+
+// RunPublisher publishes queued messages until ctx is cancelled, then
+// flushes whatever is left in the queue.
+func (tt *Target) RunPublisher(ctx context.Context) {
+	for {
+		select {
+		case m := <-tt.queue.ch:
+			queueLength.Set(float64(len(tt.queue.ch)), tt.Config.Name)
+			tt.publish(ctx, m)
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for {
+				select {
+				case m := <-tt.queue.ch:
+					tt.publish(flushCtx, m)
+				default:
+					queueLength.Delete(tt.Config.Name)
+					return
+				}
+			}
+		}
+	}
+}
+
+// stalled reports whether messages are waiting but nothing has been
+// published for longer than d.
+func (tt *Target) stalled(d time.Duration) bool {
+	if len(tt.queue.ch) == 0 {
+		return false
+	}
+	last := time.Unix(0, tt.lastPublish.Load())
+	return time.Since(last) > d
+}
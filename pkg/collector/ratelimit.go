@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+// publishLimiter is a pair of token buckets limiting the message and byte
+// rate of a target.
+type publishLimiter struct {
+	msgs  *rate.Limiter
+	bytes *rate.Limiter
+}
+
+// newPublishLimiter returns a limiter for conf, or nil when conf sets no
+// limit.
+func newPublishLimiter(conf config.RateLimitConfig) *publishLimiter {
+	if conf.MessagesPerSecond <= 0 && conf.BytesPerSecond <= 0 {
+		return nil
+	}
+	l := &publishLimiter{}
+	if conf.MessagesPerSecond > 0 {
+		burst := int(conf.MessagesPerSecond)
+		if burst < 1 {
+			burst = 1
+		}
+		l.msgs = rate.NewLimiter(rate.Limit(conf.MessagesPerSecond), burst)
+	}
+	if conf.BytesPerSecond > 0 {
+		l.bytes = rate.NewLimiter(rate.Limit(conf.BytesPerSecond), conf.BytesPerSecond)
+	}
+	return l
+}
+
+// allow reports whether a message of size bytes may be published now,
+// taking its tokens if so. A rejected message takes no tokens.
+func (l *publishLimiter) allow(size int) bool {
+	now := time.Now()
+	var msg *rate.Reservation
+	if l.msgs != nil {
+		if msg = l.msgs.ReserveN(now, 1); msg.DelayFrom(now) > 0 {
+			msg.CancelAt(now)
+			return false
+		}
+	}
+	if l.bytes != nil && !l.takeBytes(now, size) {
+		if msg != nil {
+			msg.CancelAt(now)
+		}
+		return false
+	}
+	return true
+}
+
+// takeBytes takes size tokens from the byte bucket if they are available
+// now. A message larger than the bucket only needs a full one: the rest is
+// taken as debt, which is paid back before further messages are allowed.
+func (l *publishLimiter) takeBytes(now time.Time, size int) bool {
+	burst := l.bytes.Burst()
+	first := l.bytes.ReserveN(now, min(size, burst))
+	if first.DelayFrom(now) > 0 {
+		first.CancelAt(now)
+		return false
+	}
+	for size -= burst; size > 0; size -= burst {
+		l.bytes.ReserveN(now, min(size, burst))
+	}
+	return true
+}
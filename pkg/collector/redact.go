@@ -0,0 +1,140 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"regexp"
+	"strings"
+)
+
+const defaultRedactMask = "***"
+
+// redactor masks or drops the values whose path matches one of its
+// expressions, before anything is encoded or recorded.
+type redactor struct {
+	paths []*regexp.Regexp
+	drop  bool
+	mask  string
+}
+
+func newRedactor(conf config.RedactConfig) (*redactor, error) {
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	r := &redactor{drop: conf.Action == config.RedactDrop, mask: conf.Mask}
+	if r.mask == "" {
+		r.mask = defaultRedactMask
+	}
+	for _, p := range conf.Paths {
+		r.paths = append(r.paths, regexp.MustCompile(p))
+	}
+	return r, nil
+}
+
+// apply redacts the updates of n in place and reports how many values it
+// masked or dropped. Updates left without a value are removed.
+func (r *redactor) apply(n *gnmi.Notification) int {
+	prefix := redactPath("", n.GetPrefix().GetElem())
+	redacted := 0
+	kept := n.Update[:0]
+	for _, upd := range n.GetUpdate() {
+		path := redactPath(prefix, upd.GetPath().GetElem())
+		if r.match(path) {
+			redacted++
+			if r.drop {
+				continue
+			}
+			upd.Val = &gnmi.TypedValue{Value: &gnmi.TypedValue_StringVal{StringVal: r.mask}}
+			kept = append(kept, upd)
+			continue
+		}
+		switch v := upd.GetVal().GetValue().(type) {
+		case *gnmi.TypedValue_JsonVal:
+			var count int
+			v.JsonVal, count = r.applyJSON(path, v.JsonVal)
+			redacted += count
+		case *gnmi.TypedValue_JsonIetfVal:
+			var count int
+			v.JsonIetfVal, count = r.applyJSON(path, v.JsonIetfVal)
+			redacted += count
+		}
+		kept = append(kept, upd)
+	}
+	n.Update = kept
+	return redacted
+}
+
+// applyJSON redacts the members of the JSON value at path. The value is
+// returned as is when nothing in it matches or it cannot be parsed.
+func (r *redactor) applyJSON(path string, data []byte) ([]byte, int) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return data, 0
+	}
+	count := r.walk(path, v)
+	if count == 0 {
+		return data, 0
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return data, 0
+	}
+	return out, count
+}
+
+// walk redacts the members of objects within v, whose path is path, and
+// reports how many it redacted. List entries share the path of their list.
+func (r *redactor) walk(path string, v any) int {
+	count := 0
+	switch v := v.(type) {
+	case map[string]any:
+		for k, member := range v {
+			memberPath := path + "/" + stripModule(k)
+			if r.match(memberPath) {
+				count++
+				if r.drop {
+					delete(v, k)
+				} else {
+					v[k] = r.mask
+				}
+				continue
+			}
+			count += r.walk(memberPath, member)
+		}
+	case []any:
+		for _, entry := range v {
+			count += r.walk(path, entry)
+		}
+	}
+	return count
+}
+
+func (r *redactor) match(path string) bool {
+	for _, re := range r.paths {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactPath appends the names of elems, without module prefixes, to path.
+func redactPath(path string, elems []*gnmi.PathElem) string {
+	var b strings.Builder
+	b.WriteString(path)
+	for _, e := range elems {
+		b.WriteString("/" + stripModule(e.GetName()))
+	}
+	return b.String()
+}
+
+func stripModule(name string) string {
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
@@ -0,0 +1,143 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"time"
+)
+
+// subRestart is the restart state of a failed subscription.
+type subRestart struct {
+	backoff *backoff
+	// failures counts the failures since the subscription last worked.
+	failures int
+	// pending is set while the restart waits for its backoff delay.
+	pending bool
+}
+
+// subscriptionStatus is the status event published when a subscription is
+// given up.
+type subscriptionStatus struct {
+	Event        string `json:"event"`
+	Target       string `json:"target"`
+	Subscription string `json:"subscription"`
+	Error        string `json:"error"`
+	Retries      int    `json:"retries"`
+	Action       string `json:"action"`
+	Timestamp    string `json:"timestamp"`
+}
+
+// subscriptionFailed handles the failure of the named subscription of the
+// session ctx. Without a restart policy, or when the policy gives up with
+// the reconnect action, it returns the error that ends the session.
+// Otherwise the subscription is restarted after a backoff delay, or left
+// stopped once it has been restarted max_retries times in a row.
+func (tt *Target) subscriptionFailed(ctx context.Context, name string, subErr error) error {
+	err := fmt.Errorf("subscription %q stopped: %w", name, subErr)
+	tt.mu.Lock()
+	sc := tt.running[name]
+	if !sc.Restart.Enabled {
+		tt.mu.Unlock()
+		return err
+	}
+	tt.stopSubscription(name)
+	if tt.restarts == nil {
+		tt.restarts = make(map[string]*subRestart)
+	}
+	r, ok := tt.restarts[name]
+	if !ok {
+		r = &subRestart{backoff: newBackoff(sc.Restart.Backoff)}
+		tt.restarts[name] = r
+	}
+	r.failures++
+
+	logger := tt.logger.With("subscription", name)
+	if limit := sc.Restart.MaxRetries; limit > 0 && r.failures > limit {
+		delete(tt.restarts, name)
+		action := sc.Restart.GiveUp
+		if action == "" {
+			action = config.GiveUpStop
+		}
+		if action == config.GiveUpStop {
+			if tt.failed == nil {
+				tt.failed = make(map[string]bool)
+			}
+			tt.failed[name] = true
+		}
+		tt.mu.Unlock()
+
+		gnmiSubscriptionsFailed.Inc(tt.Config.Name, name)
+		logger.Error("Giving up on subscription", "error", subErr, "retries", limit, "action", action)
+		tt.publishStatus(ctx, subscriptionStatus{
+			Event:        "subscription_failed",
+			Target:       tt.Config.Name,
+			Subscription: name,
+			Error:        subErr.Error(),
+			Retries:      limit,
+			Action:       action,
+			Timestamp:    time.Now().UTC().Format(time.RFC3339Nano),
+		})
+		if action == config.GiveUpReconnect {
+			return err
+		}
+		return nil
+	}
+
+	r.pending = true
+	delay, failures := r.backoff.next(), r.failures
+	tt.mu.Unlock()
+
+	gnmiSubscriptionRestarts.Inc(tt.Config.Name, name)
+	logger.Warn("Subscription failed, restarting", "error", subErr, "failures", failures, "retry_in", delay)
+	go func() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		tt.mu.Lock()
+		defer tt.mu.Unlock()
+		// The session may have ended, or a later failure replaced r.
+		if tt.subCtx != ctx || tt.restarts[name] != r {
+			return
+		}
+		r.pending = false
+		tt.applySubscriptions()
+	}()
+	return nil
+}
+
+// subscriptionWorked forgets the failures of the named subscription once it
+// delivers a response again.
+func (tt *Target) subscriptionWorked(name string) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if r, ok := tt.restarts[name]; ok && !r.pending {
+		delete(tt.restarts, name)
+	}
+}
+
+// publishStatus publishes status on the status subject of the target.
+// Failures are logged.
+func (tt *Target) publishStatus(ctx context.Context, status subscriptionStatus) {
+	subject := config.StatusSubject(tt.Config.Name)
+	payload, err := json.Marshal(status)
+	if err != nil {
+		tt.logger.Error("Error serializing status event", "error", err)
+		return
+	}
+	meta := map[string]string{
+		"Content-Type": config.ContentType(config.FormatJSON),
+		"Gnmi-Target":  tt.Config.Name,
+	}
+	if tt.instanceID != "" {
+		meta["Collector-Id"] = tt.instanceID
+	}
+	pubCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := tt.Sink.Publish(pubCtx, subject, payload, meta); err != nil {
+		tt.logger.Error("Error publishing status event", "subject", subject, "error", err)
+	}
+}
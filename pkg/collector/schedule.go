@@ -0,0 +1,53 @@
+package collector
+
+import (
+	"context"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/cron"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	"time"
+)
+
+// runSchedule runs the once subscription req at the times of sched until
+// ctx is cancelled. The responses of each run are held back until its sync
+// response and published as a single message.
+func (tt *Target) runSchedule(ctx context.Context, req *gnmi.SubscribeRequest, name string, sched *cron.Schedule) {
+	logger := tt.logger.With("subscription", name)
+	for {
+		next := sched.Next(time.Now())
+		if next.IsZero() {
+			logger.Error("Schedule does not run again")
+			return
+		}
+		logger.Debug("Scheduled subscription", "next", next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		tt.startRun(name)
+		logger.Debug("Running scheduled subscription")
+		tt.Target.Subscribe(ctx, req, name)
+	}
+}
+
+// startRun starts holding back the responses of a run of the named
+// scheduled subscription. A previous run whose sync response is still due
+// keeps collecting instead.
+func (tt *Target) startRun(name string) {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if s, ok := tt.syncs[name]; ok && !s.ended {
+		return
+	}
+	tt.syncs[name] = &initialSync{started: time.Now()}
+}
+
+// scheduled reports whether the named running subscription has a schedule.
+func (tt *Target) scheduled(name string) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return tt.running[name].Schedule != ""
+}
@@ -0,0 +1,129 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
+	"log/slog"
+	"time"
+)
+
+// Version is reported in the self-telemetry statistics. Programs set it to
+// their own version.
+var Version = "dev"
+
+// startTime is when the process started, for the reported uptime.
+var startTime = time.Now()
+
+// collectorStats is the self-telemetry message. Counters are totals since
+// the publisher started.
+type collectorStats struct {
+	Collector     string                 `json:"collector"`
+	Version       string                 `json:"version"`
+	Timestamp     string                 `json:"timestamp"`
+	UptimeSeconds int64                  `json:"uptime_seconds"`
+	NatsConnected bool                   `json:"nats_connected"`
+	Paused        bool                   `json:"paused"`
+	DeadLettered  uint64                 `json:"dead_lettered"`
+	Targets       map[string]targetStats `json:"targets"`
+}
+
+type targetStats struct {
+	Connected          bool    `json:"connected"`
+	Subscriptions      int     `json:"subscriptions"`
+	Received           uint64  `json:"received"`
+	Published          uint64  `json:"published"`
+	PublishFailures    uint64  `json:"publish_failures"`
+	SubscriptionErrors uint64  `json:"subscription_errors"`
+	Reconnects         uint64  `json:"reconnects"`
+	RateLimited        uint64  `json:"rate_limited"`
+	QueueDropped       uint64  `json:"queue_dropped"`
+	QueueLength        int     `json:"queue_length"`
+	MessagesPerSecond  float64 `json:"messages_per_second"`
+}
+
+// ReportStats publishes collectorStats through the sink every interval
+// until the Collector is stopped.
+func (c *Collector) ReportStats() {
+	subject := c.selfTelemetry.SubjectFor(c.instanceID)
+	ticker := time.NewTicker(c.selfTelemetry.ReportInterval())
+	defer ticker.Stop()
+	slog.Info("Publishing self-telemetry", "subject", subject, "interval", c.selfTelemetry.ReportInterval())
+
+	// Published totals from the previous report, for the message rates.
+	last := make(map[string]uint64)
+	lastTime := time.Now()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case now := <-ticker.C:
+			stats := c.stats(now, last, now.Sub(lastTime))
+			lastTime = now
+			c.publishStats(subject, stats)
+		}
+	}
+}
+
+// stats gathers the current statistics. last holds the published total of
+// every target at the previous report, elapsed ago, and is updated.
+func (c *Collector) stats(now time.Time, last map[string]uint64, elapsed time.Duration) collectorStats {
+	stats := collectorStats{
+		Collector:     c.instanceID,
+		Version:       Version,
+		Timestamp:     now.UTC().Format(time.RFC3339),
+		UptimeSeconds: int64(now.Sub(startTime).Seconds()),
+		NatsConnected: c.nats != nil && c.nats.IsConnected(),
+		Paused:        c.Paused(),
+		DeadLettered:  sink.DeadLettered(),
+		Targets:       make(map[string]targetStats),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, rt := range c.targets {
+		ts := targetStats{
+			Connected:          rt.tt.connected(),
+			Received:           uint64(gnmiResponses.Total(name)),
+			Published:          uint64(natsPublishes.Total(name)),
+			PublishFailures:    uint64(natsPublishFailures.Total(name)),
+			SubscriptionErrors: uint64(gnmiErrors.Total(name)),
+			Reconnects:         uint64(gnmiReconnects.Total(name)),
+			RateLimited:        uint64(natsRateLimited.Total(name)),
+			QueueDropped:       uint64(queueDropped.Total(name)),
+			QueueLength:        len(rt.tt.queue.ch),
+		}
+		rt.tt.mu.Lock()
+		ts.Subscriptions = len(rt.tt.running)
+		rt.tt.mu.Unlock()
+		if prev, ok := last[name]; ok && elapsed > 0 && ts.Published >= prev {
+			ts.MessagesPerSecond = float64(ts.Published-prev) / elapsed.Seconds()
+		}
+		last[name] = ts.Published
+		stats.Targets[name] = ts
+	}
+	for name := range last {
+		if _, ok := c.targets[name]; !ok {
+			delete(last, name)
+		}
+	}
+	return stats
+}
+
+func (c *Collector) publishStats(subject string, stats collectorStats) {
+	payload, err := json.MarshalIndent(stats, "", " ")
+	if err != nil {
+		slog.Error("Error serializing self-telemetry", "error", err)
+		return
+	}
+	meta := map[string]string{
+		"Content-Type": config.ContentType(config.FormatJSON),
+		"Collector-Id": c.instanceID,
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, 5*time.Second)
+	defer cancel()
+	if err := c.sink.Publish(ctx, subject, payload, meta); err != nil {
+		slog.Warn("Error publishing self-telemetry", "subject", subject, "error", err)
+	}
+}
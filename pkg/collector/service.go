@@ -0,0 +1,38 @@
+package collector
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+)
+
+var errNotConnected = errors.New("target is not connected")
+
+// requestError is the reply sent when a request cannot be served.
+type requestError struct {
+	Error string `json:"error"`
+}
+
+// respond replies to msg with payload, or with a requestError when err is
+// set.
+func respond(logger *slog.Logger, msg *nats.Msg, payload []byte, err error) {
+	if err != nil {
+		payload, _ = json.Marshal(requestError{Error: err.Error()})
+	}
+	reply := &nats.Msg{Data: payload, Header: nats.Header{}}
+	reply.Header.Set("Content-Type", config.ContentType(config.FormatJSON))
+	if err := msg.RespondMsg(reply); err != nil {
+		logger.Error("Error replying to request", "error", err)
+	}
+}
+
+// connected reports whether a gNMI session with the target is up. The gNMI
+// client must not be used otherwise; it does not exist before the first
+// connection succeeds.
+func (tt *Target) connected() bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return tt.subCtx != nil
+}
@@ -0,0 +1,97 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	api "github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	"strings"
+	"time"
+)
+
+// SetRequest is the JSON body of a request on the Set subject. Updates and
+// replaces carry a path and a JSON value; deletes are plain paths.
+type SetRequest struct {
+	Prefix string `json:"prefix"`
+	// Encoding of the values, json_ietf or json. Defaults to the target's
+	// encoding.
+	Encoding string     `json:"encoding"`
+	Update   []SetValue `json:"update"`
+	Replace  []SetValue `json:"replace"`
+	Delete   []string   `json:"delete"`
+}
+
+// SetValue is a single update or replace operation.
+type SetValue struct {
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// serveSet returns a NATS handler that applies each request to the target
+// with a gNMI Set and replies with the gnmic JSON rendering of the
+// SetResponse, or a JSON object with an "error" field.
+func (tt *Target) serveSet(timeout time.Duration) nats.MsgHandler {
+	return func(msg *nats.Msg) {
+		logger := tt.logger.With("subject", msg.Subject)
+		payload, err := tt.set(msg.Data, timeout)
+		if err != nil {
+			gnmiSetRequests.Inc(tt.Config.Name, "error")
+			logger.Warn("gNMI Set failed", "error", err)
+		} else {
+			gnmiSetRequests.Inc(tt.Config.Name, "ok")
+			logger.Info("Applied gNMI Set", "request", string(msg.Data))
+		}
+		respond(logger, msg, payload, err)
+	}
+}
+
+// set applies the Set described by data and renders the response.
+func (tt *Target) set(data []byte, timeout time.Duration) ([]byte, error) {
+	var req SetRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	if len(req.Update)+len(req.Replace)+len(req.Delete) == 0 {
+		return nil, errors.New("no update, replace or delete in request")
+	}
+
+	encoding := req.Encoding
+	if encoding == "" {
+		encoding = tt.Config.Encoding
+	}
+	encoding = strings.ToLower(encoding)
+
+	var opts []api.GNMIOption
+	if req.Prefix != "" {
+		opts = append(opts, api.Prefix(req.Prefix))
+	}
+	for _, u := range req.Update {
+		opts = append(opts, api.Update(api.Path(u.Path), api.Value(u.Value, encoding)))
+	}
+	for _, r := range req.Replace {
+		opts = append(opts, api.Replace(api.Path(r.Path), api.Value(r.Value, encoding)))
+	}
+	for _, p := range req.Delete {
+		opts = append(opts, api.Delete(p))
+	}
+	setReq, err := api.NewSetRequest(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+
+	if !tt.connected() {
+		return nil, errNotConnected
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	setRsp, err := tt.Target.Set(ctx, setReq)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &formatters.MarshalOptions{Multiline: true, Indent: " "}
+	return options.Marshal(setRsp, map[string]string{"source": tt.Config.Name})
+}
@@ -0,0 +1,202 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSnapshotThreshold   = 1 << 20
+	defaultSnapshotSyncTimeout = time.Minute
+)
+
+// initialSync holds back the messages of a subscription until the device
+// signals the end of its initial sync, or of a run of a scheduled
+// subscription.
+type initialSync struct {
+	started time.Time
+	msgs    []outMsg
+	bytes   int
+	ended   bool
+}
+
+// snapshotRef is the body of the message published in place of a stored
+// snapshot.
+type snapshotRef struct {
+	Bucket   string `json:"bucket"`
+	Name     string `json:"name"`
+	Size     int    `json:"size"`
+	Messages int    `json:"messages"`
+}
+
+// holdForSync keeps m back while the initial sync of its subscription, or a
+// run of a scheduled one, is under way, and reports whether it did. tt.mu
+// must not be held.
+func (tt *Target) holdForSync(ctx context.Context, m outMsg) bool {
+	tt.mu.Lock()
+	if tt.snapshots == nil && tt.running[m.subscription].Schedule == "" {
+		tt.mu.Unlock()
+		return false
+	}
+	s, ok := tt.syncs[m.subscription]
+	if !ok {
+		s = &initialSync{started: time.Now()}
+		tt.syncs[m.subscription] = s
+	}
+	if s.ended {
+		tt.mu.Unlock()
+		return false
+	}
+	s.msgs = append(s.msgs, m)
+	s.bytes += len(m.payload)
+	expired := time.Since(s.started) > tt.syncTimeout()
+	tt.mu.Unlock()
+
+	if expired {
+		tt.logger.Warn("Initial sync did not end in time, publishing it as is", "subscription", m.subscription)
+		tt.endSync(ctx, m.subscription, false)
+	}
+	return true
+}
+
+// endSync ends the initial sync of the named subscription. The held back
+// messages are stored as a snapshot when store is set and they reach the
+// threshold. Otherwise those of a scheduled subscription are queued as a
+// single message, and the others one by one.
+func (tt *Target) endSync(ctx context.Context, subscription string, store bool) {
+	tt.mu.Lock()
+	scheduled := tt.running[subscription].Schedule != ""
+	s, ok := tt.syncs[subscription]
+	if !ok {
+		s = &initialSync{}
+		tt.syncs[subscription] = s
+	}
+	msgs, size := s.msgs, s.bytes
+	s.msgs, s.bytes, s.ended = nil, 0, true
+	tt.mu.Unlock()
+	if len(msgs) == 0 {
+		return
+	}
+
+	if store && tt.snapshots != nil && size >= tt.snapshotThreshold() {
+		ref, err := tt.storeSnapshot(ctx, subscription, msgs)
+		if err == nil {
+			tt.enqueue(ctx, ref)
+			return
+		}
+		tt.logger.Error("Could not store snapshot, publishing the initial sync as is", "subscription", subscription, "error", err)
+	}
+	if scheduled {
+		m, err := snapshotMessage(subscription, msgs)
+		if err == nil {
+			tt.enqueue(ctx, m)
+			return
+		}
+		tt.logger.Error("Could not combine the scheduled run, publishing it as is", "subscription", subscription, "error", err)
+	}
+	for _, m := range msgs {
+		tt.enqueue(ctx, m)
+	}
+}
+
+// snapshotPayload returns the JSON array of the items of msgs, flattening
+// batches, and the number of items.
+func snapshotPayload(msgs []outMsg) ([]byte, int, error) {
+	var items []json.RawMessage
+	for _, m := range msgs {
+		batch, err := sink.BatchItems(m.payload)
+		if err != nil {
+			return nil, 0, err
+		}
+		items = append(items, batch...)
+	}
+	data, err := json.Marshal(items)
+	return data, len(items), err
+}
+
+// snapshotMeta returns the headers of m for a JSON message standing in for
+// the held back messages. The ID is dropped, as it only names m.
+func snapshotMeta(m outMsg) map[string]string {
+	meta := make(map[string]string, len(m.meta)+1)
+	for k, v := range m.meta {
+		meta[k] = v
+	}
+	meta["Content-Type"] = "application/json"
+	delete(meta, "Nats-Msg-Id")
+	return meta
+}
+
+// snapshotMessage returns msgs as a single message holding the JSON array of
+// their items, with the subject and headers of the first one.
+func snapshotMessage(subscription string, msgs []outMsg) (outMsg, error) {
+	data, n, err := snapshotPayload(msgs)
+	if err != nil {
+		return outMsg{}, err
+	}
+	first := msgs[0]
+	meta := snapshotMeta(first)
+	meta["Batch-Size"] = strconv.Itoa(n)
+	return outMsg{
+		subscription: subscription,
+		subject:      first.subject,
+		payload:      data,
+		meta:         meta,
+		trace:        first.trace,
+	}, nil
+}
+
+// storeSnapshot stores msgs as a single JSON array of their items and
+// returns the message referring to it.
+func (tt *Target) storeSnapshot(ctx context.Context, subscription string, msgs []outMsg) (outMsg, error) {
+	data, _, err := snapshotPayload(msgs)
+	if err != nil {
+		return outMsg{}, err
+	}
+
+	name := fmt.Sprintf("%s/%s/%d", tt.Config.Name, subscription, time.Now().UnixNano())
+	ref, err := tt.snapshots.Put(ctx, name, data)
+	if err != nil {
+		return outMsg{}, err
+	}
+	body, err := json.Marshal(snapshotRef{
+		Bucket: tt.snapshots.Config().Bucket, Name: name, Size: len(data), Messages: len(msgs),
+	})
+	if err != nil {
+		return outMsg{}, err
+	}
+	tt.logger.Info("Stored initial sync as a snapshot", "subscription", subscription, "snapshot", ref, "bytes", len(data), "messages", len(msgs))
+
+	// The reference keeps the headers of the first message, and goes to
+	// its subject.
+	first := msgs[0]
+	meta := snapshotMeta(first)
+	meta[sink.SnapshotHeader] = ref
+	return outMsg{
+		subscription: subscription,
+		subject:      first.subject,
+		payload:      body,
+		meta:         meta,
+		trace:        first.trace,
+	}, nil
+}
+
+func (tt *Target) snapshotThreshold() int {
+	if t := tt.snapshots.Config().Threshold; t > 0 {
+		return t
+	}
+	return defaultSnapshotThreshold
+}
+
+func (tt *Target) syncTimeout() time.Duration {
+	if tt.snapshots == nil {
+		return defaultSnapshotSyncTimeout
+	}
+	if d := tt.snapshots.Config().SyncTimeout; d > 0 {
+		return d
+	}
+	return defaultSnapshotSyncTimeout
+}
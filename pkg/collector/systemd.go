@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// sdNotify sends state to the systemd notification socket, e.g. "READY=1".
+// It does nothing when the process was not started by systemd with
+// Type=notify.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if socket[0] == '@' {
+		// An abstract socket.
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval returns the watchdog timeout systemd expects this
+// process to be pinged within, or 0 when WatchdogSec is not set.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// NotifySystemd reports the Collector to systemd until it is stopped. READY
+// is sent once NATS and at least one gNMI target are connected, and the
+// watchdog is pinged only while the Collector is responsive and no target
+// has stopped publishing with messages queued, so that systemd restarts a
+// hung process.
+func (c *Collector) NotifySystemd() {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	watchdog := watchdogInterval()
+	tick := time.Second
+	if watchdog > 0 && watchdog/2 < tick {
+		tick = watchdog / 2
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	ready := false
+	status := ""
+	for {
+		select {
+		case <-c.ctx.Done():
+			if err := sdNotify("STOPPING=1"); err != nil {
+				slog.Debug("Could not notify systemd", "error", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		connected, total, healthy := c.health(watchdog)
+		natsUp := c.nats == nil || c.nats.IsConnected()
+
+		var state string
+		if !ready && natsUp && (connected > 0 || total == 0) {
+			ready = true
+			state += "READY=1\n"
+			slog.Info("Notified systemd that the publisher is ready")
+		}
+		if s := fmt.Sprintf("Collecting from %d of %d targets", connected, total); s != status {
+			status = s
+			state += "STATUS=" + s + "\n"
+		}
+		if watchdog > 0 {
+			if healthy {
+				state += "WATCHDOG=1\n"
+			} else {
+				slog.Warn("Publishing has stalled, not pinging the systemd watchdog")
+			}
+		}
+		if state == "" {
+			continue
+		}
+		if err := sdNotify(state); err != nil {
+			slog.Warn("Could not notify systemd", "error", err)
+		}
+	}
+}
+
+// health counts the running targets and those connected to their device,
+// and reports whether every target is publishing. It blocks while the
+// Collector is locked, so a deadlock stops the watchdog pings too.
+func (c *Collector) health(stallAfter time.Duration) (connected, total int, healthy bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	healthy = true
+	for _, rt := range c.targets {
+		total++
+		if rt.tt.connected() {
+			connected++
+		}
+		if stallAfter > 0 && rt.tt.stalled(stallAfter) {
+			healthy = false
+			rt.tt.logger.Warn("Queued messages have not been published", "queued", len(rt.tt.queue.ch))
+		}
+	}
+	return connected, total, healthy
+}
@@ -0,0 +1,749 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/cron"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/tracing"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/sink"
+	"github.com/openconfig/gnmi/proto/gnmi"
+	api "github.com/openconfig/gnmic/api"
+	"github.com/openconfig/gnmic/formatters"
+	target "github.com/openconfig/gnmic/target"
+	"github.com/openconfig/grpctunnel/tunnel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tracer records spans for every update from gNMI receive to NATS publish.
+// It is nil, and records nothing, unless tracing is configured.
+var tracer *tracing.Tracer
+
+// SetTracer makes every target record spans with t.
+func SetTracer(t *tracing.Tracer) {
+	tracer = t
+}
+
+// Target collects telemetry from a single device and publishes it to Sink.
+type Target struct {
+	Config   config.TargetConfig
+	Sink     sink.Sink
+	Username string
+	Password string
+	Target   *target.Target
+
+	logger     *slog.Logger
+	processors []formatters.EventProcessor
+	changes    *changeFilter
+	redactor   *redactor
+	limiter    *publishLimiter
+	queue      *publishQueue
+
+	// lastPublish is when the last queued message was handed to the sink,
+	// in Unix nanoseconds. The systemd watchdog uses it to detect a stall.
+	lastPublish atomic.Int64
+
+	// capabilitiesSubject is where the device capabilities are published
+	// after connecting; they are not published when it is empty.
+	capabilitiesSubject string
+	capabilitiesTimeout time.Duration
+
+	// instanceID identifies this publisher in message headers.
+	instanceID string
+	// deduplicate sets a Nats-Msg-Id header for JetStream deduplication.
+	deduplicate bool
+	// recorder receives the raw responses in record mode.
+	recorder Recorder
+	// snapshots stores large initial syncs when set.
+	snapshots *sink.SnapshotStore
+	// paused, when set and true, drops responses instead of publishing them.
+	paused *atomic.Bool
+	// tunnel is the tunnel server the device of a tunnel target is reached
+	// through, registered with tunnelType.
+	tunnel     *tunnel.Server
+	tunnelType string
+
+	// mu guards the subscription state below, which can be changed by a
+	// config reload while the target is collecting.
+	mu       sync.Mutex
+	subCtx   context.Context
+	wanted   []config.SubscriptionConfig
+	requests map[string]*gnmi.SubscribeRequest
+	running  map[string]config.SubscriptionConfig
+	cancels  map[string]context.CancelFunc
+	syncs    map[string]*initialSync
+	// stopped holds the wanted subscriptions stopped through the control
+	// API, which are not run until started again.
+	stopped map[string]bool
+	// restarts tracks the failed subscriptions being restarted by their
+	// restart policy, and failed those it gave up on.
+	restarts map[string]*subRestart
+	failed   map[string]bool
+	// schedules holds the parsed schedules of the scheduled subscriptions.
+	schedules map[string]*cron.Schedule
+	// polls holds the triggers of the running POLL subscriptions, whose
+	// responses and errors arrive on pollResponses and pollErrors.
+	polls         map[string]chan struct{}
+	pollResponses chan *target.SubscribeResponse
+	pollErrors    chan *target.TargetError
+	// supported holds the encodings the device supports, from the
+	// Capabilities response of the session, and is nil when unknown.
+	supported map[gnmi.Encoding]bool
+	// responseEncoding is the gRPC compression of the device's responses.
+	responseEncoding string
+}
+
+// NewTarget returns a Target for conf that publishes to out. username and
+// password are used unless the target has its own credentials.
+func NewTarget(ctx context.Context, conf config.TargetConfig, out sink.Sink, username, password string) (*Target, error) {
+	tt := &Target{
+		Config:   conf,
+		Sink:     out,
+		Username: username,
+		Password: password,
+		logger:   slog.With("target", conf.Name),
+		running:  make(map[string]config.SubscriptionConfig),
+		syncs:    make(map[string]*initialSync),
+
+		pollResponses: make(chan *target.SubscribeResponse),
+		pollErrors:    make(chan *target.TargetError),
+	}
+
+	if err := config.CheckPayloadFormat(conf.PayloadFormat); err != nil {
+		return nil, err
+	}
+	var err error
+	tt.processors, err = newEventProcessors(conf.Processors, conf.EventProcessors, tt.logger)
+	if err != nil {
+		return nil, err
+	}
+	if conf.ChangesOnly {
+		tt.changes = newChangeFilter()
+	}
+	if conf.Redact.Enabled() {
+		if tt.redactor, err = newRedactor(conf.Redact); err != nil {
+			return nil, err
+		}
+	}
+	tt.limiter = newPublishLimiter(conf.RateLimit)
+	tt.queue = newPublishQueue(conf.Queue)
+	tt.lastPublish.Store(time.Now().UnixNano())
+	if err := tt.SetSubscriptions(conf.SubscriptionConfigs()); err != nil {
+		return nil, err
+	}
+
+	// Tunnel targets are dialed by name.
+	address := tt.Config.Address
+	if tt.Config.Tunnel && address == "" {
+		address = tt.Config.Name
+	}
+	opts := []api.TargetOption{
+		api.Name(tt.Config.Name),
+		api.Address(address),
+		api.Username(username),
+		api.Password(password),
+		api.Insecure(tt.Config.IsInsecure()),
+		api.SkipVerify(tt.Config.SkipsVerify()),
+	}
+
+	// Mutual TLS: the CA verifies the device, the client certificate and key
+	// identify the Collector to it.
+	if tt.Config.TLSCA != "" {
+		opts = append(opts, api.TLSCA(tt.Config.TLSCA))
+	}
+	if tt.Config.TLSCert != "" {
+		opts = append(opts, api.TLSCert(tt.Config.TLSCert), api.TLSKey(tt.Config.TLSKey))
+	}
+	if tt.Config.TLSMinVersion != "" {
+		opts = append(opts, api.TLSMinVersion(tt.Config.TLSMinVersion))
+	}
+	if tt.Config.TLSMaxVersion != "" {
+		opts = append(opts, api.TLSMaxVersion(tt.Config.TLSMaxVersion))
+	}
+	if tt.Config.DialTimeout > 0 {
+		opts = append(opts, api.Timeout(tt.Config.DialTimeout))
+	}
+
+	tt.Target, err = api.NewTarget(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating target: %w", err)
+	}
+
+	return tt, nil
+}
+
+// dialOptions returns the gRPC options for the connection to the device
+// that gnmic does not set itself.
+func (tt *Target) dialOptions() []grpc.DialOption {
+	var opts []grpc.DialOption
+	if ka := tt.Config.Keepalive; ka.Enabled() {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:    ka.Time,
+			Timeout: ka.Timeout,
+			// Subscriptions are long-lived streams, but a session between
+			// them must not go unchecked either.
+			PermitWithoutStream: true,
+		}))
+	}
+	if tt.Config.MaxMsgSize > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(tt.Config.MaxMsgSize)))
+	}
+	if codec := tt.Config.GRPCCompressionCodec(); codec != "" {
+		opts = append(opts, grpc.WithDefaultCallOptions(grpc.UseCompressor(codec)))
+	}
+	return append(opts, grpc.WithStatsHandler(compressionStats{tt: tt}))
+}
+
+func newSubscribeRequest(sc config.SubscriptionConfig) (*gnmi.SubscribeRequest, error) {
+	subOpts := []api.GNMIOption{
+		api.Path(sc.XPath),
+		api.SubscriptionMode(sc.SubscriptionMode),
+	}
+	// ON_CHANGE subscriptions are driven by the device, so a sample interval
+	// does not apply; the heartbeat forces a periodic update of unchanged
+	// values.
+	if !isOnChange(sc.SubscriptionMode) {
+		subOpts = append(subOpts, api.SampleInterval(time.Duration(sc.SampleInterval)*time.Second))
+	}
+	if sc.HeartbeatInterval > 0 {
+		subOpts = append(subOpts, api.HeartbeatInterval(time.Duration(sc.HeartbeatInterval)*time.Second))
+	}
+	// suppress_redundant only means something to SAMPLE subscriptions, so it
+	// is left out of the others even when inherited from the target.
+	if sc.SuppressesRedundant() && isSample(sc.SubscriptionMode) {
+		subOpts = append(subOpts, api.SuppressRedundant(true))
+	}
+
+	opts := []api.GNMIOption{
+		api.Encoding(sc.Encoding),
+		api.SubscriptionListMode(sc.ListMode),
+		api.Subscription(subOpts...),
+	}
+	// The device then skips the initial sync and only sends changes.
+	if sc.UpdatesOnly {
+		opts = append(opts, api.UpdatesOnly(true))
+	}
+	if sc.Qos != nil {
+		opts = append(opts, api.Qos(*sc.Qos))
+	}
+	if sc.AllowAggregation {
+		opts = append(opts, api.AllowAggregation(true))
+	}
+	extOpts, err := extensionOptions(sc.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	return api.NewSubscribeRequest(append(opts, extOpts...)...)
+}
+
+func isSample(mode string) bool {
+	return strings.EqualFold(mode, "sample")
+}
+
+func isOnChange(mode string) bool {
+	switch strings.ToLower(mode) {
+	case "on_change", "on-change":
+		return true
+	}
+	return false
+}
+
+// SetSubscriptions replaces the set of subscriptions the target runs. Once
+// the target is collecting, only subscriptions that were added, removed or
+// changed are started or stopped. Nothing changes if any request is invalid.
+func (tt *Target) SetSubscriptions(subs []config.SubscriptionConfig) error {
+	requests := make(map[string]*gnmi.SubscribeRequest, len(subs))
+	schedules := make(map[string]*cron.Schedule)
+	for _, sc := range subs {
+		subReq, err := newSubscribeRequest(sc)
+		if err != nil {
+			return fmt.Errorf("error creating subscribe request %q: %w", sc.Name, err)
+		}
+		requests[sc.Name] = subReq
+		if sc.Schedule != "" {
+			if schedules[sc.Name], err = cron.Parse(sc.Schedule); err != nil {
+				return fmt.Errorf("subscription %q: %w", sc.Name, err)
+			}
+		}
+	}
+
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	tt.wanted = subs
+	tt.requests = requests
+	tt.schedules = schedules
+	for name := range tt.stopped {
+		if _, ok := requests[name]; !ok {
+			delete(tt.stopped, name)
+		}
+	}
+	tt.failed = nil
+	if tt.subCtx != nil {
+		tt.applySubscriptions()
+	}
+	return nil
+}
+
+// applySubscriptions brings the running subscriptions in line with the
+// wanted ones. tt.mu must be held.
+func (tt *Target) applySubscriptions() {
+	wanted := make(map[string]config.SubscriptionConfig, len(tt.wanted))
+	for _, sc := range tt.wanted {
+		if !tt.held(sc.Name) {
+			wanted[sc.Name] = sc
+		}
+	}
+
+	for name, running := range tt.running {
+		if sc, ok := wanted[name]; ok && reflect.DeepEqual(sc, running) {
+			continue
+		}
+		tt.stopSubscription(name)
+		tt.logger.Info("Stopped subscription", "subscription", name)
+	}
+
+	for _, sc := range tt.wanted {
+		if _, ok := tt.running[sc.Name]; ok || tt.held(sc.Name) {
+			continue
+		}
+		// gnmic retries a failed subscription until its context is
+		// cancelled, so each gets its own.
+		ctx, cancel := context.WithCancel(tt.subCtx)
+		if tt.cancels == nil {
+			tt.cancels = make(map[string]context.CancelFunc)
+		}
+		tt.cancels[sc.Name] = cancel
+		tt.running[sc.Name] = sc
+		req := tt.requests[sc.Name]
+		if enc := tt.encoding(sc); enc != sc.Encoding {
+			req = withEncoding(req, enc)
+			tt.logger.Info("Device does not support the encoding, falling back", "subscription", sc.Name, "configured", sc.Encoding, "encoding", enc)
+		}
+		switch {
+		case isPoll(sc.ListMode):
+			tt.startPoll(ctx, req, sc.Name)
+		case tt.schedules[sc.Name] != nil:
+			go tt.runSchedule(ctx, req, sc.Name, tt.schedules[sc.Name])
+		default:
+			go tt.Target.Subscribe(ctx, req, sc.Name)
+		}
+		tt.logger.Info("Started subscription", "subscription", sc.Name, "path", sc.XPath)
+	}
+}
+
+// stopSubscription cancels the named running subscription. tt.mu must be
+// held.
+func (tt *Target) stopSubscription(name string) {
+	if cancel, ok := tt.cancels[name]; ok {
+		cancel()
+		delete(tt.cancels, name)
+	}
+	delete(tt.running, name)
+	delete(tt.syncs, name)
+	delete(tt.polls, name)
+}
+
+// held reports whether the named subscription is kept from running: it was
+// stopped, given up on, or waits to be restarted. tt.mu must be held.
+func (tt *Target) held(name string) bool {
+	r := tt.restarts[name]
+	return tt.stopped[name] || tt.failed[name] || (r != nil && r.pending)
+}
+
+// setStopped stops the named subscription, or starts it again, for as long
+// as the target runs. Reloads keep a stopped subscription stopped.
+func (tt *Target) setStopped(name string, stopped bool) error {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	if _, ok := tt.requests[name]; !ok {
+		return fmt.Errorf("no subscription %q", name)
+	}
+	if stopped {
+		if tt.stopped == nil {
+			tt.stopped = make(map[string]bool)
+		}
+		tt.stopped[name] = true
+	} else {
+		delete(tt.stopped, name)
+		delete(tt.failed, name)
+	}
+	if tt.subCtx != nil {
+		tt.applySubscriptions()
+	}
+	return nil
+}
+
+// subscription returns the config of the named running subscription.
+func (tt *Target) subscription(name string) config.SubscriptionConfig {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	return tt.running[name]
+}
+
+// collectTelemetry runs gNMI sessions against the target until ctx is
+// cancelled. Whenever a session fails the gNMI client is recreated and the
+// subscriptions restarted, waiting with exponential backoff between attempts.
+func collectTelemetry(ctx context.Context, tt *Target) error {
+	// Check if the Target and its internal Target are non-nil and initialized.
+	if tt == nil || tt.Target == nil {
+		return fmt.Errorf("telemetry target or its internal target is not properly initialized")
+	}
+
+	// Publish from a separate goroutine so a slow sink does not hold up
+	// reading from the device; the queue absorbs the difference.
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		tt.RunPublisher(ctx)
+	}()
+	defer func() { <-published }()
+
+	retry := newBackoff(tt.Config.Reconnect)
+	for {
+		received, err := tt.runSession(ctx)
+		if ctx.Err() != nil {
+			// Context cancelled, exit function.
+			return nil
+		}
+		if received {
+			// The session worked for a while, so start over with short delays.
+			retry.reset()
+		}
+
+		delay := retry.next()
+		gnmiReconnects.Inc(tt.Config.Name)
+		tt.logger.Warn("gNMI session failed, reconnecting", "error", err, "retry_in", delay)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// runSession connects to the target, starts the subscriptions and publishes
+// their responses until one of them fails or ctx is cancelled. It reports
+// whether any response was received.
+func (tt *Target) runSession(ctx context.Context) (bool, error) {
+	sessCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Look the credentials up for every session so rotated secrets are
+	// picked up on reconnect. Targets with their own credentials override
+	// the defaults from the environment.
+	username, password, err := tt.Config.Credentials.Resolve(sessCtx, tt.Username, tt.Password)
+	if err != nil {
+		return false, fmt.Errorf("error resolving credentials: %w", err)
+	}
+	tt.Target.Config.Username = &username
+	tt.Target.Config.Password = &password
+
+	dialOpts := tt.dialOptions()
+	if tt.Config.Tunnel {
+		dialOpts = append(dialOpts, grpc.WithContextDialer(tt.dialTunnel))
+	} else if tt.Config.Proxy.Enabled() {
+		dialer, err := newProxyDialer(sessCtx, tt.Config.Proxy, tt.logger)
+		if err != nil {
+			return false, err
+		}
+		defer dialer.Close()
+		tt.logger.Debug("Connecting through proxy", "proxy", dialer.String())
+		dialOpts = append(dialOpts, grpc.WithContextDialer(dialer.DialContext))
+	}
+
+	// Ensure that a GNMI client is created before subscribing.
+	if err := tt.Target.CreateGNMIClient(sessCtx, dialOpts...); err != nil {
+		return false, fmt.Errorf("error creating GNMI client: %w", err)
+	}
+	tt.checkCapabilities(sessCtx)
+
+	// Start each subscription in its own goroutine.
+	tt.mu.Lock()
+	tt.subCtx = sessCtx
+	tt.applySubscriptions()
+	tt.mu.Unlock()
+
+	// Stop the subscriptions and close the connection when the session ends,
+	// so the next one starts from scratch.
+	defer func() {
+		tt.mu.Lock()
+		tt.subCtx = nil
+		tt.running = make(map[string]config.SubscriptionConfig)
+		tt.syncs = make(map[string]*initialSync)
+		tt.cancels = nil
+		tt.restarts, tt.failed = nil, nil
+		tt.supported = nil
+		tt.polls = nil
+		tt.mu.Unlock()
+		if err := tt.Target.Close(); err != nil {
+			tt.logger.Debug("Error closing target", "error", err)
+		}
+	}()
+
+	// Read subscriptions and handle responses or errors. POLL subscriptions
+	// report on their own channels.
+	received := false
+	subRspChan, subErrChan := tt.Target.ReadSubscriptions()
+	for {
+		var rsp *target.SubscribeResponse
+		var tgErr *target.TargetError
+		select {
+		case rsp = <-subRspChan:
+		case rsp = <-tt.pollResponses:
+		case tgErr = <-subErrChan:
+		case tgErr = <-tt.pollErrors:
+		case <-ctx.Done():
+			return received, nil
+		}
+		if rsp != nil {
+			received = true
+			tt.subscriptionWorked(rsp.SubscriptionName)
+			tt.HandleResponse(ctx, rsp)
+			continue
+		}
+		// Errors from subscriptions stopped on purpose (reload or a
+		// previous session) are expected, and so are the notices gnmic
+		// sends after every error when it retries.
+		if isCanceled(tgErr.Err) || isRetryNotice(tgErr.Err) || !tt.isRunning(tgErr.SubscriptionName) {
+			continue
+		}
+		gnmiErrors.Inc(tt.Config.Name, tgErr.SubscriptionName)
+		if err := tt.subscriptionFailed(sessCtx, tgErr.SubscriptionName, tgErr.Err); err != nil {
+			return received, err
+		}
+	}
+}
+
+// HandleResponse encodes a subscription response and queues it for
+// publishing.
+func (tt *Target) HandleResponse(ctx context.Context, rsp *target.SubscribeResponse) {
+	// Processing subscription response...
+	logger := tt.logger.With("subscription", rsp.SubscriptionName)
+	gnmiResponses.Inc(tt.Config.Name, rsp.SubscriptionName)
+	// Drop responses while paused before they reach changes_only, which
+	// would otherwise take their values as published.
+	if tt.paused != nil && tt.paused.Load() {
+		pausedDropped.Inc(tt.Config.Name)
+		return
+	}
+	if notif := rsp.Response.GetUpdate(); notif != nil && tt.redactor != nil {
+		if n := tt.redactor.apply(notif); n > 0 {
+			gnmiValuesRedacted.Add(float64(n), tt.Config.Name, rsp.SubscriptionName)
+		}
+		if len(notif.Update) == 0 && len(notif.Delete) == 0 {
+			return
+		}
+	}
+	if tt.recorder != nil {
+		if err := tt.recorder.Record(tt.Config.Name, rsp.SubscriptionName, time.Now(), rsp.Response); err != nil {
+			logger.Error("Error recording response", "error", err)
+		}
+		return
+	}
+	if rsp.Response.GetSyncResponse() && (tt.snapshots != nil || tt.scheduled(rsp.SubscriptionName)) {
+		tt.endSync(ctx, rsp.SubscriptionName, true)
+	}
+	ctx, span := tracer.Start(ctx, "gnmi.update", tracing.KindConsumer,
+		tracing.String("gnmi.target", tt.Config.Name),
+		tracing.String("gnmi.subscription", rsp.SubscriptionName))
+	defer span.End()
+	if notif := rsp.Response.GetUpdate(); notif != nil {
+		span.SetAttributes(tracing.Int("gnmi.timestamp", notif.GetTimestamp()),
+			tracing.Int("gnmi.updates", int64(len(notif.GetUpdate()))))
+	}
+	if notif := rsp.Response.GetUpdate(); notif != nil && tt.changes != nil {
+		if n := tt.changes.filter(rsp.SubscriptionName, notif); n > 0 {
+			gnmiUpdatesSuppressed.Add(float64(n), tt.Config.Name, rsp.SubscriptionName)
+		}
+		if len(notif.Update) == 0 && len(notif.Delete) == 0 {
+			return
+		}
+	}
+	subjects := []string{tt.subscription(rsp.SubscriptionName).Topic}
+	rsps := []*target.SubscribeResponse{rsp}
+	if tt.Config.PathSubjects {
+		subjects, rsps = splitByPath(subjects[0], rsp)
+	}
+	for i := range rsps {
+		tt.queueResponse(ctx, span, rsps[i], subjects[i])
+	}
+}
+
+// queueResponse encodes rsp and queues it for publishing on subject.
+func (tt *Target) queueResponse(ctx context.Context, span *tracing.Span, rsp *target.SubscribeResponse, subject string) {
+	logger := tt.logger.With("subscription", rsp.SubscriptionName)
+	meta := map[string]string{
+		"source":            tt.Config.Name,
+		"subscription-name": rsp.SubscriptionName,
+	}
+	_, transform := tracer.Start(ctx, "transform", tracing.KindInternal,
+		tracing.String("payload.format", tt.Config.PayloadFormat))
+	payload, err := marshalResponse(tt.Config.PayloadFormat, rsp.Response, meta, tt.processors...)
+	if err == nil && tt.Config.PathKeyTags && config.IsJSONFormat(tt.Config.PayloadFormat) {
+		payload, err = addPathKeyTags(payload, rsp.Response, " ")
+	}
+	transform.RecordError(err)
+	transform.End()
+	if err != nil {
+		logger.Error("Error serializing response", "error", err)
+		span.RecordError(err)
+		return
+	}
+
+	if len(payload) == 0 {
+		return
+	}
+
+	if tt.Config.PayloadFormat != config.FormatProto {
+		logger.Debug("Received update", "payload", string(payload))
+	} else {
+		logger.Debug("Received update", "bytes", len(payload))
+	}
+	if tt.limiter != nil && !tt.limiter.allow(len(payload)) {
+		natsRateLimited.Inc(tt.Config.Name, tt.metricSubject(rsp.SubscriptionName, subject))
+		logger.Debug("Dropped update over the rate limit", "subject", subject)
+		return
+	}
+	m := outMsg{
+		subscription: rsp.SubscriptionName,
+		subject:      subject,
+		payload:      payload,
+		meta:         tt.headers(rsp),
+		trace:        span.Context(),
+	}
+	if tt.holdForSync(ctx, m) {
+		return
+	}
+	tt.enqueue(ctx, m)
+}
+
+// headers returns the metadata sent along with the payload of rsp, so
+// subscribers can route and filter messages without decoding them.
+func (tt *Target) headers(rsp *target.SubscribeResponse) map[string]string {
+	h := map[string]string{
+		"Content-Type":      config.ContentType(tt.Config.PayloadFormat),
+		"Gnmi-Target":       tt.Config.Name,
+		"Gnmi-Subscription": rsp.SubscriptionName,
+	}
+	if enc := tt.subscriptionEncoding(rsp.SubscriptionName); enc != "" {
+		h["Gnmi-Encoding"] = strings.ToLower(enc)
+	}
+	if notif := rsp.Response.GetUpdate(); notif != nil {
+		h["Gnmi-Timestamp"] = strconv.FormatInt(notif.GetTimestamp(), 10)
+		if tt.deduplicate {
+			h["Nats-Msg-Id"] = messageID(tt.Config.Name, rsp.SubscriptionName, notif)
+		}
+	}
+	addExtensionHeaders(h, rsp.Response.GetExtension())
+	if tt.instanceID != "" {
+		h["Collector-Id"] = tt.instanceID
+	}
+	return h
+}
+
+// publish sends a queued message to the sink, on its subject and on the
+// extra_topics of its subscription. The copies get their own
+// Nats-Msg-Id so a stream capturing several of the subjects keeps them all.
+func (tt *Target) publish(ctx context.Context, m outMsg) {
+	msgs := []outMsg{m}
+	for _, subject := range tt.subscription(m.subscription).ExtraTopics {
+		c := m
+		c.subject = subject
+		c.meta = make(map[string]string, len(m.meta))
+		for k, v := range m.meta {
+			c.meta[k] = v
+		}
+		if id, ok := c.meta["Nats-Msg-Id"]; ok {
+			c.meta["Nats-Msg-Id"] = id + ":" + subject
+		}
+		msgs = append(msgs, c)
+	}
+	for _, m := range msgs {
+		tt.send(ctx, m)
+	}
+}
+
+// send publishes m on its subject.
+func (tt *Target) send(ctx context.Context, m outMsg) {
+	ctx, span := tracer.Start(tracing.ContextWithSpanContext(ctx, m.trace), "nats.publish", tracing.KindProducer,
+		tracing.String("messaging.destination.name", m.subject),
+		tracing.Int("messaging.message.body.size", int64(len(m.payload))))
+	defer span.End()
+	if tp := tracing.Traceparent(ctx); tp != "" {
+		m.meta[tracing.Header] = tp
+	}
+
+	publishCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	err := tt.Sink.Publish(publishCtx, m.subject, m.payload, m.meta)
+	cancel() // Ensure to cancel the context after use to release resources.
+	tt.lastPublish.Store(time.Now().UnixNano())
+	span.RecordError(err)
+	if err != nil {
+		natsPublishFailures.Inc(tt.Config.Name, tt.metricSubject(m.subscription, m.subject))
+		tt.logger.Error("Error publishing", "subscription", m.subscription, "subject", m.subject, "error", err)
+	} else {
+		natsPublishes.Inc(tt.Config.Name, tt.metricSubject(m.subscription, m.subject))
+		natsPublishedBytes.Add(float64(len(m.payload)), tt.Config.Name)
+	}
+}
+
+// metricSubject returns the subject a message is counted under in the
+// metrics: with path_subjects, the subject of its subscription rather than
+// the one derived from the update path, which would make a series per path.
+func (tt *Target) metricSubject(subscription, subject string) string {
+	if !tt.Config.PathSubjects {
+		return subject
+	}
+	if topic := tt.subscription(subscription).Topic; topic != "" {
+		return topic
+	}
+	return subject
+}
+
+// isRunning reports whether the named subscription is part of the current
+// session.
+func (tt *Target) isRunning(name string) bool {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	_, ok := tt.running[name]
+	return ok
+}
+
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || status.Code(err) == codes.Canceled
+}
+
+func isRetryNotice(err error) bool {
+	return strings.HasPrefix(err.Error(), "retrying in ")
+}
+
+// NewOfflineTarget returns a Target that is fed responses directly
+// instead of from a gNMI session, for replaying captures and benchmarks. The
+// caller runs its publisher.
+func NewOfflineTarget(ctx context.Context, tc config.TargetConfig, out sink.Sink, conf config.Config) (*Target, error) {
+	tt, err := NewTarget(ctx, tc, out, "", "")
+	if err != nil {
+		return nil, err
+	}
+	tt.instanceID = conf.CollectorID()
+	tt.deduplicate = conf.JetStream.Enabled && conf.JetStream.Deduplicate
+	// No session is started, so mark every subscription as running for
+	// handleResponse to find its subject and encoding.
+	tt.mu.Lock()
+	for _, sc := range tt.wanted {
+		tt.running[sc.Name] = sc
+	}
+	tt.mu.Unlock()
+	return tt, nil
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// dirState summarises the target files in dir so changes can be detected
+// without parsing them.
+func dirState(dir string) (string, error) {
+	files, err := config.TargetFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, f := range files {
+		info, err := f.Info()
+		if err != nil {
+			// The file was removed since the directory was read.
+			continue
+		}
+		fmt.Fprintf(&b, "%s %d %d\n", f.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
+
+// WatchTargetsDir reloads the configuration with load whenever a file in the
+// targets directory is added, changed or removed, and applies the new target
+// list. A file that fails to parse is not read again until it changes.
+func (c *Collector) WatchTargetsDir(conf config.TargetsDirConfig, load func() (config.Config, error)) {
+	last, err := dirState(conf.Path)
+	if err != nil {
+		slog.Error("Could not watch targets directory", "path", conf.Path, "error", err)
+	}
+	ticker := time.NewTicker(conf.PollInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		state, err := dirState(conf.Path)
+		if err != nil {
+			slog.Warn("Could not read targets directory", "path", conf.Path, "error", err)
+			continue
+		}
+		if state == last {
+			continue
+		}
+		last = state
+		newConf, err := load()
+		if err != nil {
+			slog.Error("Could not reload targets", "path", conf.Path, "error", err)
+			continue
+		}
+		slog.Info("Targets directory changed, reloading", "path", conf.Path)
+		c.Apply(newConf.TargetConfigs())
+	}
+}
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	tpb "github.com/openconfig/grpctunnel/proto/tunnel"
+	"github.com/openconfig/grpctunnel/tunnel"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"log/slog"
+	"net"
+	"os"
+)
+
+// ServeTunnel runs the gRPC tunnel server of conf until the Collector is
+// stopped. Targets with tunnel set only run while their device is
+// registered on it, so ServeTunnel must be called before the first Apply.
+func (c *Collector) ServeTunnel(conf config.TunnelServerConfig) error {
+	var opts []grpc.ServerOption
+	if conf.TLSCert != "" {
+		tlsConf, err := tunnelTLS(conf)
+		if err != nil {
+			return fmt.Errorf("invalid tunnel_server TLS settings: %w", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+
+	ts, err := tunnel.NewServer(tunnel.ServerConfig{
+		AddTargetHandler: func(t tunnel.Target) error {
+			return c.tunnelRegistered(t, conf.Type(), true)
+		},
+		DeleteTargetHandler: func(t tunnel.Target) error {
+			return c.tunnelRegistered(t, conf.Type(), false)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", conf.Address)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer(opts...)
+	tpb.RegisterTunnelServer(srv, ts)
+
+	c.mu.Lock()
+	c.tunnel = ts
+	c.tunnelType = conf.Type()
+	c.registered = make(map[string]bool)
+	c.mu.Unlock()
+
+	slog.Info("Serving gRPC tunnel", "address", l.Addr().String())
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		if err := srv.Serve(l); err != nil {
+			slog.Error("gRPC tunnel server failed", "error", err)
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-c.ctx.Done():
+				srv.Stop()
+				return
+			case err := <-ts.ErrorChan():
+				slog.Debug("gRPC tunnel error", "error", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// tunnelTLS returns the TLS settings of the tunnel server.
+func tunnelTLS(conf config.TunnelServerConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(conf.TLSCert, conf.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if conf.TLSCA != "" {
+		pem, err := os.ReadFile(conf.TLSCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", conf.TLSCA)
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConf, nil
+}
+
+// tunnelRegistered starts or stops the target a device registered or
+// removed on the tunnel server. Targets of other types are ignored.
+func (c *Collector) tunnelRegistered(t tunnel.Target, targetType string, registered bool) error {
+	logger := slog.With("target", t.ID, "type", t.Type)
+	if t.Type != targetType {
+		logger.Debug("Ignoring tunnel target of another type")
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if registered {
+		c.registered[t.ID] = true
+		tunnelConnected.Set(1, t.ID)
+	} else {
+		delete(c.registered, t.ID)
+		tunnelConnected.Set(0, t.ID)
+	}
+	known := false
+	for _, tc := range c.applied {
+		if tc.Tunnel && tc.Name == t.ID {
+			known = true
+			break
+		}
+	}
+	switch {
+	case !known && registered:
+		logger.Warn("Device registered on the tunnel is not a tunnel target")
+	case registered:
+		logger.Info("Device connected through the tunnel")
+	default:
+		logger.Info("Device disconnected from the tunnel")
+	}
+	if !c.standby {
+		c.reconcile(c.applied)
+	}
+	return nil
+}
+
+// awaitingTunnel reports whether tc is a tunnel target whose device is not
+// registered on the tunnel server. c.mu must be held.
+func (c *Collector) awaitingTunnel(tc config.TargetConfig) bool {
+	return tc.Tunnel && !c.registered[tc.Name]
+}
+
+// dialTunnel opens a connection to the device of the target through the
+// tunnel it registered on.
+func (tt *Target) dialTunnel(ctx context.Context, _ string) (net.Conn, error) {
+	if tt.tunnel == nil {
+		return nil, errors.New("no tunnel server")
+	}
+	conn, err := tunnel.ServerConn(ctx, tt.tunnel, &tunnel.Target{ID: tt.Config.Name, Type: tt.tunnelType})
+	if err != nil {
+		tt.logger.Warn("Could not connect through the tunnel", "error", err)
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,10 @@
+package config
+
+import "time"
+
+// BackoffConfig controls the delay between gNMI reconnection attempts.
+type BackoffConfig struct {
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	Multiplier      float64       `yaml:"multiplier"`
+}
@@ -0,0 +1,798 @@
+// Package config defines the publisher configuration file and reads it.
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/cron"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/envsubst"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/natsopts"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/tracing"
+	"github.com/nats-io/nats.go"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// TargetConfig holds the gNMI connection and subscription settings for a
+// single device.
+type TargetConfig struct {
+	Name              string `yaml:"name"`
+	Address           string `yaml:"address"`
+	Insecure          *bool  `yaml:"insecure"`
+	SkipVerify        *bool  `yaml:"skipVerify"`
+	Gzip              *bool  `yaml:"gzip"`
+	GRPCCompression   string `yaml:"grpc_compression"`
+	TLSCA             string `yaml:"tls_ca"`
+	TLSCert           string `yaml:"tls_cert"`
+	TLSKey            string `yaml:"tls_key"`
+	TLSMinVersion     string `yaml:"tls_min_version"`
+	TLSMaxVersion     string `yaml:"tls_max_version"`
+	Topic             string `yaml:"telemetry_topic"`
+	XPath             string `yaml:"gnmi_xpath"`
+	Encoding          string `yaml:"encoding"`
+	ListMode          string `yaml:"listmode"`
+	SubscriptionMode  string `yaml:"subscription_mode"`
+	SampleInterval    int    `yaml:"sample_interval"`
+	HeartbeatInterval int    `yaml:"heartbeat_interval"`
+	SuppressRedundant *bool  `yaml:"suppress_redundant"`
+	UpdatesOnly       bool   `yaml:"updates_only"`
+	Schedule          string `yaml:"schedule"`
+	PayloadFormat     string `yaml:"payload_format"`
+	PathKeyTags       bool   `yaml:"path_key_tags"`
+	PathSubjects      bool   `yaml:"path_subjects"`
+	ChangesOnly       bool   `yaml:"changes_only"`
+	Tunnel            bool   `yaml:"tunnel"`
+
+	// Qos is the DSCP value the device is asked to mark its telemetry
+	// with, and AllowAggregation lets it aggregate values marked as
+	// eligible, both in every SubscribeRequest.
+	Qos              *uint32 `yaml:"qos"`
+	AllowAggregation bool    `yaml:"allow_aggregation"`
+
+	// EncodingFallback lists, in order of preference, the encodings used
+	// for the subscriptions whose encoding the device does not support
+	// according to its Capabilities response.
+	EncodingFallback []string `yaml:"encoding_fallback"`
+
+	Credentials   Credentials          `yaml:"credentials"`
+	Reconnect     BackoffConfig        `yaml:"reconnect"`
+	DialTimeout   time.Duration        `yaml:"dial_timeout"`
+	Keepalive     KeepaliveConfig      `yaml:"keepalive"`
+	MaxMsgSize    int                  `yaml:"max_msg_size"`
+	Proxy         ProxyConfig          `yaml:"proxy"`
+	Restart       RestartConfig        `yaml:"restart"`
+	RateLimit     RateLimitConfig      `yaml:"rate_limit"`
+	Queue         QueueConfig          `yaml:"queue"`
+	Redact        RedactConfig         `yaml:"redact"`
+	Extensions    []ExtensionConfig    `yaml:"extensions"`
+	Subscriptions []SubscriptionConfig `yaml:"subscriptions"`
+	// SubjectMap routes subscriptions by their gnmi_xpath: a subscription
+	// without its own telemetry_topic publishes on the subject of the
+	// longest xpath prefix it matches.
+	SubjectMap map[string]string `yaml:"subject_map"`
+	// ExtraTopics are subjects every message is also published on, such as
+	// a firehose subject next to the per-device ones.
+	ExtraTopics []string `yaml:"extra_topics"`
+
+	// Processors defines event processors by name; EventProcessors lists
+	// the ones applied, in order, to every update before it is published.
+	Processors      ProcessorConfigs `yaml:"processors"`
+	EventProcessors []string         `yaml:"event_processors"`
+}
+
+// SubscriptionConfig describes one gNMI subscription on a target. Each entry
+// is sent as its own SubscribeRequest so paths can use different encodings
+// and modes on the same device.
+type SubscriptionConfig struct {
+	Name              string   `yaml:"name"`
+	XPath             string   `yaml:"gnmi_xpath"`
+	Topic             string   `yaml:"telemetry_topic"`
+	Encoding          string   `yaml:"encoding"`
+	ListMode          string   `yaml:"listmode"`
+	SubscriptionMode  string   `yaml:"subscription_mode"`
+	SampleInterval    int      `yaml:"sample_interval"`
+	HeartbeatInterval int      `yaml:"heartbeat_interval"`
+	SuppressRedundant *bool    `yaml:"suppress_redundant"`
+	UpdatesOnly       bool     `yaml:"updates_only"`
+	Qos               *uint32  `yaml:"qos"`
+	AllowAggregation  bool     `yaml:"allow_aggregation"`
+	ExtraTopics       []string `yaml:"extra_topics"`
+	// Restart is the restart policy of the subscription when it fails.
+	Restart RestartConfig `yaml:"restart"`
+	// Extensions are the gNMI extensions sent with the SubscribeRequest.
+	Extensions []ExtensionConfig `yaml:"extensions"`
+	// Schedule runs a once subscription at the times of a cron-style
+	// schedule instead of once per session.
+	Schedule string `yaml:"schedule"`
+}
+
+// MaxDSCP is the highest DSCP value, the largest qos.
+const MaxDSCP = 63
+
+// gnmiEncodings are the encodings defined by gNMI.
+var gnmiEncodings = map[string]bool{
+	"json":      true,
+	"bytes":     true,
+	"proto":     true,
+	"ascii":     true,
+	"json_ietf": true,
+}
+
+// onceSchedule returns the schedule a subscription with listMode inherits
+// from t. A schedule only applies to once subscriptions, so one set for the
+// target or the whole file leaves the streams alone.
+func (t TargetConfig) onceSchedule(listMode string) string {
+	if !isOnce(listMode) {
+		return ""
+	}
+	return t.Schedule
+}
+
+func isOnce(listMode string) bool {
+	return strings.EqualFold(listMode, "once")
+}
+
+// SuppressesRedundant reports whether unchanged values should be suppressed.
+func (s SubscriptionConfig) SuppressesRedundant() bool {
+	return isTrue(s.SuppressRedundant)
+}
+
+// IsInsecure reports whether the device is reached without TLS.
+func (t TargetConfig) IsInsecure() bool {
+	return isTrue(t.Insecure)
+}
+
+// SkipsVerify reports whether the device certificate is not verified.
+func (t TargetConfig) SkipsVerify() bool {
+	return isTrue(t.SkipVerify)
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+// SubscriptionConfigs returns the subscriptions to run on the target. Unset
+// fields are inherited from the target, and a target without a subscriptions
+// list gets a single subscription built from its own gnmi_xpath.
+func (t TargetConfig) SubscriptionConfigs() []SubscriptionConfig {
+	if len(t.Subscriptions) == 0 {
+		return []SubscriptionConfig{{
+			Name:              "sub1",
+			XPath:             t.XPath,
+			Topic:             t.topicFor(t.XPath),
+			Encoding:          t.Encoding,
+			ListMode:          t.ListMode,
+			SubscriptionMode:  t.SubscriptionMode,
+			SampleInterval:    t.SampleInterval,
+			HeartbeatInterval: t.HeartbeatInterval,
+			SuppressRedundant: t.SuppressRedundant,
+			UpdatesOnly:       t.UpdatesOnly,
+			Schedule:          t.onceSchedule(t.ListMode),
+			Qos:               t.Qos,
+			AllowAggregation:  t.AllowAggregation,
+			ExtraTopics:       t.ExtraTopics,
+			Restart:           t.Restart,
+			Extensions:        t.Extensions,
+		}}
+	}
+
+	subs := make([]SubscriptionConfig, 0, len(t.Subscriptions))
+	for i, s := range t.Subscriptions {
+		if s.Name == "" {
+			s.Name = fmt.Sprintf("sub%d", i+1)
+		}
+		if s.Topic == "" {
+			s.Topic = t.topicFor(s.XPath)
+		}
+		if s.Encoding == "" {
+			s.Encoding = t.Encoding
+		}
+		if s.ListMode == "" {
+			s.ListMode = t.ListMode
+		}
+		if s.SubscriptionMode == "" {
+			s.SubscriptionMode = t.SubscriptionMode
+		}
+		if s.SampleInterval == 0 {
+			s.SampleInterval = t.SampleInterval
+		}
+		if s.HeartbeatInterval == 0 {
+			s.HeartbeatInterval = t.HeartbeatInterval
+		}
+		if s.SuppressRedundant == nil {
+			s.SuppressRedundant = t.SuppressRedundant
+		}
+		if !s.UpdatesOnly {
+			s.UpdatesOnly = t.UpdatesOnly
+		}
+		if s.Schedule == "" {
+			s.Schedule = t.onceSchedule(s.ListMode)
+		}
+		if s.Qos == nil {
+			s.Qos = t.Qos
+		}
+		if !s.AllowAggregation {
+			s.AllowAggregation = t.AllowAggregation
+		}
+		if len(s.ExtraTopics) == 0 {
+			s.ExtraTopics = t.ExtraTopics
+		}
+		if !s.Restart.Enabled {
+			s.Restart = t.Restart
+		}
+		if len(s.Extensions) == 0 {
+			s.Extensions = t.Extensions
+		}
+		subs = append(subs, s)
+	}
+	return subs
+}
+
+// topicFor returns the subject of a subscription to xpath that does not set
+// its own: the subject_map entry of the longest prefix of xpath, or the
+// target's telemetry_topic.
+func (t TargetConfig) topicFor(xpath string) string {
+	topic, longest := t.Topic, -1
+	for prefix, subject := range t.SubjectMap {
+		if len(prefix) > longest && xpathHasPrefix(xpath, prefix) {
+			topic, longest = subject, len(prefix)
+		}
+	}
+	return topic
+}
+
+// xpathHasPrefix reports whether xpath is prefix or lies below it. A prefix
+// only matches whole elements, so /interfaces does not match
+// /interfaces-extra.
+func xpathHasPrefix(xpath, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	if !strings.HasPrefix(xpath, prefix) {
+		return false
+	}
+	rest := xpath[len(prefix):]
+	return rest == "" || rest[0] == '/' || rest[0] == '['
+}
+
+// GRPCCompression values besides the compression algorithms.
+const GRPCCompressionNone = "none"
+
+// GRPCCompressionCodec returns the gRPC compressor the gNMI session asks the
+// device to use, or "" for none. grpc_compression takes precedence over the
+// older gzip flag.
+func (t TargetConfig) GRPCCompressionCodec() string {
+	switch t.GRPCCompression {
+	case "":
+		if isTrue(t.Gzip) {
+			return CompressionGzip
+		}
+		return ""
+	case GRPCCompressionNone:
+		return ""
+	}
+	return t.GRPCCompression
+}
+
+// ConnectionConfig returns t without the settings that only feed into its
+// subscriptions.
+func (t TargetConfig) ConnectionConfig() TargetConfig {
+	t.Topic = ""
+	t.XPath = ""
+	t.Encoding = ""
+	t.ListMode = ""
+	t.SubscriptionMode = ""
+	t.SampleInterval = 0
+	t.HeartbeatInterval = 0
+	t.SuppressRedundant = nil
+	t.UpdatesOnly = false
+	t.Schedule = ""
+	t.Qos = nil
+	t.AllowAggregation = false
+	t.Subscriptions = nil
+	t.SubjectMap = nil
+	t.ExtraTopics = nil
+	t.Restart = RestartConfig{}
+	t.Extensions = nil
+	return t
+}
+
+// Config is the top level publisher configuration. The inline TargetConfig
+// keeps single device config files working; when Targets is set it is used
+// instead and the inline values act as defaults for every entry.
+type Config struct {
+	TargetConfig `yaml:",inline"`
+	NatsURL      string            `yaml:"nats_url"`
+	NatsAuth     natsopts.Auth     `yaml:"nats_auth"`
+	NatsTLS      natsopts.TLS      `yaml:"nats_tls"`
+	JetStream    JetStreamConfig   `yaml:"jetstream"`
+	Sink         SinkConfig        `yaml:"sink"`
+	Batch        BatchConfig       `yaml:"batch"`
+	DeadLetter   DeadLetterConfig  `yaml:"dead_letter"`
+	Snapshots    SnapshotConfig    `yaml:"snapshots"`
+	Compression  CompressionConfig `yaml:"compression"`
+	Signing      SigningConfig     `yaml:"signing"`
+	Vault        VaultConfig       `yaml:"vault"`
+	Tracing      tracing.Config    `yaml:"tracing"`
+	GetProxy     ServiceConfig     `yaml:"get_proxy"`
+	SetRelay     ServiceConfig     `yaml:"set_relay"`
+	Capabilities ServiceConfig     `yaml:"capabilities"`
+	Targets      []TargetConfig    `yaml:"targets"`
+	TargetsDir   TargetsDirConfig  `yaml:"targets_dir"`
+	Inventory    InventoryConfig   `yaml:"inventory"`
+
+	// SelfTelemetry reports the health of the publisher itself.
+	SelfTelemetry SelfTelemetryConfig `yaml:"self_telemetry"`
+
+	// Control serves the admin API of the publisher over NATS.
+	Control ControlConfig `yaml:"control"`
+
+	// HA runs the publisher as one of a group of which only the leader
+	// collects.
+	HA HAConfig `yaml:"ha"`
+
+	// TunnelServer accepts the dial-out connections of targets with tunnel
+	// set.
+	TunnelServer TunnelServerConfig `yaml:"tunnel_server"`
+
+	// EmbeddedNats runs a NATS server in-process; nats_url is then ignored.
+	EmbeddedNats EmbeddedNatsConfig `yaml:"embedded_nats"`
+
+	// InstanceID identifies this publisher in the Collector-Id header of
+	// every message. It defaults to the host name.
+	InstanceID string `yaml:"instance_id"`
+
+	// MetricsAddress is the listen address of the Prometheus /metrics
+	// endpoint, e.g. ":9273". Metrics are not served when it is empty.
+	MetricsAddress string `yaml:"metrics_address"`
+
+	// DebugAddress is the listen address of the pprof and runtime stats
+	// endpoints, e.g. "127.0.0.1:6060". They are not served when it is
+	// empty.
+	DebugAddress string `yaml:"debug_address"`
+
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"`
+}
+
+// JetStreamConfig controls publishing through JetStream. When enabled the
+// stream is created, or updated to match, on startup.
+type JetStreamConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Stream    string        `yaml:"stream"`
+	Subjects  []string      `yaml:"subjects"`
+	Retention string        `yaml:"retention"`
+	Storage   string        `yaml:"storage"`
+	MaxAge    time.Duration `yaml:"max_age"`
+	Replicas  int           `yaml:"replicas"`
+
+	// Deduplicate sets a Nats-Msg-Id header derived from each sample so
+	// the stream drops samples published more than once within
+	// DuplicateWindow.
+	Deduplicate     bool          `yaml:"deduplicate"`
+	DuplicateWindow time.Duration `yaml:"duplicate_window"`
+}
+
+// CollectorID returns the configured instance ID or the host name.
+func (c Config) CollectorID() string {
+	if c.InstanceID != "" {
+		return c.InstanceID
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// Subjects returns every NATS subject the publisher publishes on: telemetry,
+// capabilities, status events, dead letters and self-telemetry. Targets with
+// path_subjects also publish on every subject below their telemetry subjects.
+func (c Config) Subjects() []string {
+	seen := make(map[string]bool)
+	var subjects []string
+	for _, t := range c.TargetConfigs() {
+		for _, s := range t.SubscriptionConfigs() {
+			if s.Topic != "" && !seen[s.Topic] {
+				seen[s.Topic] = true
+				subjects = append(subjects, s.Topic)
+			}
+			if s.Topic != "" && t.PathSubjects && !seen[s.Topic+".>"] {
+				seen[s.Topic+".>"] = true
+				subjects = append(subjects, s.Topic+".>")
+			}
+			for _, topic := range s.ExtraTopics {
+				if topic != "" && !seen[topic] {
+					seen[topic] = true
+					subjects = append(subjects, topic)
+				}
+			}
+		}
+		if c.Capabilities.Enabled && t.Name != "" {
+			subjects = append(subjects, c.Capabilities.SubjectFor("meta.capabilities", t.Name))
+		}
+		for _, s := range t.SubscriptionConfigs() {
+			if s.Restart.Enabled && t.Name != "" {
+				subjects = append(subjects, StatusSubject(t.Name))
+				break
+			}
+		}
+	}
+	if c.DeadLetter.Subject != "" && !seen[c.DeadLetter.Subject] {
+		subjects = append(subjects, c.DeadLetter.Subject)
+	}
+	if c.SelfTelemetry.Enabled {
+		subjects = append(subjects, c.SelfTelemetry.SubjectFor(c.CollectorID()))
+	}
+	return subjects
+}
+
+// TargetConfigs returns the list of targets to collect from, with any unset
+// fields filled in from the top level defaults.
+func (c Config) TargetConfigs() []TargetConfig {
+	if len(c.Targets) == 0 && c.TargetsDir.Path == "" && !c.Inventory.Enabled() {
+		return []TargetConfig{c.TargetConfig}
+	}
+
+	targets := make([]TargetConfig, 0, len(c.Targets))
+	for _, t := range c.Targets {
+		if t.Topic == "" {
+			// Give every target its own subject so devices can be told apart.
+			t.Topic = t.Name
+			if c.Topic != "" {
+				t.Topic = c.Topic + "." + t.Name
+			}
+		}
+		if t.TLSCA == "" {
+			t.TLSCA = c.TLSCA
+		}
+		if t.TLSCert == "" && t.TLSKey == "" {
+			t.TLSCert, t.TLSKey = c.TLSCert, c.TLSKey
+		}
+		if t.TLSMinVersion == "" {
+			t.TLSMinVersion = c.TLSMinVersion
+		}
+		if t.TLSMaxVersion == "" {
+			t.TLSMaxVersion = c.TLSMaxVersion
+		}
+		if t.GRPCCompression == "" && t.Gzip == nil {
+			t.GRPCCompression = c.GRPCCompression
+		}
+		if t.Gzip == nil {
+			t.Gzip = c.Gzip
+		}
+		if t.Insecure == nil {
+			t.Insecure = c.Insecure
+		}
+		if t.SkipVerify == nil {
+			t.SkipVerify = c.SkipVerify
+		}
+		if t.Credentials == (Credentials{}) {
+			t.Credentials = c.Credentials
+		}
+		if t.XPath == "" {
+			t.XPath = c.XPath
+		}
+		if t.Encoding == "" {
+			t.Encoding = c.Encoding
+		}
+		if t.ListMode == "" {
+			t.ListMode = c.ListMode
+		}
+		if t.SubscriptionMode == "" {
+			t.SubscriptionMode = c.SubscriptionMode
+		}
+		if t.SampleInterval == 0 {
+			t.SampleInterval = c.SampleInterval
+		}
+		if t.HeartbeatInterval == 0 {
+			t.HeartbeatInterval = c.HeartbeatInterval
+		}
+		if t.SuppressRedundant == nil {
+			t.SuppressRedundant = c.SuppressRedundant
+		}
+		if !t.UpdatesOnly {
+			t.UpdatesOnly = c.UpdatesOnly
+		}
+		if t.Qos == nil {
+			t.Qos = c.Qos
+		}
+		if !t.AllowAggregation {
+			t.AllowAggregation = c.AllowAggregation
+		}
+		if t.Schedule == "" {
+			t.Schedule = c.Schedule
+		}
+		if len(t.EncodingFallback) == 0 {
+			t.EncodingFallback = c.EncodingFallback
+		}
+		if t.PayloadFormat == "" {
+			t.PayloadFormat = c.PayloadFormat
+		}
+		if !t.PathKeyTags {
+			t.PathKeyTags = c.PathKeyTags
+		}
+		if !t.PathSubjects {
+			t.PathSubjects = c.PathSubjects
+		}
+		if !t.ChangesOnly {
+			t.ChangesOnly = c.ChangesOnly
+		}
+		if !t.Tunnel {
+			t.Tunnel = c.Tunnel
+		}
+		if t.Reconnect == (BackoffConfig{}) {
+			t.Reconnect = c.Reconnect
+		}
+		if t.DialTimeout == 0 {
+			t.DialTimeout = c.DialTimeout
+		}
+		if t.Keepalive == (KeepaliveConfig{}) {
+			t.Keepalive = c.Keepalive
+		}
+		if t.MaxMsgSize == 0 {
+			t.MaxMsgSize = c.MaxMsgSize
+		}
+		if t.Proxy == (ProxyConfig{}) {
+			t.Proxy = c.Proxy
+		}
+		if !t.Restart.Enabled {
+			t.Restart = c.Restart
+		}
+		if t.RateLimit == (RateLimitConfig{}) {
+			t.RateLimit = c.RateLimit
+		}
+		if t.Queue == (QueueConfig{}) {
+			t.Queue = c.Queue
+		}
+		if !t.Redact.Enabled() {
+			t.Redact = c.Redact
+		}
+		if len(t.Subscriptions) == 0 {
+			t.Subscriptions = c.Subscriptions
+		}
+		if len(t.ExtraTopics) == 0 {
+			t.ExtraTopics = c.ExtraTopics
+		}
+		if len(t.Extensions) == 0 {
+			t.Extensions = c.Extensions
+		}
+		if len(c.Processors) > 0 {
+			defs := make(ProcessorConfigs, len(c.Processors)+len(t.Processors))
+			for name, def := range c.Processors {
+				defs[name] = def
+			}
+			for name, def := range t.Processors {
+				defs[name] = def
+			}
+			t.Processors = defs
+		}
+		if len(t.EventProcessors) == 0 {
+			t.EventProcessors = c.EventProcessors
+		}
+		if len(c.SubjectMap) > 0 {
+			subjects := make(map[string]string, len(c.SubjectMap)+len(t.SubjectMap))
+			for prefix, subject := range c.SubjectMap {
+				subjects[prefix] = subject
+			}
+			for prefix, subject := range t.SubjectMap {
+				subjects[prefix] = subject
+			}
+			t.SubjectMap = subjects
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// Validate checks the settings that can be checked without building the
+// pipeline; see collector.Validate for the complete check.
+func (c Config) Validate() error {
+	var errs []error
+	if _, err := c.NatsOptions(context.Background()); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Sink.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Compression.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Snapshots.Enabled() && !c.Sink.UsesNats() {
+		errs = append(errs, fmt.Errorf("snapshots need the nats sink"))
+	}
+	if c.HA.Enabled && !c.Sink.UsesNats() {
+		errs = append(errs, fmt.Errorf("ha needs the nats sink"))
+	}
+	if c.HA.Enabled && c.CollectorID() == "" {
+		errs = append(errs, fmt.Errorf("ha needs an instance_id"))
+	}
+	if c.SelfTelemetry.Enabled && c.SelfTelemetry.Subject == "" && c.CollectorID() == "" {
+		errs = append(errs, fmt.Errorf("self_telemetry needs a subject or instance_id"))
+	}
+	if c.Control.Enabled && c.Control.Subject == "" && c.CollectorID() == "" {
+		errs = append(errs, fmt.Errorf("control needs a subject or instance_id"))
+	}
+	if (c.TunnelServer.TLSCert == "") != (c.TunnelServer.TLSKey == "") {
+		errs = append(errs, fmt.Errorf("tunnel_server needs both tls_cert and tls_key"))
+	}
+	if c.TunnelServer.TLSCA != "" && c.TunnelServer.TLSCert == "" {
+		errs = append(errs, fmt.Errorf("tunnel_server tls_ca needs tls_cert and tls_key"))
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range c.TargetConfigs() {
+		if t.Name == "" {
+			errs = append(errs, fmt.Errorf("target with address %q has no name", t.Address))
+		} else if seen[t.Name] {
+			errs = append(errs, fmt.Errorf("duplicate target name %q", t.Name))
+		}
+		seen[t.Name] = true
+		if t.Address == "" && !t.Tunnel {
+			errs = append(errs, fmt.Errorf("target %q has no address", t.Name))
+		}
+		if t.Tunnel && !c.TunnelServer.Enabled() {
+			errs = append(errs, fmt.Errorf("target %q: tunnel needs a tunnel_server address", t.Name))
+		}
+		if t.Tunnel && t.Proxy.Enabled() {
+			errs = append(errs, fmt.Errorf("target %q: tunnel cannot be used with a proxy", t.Name))
+		}
+		if err := CheckPayloadFormat(t.PayloadFormat); err != nil {
+			errs = append(errs, fmt.Errorf("target %q: %w", t.Name, err))
+		}
+		if err := t.Queue.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("target %q: %w", t.Name, err))
+		}
+		if err := t.Redact.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("target %q: %w", t.Name, err))
+		}
+		if err := t.Keepalive.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("target %q: %w", t.Name, err))
+		}
+		if err := t.Proxy.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("target %q: %w", t.Name, err))
+		}
+		for _, enc := range t.EncodingFallback {
+			if !gnmiEncodings[strings.ToLower(enc)] {
+				errs = append(errs, fmt.Errorf("target %q: unknown encoding_fallback encoding %q", t.Name, enc))
+			}
+		}
+		switch t.GRPCCompression {
+		case "", GRPCCompressionNone, CompressionGzip, CompressionZstd:
+		default:
+			errs = append(errs, fmt.Errorf("target %q: unknown grpc_compression %q", t.Name, t.GRPCCompression))
+		}
+		if t.DialTimeout < 0 {
+			errs = append(errs, fmt.Errorf("target %q: dial_timeout must not be negative", t.Name))
+		}
+		if t.MaxMsgSize < 0 {
+			errs = append(errs, fmt.Errorf("target %q: max_msg_size must not be negative", t.Name))
+		}
+		if c.Batch.Enabled() && t.PayloadFormat == FormatProto {
+			errs = append(errs, fmt.Errorf("target %q: batch cannot be used with payload_format %q", t.Name, FormatProto))
+		}
+		if c.Snapshots.Enabled() && t.PayloadFormat == FormatProto {
+			errs = append(errs, fmt.Errorf("target %q: snapshots cannot be used with payload_format %q", t.Name, FormatProto))
+		}
+		if t.PathKeyTags && t.PayloadFormat == FormatProto {
+			errs = append(errs, fmt.Errorf("target %q: path_key_tags cannot be used with payload_format %q", t.Name, FormatProto))
+		}
+		if len(t.EventProcessors) > 0 && t.PayloadFormat != FormatEvent {
+			errs = append(errs, fmt.Errorf("target %q: event_processors require payload_format %q", t.Name, FormatEvent))
+		}
+
+		subNames := make(map[string]bool)
+		for _, sc := range t.SubscriptionConfigs() {
+			if subNames[sc.Name] {
+				errs = append(errs, fmt.Errorf("target %q: duplicate subscription name %q", t.Name, sc.Name))
+			}
+			subNames[sc.Name] = true
+			if sc.Topic == "" {
+				errs = append(errs, fmt.Errorf("target %q: subscription %q has no telemetry_topic", t.Name, sc.Name))
+			}
+			for _, topic := range sc.ExtraTopics {
+				if topic == "" || topic == sc.Topic {
+					errs = append(errs, fmt.Errorf("target %q: subscription %q has an empty or repeated extra_topics entry", t.Name, sc.Name))
+				}
+			}
+			if err := sc.Restart.Validate(); err != nil {
+				errs = append(errs, fmt.Errorf("target %q: subscription %q: %w", t.Name, sc.Name, err))
+			}
+			if sc.UpdatesOnly && sc.ListMode != "" && !strings.EqualFold(sc.ListMode, "stream") {
+				errs = append(errs, fmt.Errorf("target %q: subscription %q: updates_only needs listmode stream", t.Name, sc.Name))
+			}
+			if sc.Schedule != "" {
+				if !isOnce(sc.ListMode) {
+					errs = append(errs, fmt.Errorf("target %q: subscription %q: schedule needs listmode once", t.Name, sc.Name))
+				}
+				if _, err := cron.Parse(sc.Schedule); err != nil {
+					errs = append(errs, fmt.Errorf("target %q: subscription %q: %w", t.Name, sc.Name, err))
+				}
+				if t.PayloadFormat == FormatProto {
+					errs = append(errs, fmt.Errorf("target %q: subscription %q: schedule cannot be used with payload_format %q", t.Name, sc.Name, FormatProto))
+				}
+			}
+			if sc.Qos != nil && *sc.Qos > MaxDSCP {
+				errs = append(errs, fmt.Errorf("target %q: subscription %q: qos must be a DSCP value up to %d", t.Name, sc.Name, MaxDSCP))
+			}
+			for _, ext := range sc.Extensions {
+				if err := ext.Validate(); err != nil {
+					errs = append(errs, fmt.Errorf("target %q: subscription %q: %w", t.Name, sc.Name, err))
+				}
+			}
+		}
+		for prefix, subject := range t.SubjectMap {
+			if !strings.HasPrefix(prefix, "/") {
+				errs = append(errs, fmt.Errorf("target %q: subject_map xpath %q must start with /", t.Name, prefix))
+			}
+			if subject == "" || strings.ContainsAny(subject, "*> \t") {
+				errs = append(errs, fmt.Errorf("target %q: invalid subject_map subject %q for %s", t.Name, subject, prefix))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NatsOptions returns the connection options for the configured NATS
+// server. Environment variables take precedence over the config file, and
+// secret references in the credentials are resolved.
+func (c Config) NatsOptions(ctx context.Context) ([]nats.Option, error) {
+	c.NatsAuth.ApplyEnv()
+	c.NatsTLS.ApplyEnv()
+	for _, secret := range []*string{&c.NatsAuth.User, &c.NatsAuth.Password, &c.NatsAuth.Token} {
+		value, err := ResolveSecret(ctx, *secret)
+		if err != nil {
+			return nil, fmt.Errorf("invalid NATS credentials: %w", err)
+		}
+		*secret = value
+	}
+	opts, err := c.NatsAuth.Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS credentials: %w", err)
+	}
+	tlsOpts, err := c.NatsTLS.Options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS TLS settings: %w", err)
+	}
+	return append(opts, tlsOpts...), nil
+}
+
+// Read parses the configuration file and adds the targets found in the
+// targets directory. Targets in an inventory are not read; see
+// collector.LoadConfig.
+func Read(filename string) (Config, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return Config{}, fmt.Errorf("error opening YAML file: %v", err)
+	}
+	defer file.Close()
+
+	yamlFile, err := io.ReadAll(file)
+	if err != nil {
+		return Config{}, fmt.Errorf("error reading YAML file: %v", err)
+	}
+
+	var conf Config
+	err = yaml.Unmarshal(envsubst.Expand(yamlFile), &conf)
+	if err != nil {
+		return Config{}, fmt.Errorf("Error parsing YAML file: %v", err)
+	}
+
+	if conf.TargetsDir.Path != "" {
+		targets, err := ReadTargetsDir(conf.TargetsDir.Path)
+		if err != nil {
+			return Config{}, err
+		}
+		conf.Targets = append(conf.Targets, targets...)
+	}
+
+	return conf, nil
+}
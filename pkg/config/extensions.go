@@ -0,0 +1,54 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ExtensionConfig is a gNMI extension attached to a SubscribeRequest: either
+// a registered extension, an ID with its message, or a history request.
+type ExtensionConfig struct {
+	ID int32 `yaml:"id"`
+	// Msg is the base64 encoded message of the registered extension.
+	Msg     string         `yaml:"msg"`
+	History *HistoryConfig `yaml:"history"`
+}
+
+// HistoryConfig asks the device for the values at SnapshotTime, or for
+// those between Start and End, instead of the current ones.
+type HistoryConfig struct {
+	SnapshotTime time.Time `yaml:"snapshot_time"`
+	Start        time.Time `yaml:"start"`
+	End          time.Time `yaml:"end"`
+}
+
+// Message returns the decoded message of a registered extension.
+func (e ExtensionConfig) Message() ([]byte, error) {
+	return base64.StdEncoding.DecodeString(e.Msg)
+}
+
+// Validate checks that e is a single, complete extension.
+func (e ExtensionConfig) Validate() error {
+	if e.History != nil {
+		if e.ID != 0 || e.Msg != "" {
+			return errors.New("extension sets both id and history")
+		}
+		h := e.History
+		if h.SnapshotTime.IsZero() == (h.Start.IsZero() && h.End.IsZero()) {
+			return errors.New("history extension needs either snapshot_time or start and end")
+		}
+		if h.SnapshotTime.IsZero() && (h.Start.IsZero() || h.End.IsZero() || h.End.Before(h.Start)) {
+			return errors.New("history extension needs a start before its end")
+		}
+		return nil
+	}
+	if e.ID <= 0 {
+		return errors.New("extension needs an id or history")
+	}
+	if _, err := e.Message(); err != nil {
+		return fmt.Errorf("extension %d: msg is not base64: %w", e.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import "fmt"
+
+// ContentType returns the Content-Type header value for format.
+func ContentType(format string) string {
+	if format == "" {
+		format = FormatJSON
+	}
+	return contentTypes[format]
+}
+
+// Payload formats supported by the publisher.
+const (
+	// FormatJSON publishes the SubscribeResponse as gnmic formatted JSON.
+	FormatJSON = "json"
+	// FormatEvent publishes a list of gnmic event messages with flat
+	// name/timestamp/tags/values fields.
+	FormatEvent = "event"
+	// FormatProto publishes the raw marshaled gnmi.SubscribeResponse.
+	FormatProto = "proto"
+)
+
+// contentTypes maps each payload format to the Content-Type header sent with
+// it.
+var contentTypes = map[string]string{
+	FormatJSON:  "application/json",
+	FormatEvent: "application/json",
+	FormatProto: "application/x-protobuf; messageType=gnmi.SubscribeResponse",
+}
+
+// IsJSONFormat reports whether format renders the gnmic JSON output.
+func IsJSONFormat(format string) bool {
+	return format == "" || format == FormatJSON
+}
+
+// CheckPayloadFormat reports whether format is a known payload format.
+func CheckPayloadFormat(format string) error {
+	switch format {
+	case "", FormatJSON, FormatEvent, FormatProto:
+		return nil
+	}
+	return fmt.Errorf("unknown payload format %q", format)
+}
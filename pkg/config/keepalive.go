@@ -0,0 +1,34 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// MinKeepaliveTime is the shortest keepalive interval gRPC clients allow.
+const MinKeepaliveTime = 10 * time.Second
+
+// KeepaliveConfig sends gRPC keepalive pings to the device every Time the
+// connection is idle, and closes it when a ping is not answered within
+// Timeout (20s by default), so a dead TCP session is noticed without
+// waiting for the operating system to give up on it.
+type KeepaliveConfig struct {
+	Time    time.Duration `yaml:"time"`
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Enabled reports whether keepalive pings are sent.
+func (k KeepaliveConfig) Enabled() bool {
+	return k.Time > 0
+}
+
+// Validate checks that the interval is one gRPC accepts.
+func (k KeepaliveConfig) Validate() error {
+	if k.Time < 0 || k.Timeout < 0 {
+		return fmt.Errorf("keepalive: time and timeout must not be negative")
+	}
+	if k.Enabled() && k.Time < MinKeepaliveTime {
+		return fmt.Errorf("keepalive: time must be at least %s", MinKeepaliveTime)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+package config
+
+// ProcessorConfigs maps a processor name to its definition, a single gnmic
+// event processor type with that processor's settings, e.g.
+//
+//	drop-discards:
+//	  event-drop:
+//	    value-names: ["discards$"]
+type ProcessorConfigs map[string]map[string]interface{}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Proxy schemes.
+const (
+	ProxyHTTP    = "http"
+	ProxySOCKS5  = "socks5"
+	ProxySOCKS5H = "socks5h"
+	ProxySSH     = "ssh"
+)
+
+// ProxyConfig is the proxy or SSH jump host a target is reached through,
+// for devices that are only reachable from a bastion.
+type ProxyConfig struct {
+	// URL is the http://, socks5:// or ssh:// address of the proxy. A user
+	// and password in the URL are used unless Credentials are set.
+	URL         string      `yaml:"url"`
+	Credentials Credentials `yaml:"credentials"`
+
+	// KeyFile is the private key of the SSH user, and KnownHosts the file
+	// the jump host's key is checked against (~/.ssh/known_hosts by
+	// default). InsecureIgnoreHostKey skips the check.
+	KeyFile               string `yaml:"key_file"`
+	KnownHosts            string `yaml:"known_hosts"`
+	InsecureIgnoreHostKey bool   `yaml:"insecure_ignore_host_key"`
+}
+
+// Enabled reports whether a proxy is configured.
+func (p ProxyConfig) Enabled() bool {
+	return p.URL != ""
+}
+
+// Parse parses the URL of the proxy and checks its scheme.
+func (p ProxyConfig) Parse() (*url.URL, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %w", err)
+	}
+	switch u.Scheme {
+	case ProxyHTTP, ProxySOCKS5, ProxySOCKS5H, ProxySSH:
+	default:
+		return nil, fmt.Errorf("unknown proxy scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("proxy url %q has no host", u.Redacted())
+	}
+	return u, nil
+}
+
+// Validate checks the proxy settings.
+func (p ProxyConfig) Validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	u, err := p.Parse()
+	if err != nil {
+		return err
+	}
+	if u.Scheme != ProxySSH && (p.KeyFile != "" || p.KnownHosts != "" || p.InsecureIgnoreHostKey) {
+		return fmt.Errorf("proxy key_file, known_hosts and insecure_ignore_host_key need an ssh:// url")
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import "fmt"
+
+// QueueConfig sizes the buffer between receiving gNMI responses and
+// publishing them, so a slow sink does not stall the gNMI stream right away.
+type QueueConfig struct {
+	Size   int    `yaml:"size"`
+	Policy string `yaml:"policy"`
+}
+
+// Validate reports an unknown policy or a negative size.
+func (q QueueConfig) Validate() error {
+	switch q.Policy {
+	case "", QueueBlock, QueueDropOldest, QueueDropNewest:
+	default:
+		return fmt.Errorf("unknown queue policy %q", q.Policy)
+	}
+	if q.Size < 0 {
+		return fmt.Errorf("queue size must not be negative")
+	}
+	return nil
+}
+
+// Queue policies, applied when the queue of a target is full.
+const (
+	// QueueBlock waits for room, pushing back on the gNMI stream.
+	QueueBlock = "block"
+	// QueueDropOldest discards the oldest queued message.
+	QueueDropOldest = "drop-oldest"
+	// QueueDropNewest discards the message being queued.
+	QueueDropNewest = "drop-newest"
+)
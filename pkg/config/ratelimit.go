@@ -0,0 +1,11 @@
+package config
+
+// RateLimitConfig caps how much a single target may publish. Zero values
+// mean no limit. Bursts of up to one second's worth are allowed, and a
+// message larger than a second's worth of bytes is allowed when nothing else
+// was published in the last second; later messages are rejected until the
+// excess has been paid back.
+type RateLimitConfig struct {
+	MessagesPerSecond float64 `yaml:"messages_per_second"`
+	BytesPerSecond    int     `yaml:"bytes_per_second"`
+}
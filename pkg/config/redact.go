@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Redaction actions.
+const (
+	RedactMask = "mask"
+	RedactDrop = "drop"
+)
+
+// RedactConfig keeps sensitive leaves, such as SNMP communities, BGP
+// passwords or user names, from leaving the publisher. Paths are regular
+// expressions matched against the path of every value, without keys or
+// module prefixes, e.g. /bgp/neighbors/neighbor/config/auth-password. Values
+// inside JSON encoded updates are matched by their path too. Matching values
+// are replaced by Mask ("***" by default) or, with the drop Action, removed.
+type RedactConfig struct {
+	Paths  []string `yaml:"paths"`
+	Action string   `yaml:"action"`
+	Mask   string   `yaml:"mask"`
+}
+
+// Enabled reports whether any values are redacted.
+func (r RedactConfig) Enabled() bool {
+	return len(r.Paths) > 0
+}
+
+// Validate reports an unknown action or an invalid expression.
+func (r RedactConfig) Validate() error {
+	switch r.Action {
+	case "", RedactMask, RedactDrop:
+	default:
+		return fmt.Errorf("unknown redact action %q", r.Action)
+	}
+	for _, p := range r.Paths {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("invalid redact path %q: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package config
+
+import "fmt"
+
+// Give-up actions of a RestartConfig.
+const (
+	GiveUpStop      = "stop"
+	GiveUpReconnect = "reconnect"
+)
+
+// RestartConfig restarts a failed subscription on its own, on the existing
+// gNMI session, instead of reconnecting to the target. Restarts wait with
+// Backoff, and after MaxRetries restarts in a row that failed (0 for no
+// limit) the subscription is given up: with the stop GiveUp action (the
+// default) it stays stopped until the next reload or reconnection, with
+// reconnect the session is restarted as without a policy.
+type RestartConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	MaxRetries int           `yaml:"max_retries"`
+	Backoff    BackoffConfig `yaml:"backoff"`
+	GiveUp     string        `yaml:"give_up"`
+}
+
+// Validate checks the give-up action and retry limit.
+func (r RestartConfig) Validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	switch r.GiveUp {
+	case "", GiveUpStop, GiveUpReconnect:
+	default:
+		return fmt.Errorf("restart: unknown give_up action %q", r.GiveUp)
+	}
+	if r.MaxRetries < 0 {
+		return fmt.Errorf("restart: max_retries must not be negative")
+	}
+	return nil
+}
+
+// StatusSubject returns the subject the status events of the named target,
+// such as a subscription given up by its restart policy, are published on.
+func StatusSubject(target string) string {
+	return "meta.status." + target
+}
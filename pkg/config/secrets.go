@@ -0,0 +1,96 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SecretProvider looks up secrets for references with its scheme. ref is
+// the part of the reference after "<scheme>:".
+type SecretProvider interface {
+	Secret(ctx context.Context, ref string) (string, error)
+}
+
+// secretProviders maps reference schemes to providers. A nil provider is a
+// known scheme that is not configured. Providers are registered at startup,
+// before any secret is resolved.
+var secretProviders = map[string]SecretProvider{
+	"env":   envSecrets{},
+	"file":  fileSecrets{},
+	"vault": nil,
+}
+
+// RegisterSecretProvider makes p resolve references with the given scheme.
+func RegisterSecretProvider(scheme string, p SecretProvider) {
+	secretProviders[scheme] = p
+}
+
+// ResolveSecret returns the value ref refers to: "env:NAME" reads an
+// environment variable, "file:/path" reads a file and "vault:path#key"
+// reads a key of a Vault secret. Anything else is returned as is.
+func ResolveSecret(ctx context.Context, ref string) (string, error) {
+	scheme, rest, ok := strings.Cut(ref, ":")
+	if !ok {
+		return ref, nil
+	}
+	p, known := secretProviders[scheme]
+	if !known {
+		return ref, nil
+	}
+	if p == nil {
+		return "", fmt.Errorf("%s secrets are not configured", scheme)
+	}
+	return p.Secret(ctx, rest)
+}
+
+// envSecrets reads secrets from environment variables.
+type envSecrets struct{}
+
+func (envSecrets) Secret(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// fileSecrets reads secrets from files, such as mounted Kubernetes or
+// Docker secrets. A trailing newline is removed.
+type fileSecrets struct{}
+
+func (fileSecrets) Secret(_ context.Context, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Credentials are the gNMI login of a target. Each value is either the
+// secret itself or a reference to it, see ResolveSecret.
+type Credentials struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Resolve returns the username and password, falling back to the given
+// defaults for values that are not set.
+func (c Credentials) Resolve(ctx context.Context, defaultUser, defaultPassword string) (string, string, error) {
+	username, err := ResolveSecret(ctx, c.Username)
+	if err != nil {
+		return "", "", fmt.Errorf("username: %w", err)
+	}
+	password, err := ResolveSecret(ctx, c.Password)
+	if err != nil {
+		return "", "", fmt.Errorf("password: %w", err)
+	}
+	if username == "" {
+		username = defaultUser
+	}
+	if password == "" {
+		password = defaultPassword
+	}
+	return username, password, nil
+}
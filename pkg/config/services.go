@@ -0,0 +1,154 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// SelfTelemetryConfig makes the publisher report its own health on a NATS
+// subject every Interval, so a fleet of collectors can be monitored through
+// NATS itself. Subject defaults to "telemetry.meta.<instance_id>", with any
+// dots in the instance ID replaced so it stays a single subject token.
+type SelfTelemetryConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Subject  string        `yaml:"subject"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// SubjectFor returns the subject the publisher with instanceID reports on.
+func (s SelfTelemetryConfig) SubjectFor(instanceID string) string {
+	if s.Subject != "" {
+		return s.Subject
+	}
+	return "telemetry.meta." + strings.ReplaceAll(instanceID, ".", "_")
+}
+
+// ReportInterval returns how often the statistics are published.
+func (s SelfTelemetryConfig) ReportInterval() time.Duration {
+	if s.Interval <= 0 {
+		return 30 * time.Second
+	}
+	return s.Interval
+}
+
+// ControlConfig answers admin requests on a NATS subject, so a fleet of
+// publishers can be managed remotely. Subject defaults to
+// "collector.<instance_id>.ctrl", with any dots in the instance ID replaced
+// so it stays a single subject token.
+type ControlConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Subject string `yaml:"subject"`
+}
+
+// SubjectFor returns the subject the publisher with instanceID is
+// controlled on.
+func (c ControlConfig) SubjectFor(instanceID string) string {
+	if c.Subject != "" {
+		return c.Subject
+	}
+	return "collector." + strings.ReplaceAll(instanceID, ".", "_") + ".ctrl"
+}
+
+// EmbeddedNatsConfig runs a NATS server inside the publisher, so the whole
+// pipeline can run as a single binary with no external broker. Subscribers
+// connect to it on Host and Port like any other server.
+type EmbeddedNatsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Host    string `yaml:"host"`
+	Port    int    `yaml:"port"`
+	// HTTPPort serves the NATS monitoring endpoints when set.
+	HTTPPort int `yaml:"http_port"`
+	// StoreDir is where JetStream keeps its data when jetstream is enabled.
+	// It defaults to a directory under the system temporary directory.
+	StoreDir string `yaml:"store_dir"`
+}
+
+// ServiceConfig enables a NATS request/reply service on every target. Each
+// target listens on SubjectPrefix followed by its name, e.g.
+// "gnmi.get.leaf1".
+type ServiceConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	SubjectPrefix string        `yaml:"subject_prefix"`
+	Timeout       time.Duration `yaml:"timeout"`
+}
+
+// SubjectFor returns the subject the named target answers requests on,
+// using defaultPrefix when no prefix is configured.
+func (s ServiceConfig) SubjectFor(defaultPrefix, target string) string {
+	prefix := s.SubjectPrefix
+	if prefix == "" {
+		prefix = defaultPrefix
+	}
+	return prefix + "." + target
+}
+
+// RequestTimeout returns how long a single request to the device may take.
+func (s ServiceConfig) RequestTimeout() time.Duration {
+	if s.Timeout <= 0 {
+		return 10 * time.Second
+	}
+	return s.Timeout
+}
+
+// HAConfig makes publishers with the same targets share them: the instances
+// of a Group compete for a lease, a key of the JetStream key-value Bucket,
+// and only the holder collects. The leader renews the lease three times per
+// TTL. When it stops renewing it, because it died or lost its connection, the
+// key expires after TTL and another instance takes over.
+type HAConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Bucket  string        `yaml:"bucket"`
+	Group   string        `yaml:"group"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// BucketName returns the bucket holding the leases.
+func (h HAConfig) BucketName() string {
+	if h.Bucket == "" {
+		return "publisher-leases"
+	}
+	return h.Bucket
+}
+
+// GroupName returns the name of the lease the instances compete for.
+func (h HAConfig) GroupName() string {
+	if h.Group == "" {
+		return "default"
+	}
+	return h.Group
+}
+
+// LeaseTTL returns how long a lease lasts without being renewed.
+func (h HAConfig) LeaseTTL() time.Duration {
+	if h.TTL <= 0 {
+		return 10 * time.Second
+	}
+	return h.TTL
+}
+
+// TunnelServerConfig runs a gRPC tunnel server on Address, which devices
+// configured for dial-out connect to, for instance from behind NAT. Targets
+// with tunnel set are subscribed to over the tunnel once their device has
+// registered on it with the target's name as ID and TargetType as type.
+type TunnelServerConfig struct {
+	Address string `yaml:"address"`
+	// TLSCert and TLSKey serve the tunnel over TLS. With TLSCA devices must
+	// present a client certificate it signed.
+	TLSCert    string `yaml:"tls_cert"`
+	TLSKey     string `yaml:"tls_key"`
+	TLSCA      string `yaml:"tls_ca"`
+	TargetType string `yaml:"target_type"`
+}
+
+// Enabled reports whether the tunnel server is configured.
+func (t TunnelServerConfig) Enabled() bool {
+	return t.Address != ""
+}
+
+// Type returns the tunnel target type of the devices.
+func (t TunnelServerConfig) Type() string {
+	if t.TargetType == "" {
+		return "GNMI_GNOI"
+	}
+	return t.TargetType
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Sink types.
+const (
+	SinkNATS   = "nats"
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+)
+
+// SinkConfig selects where telemetry is written.
+type SinkConfig struct {
+	// Type is nats (the default), stdout or file.
+	Type string `yaml:"type"`
+	// Path is the file appended to by the file sink.
+	Path string `yaml:"path"`
+	// Pretty indents the records written by the stdout and file sinks
+	// instead of writing one per line.
+	Pretty bool `yaml:"pretty"`
+}
+
+// UsesNats reports whether the sink publishes to NATS.
+func (s SinkConfig) UsesNats() bool {
+	return s.Type == "" || s.Type == SinkNATS
+}
+
+// Validate reports an unknown sink type or missing settings.
+func (s SinkConfig) Validate() error {
+	switch s.Type {
+	case "", SinkNATS, SinkStdout:
+		return nil
+	case SinkFile:
+		if s.Path == "" {
+			return fmt.Errorf("file sink has no path")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown sink type %q", s.Type)
+}
+
+// BatchConfig controls batching of published messages. A batch is sent when
+// it holds MaxMessages entries or MaxDelay after its first entry, whichever
+// comes first.
+type BatchConfig struct {
+	MaxMessages int           `yaml:"max_messages"`
+	MaxDelay    time.Duration `yaml:"max_delay"`
+}
+
+// Enabled reports whether messages are batched.
+func (b BatchConfig) Enabled() bool {
+	return b.MaxMessages > 1 || b.MaxDelay > 0
+}
+
+// DeadLetterConfig sets how often a failed publish is retried and where the
+// message goes when it still fails: a NATS subject, a local spool file, or
+// both, the file being used when the subject cannot be reached either.
+type DeadLetterConfig struct {
+	Subject       string        `yaml:"subject"`
+	File          string        `yaml:"file"`
+	Retries       int           `yaml:"retries"`
+	RetryInterval time.Duration `yaml:"retry_interval"`
+}
+
+// Enabled reports whether failed publishes are retried or dead-lettered.
+func (d DeadLetterConfig) Enabled() bool {
+	return d.Subject != "" || d.File != "" || d.Retries > 0
+}
+
+// SnapshotConfig keeps large initial syncs off core NATS. The messages of
+// the initial sync of each subscription are held back until the device
+// signals the end of the sync; when they add up to Threshold bytes or more,
+// they are stored together as one object of the JetStream Object Store
+// Bucket, and a reference to it is published in their place. Objects are
+// kept for TTL when it is set, on Replicas servers. A sync that has not
+// ended after SyncTimeout is published as is.
+type SnapshotConfig struct {
+	Bucket      string        `yaml:"bucket"`
+	Threshold   int           `yaml:"threshold"`
+	TTL         time.Duration `yaml:"ttl"`
+	Replicas    int           `yaml:"replicas"`
+	SyncTimeout time.Duration `yaml:"sync_timeout"`
+}
+
+// Enabled reports whether large initial syncs are stored as snapshots.
+func (s SnapshotConfig) Enabled() bool {
+	return s.Bucket != ""
+}
+
+// Compression algorithms.
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// CompressionConfig compresses published payloads of Threshold bytes or
+// more with Algorithm, gzip or zstd, and names it in a Content-Encoding
+// header so subscribers can decompress them. Threshold defaults to 1 KiB.
+type CompressionConfig struct {
+	Algorithm string `yaml:"algorithm"`
+	Threshold int    `yaml:"threshold"`
+}
+
+// Enabled reports whether payloads are compressed.
+func (c CompressionConfig) Enabled() bool {
+	return c.Algorithm != ""
+}
+
+// Validate reports an unknown algorithm.
+func (c CompressionConfig) Validate() error {
+	switch c.Algorithm {
+	case "", CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression algorithm %q", c.Algorithm)
+}
+
+// SigningConfig signs every published payload with the ed25519 private key
+// in KeyFile, a PKCS #8 PEM file such as openssl genpkey writes, so
+// subscribers holding the public key can tell where the telemetry came from.
+type SigningConfig struct {
+	KeyFile string `yaml:"key_file"`
+}
+
+// Enabled reports whether payloads are signed.
+func (s SigningConfig) Enabled() bool {
+	return s.KeyFile != ""
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/internal/envsubst"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// TargetsDirConfig points at a directory of per-device YAML files, each
+// holding a single target. Files are added to the targets of the main config
+// and the directory is checked for changes every Interval.
+type TargetsDirConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// PollInterval returns how often the directory is checked for changes.
+func (d TargetsDirConfig) PollInterval() time.Duration {
+	if d.Interval <= 0 {
+		return 5 * time.Second
+	}
+	return d.Interval
+}
+
+// InventoryConfig reads target definitions from a key/value store or from
+// Kubernetes. Every key under Prefix holds a single target in YAML or JSON.
+// Token is the Consul ACL token or Kubernetes bearer token; Username and
+// Password authenticate to etcd. All three may be secret references.
+// Namespace and CAFile are only used by Kubernetes.
+type InventoryConfig struct {
+	Type      string `yaml:"type"`
+	Address   string `yaml:"address"`
+	Prefix    string `yaml:"prefix"`
+	Token     string `yaml:"token"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+	Namespace string `yaml:"namespace"`
+	CAFile    string `yaml:"ca_file"`
+}
+
+// Enabled reports whether targets are read from an inventory.
+func (i InventoryConfig) Enabled() bool {
+	return i.Type != ""
+}
+
+// Inventory backends.
+const (
+	InventoryConsul     = "consul"
+	InventoryEtcd       = "etcd"
+	InventoryKubernetes = "kubernetes"
+)
+
+// TargetFiles returns the YAML files in dir in name order. Hidden files,
+// such as those written by editors or during an atomic rename, are skipped.
+func TargetFiles(dir string) ([]os.DirEntry, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.DirEntry
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if ext := filepath.Ext(name); ext == ".yaml" || ext == ".yml" {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+// ReadTargetsDir reads every target file in dir. A target without a name is
+// named after its file.
+func ReadTargetsDir(dir string) ([]TargetConfig, error) {
+	files, err := TargetFiles(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading targets directory: %v", err)
+	}
+
+	targets := make([]TargetConfig, 0, len(files))
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading target file: %v", err)
+		}
+		var t TargetConfig
+		if err := yaml.Unmarshal(envsubst.Expand(data), &t); err != nil {
+			return nil, fmt.Errorf("error parsing %s: %v", path, err)
+		}
+		if t.Name == "" {
+			t.Name = strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	vault "github.com/hashicorp/vault/api"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultConfig connects to HashiCorp Vault to resolve "vault:" secret
+// references. The address, token and CA certificate default to the standard
+// VAULT_ADDR, VAULT_TOKEN and VAULT_CACERT environment variables.
+type VaultConfig struct {
+	Address   string `yaml:"address"`
+	Token     string `yaml:"token"`
+	CAFile    string `yaml:"ca_file"`
+	Namespace string `yaml:"namespace"`
+}
+
+// Enabled reports whether Vault is configured, in the file or the
+// environment.
+func (v VaultConfig) Enabled() bool {
+	return v.Address != "" || os.Getenv("VAULT_ADDR") != ""
+}
+
+// VaultSecrets reads secrets from Vault. References have the form
+// "path#key", where path is the API path of the secret without the /v1
+// prefix, e.g. "secret/data/network/leaf1#password" for a KV version 2
+// engine mounted at secret/.
+type VaultSecrets struct {
+	client *vault.Client
+}
+
+// SetupVault connects to Vault when it is configured and registers it as
+// the provider of "vault:" references.
+func SetupVault(ctx context.Context, conf VaultConfig) (*VaultSecrets, error) {
+	if !conf.Enabled() {
+		return nil, nil
+	}
+
+	vc := vault.DefaultConfig()
+	if vc.Error != nil {
+		return nil, fmt.Errorf("invalid Vault environment: %w", vc.Error)
+	}
+	if conf.Address != "" {
+		vc.Address = conf.Address
+	}
+	if conf.CAFile != "" {
+		if err := vc.ConfigureTLS(&vault.TLSConfig{CACert: conf.CAFile}); err != nil {
+			return nil, fmt.Errorf("invalid Vault TLS settings: %w", err)
+		}
+	}
+	client, err := vault.NewClient(vc)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Vault client: %w", err)
+	}
+	if conf.Token != "" {
+		// The token itself may come from the environment or a file.
+		token, err := ResolveSecret(ctx, conf.Token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Vault token: %w", err)
+		}
+		client.SetToken(token)
+	}
+	if conf.Namespace != "" {
+		client.SetNamespace(conf.Namespace)
+	}
+
+	v := &VaultSecrets{client: client}
+	RegisterSecretProvider("vault", v)
+	slog.Info("Using Vault for secrets", "address", vc.Address)
+	return v, nil
+}
+
+func (v *VaultSecrets) Secret(ctx context.Context, ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || key == "" {
+		return "", fmt.Errorf("vault reference %q has no #key", ref)
+	}
+	secret, err := v.client.Logical().ReadWithContext(ctx, path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s from Vault: %w", path, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("no secret at %s in Vault", path)
+	}
+
+	data := secret.Data
+	// KV version 2 nests the values under "data".
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s in Vault has no key %q", path, key)
+	}
+	return fmt.Sprint(value), nil
+}
+
+// RenewToken keeps a renewable Vault token alive until ctx is cancelled,
+// renewing it when half of its TTL has passed.
+func (v *VaultSecrets) RenewToken(ctx context.Context) {
+	for {
+		wait := time.Minute
+		self, err := v.client.Auth().Token().LookupSelfWithContext(ctx)
+		if err != nil {
+			slog.Warn("Could not look up Vault token", "error", err)
+		} else {
+			renewable, _ := self.TokenIsRenewable()
+			ttl, _ := self.TokenTTL()
+			if !renewable || ttl <= 0 {
+				// The token does not expire or cannot be renewed.
+				return
+			}
+			wait = ttl / 2
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		if err == nil {
+			if _, err := v.client.Auth().Token().RenewSelfWithContext(ctx, 0); err != nil {
+				slog.Warn("Could not renew Vault token", "error", err)
+			} else {
+				slog.Debug("Renewed Vault token")
+			}
+		}
+	}
+}
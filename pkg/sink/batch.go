@@ -0,0 +1,156 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// batchSink groups messages per subject into a single JSON array before
+// handing them to the next sink. Payloads that are arrays themselves, such
+// as event messages, are flattened into the batch.
+type batchSink struct {
+	next Sink
+	conf config.BatchConfig
+
+	mu      sync.Mutex
+	batches map[string]*batch
+}
+
+type batch struct {
+	meta  map[string]string
+	items []json.RawMessage
+	ids   []string
+	timer *time.Timer
+}
+
+// NewBatch returns a sink that batches messages for next according to conf.
+func NewBatch(next Sink, conf config.BatchConfig) Sink {
+	if conf.MaxDelay <= 0 {
+		conf.MaxDelay = time.Second
+	}
+	return &batchSink{
+		next:    next,
+		conf:    conf,
+		batches: make(map[string]*batch),
+	}
+}
+
+func (s *batchSink) Publish(ctx context.Context, subject string, payload []byte, meta map[string]string) error {
+	items, err := BatchItems(payload)
+	if err != nil {
+		return fmt.Errorf("cannot batch payload: %w", err)
+	}
+
+	s.mu.Lock()
+	b, ok := s.batches[subject]
+	if !ok {
+		b = &batch{meta: meta}
+		b.timer = time.AfterFunc(s.conf.MaxDelay, func() { s.flushLater(subject, b) })
+		s.batches[subject] = b
+	}
+	b.items = append(b.items, items...)
+	if id := meta["Nats-Msg-Id"]; id != "" {
+		b.ids = append(b.ids, id)
+	}
+	full := s.conf.MaxMessages > 0 && len(b.items) >= s.conf.MaxMessages
+	if full {
+		delete(s.batches, subject)
+	}
+	s.mu.Unlock()
+
+	if !full {
+		return nil
+	}
+	b.timer.Stop()
+	return s.send(ctx, subject, b)
+}
+
+// flushLater sends b when its delay expires, unless it was already sent
+// because it filled up.
+func (s *batchSink) flushLater(subject string, b *batch) {
+	s.mu.Lock()
+	if s.batches[subject] != b {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.batches, subject)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.send(ctx, subject, b); err != nil {
+		slog.Error("Error publishing batch", "subject", subject, "messages", len(b.items), "error", err)
+	}
+}
+
+func (s *batchSink) send(ctx context.Context, subject string, b *batch) error {
+	payload, err := json.MarshalIndent(b.items, "", " ")
+	if err != nil {
+		return err
+	}
+	meta := make(map[string]string, len(b.meta)+1)
+	for k, v := range b.meta {
+		meta[k] = v
+	}
+	meta["Batch-Size"] = strconv.Itoa(len(b.items))
+	if len(b.ids) > 0 {
+		// The ID of the first message alone would make JetStream drop
+		// later batches that start with the same message.
+		meta["Nats-Msg-Id"] = combineIDs(b.ids)
+	}
+	return s.next.Publish(ctx, subject, payload, meta)
+}
+
+// Close sends every pending batch and closes the next sink.
+func (s *batchSink) Close() error {
+	s.mu.Lock()
+	pending := s.batches
+	s.batches = make(map[string]*batch)
+	s.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for subject, b := range pending {
+		b.timer.Stop()
+		if err := s.send(ctx, subject, b); err != nil {
+			slog.Error("Error publishing batch", "subject", subject, "messages", len(b.items), "error", err)
+		}
+	}
+	return s.next.Close()
+}
+
+// BatchItems splits a JSON payload into the elements added to a batch: the
+// elements of an array, or the payload itself.
+func BatchItems(payload []byte) ([]json.RawMessage, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) > 0 && payload[0] == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(payload, &items); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("not JSON")
+	}
+	return []json.RawMessage{payload}, nil
+}
+
+// combineIDs returns a deterministic ID for a batch of messages with ids.
+func combineIDs(ids []string) string {
+	h := sha256.New()
+	for _, id := range ids {
+		h.Write([]byte(id))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"strconv"
+)
+
+// Chunk headers mark the parts of a message too large for the server's
+// max_payload. Every chunk keeps the headers of the message and adds the ID
+// shared by its chunks, its sequence number counting from 1 and the number
+// of chunks, so subscribers can put the payload back together.
+const (
+	ChunkIDHeader    = "Gnmi-Chunk-Id"
+	ChunkSeqHeader   = "Gnmi-Chunk-Seq"
+	ChunkCountHeader = "Gnmi-Chunk-Count"
+)
+
+// chunkOverhead leaves room in each chunk for the protocol line and the
+// chunk headers.
+const chunkOverhead = 256
+
+// chunk splits msg into messages that fit in maxPayload bytes, headers
+// included, or returns msg alone when it already fits.
+func chunk(msg *nats.Msg, maxPayload int64) ([]*nats.Msg, error) {
+	headerSize := len("NATS/1.0\r\n\r\n")
+	for k, vs := range msg.Header {
+		for _, v := range vs {
+			headerSize += len(k) + len(v) + 4
+		}
+	}
+	if maxPayload <= 0 || int64(headerSize+len(msg.Data)) <= maxPayload {
+		return []*nats.Msg{msg}, nil
+	}
+	size := int(maxPayload) - headerSize - chunkOverhead
+	if size <= 0 {
+		return nil, fmt.Errorf("headers of %d bytes leave no room for the payload within max_payload %d", headerSize, maxPayload)
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(b)
+	count := (len(msg.Data) + size - 1) / size
+	msgs := make([]*nats.Msg, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg.Data) {
+			end = len(msg.Data)
+		}
+		header := nats.Header{}
+		for k, vs := range msg.Header {
+			header[k] = append([]string(nil), vs...)
+		}
+		header.Set(ChunkIDHeader, id)
+		header.Set(ChunkSeqHeader, strconv.Itoa(i+1))
+		header.Set(ChunkCountHeader, strconv.Itoa(count))
+		// Each chunk is a message of its own to JetStream deduplication.
+		if msgID := header.Get(nats.MsgIdHdr); msgID != "" {
+			header.Set(nats.MsgIdHdr, msgID+"."+strconv.Itoa(i+1))
+		}
+		msgs = append(msgs, &nats.Msg{Subject: msg.Subject, Data: msg.Data[i*size : end], Header: header})
+	}
+	return msgs, nil
+}
@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/klauspost/compress/zstd"
+	"sync"
+)
+
+// ContentEncodingHeader names the algorithm a payload is compressed with.
+const ContentEncodingHeader = "Content-Encoding"
+
+const defaultCompressionThreshold = 1024
+
+// compressSink compresses the payloads of the messages it hands to the next
+// sink. Payloads under the threshold, or that would not get smaller, are
+// passed on as is.
+type compressSink struct {
+	next Sink
+	conf config.CompressionConfig
+
+	zstd  *zstd.Encoder
+	gzips sync.Pool
+}
+
+// NewCompress returns a sink that compresses payloads for next according to
+// conf.
+func NewCompress(next Sink, conf config.CompressionConfig) (Sink, error) {
+	if conf.Threshold <= 0 {
+		conf.Threshold = defaultCompressionThreshold
+	}
+	s := &compressSink{next: next, conf: conf}
+	switch conf.Algorithm {
+	case config.CompressionGzip:
+		s.gzips.New = func() any { return gzip.NewWriter(nil) }
+	case config.CompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, fmt.Errorf("error creating zstd encoder: %v", err)
+		}
+		s.zstd = enc
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm %q", conf.Algorithm)
+	}
+	return s, nil
+}
+
+func (s *compressSink) Publish(ctx context.Context, subject string, payload []byte, meta map[string]string) error {
+	if len(payload) < s.conf.Threshold || meta[ContentEncodingHeader] != "" {
+		return s.next.Publish(ctx, subject, payload, meta)
+	}
+	compressed, err := s.compress(payload)
+	if err != nil {
+		return fmt.Errorf("error compressing payload: %v", err)
+	}
+	if len(compressed) >= len(payload) {
+		return s.next.Publish(ctx, subject, payload, meta)
+	}
+
+	headers := make(map[string]string, len(meta)+1)
+	for k, v := range meta {
+		headers[k] = v
+	}
+	headers[ContentEncodingHeader] = s.conf.Algorithm
+	return s.next.Publish(ctx, subject, compressed, headers)
+}
+
+func (s *compressSink) compress(payload []byte) ([]byte, error) {
+	if s.zstd != nil {
+		return s.zstd.EncodeAll(payload, nil), nil
+	}
+	var buf bytes.Buffer
+	zw := s.gzips.Get().(*gzip.Writer)
+	defer s.gzips.Put(zw)
+	zw.Reset(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *compressSink) Close() error {
+	if s.zstd != nil {
+		s.zstd.Close()
+	}
+	return s.next.Close()
+}
@@ -0,0 +1,20 @@
+package sink
+
+import "github.com/gwoodwa1/nats-gnmi-example/internal/metrics"
+
+var (
+	deadLettered = metrics.Default.NewCounterVec("publisher_dead_lettered_total",
+		"Messages sent to the dead-letter subject or file.", "subject")
+	natsReconnects = metrics.Default.NewCounterVec("publisher_nats_reconnects_total",
+		"Reconnections to the NATS server.")
+	natsDisconnects = metrics.Default.NewCounterVec("publisher_nats_disconnects_total",
+		"Disconnections from the NATS server.")
+	natsChunked = metrics.Default.NewCounterVec("publisher_nats_chunked_total",
+		"Messages split into chunks to fit the server's max_payload.", "subject")
+)
+
+// DeadLettered returns how many messages were sent to the dead-letter
+// destinations since the process started.
+func DeadLettered() uint64 {
+	return uint64(deadLettered.Total())
+}
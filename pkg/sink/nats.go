@@ -0,0 +1,210 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+	"time"
+)
+
+// NATS wraps a long lived NATS connection that is shared by every telemetry
+// target. The client library takes care of reconnecting and buffers
+// publishes while the connection is down.
+type NATS struct {
+	nc *nats.Conn
+	js nats.JetStreamContext
+}
+
+// Connect connects to the NATS server at natsURL. extra is applied after the
+// default options.
+func Connect(natsURL string, extra ...nats.Option) (*NATS, error) {
+	opts := []nats.Option{
+		nats.Name("nats-gnmi-publisher"),
+		nats.Timeout(5 * time.Second), // Set a connection timeout.
+		nats.MaxReconnects(-1),        // Keep trying for the life of the process.
+		nats.ReconnectWait(2 * time.Second),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			natsDisconnects.Inc()
+			if err != nil {
+				slog.Warn("Disconnected from NATS", "error", err)
+			}
+		}),
+		nats.ReconnectHandler(func(nc *nats.Conn) {
+			natsReconnects.Inc()
+			slog.Info("Reconnected to NATS", "url", nc.ConnectedUrl())
+		}),
+		nats.ClosedHandler(func(_ *nats.Conn) {
+			slog.Info("NATS connection closed")
+		}),
+	}
+	nc, err := nats.Connect(natsURL, append(opts, extra...)...)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to NATS: %v", err)
+	}
+	slog.Info("Connected to NATS", "url", nc.ConnectedUrl())
+
+	return &NATS{nc: nc}, nil
+}
+
+// EnableJetStream provisions the configured stream and switches Publish to
+// JetStream so every message is acknowledged by the server. subjects is used
+// when the config does not list the stream subjects explicitly.
+func (p *NATS) EnableJetStream(conf config.JetStreamConfig, subjects []string) error {
+	js, err := p.nc.JetStream()
+	if err != nil {
+		return fmt.Errorf("error creating JetStream context: %v", err)
+	}
+
+	streamConf, err := StreamConfig(conf, subjects)
+	if err != nil {
+		return err
+	}
+
+	if _, err := js.StreamInfo(streamConf.Name); err == nil {
+		if _, err := js.UpdateStream(streamConf); err != nil {
+			return fmt.Errorf("error updating stream %s: %v", streamConf.Name, err)
+		}
+		slog.Info("Updated JetStream stream", "stream", streamConf.Name)
+	} else if errors.Is(err, nats.ErrStreamNotFound) {
+		if _, err := js.AddStream(streamConf); err != nil {
+			return fmt.Errorf("error creating stream %s: %v", streamConf.Name, err)
+		}
+		slog.Info("Created JetStream stream", "stream", streamConf.Name)
+	} else {
+		return fmt.Errorf("error looking up stream %s: %v", streamConf.Name, err)
+	}
+
+	p.js = js
+	return nil
+}
+
+// StreamConfig returns the stream described by conf. subjects is used when
+// conf does not list the stream subjects.
+func StreamConfig(conf config.JetStreamConfig, subjects []string) (*nats.StreamConfig, error) {
+	sc := &nats.StreamConfig{
+		Name:       conf.Stream,
+		Subjects:   conf.Subjects,
+		MaxAge:     conf.MaxAge,
+		Replicas:   conf.Replicas,
+		Duplicates: conf.DuplicateWindow,
+	}
+	if sc.Name == "" {
+		sc.Name = "TELEMETRY"
+	}
+	if len(sc.Subjects) == 0 {
+		sc.Subjects = subjects
+	}
+
+	switch conf.Retention {
+	case "", "limits":
+		sc.Retention = nats.LimitsPolicy
+	case "interest":
+		sc.Retention = nats.InterestPolicy
+	case "workqueue":
+		sc.Retention = nats.WorkQueuePolicy
+	default:
+		return nil, fmt.Errorf("unknown JetStream retention policy %q", conf.Retention)
+	}
+
+	switch conf.Storage {
+	case "", "file":
+		sc.Storage = nats.FileStorage
+	case "memory":
+		sc.Storage = nats.MemoryStorage
+	default:
+		return nil, fmt.Errorf("unknown JetStream storage type %q", conf.Storage)
+	}
+
+	return sc, nil
+}
+
+// Publish sends data on subject using the shared connection, with meta as
+// message headers. With JetStream enabled it waits for the server to
+// acknowledge the message. Messages over the server's max_payload are sent
+// in chunks.
+func (p *NATS) Publish(ctx context.Context, subject string, data []byte, meta map[string]string) error {
+	// Check if context is done before trying to publish to prevent hanging when NATS server is not responsive.
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("context cancelled before sending message: %v", ctx.Err())
+	default:
+	}
+
+	header := nats.Header{}
+	for k, v := range meta {
+		header.Set(k, v)
+	}
+	msgs, err := chunk(&nats.Msg{Subject: subject, Data: data, Header: header}, p.nc.MaxPayload())
+	if err != nil {
+		return fmt.Errorf("failed to split message for %s: %v", subject, err)
+	}
+	if len(msgs) > 1 {
+		natsChunked.Inc(subject)
+		slog.Debug("Splitting message over max_payload", "subject", subject, "bytes", len(data), "chunks", len(msgs))
+	}
+
+	for _, msg := range msgs {
+		if p.js != nil {
+			ack, err := p.js.PublishMsg(msg, nats.Context(ctx))
+			if err != nil {
+				return fmt.Errorf("failed to publish message to JetStream: %v", err)
+			}
+			slog.Debug("Message stored in stream", "stream", ack.Stream, "seq", ack.Sequence, "subject", subject)
+			continue
+		}
+
+		if err := p.nc.PublishMsg(msg); err != nil {
+			return fmt.Errorf("failed to send message to NATS: %v", err)
+		}
+		slog.Debug("Message sent to NATS", "subject", subject)
+	}
+
+	return nil
+}
+
+// Subscribe registers handler for requests on subject. Requests are served
+// over core NATS even when telemetry is published through JetStream.
+func (p *NATS) Subscribe(subject string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	sub, err := p.nc.Subscribe(subject, handler)
+	if err != nil {
+		return nil, fmt.Errorf("error subscribing to %s: %v", subject, err)
+	}
+	return sub, nil
+}
+
+// KeyValue opens the key-value bucket of conf, creating it when missing.
+func (p *NATS) KeyValue(conf *nats.KeyValueConfig) (nats.KeyValue, error) {
+	js, err := p.nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("error creating JetStream context: %v", err)
+	}
+	kv, err := js.KeyValue(conf.Bucket)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(conf)
+		if err == nil {
+			slog.Info("Created key-value bucket", "bucket", conf.Bucket)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening key-value bucket %s: %v", conf.Bucket, err)
+	}
+	return kv, nil
+}
+
+// IsConnected reports whether the connection to the server is up.
+func (p *NATS) IsConnected() bool {
+	return p.nc.IsConnected()
+}
+
+// Close flushes any buffered messages and closes the connection.
+func (p *NATS) Close() error {
+	err := p.nc.FlushTimeout(5 * time.Second)
+	p.nc.Close()
+	if err != nil {
+		return fmt.Errorf("error flushing NATS connection: %v", err)
+	}
+	return nil
+}
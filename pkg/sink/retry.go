@@ -0,0 +1,101 @@
+package sink
+
+import (
+	"context"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// retrySink retries failed publishes to the next sink and then hands the
+// message to the dead-letter destinations, with the original subject and
+// the error in its metadata.
+type retrySink struct {
+	next     Sink
+	conf     config.DeadLetterConfig
+	subject  Sink
+	spool    Sink
+	interval time.Duration
+}
+
+// NewRetry wraps next according to conf. nc is used for the dead-letter
+// subject and may be nil when none is configured.
+func NewRetry(next Sink, conf config.DeadLetterConfig, nc *NATS) (Sink, error) {
+	s := &retrySink{next: next, conf: conf, interval: conf.RetryInterval}
+	if s.interval <= 0 {
+		s.interval = 500 * time.Millisecond
+	}
+	if conf.Subject != "" {
+		s.subject = natsSink{nc}
+	}
+	if conf.File != "" {
+		spool, err := New(config.SinkConfig{Type: config.SinkFile, Path: conf.File}, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.spool = spool
+	}
+	return s, nil
+}
+
+func (s *retrySink) Publish(ctx context.Context, subject string, payload []byte, meta map[string]string) error {
+	err := s.next.Publish(ctx, subject, payload, meta)
+	attempts := 1
+	for err != nil && attempts <= s.conf.Retries && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-time.After(s.interval):
+			err = s.next.Publish(ctx, subject, payload, meta)
+			attempts++
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	s.deadLetter(subject, payload, meta, attempts, err)
+	return err
+}
+
+// deadLetter stores a message that could not be published.
+func (s *retrySink) deadLetter(subject string, payload []byte, meta map[string]string, attempts int, pubErr error) {
+	dlMeta := make(map[string]string, len(meta)+4)
+	for k, v := range meta {
+		dlMeta[k] = v
+	}
+	dlMeta["Dead-Letter-Subject"] = subject
+	dlMeta["Dead-Letter-Error"] = pubErr.Error()
+	dlMeta["Dead-Letter-Attempts"] = strconv.Itoa(attempts)
+	dlMeta["Dead-Letter-Time"] = time.Now().UTC().Format(time.RFC3339Nano)
+
+	// The caller's context has usually expired by now.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if s.subject != nil {
+		err := s.subject.Publish(ctx, s.conf.Subject, payload, dlMeta)
+		if err == nil {
+			deadLettered.Inc(subject)
+			return
+		}
+		slog.Error("Error publishing to dead-letter subject", "subject", s.conf.Subject, "error", err)
+	}
+	if s.spool != nil {
+		err := s.spool.Publish(ctx, subject, payload, dlMeta)
+		if err == nil {
+			deadLettered.Inc(subject)
+			return
+		}
+		slog.Error("Error writing to dead-letter file", "file", s.conf.File, "error", err)
+	}
+	slog.Error("Dropped message that could not be published", "subject", subject, "error", pubErr)
+}
+
+func (s *retrySink) Close() error {
+	if s.spool != nil {
+		if err := s.spool.Close(); err != nil {
+			slog.Error("Error closing dead-letter file", "error", err)
+		}
+	}
+	return s.next.Close()
+}
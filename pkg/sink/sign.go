@@ -0,0 +1,71 @@
+package sink
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"os"
+)
+
+// Signature headers. The signature is the base64 encoded ed25519 signature
+// of the payload as published, after any compression; the key header holds
+// the ID of the public key that verifies it.
+const (
+	SignatureHeader  = "Gnmi-Signature"
+	SigningKeyHeader = "Gnmi-Signing-Key"
+)
+
+// signSink signs the payloads of the messages it hands to the next sink.
+type signSink struct {
+	next  Sink
+	key   ed25519.PrivateKey
+	keyID string
+}
+
+// NewSign returns a sink that signs payloads for next with the key of conf.
+func NewSign(next Sink, conf config.SigningConfig) (Sink, error) {
+	data, err := os.ReadFile(conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading signing key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", conf.KeyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing signing key %s: %v", conf.KeyFile, err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is a %T, not an ed25519 key", conf.KeyFile, parsed)
+	}
+	return &signSink{next: next, key: key, keyID: KeyID(key.Public().(ed25519.PublicKey))}, nil
+}
+
+// KeyID returns the ID of pub sent in the SigningKeyHeader: the first 8
+// bytes of its SHA-256 digest, hex encoded.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s *signSink) Publish(ctx context.Context, subject string, payload []byte, meta map[string]string) error {
+	headers := make(map[string]string, len(meta)+2)
+	for k, v := range meta {
+		headers[k] = v
+	}
+	headers[SignatureHeader] = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, payload))
+	headers[SigningKeyHeader] = s.keyID
+	return s.next.Publish(ctx, subject, payload, headers)
+}
+
+func (s *signSink) Close() error {
+	return s.next.Close()
+}
@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gwoodwa1/nats-gnmi-example/pkg/config"
+	"github.com/nats-io/nats.go"
+	"log/slog"
+)
+
+// SnapshotHeader names the message header holding the reference, as
+// bucket/name, to the Object Store entry a message stands for.
+const SnapshotHeader = "Gnmi-Snapshot"
+
+// SnapshotStore stores large initial syncs in a JetStream Object Store
+// bucket.
+type SnapshotStore struct {
+	obs  nats.ObjectStore
+	conf config.SnapshotConfig
+}
+
+// OpenSnapshotStore opens the bucket of conf, creating it when missing.
+func (p *NATS) OpenSnapshotStore(conf config.SnapshotConfig) (*SnapshotStore, error) {
+	js, err := p.nc.JetStream()
+	if err != nil {
+		return nil, fmt.Errorf("error creating JetStream context: %v", err)
+	}
+	obs, err := js.ObjectStore(conf.Bucket)
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		obs, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
+			Bucket:      conf.Bucket,
+			Description: "Initial syncs of gNMI subscriptions",
+			TTL:         conf.TTL,
+			Replicas:    conf.Replicas,
+		})
+		if err == nil {
+			slog.Info("Created object store bucket", "bucket", conf.Bucket)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening object store bucket %s: %v", conf.Bucket, err)
+	}
+	return &SnapshotStore{obs: obs, conf: conf}, nil
+}
+
+// Config returns the settings of the store.
+func (s *SnapshotStore) Config() config.SnapshotConfig {
+	return s.conf
+}
+
+// Put stores data as the object name and returns its reference.
+func (s *SnapshotStore) Put(ctx context.Context, name string, data []byte) (string, error) {
+	info, err := s.obs.Put(&nats.ObjectMeta{Name: name}, bytes.NewReader(data), nats.Context(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error storing snapshot %s: %v", name, err)
+	}
+	slog.Debug("Snapshot stored", "bucket", info.Bucket, "name", info.Name, "size", info.Size)
+	return info.Bucket + "/" + info.Name, nil
+}
@@ -0,0 +1,5 @@
+// Package telemetrypb holds the TelemetryStream gRPC service the subscriber
+// serves received telemetry on, generated from telemetry.proto.
+package telemetrypb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative telemetry.proto
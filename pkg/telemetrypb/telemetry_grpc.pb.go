@@ -0,0 +1,140 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             v4.24.3
+// source: telemetry.proto
+
+package telemetrypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	TelemetryStream_Subscribe_FullMethodName = "/telemetry.v1.TelemetryStream/Subscribe"
+)
+
+// TelemetryStreamClient is the client API for TelemetryStream service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TelemetryStreamClient interface {
+	// Subscribe streams the events matching the request until the client
+	// cancels the call or the subscriber shuts down.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (TelemetryStream_SubscribeClient, error)
+}
+
+type telemetryStreamClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTelemetryStreamClient(cc grpc.ClientConnInterface) TelemetryStreamClient {
+	return &telemetryStreamClient{cc}
+}
+
+func (c *telemetryStreamClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (TelemetryStream_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TelemetryStream_ServiceDesc.Streams[0], TelemetryStream_Subscribe_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &telemetryStreamSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TelemetryStream_SubscribeClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type telemetryStreamSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *telemetryStreamSubscribeClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// TelemetryStreamServer is the server API for TelemetryStream service.
+// All implementations must embed UnimplementedTelemetryStreamServer
+// for forward compatibility
+type TelemetryStreamServer interface {
+	// Subscribe streams the events matching the request until the client
+	// cancels the call or the subscriber shuts down.
+	Subscribe(*SubscribeRequest, TelemetryStream_SubscribeServer) error
+	mustEmbedUnimplementedTelemetryStreamServer()
+}
+
+// UnimplementedTelemetryStreamServer must be embedded to have forward compatible implementations.
+type UnimplementedTelemetryStreamServer struct {
+}
+
+func (UnimplementedTelemetryStreamServer) Subscribe(*SubscribeRequest, TelemetryStream_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedTelemetryStreamServer) mustEmbedUnimplementedTelemetryStreamServer() {}
+
+// UnsafeTelemetryStreamServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TelemetryStreamServer will
+// result in compilation errors.
+type UnsafeTelemetryStreamServer interface {
+	mustEmbedUnimplementedTelemetryStreamServer()
+}
+
+func RegisterTelemetryStreamServer(s grpc.ServiceRegistrar, srv TelemetryStreamServer) {
+	s.RegisterService(&TelemetryStream_ServiceDesc, srv)
+}
+
+func _TelemetryStream_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TelemetryStreamServer).Subscribe(m, &telemetryStreamSubscribeServer{stream})
+}
+
+type TelemetryStream_SubscribeServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type telemetryStreamSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *telemetryStreamSubscribeServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// TelemetryStream_ServiceDesc is the grpc.ServiceDesc for TelemetryStream service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TelemetryStream_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "telemetry.v1.TelemetryStream",
+	HandlerType: (*TelemetryStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _TelemetryStream_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "telemetry.proto",
+}